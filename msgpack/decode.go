@@ -1,9 +1,14 @@
 package msgpack
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 	"sync"
 )
 
@@ -25,10 +30,21 @@ type DecodeConvertError struct {
 	SrcValue interface{}
 	// Type of the Go value that could not be assigned to.
 	DestType reflect.Type
+	// Name of the required struct field missing from the value.
+	MissingField string
+	// Map key without a struct field when the decoder disallows unknown
+	// fields.
+	UnknownField string
 }
 
 // Error implements the error interface.
 func (e *DecodeConvertError) Error() string {
+	if e.MissingField != "" {
+		return fmt.Sprintf("msgpack: missing required field %q for %s", e.MissingField, e.DestType)
+	}
+	if e.UnknownField != "" {
+		return fmt.Sprintf("msgpack: unknown field %q for %s", e.UnknownField, e.DestType)
+	}
 	if e.SrcValue == nil {
 		return fmt.Sprintf("msgpack: cannot convert %s to %s", e.SrcType, e.DestType)
 	}
@@ -39,10 +55,32 @@ func decodeUnsupportedType(ds *decodeState, v reflect.Value) {
 	ds.saveErrorAndSkip(v, nil)
 }
 
+// ErrMaxDepthExceeded is returned by Decode when arrays and maps are nested
+// deeper than maxDecodeDepth.
+var ErrMaxDepthExceeded = errors.New("msgpack: exceeded max nesting depth")
+
+// maxDecodeDepth is the maximum nesting depth of arrays and maps. The limit
+// prevents a corrupt stream from exhausting the stack.
+const maxDecodeDepth = 10000
+
 // decodeState represents the state while decoding value.
 type decodeState struct {
 	*Decoder
 	errSaved error
+	depth    int
+}
+
+// enter increments the nesting depth and aborts the decoding if the depth
+// exceeds maxDecodeDepth. Call leave when the nested value is decoded.
+func (ds *decodeState) enter() {
+	ds.depth++
+	if ds.depth > maxDecodeDepth {
+		abort(ErrMaxDepthExceeded)
+	}
+}
+
+func (ds *decodeState) leave() {
+	ds.depth--
 }
 
 func (ds *decodeState) unpack() {
@@ -57,6 +95,32 @@ func (ds *decodeState) skip() {
 	}
 }
 
+func (ds *decodeState) isEmptyString() bool {
+	return (ds.Type() == String || ds.Type() == Binary) && len(ds.BytesNoCopy()) == 0
+}
+
+// saveMissingField saves the error for the required field name missing from a
+// value of srcType, a MapLen or an ArrayLen.
+func (ds *decodeState) saveMissingField(destValue reflect.Value, srcType Type, name string) {
+	if ds.errSaved == nil {
+		ds.errSaved = &DecodeConvertError{
+			SrcType:      srcType,
+			DestType:     destValue.Type(),
+			MissingField: name,
+		}
+	}
+}
+
+func (ds *decodeState) saveUnknownField(destValue reflect.Value, name string) {
+	if ds.errSaved == nil {
+		ds.errSaved = &DecodeConvertError{
+			SrcType:      MapLen,
+			DestType:     destValue.Type(),
+			UnknownField: name,
+		}
+	}
+}
+
 func (ds *decodeState) saveErrorAndSkip(destValue reflect.Value, srcValue interface{}) {
 	if ds.errSaved == nil {
 		ds.errSaved = &DecodeConvertError{
@@ -95,6 +159,31 @@ func (ds *decodeState) saveErrorAndSkip(destValue reflect.Value, srcValue interf
 // completes the decoding as best it can.  If no more serious errors are
 // encountered, Decode returns an DecodeConvertError describing the earliest
 // such error.
+//
+// The struct field tag "required" option makes a field mandatory. If the key
+// for the field is missing from a MessagePack map, or the MessagePack array
+// for a struct with the "array" option is too short to hold the field, Decode
+// returns a DecodeConvertError with MissingField set to the field name and
+// SrcType set to MapLen or ArrayLen. A MessagePack nil decoded into a struct
+// with required fields is reported as missing the first required field.
+//
+// The struct field tag "extra" option marks a field of map type with string
+// keys that receives the members of a MessagePack map without a corresponding
+// struct field. Decode allocates the map if it is nil and otherwise adds to
+// the existing map. Without an "extra" field, unknown members are discarded
+// unless Decoder.DisallowUnknownFields was called.
+//
+// To decode into a big.Float, Decode accepts a MessagePack string holding a
+// number in the format accepted by big.Float.Parse, an integer or a float. If
+// the precision of the big.Float is 0, Decode uses the exact precision of an
+// integer or float, and for a string, a precision large enough to hold the
+// digits of the string, but at least 64 bits.
+//
+// If SetEmptyStringAsNil is enabled, an empty MessagePack string or binary
+// value decoded into a pointer sets the pointer to nil.
+//
+// Decode returns ErrMaxDepthExceeded if arrays and maps are nested more than
+// 10000 levels deep.
 func (d *Decoder) Decode(v interface{}) (err error) {
 	defer handleAbort(&err)
 	ds := &decodeState{
@@ -116,6 +205,136 @@ func (d *Decoder) Decode(v interface{}) (err error) {
 	return ds.errSaved
 }
 
+// Unmarshal decodes the MessagePack value in data and stores the result in the
+// value pointed to by v. See Decoder.Decode for details of the decoding.
+//
+// Unmarshal returns an error if data does not hold exactly one value.
+func Unmarshal(data []byte, v interface{}) error {
+	d := NewDecoder(bytes.NewReader(data))
+	err := d.Decode(v)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	var convertErr *DecodeConvertError
+	if err != nil && !errors.As(err, &convertErr) {
+		return err
+	}
+	if _, peekErr := d.r.Peek(1); peekErr != io.EOF {
+		return errors.New("msgpack: invalid data after top-level value")
+	}
+	return err
+}
+
+// DecodeArrayFunc reads a MessagePack array from the stream and calls fn for
+// each element with the index of the element. Use DecodeArrayFunc to process
+// large arrays one element at a time instead of decoding the whole array to a
+// slice.
+//
+// The function fn must read exactly one value, the element, from d, for
+// example with a call to Decode, Skip after Unpack, or DecodeArrayFunc for a
+// nested array. DecodeArrayFunc returns an error if fn reads less or more than
+// the element. If fn returns an error, DecodeArrayFunc returns the error
+// without reading the remaining elements.
+//
+// A nil value is decoded as an empty array. DecodeArrayFunc skips any other
+// value that is not an array and returns an error.
+func (d *Decoder) DecodeArrayFunc(fn func(i int, d *Decoder) error) error {
+	if err := d.Unpack(); err != nil {
+		return err
+	}
+	switch d.Type() {
+	case ArrayLen:
+	case Nil:
+		return nil
+	default:
+		t := d.Type()
+		if err := d.Skip(); err != nil {
+			return err
+		}
+		return fmt.Errorf("msgpack: cannot decode %s as array", t)
+	}
+
+	n := d.Len()
+	open := d.open
+	for i := 0; i < n; i++ {
+		if err := fn(i, d); err != nil {
+			return err
+		}
+		if d.err != nil {
+			return d.err
+		}
+		open--
+		if d.open != open {
+			return fmt.Errorf("msgpack: array element %d not read exactly once", i)
+		}
+	}
+	return nil
+}
+
+// DecodeMapFunc reads a MessagePack map from the stream and calls fn for each
+// entry with the key of the entry. Use DecodeMapFunc to decode dictionaries
+// with known keys without building an intermediate map or using reflection.
+//
+// String and Binary keys are passed to fn as is. Int and Uint keys are passed
+// as their decimal representation. When fn is called, the key is the current
+// value of d, so fn can call Type, Int or Uint to get the key type and integer
+// value before reading the entry value. DecodeMapFunc returns an error for keys
+// of any other type.
+//
+// The function fn must read exactly one value, the entry value, from d.
+// DecodeMapFunc returns an error if fn reads less or more than the value. If fn
+// returns an error, DecodeMapFunc returns the error without reading the
+// remaining entries.
+//
+// A nil value is decoded as an empty map. DecodeMapFunc skips any other value
+// that is not a map and returns an error.
+func (d *Decoder) DecodeMapFunc(fn func(key string, d *Decoder) error) error {
+	if err := d.Unpack(); err != nil {
+		return err
+	}
+	switch d.Type() {
+	case MapLen:
+	case Nil:
+		return nil
+	default:
+		t := d.Type()
+		if err := d.Skip(); err != nil {
+			return err
+		}
+		return fmt.Errorf("msgpack: cannot decode %s as map", t)
+	}
+
+	n := d.Len()
+	open := d.open
+	for i := 0; i < n; i++ {
+		if err := d.Unpack(); err != nil {
+			return err
+		}
+		var key string
+		switch d.Type() {
+		case String, Binary:
+			key = d.String()
+		case Int:
+			key = strconv.FormatInt(d.Int(), 10)
+		case Uint:
+			key = strconv.FormatUint(d.Uint(), 10)
+		default:
+			return fmt.Errorf("msgpack: cannot decode %s as map key", d.Type())
+		}
+		if err := fn(key, d); err != nil {
+			return err
+		}
+		if d.err != nil {
+			return d.err
+		}
+		open -= 2
+		if d.open != open {
+			return fmt.Errorf("msgpack: map value for key %q not read exactly once", key)
+		}
+	}
+	return nil
+}
+
 var decodeFuncCache struct {
 	sync.RWMutex
 	m map[reflect.Type]decodeFunc
@@ -143,12 +362,15 @@ func decoderForType(t reflect.Type, b *decodeBuilder) decodeFunc {
 		return f
 	}
 
-	// Add temporary entry to break recursion
+	// Add temporary entry to break recursion. The function is built into a
+	// separate variable, so that the lookup in the cache above does not
+	// allocate.
+	var built decodeFunc
 	b.m[t] = func(ds *decodeState, v reflect.Value) {
-		f(ds, v)
+		built(ds, v)
 	}
-	f = b.decoder(t)
-	b.m[t] = f
+	built = b.decoder(t)
+	b.m[t] = built
 
 	if save {
 		decodeFuncCache.Lock()
@@ -162,7 +384,7 @@ func decoderForType(t reflect.Type, b *decodeBuilder) decodeFunc {
 
 		decodeFuncCache.Unlock()
 	}
-	return f
+	return built
 }
 
 func (b *decodeBuilder) decoder(t reflect.Type) decodeFunc {
@@ -170,6 +392,10 @@ func (b *decodeBuilder) decoder(t reflect.Type) decodeFunc {
 		return unmarshalDecoder
 	}
 
+	if t == bigFloatType {
+		return bigFloatDecoder
+	}
+
 	var f decodeFunc
 	switch t.Kind() {
 	case reflect.Bool:
@@ -317,6 +543,40 @@ func floatDecoder(ds *decodeState, v reflect.Value) {
 	v.SetFloat(x)
 }
 
+func bigFloatDecoder(ds *decodeState, v reflect.Value) {
+	x := v.Addr().Interface().(*big.Float)
+
+	switch ds.Type() {
+	case Int:
+		x.SetInt64(ds.Int())
+	case Uint:
+		x.SetUint64(ds.Uint())
+	case Float:
+		f := ds.Float()
+		if math.IsNaN(f) {
+			ds.saveErrorAndSkip(v, f)
+			return
+		}
+		x.SetFloat64(f)
+	case Binary, String:
+		s := ds.String()
+		if x.Prec() == 0 {
+			// Each decimal digit needs less than 4 bits.
+			prec := uint(4 * len(s))
+			if prec < 64 {
+				prec = 64
+			}
+			x.SetPrec(prec)
+		}
+		if _, _, err := x.Parse(s, 0); err != nil {
+			ds.saveErrorAndSkip(v, s)
+			return
+		}
+	default:
+		ds.saveErrorAndSkip(v, nil)
+	}
+}
+
 func stringDecoder(ds *decodeState, v reflect.Value) {
 	var x string
 
@@ -380,7 +640,20 @@ type sliceArrayDecoder struct {
 }
 
 func (dec sliceArrayDecoder) decodeArray(ds *decodeState, v reflect.Value) {
-	n := ds.Len()
+	var n int
+	switch ds.Type() {
+	case ArrayLen:
+		n = ds.Len()
+	case Nil:
+		// Nil decodes as an empty array.
+	default:
+		ds.saveErrorAndSkip(v, nil)
+		return
+	}
+
+	ds.enter()
+	defer ds.leave()
+
 	for i := 0; i < n; i++ {
 		ds.unpack()
 		if i < v.Len() {
@@ -408,9 +681,22 @@ func (dec sliceArrayDecoder) decodeSlice(ds *decodeState, v reflect.Value) {
 		return
 	}
 
-	n := ds.Len()
+	var n int
+	switch ds.Type() {
+	case ArrayLen:
+		n = ds.Len()
+	case Nil:
+		// Nil decodes as an empty slice.
+	default:
+		ds.saveErrorAndSkip(v, nil)
+		return
+	}
+
+	ds.enter()
+	defer ds.leave()
+
 	if n > v.Cap() {
-		newv := reflect.MakeSlice(v.Type(), n, n)
+		newv := reflect.MakeSlice(v.Type(), v.Len(), preallocLen(n))
 		reflect.Copy(newv, v)
 		v.Set(newv)
 	} else {
@@ -418,11 +704,32 @@ func (dec sliceArrayDecoder) decodeSlice(ds *decodeState, v reflect.Value) {
 	}
 
 	for i := 0; i < n; i++ {
+		if i == v.Len() {
+			if i < v.Cap() {
+				v.SetLen(i + 1)
+			} else {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+		}
 		ds.unpack()
 		dec.elem(ds, v.Index(i))
 	}
 }
 
+// maxPreallocLen is the largest number of slice elements allocated before
+// the elements are decoded.
+const maxPreallocLen = 1024
+
+// preallocLen returns the capacity to allocate for an array with n elements.
+// The capacity is limited so that a corrupt length does not allocate a large
+// slice for a short stream.
+func preallocLen(n int) int {
+	if n > maxPreallocLen {
+		return maxPreallocLen
+	}
+	return n
+}
+
 func (b *decodeBuilder) sliceDecoder(t reflect.Type) decodeFunc {
 	if t.Elem().Kind() == reflect.Uint8 {
 		return byteSliceDecoder
@@ -446,6 +753,9 @@ func (dec *mapDecoder) decode(ds *decodeState, v reflect.Value) {
 		v.Set(reflect.MakeMap(v.Type()))
 	}
 
+	ds.enter()
+	defer ds.leave()
+
 	n := ds.Len()
 	for i := 0; i < n; i++ {
 		ds.unpack()
@@ -469,9 +779,11 @@ func (b *decodeBuilder) mapDecoder(t reflect.Type) decodeFunc {
 }
 
 type fieldDec struct {
-	index []int
-	f     decodeFunc
-	empty reflect.Value
+	name     string
+	index    []int
+	f        decodeFunc
+	empty    reflect.Value
+	required bool
 }
 
 func (fd *fieldDec) setEmpty(v reflect.Value) {
@@ -491,10 +803,23 @@ func (dec structArrayDecoder) decode(ds *decodeState, v reflect.Value) {
 	}
 
 	if ds.Type() != ArrayLen {
+		if ds.Type() == Nil {
+			// Nil is decoded as an empty array, so the required fields
+			// are missing.
+			for _, fd := range dec {
+				if fd.required {
+					ds.saveMissingField(v, ArrayLen, fd.name)
+					return
+				}
+			}
+		}
 		ds.saveErrorAndSkip(v, nil)
 		return
 	}
 
+	ds.enter()
+	defer ds.leave()
+
 	n := ds.Len()
 	for i := 0; i < n; i++ {
 		ds.unpack()
@@ -506,28 +831,67 @@ func (dec structArrayDecoder) decode(ds *decodeState, v reflect.Value) {
 			ds.skip()
 		}
 	}
+
+	for i := n; i < len(dec); i++ {
+		if dec[i].required {
+			ds.saveMissingField(v, ArrayLen, dec[i].name)
+			break
+		}
+	}
 }
 
-type structDecoder map[string]*fieldDec
+type structDecoder struct {
+	fields   map[string]*fieldDec
+	required []*fieldDec
 
-func (dec structDecoder) decode(ds *decodeState, v reflect.Value) {
-	for _, fd := range dec {
+	// extra is the field with the "extra" option or nil.
+	extra     *fieldDec
+	extraElem reflect.Type
+}
+
+func (dec *structDecoder) decode(ds *decodeState, v reflect.Value) {
+	for _, fd := range dec.fields {
 		fd.setEmpty(v)
 	}
 
 	if ds.Type() != MapLen {
+		if ds.Type() == Nil && len(dec.required) > 0 {
+			// Nil is decoded as an empty map, so the required fields are
+			// missing.
+			ds.saveMissingField(v, MapLen, dec.required[0].name)
+			return
+		}
 		ds.saveErrorAndSkip(v, nil)
 		return
 	}
 
+	var found map[*fieldDec]bool
+	if len(dec.required) > 0 {
+		found = make(map[*fieldDec]bool, len(dec.required))
+	}
+
+	ds.enter()
+	defer ds.leave()
+
 	n := ds.Len()
 	for i := 0; i < n; i++ {
 		// Key
 		ds.unpack()
 
-		var fd *fieldDec
+		var (
+			fd    *fieldDec
+			key   string
+			extra bool
+		)
 		if ds.Type() == String || ds.Type() == Binary {
-			fd = dec[string(ds.BytesNoCopy())]
+			fd = dec.fields[string(ds.BytesNoCopy())]
+			if fd == nil {
+				if dec.extra != nil {
+					key, extra = string(ds.BytesNoCopy()), true
+				} else if ds.strict {
+					ds.saveUnknownField(v, string(ds.BytesNoCopy()))
+				}
+			}
 		} else {
 			ds.saveErrorAndSkip(reflect.ValueOf(""), nil)
 		}
@@ -535,36 +899,81 @@ func (dec structDecoder) decode(ds *decodeState, v reflect.Value) {
 		// Value
 		ds.unpack()
 
-		if fd != nil {
+		switch {
+		case fd != nil:
+			if fd.required {
+				found[fd] = true
+			}
 			fv := fieldByIndex(v, fd.index)
 			fd.f(ds, fv)
-		} else {
+		case extra:
+			dec.decodeExtra(ds, v, key)
+		default:
 			ds.skip()
 		}
 	}
+
+	for _, fd := range dec.required {
+		if !found[fd] {
+			ds.saveMissingField(v, MapLen, fd.name)
+			break
+		}
+	}
+}
+
+// decodeExtra decodes the current value to the entry for key in the map held
+// by the extra field of v.
+func (dec *structDecoder) decodeExtra(ds *decodeState, v reflect.Value, key string) {
+	mv := fieldByIndex(v, dec.extra.index)
+	if !mv.IsValid() {
+		ds.skip()
+		return
+	}
+	if mv.IsNil() {
+		mv.Set(reflect.MakeMap(mv.Type()))
+	}
+	elem := reflect.New(dec.extraElem).Elem()
+	dec.extra.f(ds, elem)
+	mv.SetMapIndex(reflect.ValueOf(key).Convert(mv.Type().Key()), elem)
 }
 
 func (b *decodeBuilder) structDecoder(t reflect.Type) decodeFunc {
-	fields, array := fieldsForType(t)
+	fields, extra, array := fieldsForType(t)
 
 	if array {
 		var dec structArrayDecoder
 		for _, field := range fields {
 			dec = append(dec, &fieldDec{
-				index: field.index,
-				f:     decoderForType(field.typ, b),
+				name:     field.name,
+				index:    field.index,
+				f:        decoderForType(field.typ, b),
+				required: field.required,
 			})
 		}
 		return dec.decode
 	}
 
-	dec := make(structDecoder)
+	dec := &structDecoder{fields: make(map[string]*fieldDec)}
 	for _, field := range fields {
-		dec[field.name] = &fieldDec{
-			index: field.index,
-			f:     decoderForType(field.typ, b),
-			empty: field.empty,
+		fd := &fieldDec{
+			name:     field.name,
+			index:    field.index,
+			f:        decoderForType(field.typ, b),
+			empty:    field.empty,
+			required: field.required,
 		}
+		dec.fields[field.name] = fd
+		if fd.required {
+			dec.required = append(dec.required, fd)
+		}
+	}
+	if extra != nil {
+		dec.extra = &fieldDec{
+			name:  extra.name,
+			index: extra.index,
+			f:     decoderForType(extra.typ.Elem(), b),
+		}
+		dec.extraElem = extra.typ.Elem()
 	}
 
 	return dec.decode
@@ -575,7 +984,7 @@ type ptrDecoder struct {
 }
 
 func (dec ptrDecoder) decode(ds *decodeState, v reflect.Value) {
-	if ds.Type() == Nil {
+	if ds.Type() == Nil || (ds.emptyNil && ds.isEmptyString()) {
 		v.Set(reflect.Zero(v.Type()))
 		return
 	}
@@ -603,7 +1012,10 @@ func unmarshalDecoder(ds *decodeState, v reflect.Value) {
 		v.Set(reflect.New(v.Type().Elem()))
 	}
 
-	m := v.Interface().(Unmarshaler)
+	callUnmarshaler(ds, v.Interface().(Unmarshaler))
+}
+
+func callUnmarshaler(ds *decodeState, m Unmarshaler) {
 	err := m.UnmarshalMsgPack(ds.Decoder)
 	if e, ok := err.(*DecodeConvertError); ok {
 		if ds.errSaved != nil {
@@ -622,7 +1034,9 @@ func (dec unmarshalAddrDecoder) decode(ds *decodeState, v reflect.Value) {
 		return
 	}
 
-	unmarshalDecoder(ds, v.Addr())
+	// The address of v cannot be set to nil, so the Unmarshaler decodes all
+	// values including nil.
+	callUnmarshaler(ds, v.Addr().Interface().(Unmarshaler))
 }
 
 type extensionValue struct {
@@ -634,6 +1048,8 @@ func (ev extensionValue) MarshalMsgPack(e *Encoder) error {
 	return e.PackExtension(ev.kind, ev.data)
 }
 
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
 func decodeNoReflect(ds *decodeState) (x interface{}) {
 	switch ds.Type() {
 	case Int:
@@ -651,16 +1067,46 @@ func decodeNoReflect(ds *decodeState) (x interface{}) {
 	case Binary:
 		return ds.Bytes()
 	case ArrayLen:
+		ds.enter()
+		defer ds.leave()
+
 		n := ds.Len()
-		a := make([]interface{}, n)
+		a := make([]interface{}, 0, preallocLen(n))
 		for i := 0; i < n; i++ {
 			ds.unpack()
-			a[i] = decodeNoReflect(ds)
+			a = append(a, decodeNoReflect(ds))
 		}
 		return a
 
 	case MapLen:
+		ds.enter()
+		defer ds.leave()
+
 		n := ds.Len()
+		if ds.mapBuilder != nil {
+			b := ds.mapBuilder()
+			_, hashKeys := b.(interfaceKeyMapBuilder)
+			for i := 0; i < n; i++ {
+				ds.unpack()
+				keyType := ds.Type()
+				key := decodeNoReflect(ds)
+				if hashKeys && !isHashable(key) {
+					if ds.errSaved == nil {
+						ds.errSaved = &DecodeConvertError{
+							SrcType:  keyType,
+							DestType: interfaceType,
+						}
+					}
+					ds.unpack()
+					ds.skip()
+					continue
+				}
+				ds.unpack()
+				b.Set(key, decodeNoReflect(ds))
+			}
+			return b.Map()
+		}
+
 		m := make(map[string]interface{})
 		for i := 0; i < n; i++ {
 			ds.unpack()
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"testing"
 )
@@ -1099,3 +1100,999 @@ func Test_byteSliceDecoder(t *testing.T) {
 		})
 	}
 }
+
+type orderedMap struct {
+	keys   []interface{}
+	values []interface{}
+}
+
+func (m *orderedMap) Set(key, value interface{}) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+func (m *orderedMap) Map() interface{} { return m }
+
+func TestDecodeMapBuilder(t *testing.T) {
+	t.Parallel()
+
+	data, err := pack(
+		mapLen(3),
+		int64(1), "one",
+		[]byte("bin"), int64(2),
+		"nested", mapLen(1), true, "yes",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		var got interface{}
+		err := NewDecoder(bytes.NewReader(data)).Decode(&got)
+		var decConvErr *DecodeConvertError
+		if !errors.As(err, &decConvErr) {
+			t.Fatalf("got %v error but want DecodeConvertError", err)
+		}
+		want := map[string]interface{}{
+			"bin":    int64(2),
+			"nested": map[string]interface{}{},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v but want %#v", got, want)
+		}
+	})
+
+	t.Run("InterfaceKey", func(t *testing.T) {
+		t.Parallel()
+
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetMapBuilder(InterfaceKeyMapBuilder)
+
+		var got interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[interface{}]interface{}{
+			int64(1): "one",
+			"bin":    int64(2),
+			"nested": map[interface{}]interface{}{true: "yes"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v but want %#v", got, want)
+		}
+	})
+
+	t.Run("UnhashableKey", func(t *testing.T) {
+		t.Parallel()
+
+		data, err := pack(
+			mapLen(3),
+			arrayLen(1), int64(1), int64(1),
+			mapLen(0), int64(2),
+			"a", int64(3),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetMapBuilder(InterfaceKeyMapBuilder)
+
+		var got interface{}
+		err = dec.Decode(&got)
+		var decConvErr *DecodeConvertError
+		if !errors.As(err, &decConvErr) {
+			t.Fatalf("got %v error but want DecodeConvertError", err)
+		}
+		if decConvErr.SrcType != ArrayLen {
+			t.Fatalf("got SrcType %v but want %v", decConvErr.SrcType, ArrayLen)
+		}
+		want := map[interface{}]interface{}{"a": int64(3)}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v but want %#v", got, want)
+		}
+	})
+
+	t.Run("Ordered", func(t *testing.T) {
+		t.Parallel()
+
+		dec := NewDecoder(bytes.NewReader(data))
+		dec.SetMapBuilder(func() MapBuilder { return &orderedMap{} })
+
+		var got interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := &orderedMap{
+			keys: []interface{}{int64(1), []byte("bin"), "nested"},
+			values: []interface{}{
+				"one",
+				int64(2),
+				&orderedMap{keys: []interface{}{true}, values: []interface{}{"yes"}},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v but want %#v", got, want)
+		}
+	})
+}
+
+func TestDecodeRequired(t *testing.T) {
+	t.Parallel()
+
+	type mapStruct struct {
+		A int    `msgpack:"a,required"`
+		B string `msgpack:"b"`
+		C bool   `msgpack:"c,required"`
+	}
+	type arrayStruct struct {
+		A int `msgpack:",array,required"`
+		B string
+		C bool `msgpack:",required"`
+		D int
+	}
+
+	tests := map[string]struct {
+		in          []interface{}
+		v           interface{}
+		want        interface{}
+		wantMissing string
+	}{
+		"Map": {
+			in:   []interface{}{mapLen(2), "a", int64(1), "c", true},
+			v:    &mapStruct{},
+			want: &mapStruct{A: 1, C: true},
+		},
+		"MapMissing": {
+			in:          []interface{}{mapLen(2), "a", int64(1), "b", "x"},
+			v:           &mapStruct{},
+			want:        &mapStruct{A: 1, B: "x"},
+			wantMissing: "c",
+		},
+		"MapMissingFirst": {
+			in:          []interface{}{mapLen(0)},
+			v:           &mapStruct{},
+			want:        &mapStruct{},
+			wantMissing: "a",
+		},
+		"MapNil": {
+			in:          []interface{}{nil},
+			v:           &mapStruct{},
+			want:        &mapStruct{},
+			wantMissing: "a",
+		},
+		"Array": {
+			in:   []interface{}{arrayLen(3), int64(1), "x", true},
+			v:    &arrayStruct{},
+			want: &arrayStruct{A: 1, B: "x", C: true},
+		},
+		"ArrayMissing": {
+			in:          []interface{}{arrayLen(2), int64(1), "x"},
+			v:           &arrayStruct{},
+			want:        &arrayStruct{A: 1, B: "x"},
+			wantMissing: "C",
+		},
+		"ArrayNil": {
+			in:          []interface{}{nil},
+			v:           &arrayStruct{},
+			want:        &arrayStruct{},
+			wantMissing: "A",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = NewDecoder(bytes.NewReader(p)).Decode(tt.v)
+			if tt.wantMissing == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var decConvErr *DecodeConvertError
+				if !errors.As(err, &decConvErr) {
+					t.Fatalf("got %v error but want DecodeConvertError", err)
+				}
+				if decConvErr.MissingField != tt.wantMissing {
+					t.Fatalf("got %q missing field but want %q", decConvErr.MissingField, tt.wantMissing)
+				}
+				wantSrcType := MapLen
+				if _, ok := tt.v.(*arrayStruct); ok {
+					wantSrcType = ArrayLen
+				}
+				if decConvErr.SrcType != wantSrcType {
+					t.Fatalf("got %v source type but want %v", decConvErr.SrcType, wantSrcType)
+				}
+				want := fmt.Sprintf("msgpack: missing required field %q for %s", tt.wantMissing, reflect.TypeOf(tt.v).Elem())
+				if got := err.Error(); got != want {
+					t.Fatalf("got %q error message but want %q", got, want)
+				}
+			}
+
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Fatalf("got %+v but want %+v", tt.v, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeExtra(t *testing.T) {
+	t.Parallel()
+
+	type extraStruct struct {
+		A     int                    `msgpack:"a"`
+		Extra map[string]interface{} `msgpack:",extra"`
+	}
+	type embedded struct {
+		extraStruct
+		B string `msgpack:"b"`
+	}
+	type typedExtra struct {
+		A     int            `msgpack:"a"`
+		Extra map[string]int `msgpack:",extra"`
+	}
+
+	tests := map[string]struct {
+		in   []interface{}
+		v    interface{}
+		want interface{}
+	}{
+		"NoExtra": {
+			in:   []interface{}{mapLen(1), "a", int64(1)},
+			v:    &extraStruct{},
+			want: &extraStruct{A: 1},
+		},
+		"Extra": {
+			in:   []interface{}{mapLen(3), "x", "y", "a", int64(1), "", arrayLen(1), true},
+			v:    &extraStruct{},
+			want: &extraStruct{A: 1, Extra: map[string]interface{}{"x": "y", "": []interface{}{true}}},
+		},
+		"ExistingMap": {
+			in:   []interface{}{mapLen(1), "x", int64(2)},
+			v:    &extraStruct{Extra: map[string]interface{}{"y": int64(1)}},
+			want: &extraStruct{Extra: map[string]interface{}{"x": int64(2), "y": int64(1)}},
+		},
+		"Embedded": {
+			in:   []interface{}{mapLen(3), "a", int64(1), "b", "x", "c", "y"},
+			v:    &embedded{},
+			want: &embedded{extraStruct: extraStruct{A: 1, Extra: map[string]interface{}{"c": "y"}}, B: "x"},
+		},
+		"Typed": {
+			in:   []interface{}{mapLen(2), "a", int64(1), "b", int64(2)},
+			v:    &typedExtra{},
+			want: &typedExtra{A: 1, Extra: map[string]int{"b": 2}},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := NewDecoder(bytes.NewReader(p)).Decode(tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Fatalf("got %+v but want %+v", tt.v, tt.want)
+			}
+
+			// Encoding writes the extra members back to the map.
+			b, err := Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.New(reflect.TypeOf(tt.v).Elem()).Interface()
+			if err := Unmarshal(b, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.v) {
+				t.Fatalf("round trip got %+v but want %+v", got, tt.v)
+			}
+		})
+	}
+
+	t.Run("Collision", func(t *testing.T) {
+		t.Parallel()
+
+		b, err := Marshal(&extraStruct{A: 1, Extra: map[string]interface{}{"a": int64(2), "x": "y"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{"a": int64(1), "x": "y"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v but want %v", got, want)
+		}
+	})
+
+	t.Run("InvalidType", func(t *testing.T) {
+		t.Parallel()
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("expected panic for extra field tag on slice field")
+			}
+		}()
+		Marshal(struct {
+			Extra []string `msgpack:",extra"`
+		}{})
+	})
+}
+
+func TestDecodeDisallowUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	type inner struct {
+		A int `msgpack:"a"`
+	}
+	type outer struct {
+		B     string   `msgpack:"b"`
+		Inner inner    `msgpack:"inner"`
+		List  []*inner `msgpack:"list"`
+	}
+	type extraStruct struct {
+		A     int                    `msgpack:"a"`
+		Extra map[string]interface{} `msgpack:",extra"`
+	}
+
+	tests := map[string]struct {
+		in          []interface{}
+		v           interface{}
+		want        interface{}
+		wantUnknown string
+	}{
+		"Known": {
+			in:   []interface{}{mapLen(2), "b", "x", "inner", mapLen(1), "a", int64(1)},
+			v:    &outer{},
+			want: &outer{B: "x", Inner: inner{A: 1}},
+		},
+		"Unknown": {
+			in:          []interface{}{mapLen(3), "c", int64(1), "b", "x", "d", int64(2)},
+			v:           &outer{},
+			want:        &outer{B: "x"},
+			wantUnknown: "c",
+		},
+		"Nested": {
+			in:          []interface{}{mapLen(2), "inner", mapLen(2), "a", int64(1), "z", nil, "b", "x"},
+			v:           &outer{},
+			want:        &outer{B: "x", Inner: inner{A: 1}},
+			wantUnknown: "z",
+		},
+		"NestedSlice": {
+			in:          []interface{}{mapLen(1), "list", arrayLen(2), mapLen(1), "a", int64(1), mapLen(1), "y", int64(2)},
+			v:           &outer{},
+			want:        &outer{List: []*inner{{A: 1}, {}}},
+			wantUnknown: "y",
+		},
+		"Extra": {
+			in:   []interface{}{mapLen(2), "a", int64(1), "x", "y"},
+			v:    &extraStruct{},
+			want: &extraStruct{A: 1, Extra: map[string]interface{}{"x": "y"}},
+		},
+		"Map": {
+			in:   []interface{}{mapLen(1), "x", "y"},
+			v:    &map[string]string{},
+			want: &map[string]string{"x": "y"},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dec := NewDecoder(bytes.NewReader(p))
+			dec.DisallowUnknownFields()
+			err = dec.Decode(tt.v)
+			if tt.wantUnknown == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var decConvErr *DecodeConvertError
+				if !errors.As(err, &decConvErr) {
+					t.Fatalf("got %v error but want DecodeConvertError", err)
+				}
+				if decConvErr.UnknownField != tt.wantUnknown {
+					t.Fatalf("got %q unknown field but want %q", decConvErr.UnknownField, tt.wantUnknown)
+				}
+			}
+
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Fatalf("got %+v but want %+v", tt.v, tt.want)
+			}
+
+			// The default decoder ignores unknown fields.
+			v := reflect.New(reflect.TypeOf(tt.v).Elem()).Interface()
+			if err := NewDecoder(bytes.NewReader(p)).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDecodeEmptyStringAsNil(t *testing.T) {
+	t.Parallel()
+
+	type optional struct {
+		A *string `msgpack:"a"`
+		B *int    `msgpack:"b"`
+		C string  `msgpack:"c"`
+	}
+
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+
+	tests := map[string]struct {
+		in       []interface{}
+		emptyNil bool
+		v        interface{}
+		want     interface{}
+	}{
+		"Disabled": {
+			in:   []interface{}{mapLen(3), "a", "", "b", int64(1), "c", ""},
+			v:    &optional{},
+			want: &optional{A: str(""), B: num(1)},
+		},
+		"Enabled": {
+			in:       []interface{}{mapLen(3), "a", "", "b", "", "c", ""},
+			emptyNil: true,
+			v:        &optional{A: str("x"), B: num(1)},
+			want:     &optional{},
+		},
+		"EnabledNotEmpty": {
+			in:       []interface{}{mapLen(3), "a", "x", "b", int64(1), "c", "y"},
+			emptyNil: true,
+			v:        &optional{},
+			want:     &optional{A: str("x"), B: num(1), C: "y"},
+		},
+		"EnabledBinary": {
+			in:       []interface{}{mapLen(1), "a", []byte{}},
+			emptyNil: true,
+			v:        &optional{A: str("x")},
+			want:     &optional{},
+		},
+		"EnabledPointer": {
+			in:       []interface{}{""},
+			emptyNil: true,
+			v:        func() interface{} { p := str("x"); return &p }(),
+			want:     func() interface{} { var p *string; return &p }(),
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dec := NewDecoder(bytes.NewReader(p))
+			dec.SetEmptyStringAsNil(tt.emptyNil)
+			if err := dec.Decode(tt.v); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(tt.v, tt.want) {
+				t.Fatalf("got %+v but want %+v", tt.v, tt.want)
+			}
+		})
+	}
+}
+
+func TestBigFloat(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Decode", func(t *testing.T) {
+		t.Parallel()
+
+		tests := map[string]struct {
+			in      interface{}
+			want    string
+			wantErr bool
+		}{
+			"String": {
+				in:   "3.14159265358979323846264338327950288419716939937510",
+				want: "3.14159265358979323846264338327950288419716939937510",
+			},
+			"Int": {
+				in:   int64(-42),
+				want: "-42",
+			},
+			"Uint": {
+				in:   uint64(1 << 63),
+				want: "9223372036854775808",
+			},
+			"Float": {
+				in:   0.5,
+				want: "0.5",
+			},
+			"InvalidString": {
+				in:      "pi",
+				wantErr: true,
+			},
+			"NaN": {
+				in:      math.NaN(),
+				wantErr: true,
+			},
+			"Bool": {
+				in:      true,
+				wantErr: true,
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				p, err := pack(tt.in)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var x *big.Float
+				err = NewDecoder(bytes.NewReader(p)).Decode(&x)
+				if tt.wantErr {
+					if _, ok := err.(*DecodeConvertError); !ok {
+						t.Fatalf("got error %v, want DecodeConvertError", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want, _, err := big.ParseFloat(tt.want, 10, x.Prec(), big.ToNearestEven)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if x.Cmp(want) != 0 {
+					t.Fatalf("got %s, want %s", x.Text('g', -1), tt.want)
+				}
+			})
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		t.Parallel()
+
+		type value struct {
+			X  big.Float  `msgpack:"x"`
+			PX *big.Float `msgpack:"px"`
+		}
+
+		x, _, err := big.ParseFloat("0.1000000000000000000000000000000000000001", 10, 200, big.ToNearestEven)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := value{PX: x}
+		in.X.Set(x)
+
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(&in); err != nil {
+			t.Fatal(err)
+		}
+
+		// Decoding into a value with the precision of the encoded value
+		// restores the exact value.
+		out := value{PX: new(big.Float).SetPrec(x.Prec())}
+		out.X.SetPrec(x.Prec())
+		if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.X.Cmp(x) != 0 || out.PX.Cmp(x) != 0 {
+			t.Fatalf("got (%s, %s), want %s", out.X.Text('g', -1), out.PX.Text('g', -1), x.Text('g', -1))
+		}
+
+		// Decoding into a value with zero precision preserves the decimal
+		// digits.
+		out = value{}
+		if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		want := x.Text('g', -1)
+		if got := out.X.Text('g', -1); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+		if got := out.PX.Text('g', -1); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	})
+}
+
+func TestDecodeArrayFunc(t *testing.T) {
+	t.Parallel()
+
+	decodeString := func(got *[]string) func(i int, d *Decoder) error {
+		return func(i int, d *Decoder) error {
+			var s string
+			if err := d.Decode(&s); err != nil {
+				return err
+			}
+			*got = append(*got, fmt.Sprintf("%d:%s", i, s))
+			return nil
+		}
+	}
+
+	errCallback := errors.New("callback error")
+
+	tests := map[string]struct {
+		in      []interface{}
+		fn      func(got *[]string) func(i int, d *Decoder) error
+		want    []string
+		wantErr bool
+	}{
+		"Strings": {
+			in:   []interface{}{arrayLen(3), "a", "b", "c", "next"},
+			fn:   decodeString,
+			want: []string{"0:a", "1:b", "2:c"},
+		},
+		"Nil": {
+			in: []interface{}{nil, "next"},
+			fn: decodeString,
+		},
+		"Nested": {
+			in: []interface{}{arrayLen(2), arrayLen(1), "a", arrayLen(2), "b", mapLen(1), "k", "v", "next"},
+			fn: func(got *[]string) func(i int, d *Decoder) error {
+				return func(i int, d *Decoder) error {
+					return d.DecodeArrayFunc(func(j int, d *Decoder) error {
+						if err := d.Unpack(); err != nil {
+							return err
+						}
+						*got = append(*got, fmt.Sprintf("%d.%d:%s", i, j, d.Type()))
+						return d.Skip()
+					})
+				}
+			},
+			want: []string{"0.0:String", "1.0:String", "1.1:MapLen"},
+		},
+		"NotArray": {
+			in:      []interface{}{mapLen(1), "k", "v", "next"},
+			fn:      decodeString,
+			wantErr: true,
+		},
+		"ReadNothing": {
+			in: []interface{}{arrayLen(2), "a", "b"},
+			fn: func(got *[]string) func(i int, d *Decoder) error {
+				return func(i int, d *Decoder) error { return nil }
+			},
+			wantErr: true,
+		},
+		"ReadTooMuch": {
+			in: []interface{}{arrayLen(2), "a", "b"},
+			fn: func(got *[]string) func(i int, d *Decoder) error {
+				return func(i int, d *Decoder) error {
+					var s1, s2 string
+					if err := d.Decode(&s1); err != nil {
+						return err
+					}
+					return d.Decode(&s2)
+				}
+			},
+			wantErr: true,
+		},
+		"CallbackError": {
+			in: []interface{}{arrayLen(2), "a", "b"},
+			fn: func(got *[]string) func(i int, d *Decoder) error {
+				return func(i int, d *Decoder) error { return errCallback }
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			d := NewDecoder(bytes.NewReader(p))
+			err = d.DecodeArrayFunc(tt.fn(&got))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			// The decoder is positioned after the array.
+			var next string
+			if err := d.Decode(&next); err != nil {
+				t.Fatal(err)
+			}
+			if next != "next" {
+				t.Fatalf("got %q after array, want %q", next, "next")
+			}
+		})
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	in := testDecStruct{B: true, I: -1, U: 2, F64: 3.5, S: "s", SS: []string{"a", "b"}, M: map[string]interface{}{"k": "v"}, IF: "x"}
+	p, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testDecStruct
+	if err := Unmarshal(p, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+
+	tests := map[string]struct {
+		in      []interface{}
+		wantErr error
+	}{
+		"TrailingData": {
+			in: []interface{}{"a", "b"},
+		},
+		"Empty": {
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		"Truncated": {
+			in:      []interface{}{arrayLen(2), "a"},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			err = Unmarshal(p, &v)
+			if err == nil {
+				t.Fatalf("Unmarshal(%x) did not return an error", p)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeArrayConvertError(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		in   []interface{}
+		dest interface{}
+	}{
+		"Array": {
+			in:   []interface{}{int64(-1), "a"},
+			dest: new([2]int),
+		},
+		"Slice": {
+			in:   []interface{}{mapLen(1), "k", int64(1), "a"},
+			dest: new([]int),
+		},
+		"StructField": {
+			in: []interface{}{mapLen(1), "a", int64(-1), "b"},
+			dest: new(struct {
+				A [2]int `msgpack:"a"`
+			}),
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dec := NewDecoder(bytes.NewReader(p))
+			var convertErr *DecodeConvertError
+			if err := dec.Decode(tt.dest); !errors.As(err, &convertErr) {
+				t.Fatalf("got error %v, want DecodeConvertError", err)
+			}
+			// The value is skipped and the decoder is at the next value.
+			var s string
+			if err := dec.Decode(&s); err != nil || s != "a" && s != "b" {
+				t.Fatalf("next value is %q, %v", s, err)
+			}
+		})
+	}
+
+	var a [2]int
+	if err := Unmarshal([]byte{0xc0}, &a); err != nil || a != [2]int{} {
+		t.Fatalf("nil into array returned %v, %v", a, err)
+	}
+}
+
+func TestDecodeMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	nested := func(depth int) []byte {
+		return append(bytes.Repeat([]byte{0x91}, depth), 0x01)
+	}
+
+	var v interface{}
+	if err := Unmarshal(nested(maxDecodeDepth), &v); err != nil {
+		t.Fatalf("depth %d returned error %v", maxDecodeDepth, err)
+	}
+	v = nil
+	if err := Unmarshal(nested(maxDecodeDepth+1), &v); !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("depth %d returned error %v, want %v", maxDecodeDepth+1, err, ErrMaxDepthExceeded)
+	}
+
+	type node []node
+	var n node
+	if err := Unmarshal(nested(maxDecodeDepth+1), &n); !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("depth %d into slice returned error %v, want %v", maxDecodeDepth+1, err, ErrMaxDepthExceeded)
+	}
+}
+
+func TestDecodeMapFunc(t *testing.T) {
+	t.Parallel()
+
+	decodeString := func(got *[]string) func(key string, d *Decoder) error {
+		return func(key string, d *Decoder) error {
+			var s string
+			if err := d.Decode(&s); err != nil {
+				return err
+			}
+			*got = append(*got, key+":"+s)
+			return nil
+		}
+	}
+
+	errCallback := errors.New("callback error")
+
+	tests := map[string]struct {
+		in      []interface{}
+		fn      func(got *[]string) func(key string, d *Decoder) error
+		want    []string
+		wantErr bool
+	}{
+		"Strings": {
+			in:   []interface{}{mapLen(2), "a", "x", "b", "y", "next"},
+			fn:   decodeString,
+			want: []string{"a:x", "b:y"},
+		},
+		"BinaryKey": {
+			in:   []interface{}{mapLen(1), []byte("a"), "x", "next"},
+			fn:   decodeString,
+			want: []string{"a:x"},
+		},
+		"IntegerKeys": {
+			in: []interface{}{mapLen(2), int64(-1), "x", uint64(math.MaxUint64), "y", "next"},
+			fn: func(got *[]string) func(key string, d *Decoder) error {
+				return func(key string, d *Decoder) error {
+					*got = append(*got, fmt.Sprintf("%s:%s", d.Type(), key))
+					return d.Decode(new(string))
+				}
+			},
+			want: []string{"Int:-1", "Uint:18446744073709551615"},
+		},
+		"Nil": {
+			in: []interface{}{nil, "next"},
+			fn: decodeString,
+		},
+		"Nested": {
+			in: []interface{}{mapLen(2), "a", mapLen(1), "b", "x", "c", arrayLen(1), "y", "next"},
+			fn: func(got *[]string) func(key string, d *Decoder) error {
+				return func(key string, d *Decoder) error {
+					if key == "a" {
+						return d.DecodeMapFunc(func(k string, d *Decoder) error {
+							*got = append(*got, key+"."+k)
+							return d.Decode(new(string))
+						})
+					}
+					if err := d.Unpack(); err != nil {
+						return err
+					}
+					*got = append(*got, fmt.Sprintf("%s:%s", key, d.Type()))
+					return d.Skip()
+				}
+			},
+			want: []string{"a.b", "c:ArrayLen"},
+		},
+		"NotMap": {
+			in:      []interface{}{arrayLen(1), "a", "next"},
+			fn:      decodeString,
+			wantErr: true,
+		},
+		"InvalidKey": {
+			in:      []interface{}{mapLen(1), arrayLen(0), "x"},
+			fn:      decodeString,
+			wantErr: true,
+		},
+		"ReadNothing": {
+			in: []interface{}{mapLen(2), "a", "x", "b", "y"},
+			fn: func(got *[]string) func(key string, d *Decoder) error {
+				return func(key string, d *Decoder) error { return nil }
+			},
+			wantErr: true,
+		},
+		"ReadTooMuch": {
+			in: []interface{}{mapLen(2), "a", "x", "b", "y"},
+			fn: func(got *[]string) func(key string, d *Decoder) error {
+				return func(key string, d *Decoder) error {
+					var s1, s2 string
+					if err := d.Decode(&s1); err != nil {
+						return err
+					}
+					return d.Decode(&s2)
+				}
+			},
+			wantErr: true,
+		},
+		"CallbackError": {
+			in: []interface{}{mapLen(1), "a", "x"},
+			fn: func(got *[]string) func(key string, d *Decoder) error {
+				return func(key string, d *Decoder) error { return errCallback }
+			},
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := pack(tt.in...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			d := NewDecoder(bytes.NewReader(p))
+			err = d.DecodeMapFunc(tt.fn(&got))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			// The decoder is positioned after the map.
+			var next string
+			if err := d.Decode(&next); err != nil {
+				t.Fatal(err)
+			}
+			if next != "next" {
+				t.Fatalf("got %q after map, want %q", next, "next")
+			}
+		})
+	}
+}
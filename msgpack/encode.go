@@ -1,7 +1,12 @@
 package msgpack
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
 	"sync"
 )
 
@@ -38,6 +43,11 @@ func encodeUnsupportedType(e *Encoder, v reflect.Value) {
 //  []byte              binary
 //  slices, arrays      array
 //  struct, map         map
+//  big.Float           string
+//
+// A big.Float encodes as a string holding the shortest decimal representation
+// that decodes to the same value at the precision of the big.Float, so the
+// value is not rounded to a float64.
 //
 // Struct values encode as maps or arrays. If any struct field tag specifies
 // the "array" option, then the struct is encoded as an array. Otherwise, the
@@ -46,11 +56,37 @@ func encodeUnsupportedType(e *Encoder, v reflect.Value) {
 //   - the field's tag is "-", or
 //   - the field is empty and its tag specifies the "omitempty" option.
 //
+// For the "omitempty" option, a field is empty if it is false, 0, a nil
+// pointer, a nil interface value, or an array, slice, map or string of length
+// zero. A nil slice and an empty slice are both empty. An interface value
+// holding a nil pointer is not empty. As in the encoding/json package, a
+// struct value is never empty, so a nested struct is encoded as a map even if
+// all of its fields are omitted. Use a pointer field to omit a nested struct.
+// The "omitempty" option has no effect on the fields of a struct encoded as an
+// array.
+//
 // Anonymous struct fields are marshaled as if their inner exported fields
-// were fields in the outer struct.
+// were fields in the outer struct. The fields of a nil embedded struct pointer
+// are omitted.
+//
+// The struct field tag "str" option encodes a []byte field as a MessagePack
+// string instead of the default binary. The option is an error for fields of
+// any other type. Decoding accepts either string or binary for a []byte field,
+// so the option only affects encoding.
+//
+// The struct field tag "extra" option marks a field of map type with string
+// keys that holds the members of a MessagePack map without a corresponding
+// struct field. When encoding, the entries of the map are written after the
+// struct fields, skipping keys that are also struct field names. The option
+// is an error for a struct encoded as an array.
 //
 // The struct field tag "empty" specifies a default value when decoding and the
-// empty value for the "omitempty" option.
+// empty value for the "omitempty" option. A field with an "empty" tag is empty
+// only if it equals the tag value. The tag is supported for bool, signed integer
+// and string fields.
+//
+// Map entries are written in the iteration order of the map unless
+// Encoder.SortMapKeys is enabled.
 //
 // Pointer values encode as the value pointed to. A nil pointer encodes as the
 // MessagePack nil value.
@@ -69,6 +105,72 @@ func (e *Encoder) Encode(v interface{}) (err error) {
 	return nil
 }
 
+// Marshal returns the MessagePack encoding of v. See Encoder.Encode for
+// details of the encoding.
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrInvalidStructArgsArg is the invalid argument error returned by
+// StructArgs.
+var ErrInvalidStructArgsArg = errors.New("msgpack: argument to StructArgs must be struct or non-nil pointer to struct")
+
+// StructArgs returns the fields of the struct v in the order that Encode
+// writes them when the struct is encoded as an array.
+//
+// StructArgs is used to call an RPC method with positional arguments defined
+// by a struct:
+//
+//	type setLinesArgs struct {
+//	    Buffer int
+//	    Start  int
+//	    End    int
+//	    Strict bool
+//	    Lines  []string
+//	}
+//
+//	args, err := msgpack.StructArgs(&setLinesArgs{Buffer: 0, Start: 0, End: -1, Lines: lines})
+//	if err != nil {
+//	    return err
+//	}
+//	err = v.Request("nvim_buf_set_lines", nil, args...)
+//
+// The field tags are interpreted as described in Encode. Fields of a nil
+// embedded struct pointer are returned as nil and fields with the "str" option
+// are returned as string.
+func StructArgs(v interface{}) ([]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, ErrInvalidStructArgsArg
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrInvalidStructArgsArg
+	}
+
+	fields, _, _ := fieldsForType(rv.Type())
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		fv := fieldByIndex(rv, f.index)
+		if !fv.IsValid() {
+			continue
+		}
+		if f.str {
+			args[i] = string(fv.Bytes())
+			continue
+		}
+		args[i] = fv.Interface()
+	}
+
+	return args, nil
+}
+
 type encodeFunc func(e *Encoder, v reflect.Value)
 
 type encodeBuilder struct {
@@ -96,12 +198,15 @@ func encoderForType(t reflect.Type, b *encodeBuilder) encodeFunc {
 		return f
 	}
 
-	// Add temporary entry to break recursion.
+	// Add temporary entry to break recursion. The function is built into a
+	// separate variable, so that the lookup in the cache above does not
+	// allocate.
+	var built encodeFunc
 	b.m[t] = func(e *Encoder, v reflect.Value) {
-		f(e, v)
+		built(e, v)
 	}
-	f = b.encoder(t)
-	b.m[t] = f
+	built = b.encoder(t)
+	b.m[t] = built
 
 	if save {
 		encodeFuncCache.Lock()
@@ -116,7 +221,7 @@ func encoderForType(t reflect.Type, b *encodeBuilder) encodeFunc {
 		encodeFuncCache.Unlock()
 	}
 
-	return f
+	return built
 }
 
 func (b *encodeBuilder) encoder(t reflect.Type) encodeFunc {
@@ -124,6 +229,10 @@ func (b *encodeBuilder) encoder(t reflect.Type) encodeFunc {
 		return b.marshalEncoder(t)
 	}
 
+	if t == bigFloatType {
+		return bigFloatEncoder
+	}
+
 	var f encodeFunc
 	switch t.Kind() {
 	case reflect.Bool:
@@ -195,12 +304,33 @@ func stringEncoder(e *Encoder, v reflect.Value) {
 	}
 }
 
+var bigFloatType = reflect.TypeOf(big.Float{})
+
+func bigFloatEncoder(e *Encoder, v reflect.Value) {
+	var x *big.Float
+	if v.CanAddr() {
+		x = v.Addr().Interface().(*big.Float)
+	} else {
+		f := v.Interface().(big.Float)
+		x = &f
+	}
+	if err := e.PackString(x.Text('g', -1)); err != nil {
+		abort(err)
+	}
+}
+
 func byteSliceEncoder(e *Encoder, v reflect.Value) {
 	if err := e.PackBinary(v.Bytes()); err != nil {
 		abort(err)
 	}
 }
 
+func stringBytesEncoder(e *Encoder, v reflect.Value) {
+	if err := e.PackStringBytes(v.Bytes()); err != nil {
+		abort(err)
+	}
+}
+
 func interfaceEncoder(e *Encoder, v reflect.Value) {
 	if !v.IsValid() || v.IsNil() {
 		nilEncoder(e, v)
@@ -238,12 +368,63 @@ func (enc *mapEncoder) encode(e *Encoder, v reflect.Value) {
 		abort(err)
 	}
 
-	for _, k := range v.MapKeys() {
+	for _, k := range e.mapKeys(v) {
 		enc.key(e, k)
 		enc.elem(e, v.MapIndex(k))
 	}
 }
 
+// mapKeys returns the keys of map v, sorted if the encoder sorts map keys.
+func (e *Encoder) mapKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	if e.sortKeys {
+		sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	}
+	return keys
+}
+
+// keyLess orders map keys. Values of different kinds are ordered by kind.
+func keyLess(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() && !b.IsNil()
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	if ka, kb := keyKind(a.Kind()), keyKind(b.Kind()); ka != kb {
+		return ka < kb
+	}
+
+	switch keyKind(a.Kind()) {
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	case reflect.Int:
+		return a.Int() < b.Int()
+	case reflect.Uint:
+		return a.Uint() < b.Uint()
+	case reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	default:
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+}
+
+// keyKind groups the kinds of map keys that compare the same way.
+func keyKind(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	default:
+		return k
+	}
+}
+
 func (b *encodeBuilder) mapEncoder(t reflect.Type) encodeFunc {
 	enc := &mapEncoder{key: encoderForType(t.Key(), b), elem: encoderForType(t.Elem(), b)}
 	return enc.encode
@@ -330,6 +511,14 @@ type fieldEnc struct {
 type structEncoder []*fieldEnc
 
 func (enc structEncoder) encode(e *Encoder, v reflect.Value) {
+	if err := e.PackMapLen(enc.count(v)); err != nil {
+		abort(err)
+	}
+	enc.encodeFields(e, v)
+}
+
+// count returns the number of fields of v that are encoded in a map.
+func (enc structEncoder) count(v reflect.Value) int64 {
 	var n int64
 	for _, fe := range enc {
 		fv := fieldByIndex(v, fe.index)
@@ -338,11 +527,10 @@ func (enc structEncoder) encode(e *Encoder, v reflect.Value) {
 		}
 		n++
 	}
+	return n
+}
 
-	if err := e.PackMapLen(n); err != nil {
-		abort(err)
-	}
-
+func (enc structEncoder) encodeFields(e *Encoder, v reflect.Value) {
 	for _, fe := range enc {
 		fv := fieldByIndex(v, fe.index)
 		if !fv.IsValid() || (fe.empty != nil && fe.empty(fv)) {
@@ -368,8 +556,47 @@ func (enc structEncoder) encodeArray(e *Encoder, v reflect.Value) {
 	}
 }
 
+// extraStructEncoder encodes a struct with an "extra" field. The entries of
+// the extra map are encoded after the struct fields, except for keys that
+// collide with the name of a struct field.
+type extraStructEncoder struct {
+	fields structEncoder
+	names  map[string]bool
+	index  []int
+	elem   encodeFunc
+}
+
+func (enc *extraStructEncoder) encode(e *Encoder, v reflect.Value) {
+	n := enc.fields.count(v)
+	mv := fieldByIndex(v, enc.index)
+	var keys []reflect.Value
+	if mv.IsValid() {
+		keys = e.mapKeys(mv)
+		for _, k := range keys {
+			if !enc.names[k.String()] {
+				n++
+			}
+		}
+	}
+
+	if err := e.PackMapLen(n); err != nil {
+		abort(err)
+	}
+	enc.fields.encodeFields(e, v)
+
+	for _, k := range keys {
+		if enc.names[k.String()] {
+			continue
+		}
+		if err := e.PackString(k.String()); err != nil {
+			abort(err)
+		}
+		enc.elem(e, mv.MapIndex(k))
+	}
+}
+
 func (b *encodeBuilder) structEncoder(t reflect.Type) encodeFunc {
-	fields, array := fieldsForType(t)
+	fields, extra, array := fieldsForType(t)
 	enc := make(structEncoder, len(fields))
 
 	for i, f := range fields {
@@ -382,12 +609,29 @@ func (b *encodeBuilder) structEncoder(t reflect.Type) encodeFunc {
 			empty: empty,
 			index: f.index,
 			f:     encoderForType(f.typ, b)}
+
+		if f.str && !f.typ.Implements(marshalerType) && !reflect.PtrTo(f.typ).Implements(marshalerType) {
+			enc[i].f = stringBytesEncoder
+		}
 	}
 
 	if array {
 		return enc.encodeArray
 	}
 
+	if extra != nil {
+		xenc := &extraStructEncoder{
+			fields: enc,
+			names:  make(map[string]bool, len(fields)),
+			index:  extra.index,
+			elem:   encoderForType(extra.typ.Elem(), b),
+		}
+		for _, f := range fields {
+			xenc.names[f.name] = true
+		}
+		return xenc.encode
+	}
+
 	return enc.encode
 }
 
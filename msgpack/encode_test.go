@@ -30,6 +30,14 @@ type rb struct {
 	Rb *ra
 }
 
+type omitInner struct {
+	A int `msgpack:"a,omitempty"`
+}
+
+type OmitEmbedded struct {
+	E int `msgpack:"e"`
+}
+
 type me struct {
 	s string
 }
@@ -245,6 +253,63 @@ func TestEncode(t *testing.T) {
 				"p", 0,
 			},
 		},
+		"OmitEmptyNested": {
+			v: struct {
+				P  *omitInner  `msgpack:"p,omitempty"`
+				Pz *omitInner  `msgpack:"pz,omitempty"`
+				S  omitInner   `msgpack:"s,omitempty"`
+				I  interface{} `msgpack:"i,omitempty"`
+				Ip interface{} `msgpack:"ip,omitempty"`
+				A  [0]int      `msgpack:"a,omitempty"`
+				*OmitEmbedded
+			}{
+				Pz: &omitInner{},
+				Ip: (*omitInner)(nil),
+			},
+			data: []interface{}{
+				mapLen(3),
+				"pz", mapLen(0),
+				"s", mapLen(0),
+				"ip", nil,
+			},
+		},
+		"OmitEmptyArray": {
+			v: struct {
+				P *omitInner `msgpack:",array,omitempty"`
+				I int        `msgpack:",omitempty"`
+			}{},
+			data: []interface{}{arrayLen(2), nil, 0},
+		},
+		"StrTag": {
+			v: struct {
+				B  []byte         `msgpack:"b"`
+				S  []byte         `msgpack:"s,str"`
+				T  typedByteSlice `msgpack:"t,str"`
+				N  []byte         `msgpack:"n,str"`
+				Se []byte         `msgpack:"se,omitempty,str"`
+			}{
+				B: []byte("bin"),
+				S: []byte("str"),
+				T: typedByteSlice("typed"),
+			},
+			data: []interface{}{
+				mapLen(4),
+				"b", []byte("bin"),
+				"s", "str",
+				"t", "typed",
+				"n", "",
+			},
+		},
+		"StrTagArray": {
+			v: struct {
+				S []byte `msgpack:",array,str"`
+				B []byte
+			}{
+				S: []byte("str"),
+				B: []byte("bin"),
+			},
+			data: []interface{}{arrayLen(2), "str", []byte("bin")},
+		},
 		"Struct": {
 			v: &ra{"foo", &rb{"bar", &ra{"quux", nil}}},
 			data: []interface{}{
@@ -290,3 +355,171 @@ func TestEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeSortMapKeys(t *testing.T) {
+	t.Parallel()
+
+	type extraStruct struct {
+		A     int                    `msgpack:"a"`
+		Extra map[string]interface{} `msgpack:",extra"`
+	}
+
+	tests := map[string]struct {
+		v    interface{}
+		want []interface{}
+	}{
+		"String": {
+			v:    map[string]interface{}{"b": 2, "a": 1, "c": map[string]int{"z": 1, "y": 2}},
+			want: []interface{}{mapLen(3), "a", int64(1), "b", int64(2), "c", mapLen(2), "y", int64(2), "z", int64(1)},
+		},
+		"Int": {
+			v:    map[int]string{10: "c", -1: "a", 2: "b"},
+			want: []interface{}{mapLen(3), int64(-1), "a", int64(2), "b", int64(10), "c"},
+		},
+		"Uint": {
+			v:    map[uint8]bool{200: true, 3: false},
+			want: []interface{}{mapLen(2), int64(3), false, int64(200), true},
+		},
+		"Interface": {
+			v:    map[interface{}]int{"b": 1, 2: 2, "a": 3, 1: 4, nil: 5},
+			want: []interface{}{mapLen(5), nil, int64(5), int64(1), int64(4), int64(2), int64(2), "a", int64(3), "b", int64(1)},
+		},
+		"Extra": {
+			v:    &extraStruct{A: 1, Extra: map[string]interface{}{"y": 1, "x": 2}},
+			want: []interface{}{mapLen(3), "a", int64(1), "x", int64(2), "y", int64(1)},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			want, err := pack(tt.want...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Map iteration order is random, so repeat to catch unsorted output.
+			for i := 0; i < 20; i++ {
+				var buf bytes.Buffer
+				enc := NewEncoder(&buf)
+				enc.SortMapKeys(true)
+				if err := enc.Encode(tt.v); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf.Bytes(), want) {
+					t.Fatalf("got %x but want %x", buf.Bytes(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeStrTagInvalidType(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic for str field tag on string field")
+		}
+	}()
+
+	var buf bytes.Buffer
+	NewEncoder(&buf).Encode(struct {
+		S string `msgpack:"s,str"`
+	}{})
+}
+
+func TestStructArgs(t *testing.T) {
+	t.Parallel()
+
+	type Embedded struct {
+		E int
+	}
+	type args struct {
+		*Embedded
+		S       string
+		Skip    int `msgpack:"-"`
+		B       []byte
+		Str     []byte `msgpack:",str"`
+		private int
+		L       []string
+	}
+
+	tests := map[string]struct {
+		v    interface{}
+		want []interface{}
+	}{
+		"Struct": {
+			v: args{
+				Embedded: &Embedded{E: 1},
+				S:        "s",
+				Skip:     2,
+				B:        []byte("b"),
+				Str:      []byte("str"),
+				private:  3,
+				L:        []string{"a", "b"},
+			},
+			want: []interface{}{1, "s", []byte("b"), "str", []string{"a", "b"}},
+		},
+		"Pointer": {
+			v:    &args{Embedded: &Embedded{E: 1}, S: "s"},
+			want: []interface{}{1, "s", []byte(nil), "", []string(nil)},
+		},
+		"NilEmbedded": {
+			v:    args{S: "s"},
+			want: []interface{}{nil, "s", []byte(nil), "", []string(nil)},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := StructArgs(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("StructArgs(%#v) = %#v, want %#v", tt.v, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("ArrayEncoding", func(t *testing.T) {
+		t.Parallel()
+
+		type arrayArgs struct {
+			I int `msgpack:",array"`
+			S string
+			B []byte `msgpack:",str"`
+		}
+		v := arrayArgs{I: 1, S: "s", B: []byte("b")}
+
+		args, err := StructArgs(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want bytes.Buffer
+		if err := NewEncoder(&got).Encode(args); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewEncoder(&want).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("StructArgs encoding = %x, want %x", got.Bytes(), want.Bytes())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, v := range []interface{}{nil, 1, (*args)(nil), []int{1}} {
+			if _, err := StructArgs(v); err != ErrInvalidStructArgsArg {
+				t.Fatalf("StructArgs(%#v) returned %v, want %v", v, err, ErrInvalidStructArgsArg)
+			}
+		}
+	})
+}
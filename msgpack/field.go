@@ -11,6 +11,9 @@ type field struct {
 	name      string
 	omitEmpty bool
 	array     bool
+	str       bool
+	required  bool
+	extra     bool
 	index     []int
 	typ       reflect.Type
 	empty     reflect.Value
@@ -34,6 +37,9 @@ func collectFields(fields []*field, t reflect.Type, visited map[reflect.Type]boo
 			name      string
 			omitEmpty bool
 			array     bool
+			str       bool
+			required  bool
+			extra     bool
 		)
 		for i, p := range strings.Split(sf.Tag.Get("msgpack"), ",") {
 			if i == 0 {
@@ -42,6 +48,12 @@ func collectFields(fields []*field, t reflect.Type, visited map[reflect.Type]boo
 				omitEmpty = true
 			} else if p == "array" {
 				array = true
+			} else if p == "str" {
+				str = true
+			} else if p == "required" {
+				required = true
+			} else if p == "extra" {
+				extra = true
 			} else {
 				panic(fmt.Errorf("msgpack: unknown field tag %s for type %s", p, t.Name()))
 			}
@@ -52,6 +64,23 @@ func collectFields(fields []*field, t reflect.Type, visited map[reflect.Type]boo
 			continue
 		}
 
+		if str && (sf.Type.Kind() != reflect.Slice || sf.Type.Elem().Kind() != reflect.Uint8) {
+			panic(fmt.Errorf("msgpack: str field tag requires byte slice type for %s.%s", t.Name(), sf.Name))
+		}
+
+		if extra {
+			if sf.Type.Kind() != reflect.Map || sf.Type.Key().Kind() != reflect.String {
+				panic(fmt.Errorf("msgpack: extra field tag requires map with string keys for %s.%s", t.Name(), sf.Name))
+			}
+			fields = append(fields, &field{
+				name:  sf.Name,
+				extra: true,
+				index: append(append([]int(nil), index...), i),
+				typ:   sf.Type,
+			})
+			continue
+		}
+
 		ft := sf.Type
 		if ft.Name() == "" && ft.Kind() == reflect.Ptr {
 			ft = ft.Elem()
@@ -92,6 +121,8 @@ func collectFields(fields []*field, t reflect.Type, visited map[reflect.Type]boo
 			name:      name,
 			omitEmpty: omitEmpty,
 			array:     array,
+			str:       str,
+			required:  required,
 			index:     make([]int, len(index)+1),
 			typ:       sf.Type,
 		}
@@ -137,18 +168,33 @@ func collectFields(fields []*field, t reflect.Type, visited map[reflect.Type]boo
 	return fields
 }
 
-func fieldsForType(t reflect.Type) ([]*field, bool) {
-	fields := collectFields(nil, t, make(map[reflect.Type]bool), make(map[string]int), nil)
+// fieldsForType returns the fields of struct type t, the field with the
+// "extra" option, if any, and whether the struct is encoded as an array.
+func fieldsForType(t reflect.Type) ([]*field, *field, bool) {
+	all := collectFields(nil, t, make(map[reflect.Type]bool), make(map[string]int), nil)
+	fields := all[:0]
+	var extra *field
 	array := false
 
-	for _, field := range fields {
+	for _, field := range all {
+		if field.extra {
+			// The least nested extra field wins.
+			if extra == nil || len(field.index) < len(extra.index) {
+				extra = field
+			}
+			continue
+		}
 		if field.array {
 			array = true
-			break
 		}
+		fields = append(fields, field)
+	}
+
+	if extra != nil && array {
+		panic(fmt.Errorf("msgpack: extra field tag not supported for array struct %s", t.Name()))
 	}
 
-	return fields, array
+	return fields, extra, array
 }
 
 func fieldByIndex(v reflect.Value, index []int) reflect.Value {
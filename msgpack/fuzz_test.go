@@ -0,0 +1,104 @@
+//go:build go1.18
+// +build go1.18
+
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+type fuzzStruct struct {
+	B   bool                    `msgpack:"b"`
+	I   int8                    `msgpack:"i"`
+	U   uint16                  `msgpack:"u"`
+	F   float32                 `msgpack:"f"`
+	S   string                  `msgpack:"s"`
+	P   []byte                  `msgpack:"p"`
+	A   [2]int                  `msgpack:"a"`
+	L   []*fuzzStruct           `msgpack:"l"`
+	M   map[string]uint         `msgpack:"m"`
+	X   interface{}             `msgpack:"x"`
+	R   *int                    `msgpack:"r,required"`
+	Arr fuzzArrayStruct         `msgpack:"arr"`
+	Ext map[int]*testExtension1 `msgpack:"ext"`
+}
+
+type fuzzArrayStruct struct {
+	A int `msgpack:",array"`
+	B string
+	C []interface{}
+}
+
+// FuzzDecode checks that decoding arbitrary data returns an error instead of
+// panicking.
+func FuzzDecode(f *testing.F) {
+	for _, tt := range unpackTests {
+		for _, h := range tt.hs {
+			p, err := hex.DecodeString(h)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(p)
+		}
+	}
+	p, err := pack(
+		mapLen(4),
+		"l", arrayLen(1), mapLen(1), "s", "str",
+		"m", mapLen(1), "k", uint64(1),
+		"arr", arrayLen(3), int64(1), "b", arrayLen(1), nil,
+		"ext", mapLen(1), int64(1), extension{1, "ext"},
+	)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(p)
+
+	// Lengths that are larger than the data in the stream.
+	f.Add([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xdf, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xdb, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xc6, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xc9, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add([]byte{0x81, 0xa1, 0x6c, 0xdd, 0xff, 0xff, 0xff, 0xff})
+
+	// A non-array value for an array field.
+	f.Add([]byte("\x81\xa1a\xff0"))
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		for _, limit := range []int64{0, 1 << 10} {
+			dec := NewDecoder(bytes.NewReader(p))
+			if limit > 0 {
+				dec.SetLimit(limit)
+			}
+			for dec.More() {
+				var x interface{}
+				if err := dec.Decode(&x); err != nil {
+					if _, ok := err.(*DecodeConvertError); !ok {
+						break
+					}
+				}
+			}
+
+			dec = NewDecoder(bytes.NewReader(p))
+			dec.SetExtensions(testExtensionMap)
+			dec.SetMapBuilder(InterfaceKeyMapBuilder)
+			if limit > 0 {
+				dec.SetLimit(limit)
+			}
+			var s fuzzStruct
+			dec.Decode(&s)
+
+			dec = NewDecoder(bytes.NewReader(p))
+			if limit > 0 {
+				dec.SetLimit(limit)
+			}
+			for dec.Unpack() == nil {
+				if dec.Skip() != nil {
+					break
+				}
+			}
+		}
+	})
+}
@@ -46,6 +46,9 @@ const (
 	negFixIntCodeMax = 0xff
 )
 
+// timestampExtension is the extension type of the MessagePack timestamp.
+const timestampExtension = -1
+
 type aborted struct{ err error }
 
 func abort(err error) { panic(aborted{err}) }
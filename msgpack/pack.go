@@ -1,9 +1,11 @@
 package msgpack
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"math"
+	"time"
 )
 
 var (
@@ -20,6 +22,7 @@ type Encoder struct {
 	w           io.Writer
 	writeString func(string) (int, error)
 	err         error // permanent error
+	sortKeys    bool
 }
 
 // NewEncoder allocates and initializes a new Unpacker.
@@ -39,6 +42,16 @@ func NewEncoder(w io.Writer) *Encoder {
 	return e
 }
 
+// SortMapKeys specifies whether Encode writes the entries of Go maps in sorted
+// key order. String keys are sorted lexically and numeric keys numerically.
+// Keys of an interface type are ordered by the kind of the dynamic value
+// first. Sorting makes the encoding of maps reproducible, for example for
+// golden files and hashing, at the cost of speed. Keys are not sorted by
+// default.
+func (e *Encoder) SortMapKeys(v bool) {
+	e.sortKeys = v
+}
+
 func (e *Encoder) writeStringUnopt(s string) (int, error) {
 	if len(s) <= len(e.buf) {
 		copy(e.buf[:], s)
@@ -351,6 +364,33 @@ func (e *Encoder) PackExtension(kind int, data []byte) error {
 	return err
 }
 
+// PackTime writes t to the MessagePack stream as a timestamp extension (type
+// -1). The 32-bit format is used for whole seconds from 1970 to 2106, the
+// 64-bit format for other times from 1970 to 2514 and the 96-bit format for
+// all other times, including times before 1970.
+//
+// The timestamp holds the Unix time in seconds and nanoseconds. The location
+// and monotonic clock reading of t are not encoded.
+func (e *Encoder) PackTime(t time.Time) error {
+	var b [12]byte
+	sec := t.Unix()
+	nsec := uint32(t.Nanosecond())
+	var data []byte
+	switch {
+	case sec>>32 == 0 && nsec == 0:
+		binary.BigEndian.PutUint32(b[:4], uint32(sec))
+		data = b[:4]
+	case sec>>34 == 0:
+		binary.BigEndian.PutUint64(b[:8], uint64(nsec)<<34|uint64(sec))
+		data = b[:8]
+	default:
+		binary.BigEndian.PutUint32(b[:4], nsec)
+		binary.BigEndian.PutUint64(b[4:], uint64(sec))
+		data = b[:12]
+	}
+	return e.PackExtension(timestampExtension, data)
+}
+
 // PackNil writes a Nil value to the MessagePack stream.
 func (e *Encoder) PackNil() error {
 	e.buf[0] = nilCode
@@ -1,10 +1,12 @@
 package msgpack
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestPack(t *testing.T) {
@@ -282,3 +284,151 @@ func TestPack(t *testing.T) {
 		})
 	}
 }
+
+func TestTime(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		t  time.Time
+		hs string
+	}{
+		"32/Epoch": {
+			t:  time.Unix(0, 0),
+			hs: "d6ff00000000",
+		},
+		"32/Max": {
+			t:  time.Unix(1<<32-1, 0),
+			hs: "d6ffffffffff",
+		},
+		"64/Nanoseconds": {
+			t:  time.Unix(1, 1),
+			hs: "d7ff0000000400000001",
+		},
+		"64/Max": {
+			t:  time.Unix(1<<34-1, 999999999),
+			hs: "d7ffee6b27ffffffffff",
+		},
+		"96/After64": {
+			t:  time.Unix(1<<34, 0),
+			hs: "c70cff000000000000000400000000",
+		},
+		"96/BeforeEpoch": {
+			t:  time.Unix(-1, 0),
+			hs: "c70cff00000000ffffffffffffffff",
+		},
+		"96/BeforeEpochNanoseconds": {
+			t:  time.Unix(0, -500000000),
+			hs: "c70cff1dcd6500ffffffffffffffff",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).PackTime(tt.t); err != nil {
+				t.Fatal(err)
+			}
+			if h := hex.EncodeToString(buf.Bytes()); h != tt.hs {
+				t.Fatalf("PackTime(%v) wrote %s, want %s", tt.t, h, tt.hs)
+			}
+
+			d := NewDecoder(&buf)
+			if err := d.Unpack(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := d.Time()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.t) {
+				t.Fatalf("Time() returned %v, want %v", got, tt.t)
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		for _, h := range []string{
+			"c0",                   // nil
+			"d60100000000",         // other extension type
+			"d5ff0000",             // invalid length
+			"d7ffffffffff00000000", // nanoseconds out of range
+		} {
+			p, err := hex.DecodeString(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewDecoder(bytes.NewReader(p))
+			if err := d.Unpack(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.Time(); err == nil {
+				t.Fatalf("Time() for %s returned nil error", h)
+			}
+		}
+	})
+
+	t.Run("Encode", func(t *testing.T) {
+		t.Parallel()
+
+		type event struct {
+			Name string    `msgpack:"name"`
+			At   Timestamp `msgpack:"at"`
+		}
+		want := event{Name: "write", At: Timestamp{time.Date(2021, 7, 1, 12, 30, 0, 123456789, time.UTC)}}
+
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(&want); err != nil {
+			t.Fatal(err)
+		}
+		var got event
+		if err := NewDecoder(&buf).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != want.Name || !got.At.Equal(want.At.Time) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("TimeIsStruct", func(t *testing.T) {
+		t.Parallel()
+
+		// A time.Time without the Timestamp wrapper keeps the struct
+		// encoding.
+		p, err := Marshal(time.Unix(1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte{0x80}; !bytes.Equal(p, want) {
+			t.Fatalf("time.Time encoded as %x, want %x", p, want)
+		}
+	})
+
+	t.Run("DecodeTimestamp", func(t *testing.T) {
+		t.Parallel()
+
+		var ts Timestamp
+		if err := Unmarshal([]byte{0xd6, 0xff, 0x00, 0x00, 0x00, 0x01}, &ts); err != nil {
+			t.Fatal(err)
+		}
+		if want := time.Unix(1, 0); !ts.Equal(want) {
+			t.Fatalf("got %v, want %v", ts, want)
+		}
+		if err := Unmarshal([]byte{0xc0}, &ts); err != nil || !ts.IsZero() {
+			t.Fatalf("nil decoded as %v, %v", ts, err)
+		}
+		dec := NewDecoder(bytes.NewReader([]byte{0x91, 0x01}))
+		if err := dec.Unpack(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ts.UnmarshalMsgPack(dec); err == nil {
+			t.Fatal("array decoded as a timestamp without an error")
+		}
+		if dec.More() {
+			t.Fatal("array elements were not skipped")
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package msgpack
+
+import "errors"
+
+// RawMessage is a raw encoded MessagePack value. It implements Marshaler and
+// Unmarshaler and can be used to delay decoding a value or to forward a value
+// without decoding it.
+//
+// Decoding into a RawMessage stores a copy of the encoding of the value,
+// including the nested values of an array or a map. Encoding a RawMessage
+// writes the stored encoding verbatim. An empty RawMessage encodes as the
+// MessagePack nil value.
+type RawMessage []byte
+
+// compile time check whether the RawMessage implements Marshaler and Unmarshaler interfaces.
+var (
+	_ Marshaler   = RawMessage(nil)
+	_ Unmarshaler = (*RawMessage)(nil)
+)
+
+// MarshalMsgPack implements Marshaler.
+func (m RawMessage) MarshalMsgPack(e *Encoder) error {
+	if len(m) == 0 {
+		return e.PackNil()
+	}
+	return e.PackRaw(m)
+}
+
+// UnmarshalMsgPack implements Unmarshaler.
+func (m *RawMessage) UnmarshalMsgPack(d *Decoder) error {
+	if m == nil {
+		return errors.New("msgpack.RawMessage: UnmarshalMsgPack on nil pointer")
+	}
+	p, err := d.appendRaw((*m)[:0])
+	if err != nil {
+		return err
+	}
+	*m = p
+	return nil
+}
@@ -0,0 +1,108 @@
+package msgpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRawMessage(t *testing.T) {
+	t.Parallel()
+
+	// A binary value larger than the buffer of the decoder.
+	const n = bufioReaderSize + 1
+	large := make([]byte, 3+n)
+	large[0], large[1], large[2] = binary16Code, n>>8, n&0xff
+
+	// The values are encoded with longer formats than needed to check that
+	// the encoding is captured exactly.
+	values := [][]byte{
+		{nilCode},
+		{trueCode},
+		{uint32Code, 0, 0, 0, 1},
+		{int16Code, 0xff, 0xfe},
+		{float32Code, 0x3f, 0x80, 0, 0},
+		{string8Code, 2, 'h', 'i'},
+		{fixExt1Code, 5, 0xaa},
+		{ext8Code, 2, 0x7f, 1, 2},
+		{array16Code, 0, 2, 0x01, fixArrayCodeMin + 1, string8Code, 1, 'x'},
+		{map32Code, 0, 0, 0, 1, fixStringCodeMin + 1, 'k', fixMapCodeMin},
+		large,
+	}
+
+	var stream []byte
+	stream = append(stream, fixArrayCodeMin+byte(len(values)))
+	for _, v := range values {
+		stream = append(stream, v...)
+	}
+
+	var got []RawMessage
+	if err := NewDecoder(bytes.NewReader(stream)).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(values) {
+		t.Fatalf("got %d values, want %d", len(got), len(values))
+	}
+	for i, v := range values {
+		if !bytes.Equal(got[i], v) {
+			t.Errorf("value %d: got %x, want %x", i, got[i], v)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), stream) {
+		t.Errorf("encoded values differ from the stream")
+	}
+}
+
+func TestRawMessageField(t *testing.T) {
+	t.Parallel()
+
+	type message struct {
+		Method string
+		Params RawMessage
+		Empty  RawMessage
+	}
+
+	p, err := pack(mapLen(2), "Method", "m", "Params", arrayLen(2), "a", mapLen(1), "k", int64(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m message
+	if err := NewDecoder(bytes.NewReader(p)).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := pack(arrayLen(2), "a", mapLen(1), "k", int64(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Method != "m" || !bytes.Equal(m.Params, params) || m.Empty != nil {
+		t.Fatalf("got %+v, want Method m and Params %x", m, params)
+	}
+
+	// The raw value can be decoded later.
+	var v []interface{}
+	if err := NewDecoder(bytes.NewReader(m.Params)).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"a", map[string]interface{}{"k": int64(-1)}}; !reflect.DeepEqual(v, want) {
+		t.Fatalf("decoded params %v, want %v", v, want)
+	}
+
+	// An empty raw value is encoded as nil.
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(&m); err != nil {
+		t.Fatal(err)
+	}
+	want, err := pack(mapLen(3), "Method", "m", "Params", arrayLen(2), "a", mapLen(1), "k", int64(-1), "Empty", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("encoded %x, want %x", buf.Bytes(), want)
+	}
+}
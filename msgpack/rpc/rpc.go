@@ -3,11 +3,16 @@ package rpc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/neovim/go-client/msgpack"
 )
@@ -28,6 +33,7 @@ type state int
 // list of state.
 const (
 	stateInit state = iota
+	stateShutdown
 	stateClosed
 )
 
@@ -35,6 +41,10 @@ var (
 	// ErrClosed session closed error.
 	ErrClosed = errors.New("msgpack/rpc: session closed")
 
+	// ErrPeerClosed is returned by Serve when the peer closes the connection
+	// normally. It wraps io.EOF.
+	ErrPeerClosed = fmt.Errorf("msgpack/rpc: peer closed the connection: %w", io.EOF)
+
 	// ErrInternal msgpack-rpc internal error.
 	ErrInternal = errors.New("msgpack/rpc: internal error")
 
@@ -44,8 +54,23 @@ var (
 	// ErrInvalidHandlerReturn invalid handler function return type error.
 	ErrInvalidHandlerReturn = errors.New("msgpack/rpc: handler return must be (), (error) or (valueType, error)")
 
+	// ErrInvalidHandlerArg invalid handler function argument type error.
+	ErrInvalidHandlerArg = errors.New("msgpack/rpc: handler argument cannot be decoded")
+
 	// ErrInvalidArgument invalid argument error.
 	ErrInvalidArgument = errors.New("msgpack/rpc: invalid argument")
+
+	// ErrCallTimeout is returned by a call that did not receive a response
+	// within the timeout set with WithCallTimeout.
+	ErrCallTimeout = errors.New("msgpack/rpc: call timeout")
+
+	// ErrShutdown is returned to the peer for requests received after
+	// Shutdown was called.
+	ErrShutdown = errors.New("msgpack/rpc: endpoint shutting down")
+
+	// ErrInvalidMessage is wrapped by the errors returned from Serve for
+	// messages that do not follow the MessagePack RPC protocol.
+	ErrInvalidMessage = errors.New("msgpack/rpc: invalid message")
 )
 
 // Error represents a MessagePack RPC error.
@@ -58,6 +83,22 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%v", e.Value)
 }
 
+// MsgpackError is the interface implemented by errors with a structured
+// payload. When a service method returns an error that implements
+// MsgpackError, or wraps one, the endpoint sends the array [code, data] as the
+// error value of the response instead of the error string. The peer receives
+// the array as the Value of an Error.
+type MsgpackError interface {
+	error
+
+	// ErrorCode returns the code of the error.
+	ErrorCode() int
+
+	// ErrorData returns the data of the error. The data is encoded with the
+	// MessagePack encoder.
+	ErrorData() interface{}
+}
+
 // Call represents a MessagePack RPC call.
 type Call struct {
 	Args   interface{}
@@ -65,21 +106,53 @@ type Call struct {
 	Err    error
 	Done   chan *Call
 	Method string
+
+	id uint64
 }
 
 func (c *Call) done(e *Endpoint, err error) {
 	c.Err = err
+	if err != nil {
+		atomic.AddInt64(&e.stats.errors, 1)
+	}
 	select {
 	case c.Done <- c:
 		// ok
 	default:
-		e.logf("msgpack/rpc: done channel over capacity for method %s", c.Method)
+		e.logger.Errorf("msgpack/rpc: done channel over capacity for method %s", c.Method)
+	}
+}
+
+// rawArgs represents pre-encoded call or notification arguments.
+type rawArgs []byte
+
+// check returns an error wrapping ErrInvalidArgument if a is not exactly one
+// complete MessagePack array.
+func (a rawArgs) check() error {
+	dec := msgpack.NewDecoder(bytes.NewReader(a))
+	if err := dec.Unpack(); err != nil || dec.Type() != msgpack.ArrayLen {
+		return fmt.Errorf("%w: raw arguments are not a MessagePack array", ErrInvalidArgument)
 	}
+	if err := dec.Skip(); err != nil {
+		return fmt.Errorf("%w: raw arguments are not a complete MessagePack array", ErrInvalidArgument)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: data after the raw arguments array", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// MarshalMsgPack implements msgpack.Marshaler.
+func (a rawArgs) MarshalMsgPack(enc *msgpack.Encoder) error {
+	return enc.PackRaw(a)
 }
 
 type handler struct {
 	fn   reflect.Value
 	args []reflect.Value
+
+	// decode is set for handlers registered with RegisterNotificationDecoder.
+	decode func(dec *msgpack.Decoder, n int) error
 }
 
 type notification struct {
@@ -89,29 +162,115 @@ type notification struct {
 	args   []reflect.Value
 }
 
+// Stats represents a snapshot of the Endpoint counters.
+type Stats struct {
+	// Calls is the number of calls made by the endpoint.
+	Calls int64
+
+	// InFlightCalls is the number of calls waiting for a reply.
+	InFlightCalls int64
+
+	// NotificationsSent is the number of notifications sent by the endpoint.
+	NotificationsSent int64
+
+	// NotificationsReceived is the number of notifications received from the peer.
+	NotificationsReceived int64
+
+	// RequestsReceived is the number of requests received from the peer.
+	RequestsReceived int64
+
+	// RepliesSent is the number of replies sent to requests from the peer.
+	RepliesSent int64
+
+	// RepliesReceived is the number of replies received for calls made by
+	// the endpoint, including replies received after the call timed out.
+	RepliesReceived int64
+
+	// HandlerCalls is the number of handler invocations for requests and
+	// notifications received from the peer.
+	HandlerCalls int64
+
+	// Errors is the number of calls completed with an error, handlers that
+	// returned an error and requests for unknown methods.
+	Errors int64
+
+	// BytesRead and BytesWritten are the number of bytes read from and
+	// written to the peer. The byte counts are only tracked by endpoints
+	// created with the WithByteCounters option.
+	BytesRead    int64
+	BytesWritten int64
+}
+
+// endpointStats holds the Endpoint counters.
+type endpointStats struct {
+	calls                 int64
+	notificationsSent     int64
+	notificationsReceived int64
+	handlerCalls          int64
+	errors                int64
+	requestsReceived      int64
+	repliesSent           int64
+	repliesReceived       int64
+	bytesRead             int64
+	bytesWritten          int64
+}
+
 // Endpoint represents a MessagePack RPC peer.
+//
+// Messages are written to the peer one at a time. Call, CallRaw, Go, Notify
+// and NotifyRaw write the message before returning, so the messages sent from
+// a single goroutine are written in the order of the calls, and a peer that
+// processes messages in order, such as Nvim, processes them in that order. The
+// order of messages sent concurrently from different goroutines is
+// unspecified. Replies are matched to calls by ID and may arrive in any order.
 type Endpoint struct {
-	err  error
-	logf func(fmt string, args ...interface{})
+	// stats is the first field to guarantee 64-bit alignment for atomic access.
+	stats endpointStats
+
+	err          error
+	logger       Logger
+	debug        bool // log messages with logger.Debugf
+	panicHandler func(method string, value interface{}, stack []byte)
+	nextID       func() uint64
+	callTimeout  time.Duration
+	synchronous  bool
+	onDisconnect func(err error)
+	countBytes   bool
+	bufferPool   msgpack.BufferPool
+
+	// handlerLimit is the maximum number of request handlers running
+	// concurrently, or 0 for no limit. Requests received while the limit is
+	// reached wait in requestQueue.
+	handlerLimit    int
+	runningRequests int
+	requestQueue    []*request
+	requestsMu      sync.Mutex
 
 	done   chan struct{}
 	closer io.Closer
-	bw     *bufio.Writer
+	bw     flushWriter
 	enc    *msgpack.Encoder
 	dec    *msgpack.Decoder
 
+	// extensions holds the msgpack.ExtensionMap for dec. The map is replaced
+	// by RegisterExtension and installed on dec by Serve.
+	extensions   atomic.Value
+	extensionsMu sync.Mutex // serializes RegisterExtension
+
 	handlers          map[string]*handler
 	pending           map[uint64]*Call
 	notificationsCond *sync.Cond
 
-	arg           reflect.Value
-	notifications []*notification
-	state         state
-	id            uint64
+	arg            reflect.Value
+	notifications  []*notification
+	state          state
+	active         sync.WaitGroup // handler calls started in stateInit
+	id             uint64
+	maxMessageSize int64
 
 	mu              sync.Mutex
 	handlersMu      sync.RWMutex
-	encMu           sync.Mutex
+	encMu           sync.Mutex // serializes writes of whole messages
 	notificationsMu sync.Mutex
 }
 
@@ -121,34 +280,309 @@ type Option struct{ f func(*Endpoint) }
 // WithExtensions configures Endpoint to define application-specific types.
 func WithExtensions(extensions msgpack.ExtensionMap) Option {
 	return Option{func(e *Endpoint) {
-		e.dec.SetExtensions(extensions)
+		e.extensions.Store(extensions)
+	}}
+}
+
+// Logger is the interface for the leveled logging of an Endpoint.
+//
+// The endpoint logs the messages sent to and received from the peer with
+// Debugf, expected conditions such as notifications for unknown methods and
+// late replies with Infof, and protocol errors, decode failures, handler errors
+// and panics with Errorf.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// WithLogger sets the logger of the Endpoint.
+func WithLogger(logger Logger) Option {
+	return Option{func(e *Endpoint) {
+		e.logger = logger
+		e.debug = logger != nil
 	}}
 }
 
-// WithLogf sets the log function to Endpoint.
+// WithLogf sets the log function to Endpoint. The function receives the
+// messages logged at the info and error levels. Use WithLogger to receive the
+// debug messages for each message sent and received.
 func WithLogf(f func(fmt string, args ...interface{})) Option {
 	return Option{func(e *Endpoint) {
-		e.logf = f
+		e.logger = logfLogger(f)
+		e.debug = false
+	}}
+}
+
+// logfLogger is the Logger for a log function. Debug messages are discarded.
+type logfLogger func(fmt string, args ...interface{})
+
+func (f logfLogger) Debugf(format string, args ...interface{}) {}
+
+func (f logfLogger) Infof(format string, args ...interface{}) {
+	if f != nil {
+		f(format, args...)
+	}
+}
+
+func (f logfLogger) Errorf(format string, args ...interface{}) {
+	if f != nil {
+		f(format, args...)
+	}
+}
+
+// WithPanicHandler sets the function called when a service method panics.
+//
+// The endpoint recovers panics in service methods and notification decoders
+// and logs the panic value and stack. For a request, the endpoint replies to
+// the caller with an error describing the panic. A panic in a notification
+// decoder leaves the decoder in an unknown position in the input, so Serve
+// returns an error and closes the endpoint.
+//
+// If f is not nil, the endpoint also calls f with the method name, the value
+// passed to panic and the stack of the panicking goroutine. Panics in f are
+// not recovered.
+func WithPanicHandler(f func(method string, value interface{}, stack []byte)) Option {
+	return Option{func(e *Endpoint) {
+		e.panicHandler = f
+	}}
+}
+
+// WithIDAllocator sets the function that returns the ID of each request sent
+// by the endpoint. The function is called with a lock held, so calls are
+// serialized. The IDs should fit in 32 bits to interoperate with all peers.
+//
+// If the returned ID is in use by a pending request, the call fails with an
+// error. By default, IDs are allocated sequentially starting from 1.
+func WithIDAllocator(f func() uint64) Option {
+	return Option{func(e *Endpoint) {
+		e.nextID = f
+	}}
+}
+
+// WithCallTimeout sets the maximum time that Call, CallRaw and CallContext
+// wait for a response. When the timeout expires, the call returns
+// ErrCallTimeout and the response, when it arrives, is discarded. The request
+// is not canceled in the peer.
+//
+// CallContext does not apply the timeout if the context has a deadline, so
+// the timeout can be overridden for a single call. A zero timeout, the
+// default, waits forever.
+func WithCallTimeout(d time.Duration) Option {
+	return Option{func(e *Endpoint) {
+		e.callTimeout = d
+	}}
+}
+
+// WithMaxMessageSize limits the size in bytes of incoming messages to n.
+//
+// Serve returns an error and closes the endpoint when a message exceeds the
+// limit. Values are checked against the limit before they are read, so a peer
+// cannot exhaust memory by declaring an enormous string or array.
+func WithMaxMessageSize(n int) Option {
+	return Option{func(e *Endpoint) {
+		e.maxMessageSize = int64(n)
+	}}
+}
+
+// WithBufferPool sets the pool for the buffers used to read large string,
+// binary and extension values. See msgpack.Decoder.SetBufferPool.
+//
+// Arguments decoded for handlers registered with Register are copied out of
+// the buffers. Decoders registered with RegisterNotificationDecoder must copy
+// the data returned by BytesNoCopy before reading the next value.
+func WithBufferPool(pool msgpack.BufferPool) Option {
+	return Option{func(e *Endpoint) {
+		e.bufferPool = pool
+	}}
+}
+
+// WithSynchronousDispatch runs the handlers registered with Register on the
+// goroutine running Serve, one at a time in the order the requests and
+// notifications are received, instead of running each request on a new
+// goroutine and the notifications on a separate goroutine.
+//
+// While a handler runs, the endpoint does not read from the peer, so replies
+// to calls are not received. A handler must not call the peer with Call,
+// CallRaw or CallContext, which would deadlock. Notify and Go can be used,
+// but Go calls do not complete until the handler returns.
+func WithSynchronousDispatch() Option {
+	return Option{func(e *Endpoint) {
+		e.synchronous = true
+	}}
+}
+
+// WithHandlerConcurrency limits the number of handlers for requests from the
+// peer that run concurrently to n. Requests received while n handlers are
+// running wait in a queue and are handled in the order they were received, so
+// a burst of requests does not start a goroutine for each request. Serve keeps
+// reading messages while requests wait, so running handlers can call the peer.
+//
+// Notifications are not affected by the limit. The handlers for notifications
+// run one at a time in the order the notifications are received.
+//
+// If n is 0, the default, the handler for each request runs on a new goroutine
+// without a limit. The limit does not apply to endpoints using
+// WithSynchronousDispatch.
+func WithHandlerConcurrency(n int) Option {
+	return Option{func(e *Endpoint) {
+		e.handlerLimit = n
+	}}
+}
+
+// WithOnDisconnect specifies a function called once when Serve returns. The
+// function is called with io.EOF if the peer disconnected normally, with
+// ErrClosed if the endpoint was closed locally, for example with Close or
+// Shutdown, and with the error returned from the transport or the decoder
+// otherwise.
+//
+// The function is called on the goroutine running Serve after the endpoint is
+// closed.
+func WithOnDisconnect(f func(err error)) Option {
+	return Option{func(e *Endpoint) {
+		e.onDisconnect = f
+	}}
+}
+
+// WithByteCounters enables counting the bytes read from and written to the
+// peer, see Stats. The counters are disabled by default to avoid the overhead
+// on every read and write.
+func WithByteCounters() Option {
+	return Option{func(e *Endpoint) {
+		e.countBytes = true
 	}}
 }
 
 // NewEndpoint returns a new endpoint with the specified options.
 func NewEndpoint(r io.Reader, w io.Writer, c io.Closer, options ...Option) (*Endpoint, error) {
-	bw := bufio.NewWriter(w)
+	return newEndpoint(r, bufio.NewWriter(w), c, options), nil
+}
+
+// FrameReader is the interface implemented by transports that receive
+// messages in frames.
+type FrameReader interface {
+	// ReadFrame returns the next frame. The frame holds one or more complete
+	// MessagePack RPC messages, or a part of a message that is continued in
+	// the following frames. The returned slice must not be modified until
+	// the next call to ReadFrame.
+	ReadFrame() ([]byte, error)
+}
+
+// FrameWriter is the interface implemented by transports that send messages
+// in frames.
+type FrameWriter interface {
+	// WriteFrame sends p, which holds exactly one MessagePack RPC message.
+	// WriteFrame must not retain p. Calls to WriteFrame are serialized by the
+	// endpoint.
+	WriteFrame(p []byte) error
+}
+
+// NewFramedEndpoint returns a new endpoint that reads messages from r and
+// writes messages to w, with the specified options. Use NewFramedEndpoint to
+// run MessagePack RPC over a transport with its own framing, for example to
+// multiplex several endpoints over a single connection.
+func NewFramedEndpoint(r FrameReader, w FrameWriter, c io.Closer, options ...Option) (*Endpoint, error) {
+	return newEndpoint(&frameReader{r: r}, &frameWriter{w: w}, c, options), nil
+}
+
+func newEndpoint(r io.Reader, bw flushWriter, c io.Closer, options []Option) *Endpoint {
 	e := &Endpoint{
 		done:     make(chan struct{}),
 		handlers: make(map[string]*handler),
 		pending:  make(map[uint64]*Call),
 		closer:   c,
-		bw:       bw,
-		enc:      msgpack.NewEncoder(bw),
-		dec:      msgpack.NewDecoder(r),
 	}
 	for _, option := range options {
 		option.f(e)
 	}
-	return e, nil
+	if e.logger == nil {
+		e.logger = logfLogger(nil)
+	}
+
+	if e.countBytes {
+		r = &countingReader{r: r, n: &e.stats.bytesRead}
+		bw = &countingWriter{flushWriter: bw, n: &e.stats.bytesWritten}
+	}
+	e.bw = bw
+	e.enc = msgpack.NewEncoder(bw)
+	e.dec = msgpack.NewDecoder(r)
+	if extensions, ok := e.extensions.Load().(msgpack.ExtensionMap); ok {
+		e.dec.SetExtensions(extensions)
+	}
+	if e.bufferPool != nil {
+		e.dec.SetBufferPool(e.bufferPool)
+	}
+	return e
+}
+
+// countingReader counts the bytes read from r in n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(cr.n, int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to the flushWriter in n.
+type countingWriter struct {
+	flushWriter
+	n *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.flushWriter.Write(p)
+	atomic.AddInt64(cw.n, int64(n))
+	return n, err
+}
+
+// flushWriter is the buffered writer of an Endpoint. The endpoint calls Flush
+// after writing each message.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// frameReader adapts a FrameReader to an io.Reader.
+type frameReader struct {
+	r FrameReader
+	p []byte
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	for len(fr.p) == 0 {
+		frame, err := fr.r.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		fr.p = frame
+	}
+	n := copy(p, fr.p)
+	fr.p = fr.p[n:]
+	return n, nil
+}
 
+// frameWriter adapts a FrameWriter to a flushWriter. The written message is
+// buffered until Flush.
+type frameWriter struct {
+	w   FrameWriter
+	buf bytes.Buffer
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	return fw.buf.Write(p)
+}
+
+func (fw *frameWriter) Flush() error {
+	if fw.buf.Len() == 0 {
+		return nil
+	}
+	err := fw.w.WriteFrame(fw.buf.Bytes())
+	fw.buf.Reset()
+	return err
 }
 
 func (e *Endpoint) decodeUint(what string) (uint64, error) {
@@ -157,7 +591,7 @@ func (e *Endpoint) decodeUint(what string) (uint64, error) {
 	}
 	t := e.dec.Type()
 	if t != msgpack.Uint && t != msgpack.Int {
-		return 0, fmt.Errorf("msgpack/rpc: error decoding %s, found %s", what, e.dec.Type())
+		return 0, fmt.Errorf("%w: error decoding %s, found %s", ErrInvalidMessage, what, e.dec.Type())
 	}
 	return e.dec.Uint(), nil
 }
@@ -167,7 +601,7 @@ func (e *Endpoint) decodeString(what string) (string, error) {
 		return "", err
 	}
 	if e.dec.Type() != msgpack.String {
-		return "", fmt.Errorf("msgpack/rpc: error decoding %s, found %s", what, e.dec.Type())
+		return "", fmt.Errorf("%w: error decoding %s, found %s", ErrInvalidMessage, what, e.dec.Type())
 	}
 	return e.dec.String(), nil
 }
@@ -186,27 +620,39 @@ func (e *Endpoint) skip(n int) error {
 
 // Serve serves incoming requests. Serve blocks until the peer disconnects or
 // there is an error.
+//
+// Serve returns ErrPeerClosed if the peer disconnects normally, that is the
+// endpoint reads io.EOF at a message boundary, and nil if the endpoint was
+// closed with Close or Shutdown. Otherwise, Serve returns the error that
+// stopped it.
 func (e *Endpoint) Serve() error {
 	e.notificationsCond = sync.NewCond(&e.notificationsMu)
 	defer e.enqueNotification(nil)
 	go e.runNotifications()
 
 	for {
+		if e.maxMessageSize > 0 {
+			e.dec.SetLimit(e.maxMessageSize)
+		}
+
 		if err := e.dec.Unpack(); err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return e.close(err)
+			return e.disconnect(e.wrapLimitError(err))
+		}
+
+		// Install the extensions registered before the message was
+		// received.
+		if extensions, ok := e.extensions.Load().(msgpack.ExtensionMap); ok {
+			e.dec.SetExtensions(extensions)
 		}
 
 		messageLen := e.dec.Len()
 		if messageLen < 1 {
-			return e.close(fmt.Errorf("msgpack/rpc: invalid message length %d", messageLen))
+			return e.disconnect(fmt.Errorf("%w length %d", ErrInvalidMessage, messageLen))
 		}
 
 		messageType, err := e.decodeUint("message type")
 		if err != nil {
-			return e.close(err)
+			return e.disconnect(err)
 		}
 
 		switch kind(messageType) {
@@ -217,14 +663,50 @@ func (e *Endpoint) Serve() error {
 		case notificationMessage:
 			err = e.handleNotification(messageLen)
 		default:
-			err = fmt.Errorf("msgpack/rpc: unknown message type %d", messageType)
+			err = fmt.Errorf("%w: unknown message type %d", ErrInvalidMessage, messageType)
 		}
 		if err != nil {
-			return e.close(err)
+			return e.disconnect(e.wrapLimitError(err))
 		}
 	}
 }
 
+// disconnect closes the endpoint when Serve stops with the cause err and calls
+// the disconnect function.
+func (e *Endpoint) disconnect(err error) error {
+	e.mu.Lock()
+	closed := e.state == stateClosed
+	e.mu.Unlock()
+
+	cause := err
+	if err == io.EOF {
+		err = nil
+	} else if !closed {
+		e.logger.Errorf("msgpack/rpc: %v", err)
+	}
+	err = e.close(err)
+
+	if e.onDisconnect != nil {
+		if closed {
+			cause = ErrClosed
+		}
+		e.onDisconnect(cause)
+	}
+	if err == nil && cause == io.EOF && !closed {
+		err = ErrPeerClosed
+	}
+	return err
+}
+
+// wrapLimitError adds the maximum message size to err if err is caused by a
+// message exceeding the size.
+func (e *Endpoint) wrapLimitError(err error) error {
+	if errors.Is(err, msgpack.ErrLimitExceeded) {
+		return fmt.Errorf("msgpack/rpc: message exceeds maximum size of %d bytes: %w", e.maxMessageSize, err)
+	}
+	return err
+}
+
 func (e *Endpoint) close(err error) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -249,20 +731,75 @@ func (e *Endpoint) Close() error {
 	return e.close(nil)
 }
 
+// Shutdown gracefully closes the endpoint. Shutdown stops running handlers for
+// new requests and notifications, waits for the running handlers to return and
+// then closes the endpoint. Requests received during the shutdown are answered
+// with ErrShutdown. Calls to the peer work until the endpoint is closed, so
+// handlers can complete calls in progress.
+//
+// If ctx is done before the handlers return, Shutdown closes the endpoint
+// and returns the context's error. Shutdown must not be called from a handler
+// on an endpoint using WithSynchronousDispatch, because the handler blocks
+// Serve.
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if e.state == stateInit {
+		e.state = stateShutdown
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return e.close(nil)
+	case <-ctx.Done():
+		e.close(nil)
+		return ctx.Err()
+	}
+}
+
+// startHandler reports whether a handler call can start and adds it to the
+// active calls waited for by Shutdown. The caller must call e.active.Done when
+// the handler returns.
+func (e *Endpoint) startHandler() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.state != stateInit {
+		return false
+	}
+	e.active.Add(1)
+	return true
+}
+
 var errorType = reflect.ValueOf(new(error)).Elem().Type()
 
 // Register registers handler fn for the specified method name.
 //
 // When servicing a call, the arguments to fn are the values in args followed
 // by the values passed from the peer.
+//
+// The function fn must return (), (error) or (valueType, error). Register
+// returns an error naming the method and the type of fn if fn is not a
+// function, if fn does not return one of these, or if an argument or the
+// result is a channel, function or unsafe pointer, which cannot be
+// transferred over MessagePack RPC. The error wraps ErrHandlerNotFunction,
+// ErrInvalidHandlerReturn or ErrInvalidHandlerArg.
 func (e *Endpoint) Register(method string, fn interface{}, args ...interface{}) error {
+	if fn == nil {
+		return fmt.Errorf("%w: method %s, handler is nil", ErrHandlerNotFunction, method)
+	}
 	v := reflect.ValueOf(fn)
 	t := v.Type()
 	if t.Kind() != reflect.Func {
-		return ErrHandlerNotFunction
+		return fmt.Errorf("%w: method %s, handler type %s", ErrHandlerNotFunction, method, t)
 	}
 	if t.NumIn() < len(args) {
-		return fmt.Errorf("msgpack/rpc: handler must have at least %d args", len(args))
+		return fmt.Errorf("msgpack/rpc: handler for method %s must have at least %d args, handler type %s", method, len(args), t)
 	}
 
 	h := &handler{fn: v, args: make([]reflect.Value, len(args))}
@@ -284,8 +821,21 @@ func (e *Endpoint) Register(method string, fn interface{}, args ...interface{})
 		}
 	}
 
+	for i := len(args); i < t.NumIn(); i++ {
+		at := t.In(i)
+		if i == t.NumIn()-1 && t.IsVariadic() {
+			at = at.Elem()
+		}
+		if !transferable(at) {
+			return fmt.Errorf("%w: method %s, arg %d has type %s, handler type %s", ErrInvalidHandlerArg, method, i, t.In(i), t)
+		}
+	}
+
 	if t.NumOut() > 2 || (t.NumOut() > 0 && t.Out(t.NumOut()-1) != errorType) {
-		return ErrInvalidHandlerReturn
+		return fmt.Errorf("%w: method %s, handler type %s", ErrInvalidHandlerReturn, method, t)
+	}
+	if t.NumOut() == 2 && !transferable(t.Out(0)) {
+		return fmt.Errorf("%w: method %s, result has type %s, handler type %s", ErrInvalidHandlerReturn, method, t.Out(0), t)
 	}
 
 	e.handlersMu.Lock()
@@ -294,10 +844,171 @@ func (e *Endpoint) Register(method string, fn interface{}, args ...interface{})
 	return nil
 }
 
+// transferable reports whether values of type t can be sent over MessagePack
+// RPC.
+func transferable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	}
+	return true
+}
+
+// RegisterNotificationDecoder registers fn as the handler for notifications
+// with the specified method name. Unlike the handlers registered with
+// Register, fn decodes the arguments of the notification directly from the
+// stream, so that large notifications can be processed without decoding them
+// to Go values first.
+//
+// The fn function is called on the goroutine running Serve, with dec
+// positioned before the n arguments of the notification. The function must
+// read exactly n values from dec. Because replies to calls are read by the
+// same goroutine, fn must not wait for a call on the endpoint to complete. If
+// fn returns an error, Serve returns the error and closes the endpoint.
+//
+// Notifications for fn are not ordered with respect to the notifications for
+// handlers registered with Register, which are run on a separate goroutine.
+// Requests for the method are answered with an error.
+func (e *Endpoint) RegisterNotificationDecoder(method string, fn func(dec *msgpack.Decoder, n int) error) error {
+	if fn == nil {
+		return ErrHandlerNotFunction
+	}
+
+	e.handlersMu.Lock()
+	e.handlers[method] = &handler{decode: fn}
+	e.handlersMu.Unlock()
+	return nil
+}
+
+// Unregister removes the handler or notification decoder registered for the
+// specified method name. Requests for the method are answered with an error
+// and notifications are logged and dropped.
+func (e *Endpoint) Unregister(method string) {
+	e.handlersMu.Lock()
+	delete(e.handlers, method)
+	e.handlersMu.Unlock()
+}
+
+// RegisterExtension registers fn to convert the MessagePack extension with
+// type id to a Go value. The extension is used for the messages received after
+// RegisterExtension returns. It is an error to register an extension type that
+// is already registered with RegisterExtension or WithExtensions.
+//
+// RegisterExtension is safe to call concurrently with Serve.
+func (e *Endpoint) RegisterExtension(id int, fn func([]byte) (interface{}, error)) error {
+	if fn == nil {
+		return fmt.Errorf("msgpack/rpc: nil function for extension %d", id)
+	}
+
+	e.extensionsMu.Lock()
+	defer e.extensionsMu.Unlock()
+
+	old, _ := e.extensions.Load().(msgpack.ExtensionMap)
+	if old[id] != nil {
+		return fmt.Errorf("msgpack/rpc: extension %d already registered", id)
+	}
+	extensions := make(msgpack.ExtensionMap, len(old)+1)
+	for k, f := range old {
+		extensions[k] = f
+	}
+	extensions[id] = fn
+	e.extensions.Store(extensions)
+	return nil
+}
+
+// Stats returns a snapshot of the endpoint counters.
+func (e *Endpoint) Stats() Stats {
+	e.mu.Lock()
+	inFlight := len(e.pending)
+	e.mu.Unlock()
+
+	return Stats{
+		Calls:                 atomic.LoadInt64(&e.stats.calls),
+		InFlightCalls:         int64(inFlight),
+		NotificationsSent:     atomic.LoadInt64(&e.stats.notificationsSent),
+		NotificationsReceived: atomic.LoadInt64(&e.stats.notificationsReceived),
+		RequestsReceived:      atomic.LoadInt64(&e.stats.requestsReceived),
+		RepliesSent:           atomic.LoadInt64(&e.stats.repliesSent),
+		RepliesReceived:       atomic.LoadInt64(&e.stats.repliesReceived),
+		HandlerCalls:          atomic.LoadInt64(&e.stats.handlerCalls),
+		Errors:                atomic.LoadInt64(&e.stats.errors),
+		BytesRead:             atomic.LoadInt64(&e.stats.bytesRead),
+		BytesWritten:          atomic.LoadInt64(&e.stats.bytesWritten),
+	}
+}
+
+// LastID returns the ID of the last request sent by the endpoint, or 0 if no
+// request was sent.
+func (e *Endpoint) LastID() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.id
+}
+
 // Call invokes the target method and waits for a response.
 func (e *Endpoint) Call(method string, reply interface{}, args ...interface{}) error {
-	c := <-e.Go(method, make(chan *Call, 1), reply, args...).Done
-	return c.Err
+	return e.wait(context.Background(), e.Go(method, make(chan *Call, 1), reply, args...))
+}
+
+// CallContext is like Call, but stops waiting for the response when ctx is
+// done and returns ctx.Err(). The request is not canceled in the peer. The
+// response, when it arrives, is discarded.
+//
+// If the response was already being decoded into reply when ctx is done,
+// CallContext waits for the decoding to complete and returns the result of
+// the call, so reply is not modified after CallContext returns.
+func (e *Endpoint) CallContext(ctx context.Context, method string, reply interface{}, args ...interface{}) error {
+	return e.wait(ctx, e.Go(method, make(chan *Call, 1), reply, args...))
+}
+
+// wait waits for the response to c until ctx is done or the call timeout
+// expires.
+func (e *Endpoint) wait(ctx context.Context, c *Call) error {
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok && e.callTimeout > 0 {
+		t := time.NewTimer(e.callTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var err error
+	select {
+	case <-c.Done:
+		return c.Err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrCallTimeout
+	}
+	if !e.abandon(c) {
+		<-c.Done
+		return c.Err
+	}
+	return err
+}
+
+// abandon removes the pending call c, so that the response for c is
+// discarded. It returns false if c is no longer pending.
+func (e *Endpoint) abandon(c *Call) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending[c.id] != c {
+		return false
+	}
+	delete(e.pending, c.id)
+	return true
+}
+
+// CallRaw is like Call, but sends the pre-encoded MessagePack array args as
+// the arguments of the call.
+//
+// CallRaw returns an error wrapping ErrInvalidArgument without sending the
+// call if args is not exactly one complete MessagePack array.
+func (e *Endpoint) CallRaw(method string, reply interface{}, args []byte) error {
+	if err := rawArgs(args).check(); err != nil {
+		return err
+	}
+	return e.wait(context.Background(), e.goArgs(method, make(chan *Call, 1), reply, rawArgs(args)))
 }
 
 // Go append method call to queue and returns the new Call.
@@ -305,7 +1016,11 @@ func (e *Endpoint) Go(method string, done chan *Call, reply interface{}, args ..
 	if args == nil {
 		args = []interface{}{}
 	}
+	return e.goArgs(method, done, reply, args)
+}
 
+// goArgs is like Go, but args is the value encoded as the arguments array.
+func (e *Endpoint) goArgs(method string, done chan *Call, reply interface{}, args interface{}) *Call {
 	if done == nil {
 		done = make(chan *Call, 1)
 	} else if cap(done) == 0 {
@@ -318,6 +1033,7 @@ func (e *Endpoint) Go(method string, done chan *Call, reply interface{}, args ..
 		Reply:  reply,
 		Done:   done,
 	}
+	atomic.AddInt64(&e.stats.calls, 1)
 
 	e.mu.Lock()
 	if e.state == stateClosed {
@@ -325,8 +1041,19 @@ func (e *Endpoint) Go(method string, done chan *Call, reply interface{}, args ..
 		e.mu.Unlock()
 		return call
 	}
-	e.id = (e.id + 1) & 0x7fffffff
-	id := e.id
+	var id uint64
+	if e.nextID != nil {
+		id = e.nextID()
+	} else {
+		id = (e.id + 1) & 0x7fffffff
+	}
+	e.id = id
+	if _, pending := e.pending[id]; pending {
+		call.done(e, fmt.Errorf("msgpack/rpc: request id %d is in use", id))
+		e.mu.Unlock()
+		return call
+	}
+	call.id = id
 	e.pending[id] = call
 	e.mu.Unlock()
 
@@ -334,7 +1061,7 @@ func (e *Endpoint) Go(method string, done chan *Call, reply interface{}, args ..
 		Kind   kind `msgpack:",array"`
 		ID     uint64
 		Method string
-		Args   []interface{}
+		Args   interface{}
 	}{
 		requestMessage,
 		id,
@@ -357,6 +1084,8 @@ func (e *Endpoint) Go(method string, done chan *Call, reply interface{}, args ..
 		}
 		e.mu.Unlock()
 		e.close(fmt.Errorf("msgpack/rpc: error encoding %s: %w", call.Method, err))
+	} else if e.debug {
+		e.logger.Debugf("msgpack/rpc: sent request %d %s", id, method)
 	}
 
 	return call
@@ -367,11 +1096,27 @@ func (e *Endpoint) Notify(method string, args ...interface{}) error {
 	if args == nil {
 		args = []interface{}{}
 	}
+	return e.notify(method, args)
+}
+
+// NotifyRaw is like Notify, but sends the pre-encoded MessagePack array args
+// as the arguments of the notification.
+//
+// NotifyRaw returns an error wrapping ErrInvalidArgument without sending the
+// notification if args is not exactly one complete MessagePack array.
+func (e *Endpoint) NotifyRaw(method string, args []byte) error {
+	if err := rawArgs(args).check(); err != nil {
+		return err
+	}
+	return e.notify(method, rawArgs(args))
+}
 
+// notify is like Notify, but args is the value encoded as the arguments array.
+func (e *Endpoint) notify(method string, args interface{}) error {
 	message := &struct {
 		Kind   kind `msgpack:",array"`
 		Method string
-		Args   []interface{}
+		Args   interface{}
 	}{
 		notificationMessage,
 		method,
@@ -385,9 +1130,15 @@ func (e *Endpoint) Notify(method string, args ...interface{}) error {
 	}
 	e.encMu.Unlock()
 	if err != nil {
+		atomic.AddInt64(&e.stats.errors, 1)
 		e.close(fmt.Errorf("msgpack/rpc: error encoding %s: %w", method, err))
+		return err
 	}
-	return err
+	atomic.AddInt64(&e.stats.notificationsSent, 1)
+	if e.debug {
+		e.logger.Debugf("msgpack/rpc: sent notification %s", method)
+	}
+	return nil
 }
 
 func (e *Endpoint) createCall(h *handler) (func([]reflect.Value) []reflect.Value, []reflect.Value, error) {
@@ -496,6 +1247,8 @@ func (e *Endpoint) reply(id uint64, replyErr error, reply interface{}) error {
 		err = e.enc.Encode(ee.Value)
 	} else if ee, ok := replyErr.(msgpack.Marshaler); ok {
 		err = ee.MarshalMsgPack(e.enc)
+	} else if ee := MsgpackError(nil); errors.As(replyErr, &ee) {
+		err = e.enc.Encode([]interface{}{ee.ErrorCode(), ee.ErrorData()})
 	} else {
 		err = e.enc.PackString(replyErr.Error())
 	}
@@ -507,13 +1260,17 @@ func (e *Endpoint) reply(id uint64, replyErr error, reply interface{}) error {
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&e.stats.repliesSent, 1)
+	if e.debug {
+		e.logger.Debugf("msgpack/rpc: sent reply %d, error %v", id, replyErr)
+	}
 	return e.bw.Flush()
 }
 
 func (e *Endpoint) handleRequest(messageLen int) error {
 	if messageLen != 4 {
 		// messageType, id, method, args
-		return fmt.Errorf("msgpack/rpc: invalid request message length %d", messageLen)
+		return fmt.Errorf("%w: request length %d", ErrInvalidMessage, messageLen)
 	}
 
 	id, err := e.decodeUint("request id")
@@ -525,31 +1282,99 @@ func (e *Endpoint) handleRequest(messageLen int) error {
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&e.stats.requestsReceived, 1)
+	if e.debug {
+		e.logger.Debugf("msgpack/rpc: received request %d %s", id, method)
+	}
 
 	e.handlersMu.RLock()
 	h, ok := e.handlers[method]
 	e.handlersMu.RUnlock()
 
-	if !ok {
+	if !ok || h.decode != nil {
 		if err := e.skip(1); err != nil {
 			return err
 		}
-		e.logf("msgpack/rpc: request service method %s not found", method)
+		e.logger.Errorf("msgpack/rpc: request service method %s not found", method)
+		atomic.AddInt64(&e.stats.errors, 1)
 		return e.reply(id, fmt.Errorf("unknown request method: %s", method), nil)
 	}
 
 	call, args, err := e.createCall(h)
 	if _, ok := err.(*msgpack.DecodeConvertError); ok {
-		e.logf("msgpack/rpc: %s: %v", method, err)
+		e.logger.Errorf("msgpack/rpc: %s: %v", method, err)
+		atomic.AddInt64(&e.stats.errors, 1)
 		return e.reply(id, ErrInvalidArgument, nil)
 	} else if err != nil {
 		return err
 	}
 
-	go func() {
-		out := call(args)
-		var replyErr error
-		var replyVal interface{}
+	if !e.startHandler() {
+		return e.reply(id, ErrShutdown, nil)
+	}
+	switch {
+	case e.synchronous:
+		e.runRequest(id, method, h, call, args)
+	case e.handlerLimit > 0:
+		e.queueRequest(&request{id: id, method: method, h: h, call: call, args: args})
+	default:
+		go e.runRequest(id, method, h, call, args)
+	}
+
+	return nil
+}
+
+// request is a request waiting for a handler to finish on an endpoint with a
+// handler concurrency limit.
+type request struct {
+	id     uint64
+	method string
+	h      *handler
+	call   func([]reflect.Value) []reflect.Value
+	args   []reflect.Value
+}
+
+// queueRequest runs r on a new goroutine if fewer than handlerLimit requests
+// are running, and queues r otherwise.
+func (e *Endpoint) queueRequest(r *request) {
+	e.requestsMu.Lock()
+	if e.runningRequests >= e.handlerLimit {
+		e.requestQueue = append(e.requestQueue, r)
+		e.requestsMu.Unlock()
+		return
+	}
+	e.runningRequests++
+	e.requestsMu.Unlock()
+	go e.runRequests(r)
+}
+
+// runRequests runs r and then the queued requests until the queue is empty.
+func (e *Endpoint) runRequests(r *request) {
+	for r != nil {
+		e.runRequest(r.id, r.method, r.h, r.call, r.args)
+
+		e.requestsMu.Lock()
+		if len(e.requestQueue) == 0 {
+			e.runningRequests--
+			r = nil
+		} else {
+			r = e.requestQueue[0]
+			e.requestQueue[0] = nil
+			e.requestQueue = e.requestQueue[1:]
+		}
+		e.requestsMu.Unlock()
+	}
+}
+
+// runRequest calls the handler for a request and replies to the peer.
+func (e *Endpoint) runRequest(id uint64, method string, h *handler, call func([]reflect.Value) []reflect.Value, args []reflect.Value) {
+	defer e.active.Done()
+	atomic.AddInt64(&e.stats.handlerCalls, 1)
+	var replyErr error
+	var replyVal interface{}
+	if out, err := e.callHandler(method, call, args); err != nil {
+		replyErr = err
+	} else {
 		switch h.fn.Type().NumOut() {
 		case 1:
 			replyErr, _ = out[0].Interface().(error)
@@ -557,24 +1382,29 @@ func (e *Endpoint) handleRequest(messageLen int) error {
 			replyVal = out[0].Interface()
 			replyErr, _ = out[1].Interface().(error)
 		}
-		if err := e.reply(id, replyErr, replyVal); err != nil {
-			e.close(err)
-		}
-	}()
-
-	return nil
+	}
+	if replyErr != nil {
+		atomic.AddInt64(&e.stats.errors, 1)
+	}
+	if err := e.reply(id, replyErr, replyVal); err != nil {
+		e.close(err)
+	}
 }
 
 func (e *Endpoint) handleReply(messageLen int) error {
 	if messageLen != 4 {
 		// messageType, id, error, reply
-		return fmt.Errorf("msgpack/rpc: invalid reply message length %d", messageLen)
+		return fmt.Errorf("%w: reply length %d", ErrInvalidMessage, messageLen)
 	}
 
 	id, err := e.decodeUint("response id")
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&e.stats.repliesReceived, 1)
+	if e.debug {
+		e.logger.Debugf("msgpack/rpc: received reply %d", id)
+	}
 
 	e.mu.Lock()
 	call := e.pending[id]
@@ -582,7 +1412,7 @@ func (e *Endpoint) handleReply(messageLen int) error {
 	e.mu.Unlock()
 
 	if call == nil {
-		e.logf("msgpack/rpc: no pending call for reply %d", id)
+		e.logger.Infof("msgpack/rpc: no pending call for reply %d", id)
 		return e.skip(2)
 	}
 
@@ -620,32 +1450,106 @@ func (e *Endpoint) handleReply(messageLen int) error {
 func (e *Endpoint) handleNotification(messageLen int) error {
 	// messageType, method, args
 	if messageLen != 3 {
-		return fmt.Errorf("msgpack/rpc: invalid notification message length %d", messageLen)
+		return fmt.Errorf("%w: notification length %d", ErrInvalidMessage, messageLen)
 	}
 
 	method, err := e.decodeString("service method name")
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&e.stats.notificationsReceived, 1)
+	if e.debug {
+		e.logger.Debugf("msgpack/rpc: received notification %s", method)
+	}
 
 	e.handlersMu.RLock()
 	h, ok := e.handlers[method]
 	e.handlersMu.RUnlock()
 
 	if !ok {
-		e.logf("msgpack/rpc: notification service method %s not found", method)
+		e.logger.Infof("msgpack/rpc: notification service method %s not found", method)
 		return e.skip(1)
 	}
 
+	if h.decode != nil {
+		return e.decodeNotification(method, h)
+	}
+
 	call, args, err := e.createCall(h)
 	if err != nil {
 		return err
 	}
 
-	e.enqueNotification(&notification{call: call, args: args, method: method})
+	if !e.startHandler() {
+		e.logger.Infof("msgpack/rpc: notification %s dropped during shutdown", method)
+		return nil
+	}
+	n := &notification{call: call, args: args, method: method}
+	if e.synchronous {
+		e.runNotification(n)
+	} else {
+		e.enqueNotification(n)
+	}
+	return nil
+}
+
+// decodeNotification calls the notification decoder of h with the arguments of
+// the notification.
+func (e *Endpoint) decodeNotification(method string, h *handler) error {
+	if err := e.dec.Unpack(); err != nil {
+		return err
+	}
+	if e.dec.Type() != msgpack.ArrayLen {
+		e.dec.Skip()
+		return fmt.Errorf("msgpack/rpc: expected args array, found %s", e.dec.Type())
+	}
+
+	atomic.AddInt64(&e.stats.handlerCalls, 1)
+	if err := e.callDecoder(method, h, e.dec.Len()); err != nil {
+		atomic.AddInt64(&e.stats.errors, 1)
+		return fmt.Errorf("msgpack/rpc: decoding %s notification: %w", method, err)
+	}
 	return nil
 }
 
+// callHandler calls a service method. If the method panics, callHandler
+// reports the panic and returns an error describing it.
+//
+// Only the call of the method is guarded. The reply is encoded after
+// callHandler returns, so that a recovered panic never leaves a partially
+// written message in the output.
+func (e *Endpoint) callHandler(method string, call func([]reflect.Value) []reflect.Value, args []reflect.Value) (out []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = e.handlePanic(method, r)
+		}
+	}()
+	return call(args), nil
+}
+
+// callDecoder calls the notification decoder of h with the n arguments of a
+// notification. If the decoder panics, callDecoder reports the panic and
+// returns an error describing it.
+func (e *Endpoint) callDecoder(method string, h *handler, n int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = e.handlePanic(method, r)
+		}
+	}()
+	return h.decode(e.dec, n)
+}
+
+// handlePanic reports the panic value r of a service method and returns an
+// error describing the panic.
+func (e *Endpoint) handlePanic(method string, r interface{}) error {
+	stack := debug.Stack()
+	e.logger.Errorf("msgpack/rpc: service method %s panicked: %v\n%s", method, r, stack)
+	if e.panicHandler != nil {
+		e.panicHandler(method, r, stack)
+	}
+	return fmt.Errorf("service method %s panicked: %v", method, r)
+}
+
 func (e *Endpoint) enqueNotification(n *notification) {
 	e.notificationsMu.Lock()
 	e.notifications = append(e.notifications, n)
@@ -674,13 +1578,25 @@ func (e *Endpoint) runNotifications() {
 				// Serve() enqueues nil on return
 				return
 			}
-			out := n.call(n.args)
-			if len(out) > 0 {
-				replyErr, _ := out[len(out)-1].Interface().(error)
-				if replyErr != nil {
-					e.logf("msgpack/rpc: service method %s returned %v", n.method, replyErr)
-				}
-			}
+			e.runNotification(n)
+		}
+	}
+}
+
+// runNotification calls the handler for a notification.
+func (e *Endpoint) runNotification(n *notification) {
+	defer e.active.Done()
+	atomic.AddInt64(&e.stats.handlerCalls, 1)
+	out, err := e.callHandler(n.method, n.call, n.args)
+	if err != nil {
+		atomic.AddInt64(&e.stats.errors, 1)
+		return
+	}
+	if len(out) > 0 {
+		replyErr, _ := out[len(out)-1].Interface().(error)
+		if replyErr != nil {
+			atomic.AddInt64(&e.stats.errors, 1)
+			e.logger.Errorf("msgpack/rpc: service method %s returned %v", n.method, replyErr)
 		}
 	}
 }
@@ -1,105 +1,1048 @@
 package rpc
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/neovim/go-client/msgpack"
 )
 
-func testClientServer(tb testing.TB, opts ...Option) (client, server *Endpoint, cleanup func()) {
-	tb.Helper()
+func testClientServer(tb testing.TB, opts ...Option) (client, server *Endpoint, cleanup func()) {
+	tb.Helper()
+
+	opts = append(opts, WithLogf(tb.Logf))
+
+	serverConn, clientConn := net.Pipe()
+
+	var err error
+	server, err = NewEndpoint(serverConn, serverConn, serverConn, opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	client, err = NewEndpoint(clientConn, clientConn, clientConn, opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		if err := server.Serve(); err != nil && !errors.Is(err, io.ErrClosedPipe) && !errors.Is(err, ErrPeerClosed) {
+			tb.Errorf("server: %v", err)
+		}
+		wg.Done()
+	}()
+
+	wg.Add(1)
+	go func() {
+		if err := client.Serve(); err != nil && !errors.Is(err, io.ErrClosedPipe) && !errors.Is(err, ErrPeerClosed) {
+			tb.Errorf("server: %v", err)
+		}
+		wg.Done()
+	}()
+
+	if tb.Failed() {
+		tb.FailNow()
+	}
+
+	cleanup = func() {
+		client.Close()
+		server.Close()
+		wg.Wait()
+	}
+
+	return client, server, cleanup
+}
+
+func TestEndpoint(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	addFn := func(a, b int) (int, error) { return a + b, nil }
+	if err := server.Register("add", addFn); err != nil {
+		t.Fatal(err)
+	}
+
+	// call
+	var sum int
+	if err := client.Call("add", &sum, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("sum = %d, want %d", sum, 3)
+	}
+
+	// notification
+	notifCh := make(chan string)
+	n1Fn := func(s string) { notifCh <- s }
+	if err := server.Register("n1", n1Fn); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	for i := 0; i < i; i++ {
+		for j := 0; j < n; j++ {
+			if err := client.Notify("n1", fmt.Sprintf("notif %d,%d", i, j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j := 0; j < n; j++ {
+			got := <-notifCh
+			want := fmt.Sprintf("notif %d,%d", i, j)
+			if got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	if err := server.Register("add", func(a, b int) (int, error) { return a + b, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("fail", func() error { return errors.New("fail") }); err != nil {
+		t.Fatal(err)
+	}
+	notifCh := make(chan struct{})
+	if err := server.Register("n", func() { notifCh <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum int
+	if err := client.Call("add", &sum, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("fail", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := client.Call("unknown", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := client.Notify("n"); err != nil {
+		t.Fatal(err)
+	}
+	<-notifCh
+
+	wantClient := Stats{
+		Calls:             3,
+		NotificationsSent: 1,
+		RepliesReceived:   3,
+		Errors:            2,
+	}
+	if got := client.Stats(); !reflect.DeepEqual(got, wantClient) {
+		t.Fatalf("client stats = %+v, want %+v", got, wantClient)
+	}
+
+	wantServer := Stats{
+		NotificationsReceived: 1,
+		RequestsReceived:      3,
+		RepliesSent:           3,
+		HandlerCalls:          3,
+		Errors:                2,
+	}
+	if got := server.Stats(); !reflect.DeepEqual(got, wantServer) {
+		t.Fatalf("server stats = %+v, want %+v", got, wantServer)
+	}
+}
+
+func TestByteCounters(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t, WithByteCounters())
+	defer cleanup()
+
+	if err := server.Register("echo", func(s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
+	}
+	notifCh := make(chan struct{})
+	if err := server.Register("n", func() { notifCh <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	arg := strings.Repeat("x", 1000)
+	var reply string
+	if err := client.Call("echo", &reply, arg); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Notify("n"); err != nil {
+		t.Fatal(err)
+	}
+	<-notifCh
+
+	cs, ss := client.Stats(), server.Stats()
+	if cs.BytesWritten <= int64(len(arg)) || ss.BytesWritten <= int64(len(arg)) {
+		t.Fatalf("got bytes written %d and %d, want more than %d", cs.BytesWritten, ss.BytesWritten, len(arg))
+	}
+	if cs.BytesWritten != ss.BytesRead {
+		t.Errorf("client wrote %d bytes, server read %d bytes", cs.BytesWritten, ss.BytesRead)
+	}
+	if ss.BytesWritten != cs.BytesRead {
+		t.Errorf("server wrote %d bytes, client read %d bytes", ss.BytesWritten, cs.BytesRead)
+	}
+}
+
+// testLogger records the messages logged at each level.
+type testLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *testLogger) add(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.add("info", format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args...) }
+
+func (l *testLogger) has(log string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.logs {
+		if s == log {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+
+	serverConn, clientConn := net.Pipe()
+	var serverLog, clientLog testLogger
+	server, err := NewEndpoint(serverConn, serverConn, serverConn, WithLogger(&serverLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewEndpoint(clientConn, clientConn, clientConn, WithLogger(&clientLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	go client.Serve()
+	defer server.Close()
+	defer client.Close()
+
+	if err := server.Register("add", func(a, b int) (int, error) { return a + b, nil }); err != nil {
+		t.Fatal(err)
+	}
+	var sum int
+	if err := client.Call("add", &sum, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("unknown", nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := client.Notify("n"); err != nil {
+		t.Fatal(err)
+	}
+	// The server handles the notification before the request.
+	if err := client.Call("add", &sum, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, log := range []string{
+		"debug: msgpack/rpc: sent request 1 add",
+		"debug: msgpack/rpc: received reply 1",
+		"debug: msgpack/rpc: sent notification n",
+	} {
+		if !clientLog.has(log) {
+			t.Errorf("client did not log %q, got %q", log, clientLog.logs)
+		}
+	}
+	for _, log := range []string{
+		"debug: msgpack/rpc: received request 1 add",
+		"debug: msgpack/rpc: sent reply 1, error <nil>",
+		"error: msgpack/rpc: request service method unknown not found",
+		"info: msgpack/rpc: notification service method n not found",
+	} {
+		if !serverLog.has(log) {
+			t.Errorf("server did not log %q, got %q", log, serverLog.logs)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	if err := server.Register("add", func(a, b int) (int, error) { return a + b, nil }); err != nil {
+		t.Fatal(err)
+	}
+	notifCh := make(chan string, 1)
+	if err := server.Register("n", func(s string) { notifCh <- s }); err != nil {
+		t.Fatal(err)
+	}
+
+	encodeArgs := func(args ...interface{}) []byte {
+		var buf bytes.Buffer
+		if err := msgpack.NewEncoder(&buf).Encode(args); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var sum int
+	if err := client.CallRaw("add", &sum, encodeArgs(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("sum = %d, want %d", sum, 3)
+	}
+
+	if err := client.NotifyRaw("n", encodeArgs("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-notifCh, "hello"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	invalid := map[string][]byte{
+		"Nil":       nil,
+		"Empty":     {},
+		"NotArray":  {0x01},
+		"Truncated": {0x92, 0x01},
+		"Trailing":  append(encodeArgs(1, 2), 0x01),
+	}
+	for name, args := range invalid {
+		if err := client.CallRaw("add", &sum, args); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: CallRaw returned error %v, want %v", name, err, ErrInvalidArgument)
+		}
+		if err := client.NotifyRaw("n", args); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: NotifyRaw returned error %v, want %v", name, err, ErrInvalidArgument)
+		}
+	}
+
+	// The endpoint is usable after the invalid arguments are rejected.
+	if err := client.CallRaw("add", &sum, encodeArgs(2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 5 {
+		t.Fatalf("sum = %d, want %d", sum, 5)
+	}
+}
+
+func TestNotificationDecoder(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	sumCh := make(chan int64, 1)
+	sumFn := func(dec *msgpack.Decoder, n int) error {
+		var sum int64
+		for i := 0; i < n; i++ {
+			if err := dec.Unpack(); err != nil {
+				return err
+			}
+			if dec.Type() != msgpack.Int && dec.Type() != msgpack.Uint {
+				return fmt.Errorf("unexpected %s argument", dec.Type())
+			}
+			sum += dec.Int()
+		}
+		sumCh <- sum
+		return nil
+	}
+	if err := server.RegisterNotificationDecoder("sum", sumFn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Notify("sum", 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-sumCh, int64(6); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	// The endpoint continues to serve messages after the notification.
+	if err := client.Notify("sum"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-sumCh, int64(0); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+
+	if err := client.Call("sum", nil, 1); err == nil {
+		t.Fatal("expected error for request to notification decoder")
+	}
+
+	if err := server.RegisterNotificationDecoder("nil", nil); err == nil {
+		t.Fatal("expected error for nil decoder")
+	}
+}
+
+// chanFrames is a FrameReader and FrameWriter sending frames over channels.
+type chanFrames struct {
+	t    *testing.T
+	in   <-chan []byte
+	out  chan<- []byte
+	done chan struct{}
+}
+
+func (f *chanFrames) ReadFrame() ([]byte, error) {
+	select {
+	case p := <-f.in:
+		return p, nil
+	case <-f.done:
+		return nil, io.EOF
+	}
+}
+
+func (f *chanFrames) WriteFrame(p []byte) error {
+	// Check that the frame holds exactly one message.
+	r := bytes.NewReader(p)
+	var message []interface{}
+	if err := msgpack.NewDecoder(r).Decode(&message); err != nil {
+		f.t.Errorf("frame %x: %v", p, err)
+	} else if len(message) < 3 || r.Len() != 0 {
+		f.t.Errorf("frame %x does not hold exactly one message", p)
+	}
+
+	select {
+	case f.out <- append([]byte(nil), p...):
+		return nil
+	case <-f.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (f *chanFrames) Close() error {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+	return nil
+}
+
+func TestPanicHandler(t *testing.T) {
+	t.Parallel()
+
+	type panicInfo struct {
+		method string
+		value  interface{}
+		stack  []byte
+	}
+	panics := make(chan panicInfo, 1)
+	client, server, cleanup := testClientServer(t, WithPanicHandler(func(method string, value interface{}, stack []byte) {
+		panics <- panicInfo{method: method, value: value, stack: stack}
+	}))
+	defer cleanup()
+
+	if err := server.Register("panic", func(s string) (string, error) { panic(s) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("echo", func(s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, wantMethod string) {
+		t.Helper()
+		p := <-panics
+		if p.method != wantMethod || p.value != "hello" {
+			t.Fatalf("got panic (%s, %v), want (%s, hello)", p.method, p.value, wantMethod)
+		}
+		if !bytes.Contains(p.stack, []byte("TestPanicHandler")) {
+			t.Fatalf("stack does not contain the panicking function:\n%s", p.stack)
+		}
+	}
+
+	t.Run("Request", func(t *testing.T) {
+		var result string
+		err := client.Call("panic", &result, "hello")
+		var rpcErr Error
+		if !errors.As(err, &rpcErr) {
+			t.Fatalf("got error %v, want Error", err)
+		}
+		if want := "service method panic panicked: hello"; rpcErr.Value != want {
+			t.Fatalf("got error value %v, want %q", rpcErr.Value, want)
+		}
+		check(t, "panic")
+	})
+
+	t.Run("Notification", func(t *testing.T) {
+		if err := client.Notify("panic", "hello"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, "panic")
+	})
+
+	// The endpoint continues to serve messages after the panics.
+	var result string
+	if err := client.Call("echo", &result, "world"); err != nil {
+		t.Fatal(err)
+	}
+	if result != "world" {
+		t.Fatalf("got %q, want %q", result, "world")
+	}
+}
+
+func TestIDAllocator(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		client, server, cleanup := testClientServer(t)
+		defer cleanup()
+
+		if err := server.Register("add", func(a, b int) (int, error) { return a + b, nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		if id := client.LastID(); id != 0 {
+			t.Fatalf("got last id %d before the first call, want 0", id)
+		}
+		for want := uint64(1); want <= 3; want++ {
+			var sum int
+			if err := client.Call("add", &sum, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if id := client.LastID(); id != want {
+				t.Fatalf("got last id %d, want %d", id, want)
+			}
+		}
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		t.Parallel()
+
+		ids := make(chan uint64, 2)
+		ids <- 100
+		ids <- 100
+		client, server, cleanup := testClientServer(t, WithIDAllocator(func() uint64 { return <-ids }))
+		defer cleanup()
+
+		block := make(chan struct{})
+		if err := server.Register("block", func() error { <-block; return nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		first := client.Go("block", nil, nil)
+		if id := client.LastID(); id != 100 {
+			t.Fatalf("got last id %d, want 100", id)
+		}
+
+		// The second call reuses the ID of the pending first call.
+		second := <-client.Go("block", nil, nil).Done
+		if second.Err == nil {
+			t.Fatal("expected error for request id in use")
+		}
+
+		close(block)
+		if call := <-first.Done; call.Err != nil {
+			t.Fatal(call.Err)
+		}
+	})
+}
+
+func TestCallContext(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	release := make(chan struct{})
+	if err := server.Register("block", func(s string) (string, error) {
+		<-release
+		return s, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := func() int {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.pending)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		var result string
+		canceled <- client.CallContext(ctx, "block", &result, "canceled")
+	}()
+	completed := make(chan error, 1)
+	var result string
+	go func() {
+		completed <- client.CallContext(context.Background(), "block", &result, "completed")
+	}()
+
+	for pending() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if n := pending(); n != 1 {
+		t.Fatalf("got %d pending calls after cancel, want 1", n)
+	}
+
+	// The call of the other caller is not affected by the cancellation, and
+	// the response to the canceled call is discarded.
+	close(release)
+	if err := <-completed; err != nil {
+		t.Fatal(err)
+	}
+	if result != "completed" {
+		t.Fatalf("got %q, want %q", result, "completed")
+	}
+
+	if err := client.CallContext(context.Background(), "block", &result, "after"); err != nil {
+		t.Fatal(err)
+	}
+	if result != "after" {
+		t.Fatalf("got %q, want %q", result, "after")
+	}
+	if n := pending(); n != 0 {
+		t.Fatalf("got %d pending calls, want 0", n)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t, WithCallTimeout(20*time.Millisecond))
+	defer cleanup()
+
+	if err := server.Register("sleep", func(ms int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Call("sleep", nil, 500); !errors.Is(err, ErrCallTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrCallTimeout)
+	}
+	client.mu.Lock()
+	n := len(client.pending)
+	client.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("got %d pending calls after timeout, want 0", n)
+	}
+
+	if err := client.Call("sleep", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// A context with a deadline overrides the timeout of the endpoint.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.CallContext(ctx, "sleep", nil, 50); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type codeError struct {
+	code int
+	data map[string]string
+}
+
+func (e *codeError) Error() string          { return fmt.Sprintf("code %d", e.code) }
+func (e *codeError) ErrorCode() int         { return e.code }
+func (e *codeError) ErrorData() interface{} { return e.data }
+
+func TestMsgpackError(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	structured := &codeError{code: 42, data: map[string]string{"field": "name"}}
+	if err := server.Register("structured", func() error { return structured }); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("wrapped", func() error { return fmt.Errorf("wrapped: %w", structured) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("plain", func() error { return errors.New("plain error") }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		method string
+		want   interface{}
+	}{
+		"Structured": {
+			method: "structured",
+			want:   []interface{}{int64(42), map[string]interface{}{"field": "name"}},
+		},
+		"Wrapped": {
+			method: "wrapped",
+			want:   []interface{}{int64(42), map[string]interface{}{"field": "name"}},
+		},
+		"Plain": {
+			method: "plain",
+			want:   "plain error",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := client.Call(tt.method, nil)
+			var rpcErr Error
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("got error %v, want Error", err)
+			}
+			if !reflect.DeepEqual(rpcErr.Value, tt.want) {
+				t.Fatalf("got error value %#v, want %#v", rpcErr.Value, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramedEndpoint(t *testing.T) {
+	t.Parallel()
+
+	clientToServer := make(chan []byte, 16)
+	serverToClient := make(chan []byte, 16)
+	done := make(chan struct{})
+	serverFrames := &chanFrames{t: t, in: clientToServer, out: serverToClient, done: done}
+	clientFrames := &chanFrames{t: t, in: serverToClient, out: clientToServer, done: done}
+
+	server, err := NewFramedEndpoint(serverFrames, serverFrames, serverFrames, WithLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewFramedEndpoint(clientFrames, clientFrames, clientFrames, WithLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, e := range []*Endpoint{server, client} {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			if err := e.Serve(); err != nil && !errors.Is(err, ErrPeerClosed) {
+				t.Errorf("serve: %v", err)
+			}
+		}(e)
+	}
+	defer func() {
+		client.Close()
+		server.Close()
+		wg.Wait()
+	}()
+
+	if err := server.Register("concat", func(a, b string) (string, error) { return a + b, nil }); err != nil {
+		t.Fatal(err)
+	}
+	notifCh := make(chan string, 1)
+	if err := server.Register("n", func(s string) { notifCh <- s }); err != nil {
+		t.Fatal(err)
+	}
 
-	opts = append(opts, WithLogf(tb.Logf))
+	// A long argument is written with more than one write to the transport.
+	long := strings.Repeat("x", 10000)
+	var result string
+	if err := client.Call("concat", &result, long, "y"); err != nil {
+		t.Fatal(err)
+	}
+	if want := long + "y"; result != want {
+		t.Fatalf("got %d bytes result, want %d", len(result), len(want))
+	}
 
-	serverConn, clientConn := net.Pipe()
+	if err := client.Notify("n", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-notifCh, "hello"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
 
-	var err error
-	server, err = NewEndpoint(serverConn, serverConn, serverConn, opts...)
-	if err != nil {
-		tb.Fatal(err)
+func TestSynchronousDispatch(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t, WithSynchronousDispatch())
+	defer cleanup()
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	add := func(s string) {
+		mu.Lock()
+		events = append(events, s)
+		mu.Unlock()
+	}
+	if err := server.Register("sleep", func(ms int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		add("sleep")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("add", func(s string) (string, error) {
+		add(s)
+		return s, nil
+	}); err != nil {
+		t.Fatal(err)
 	}
 
-	client, err = NewEndpoint(clientConn, clientConn, clientConn, opts...)
-	if err != nil {
-		tb.Fatal(err)
+	// The handlers run in the order the messages are received, although the
+	// sleep request takes longer than the following notifications.
+	sleep := client.Go("sleep", nil, nil, 50)
+	if err := client.Notify("add", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Notify("add", "b"); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := client.Call("add", &s, "c"); err != nil {
+		t.Fatal(err)
+	}
+	<-sleep.Done
+	if sleep.Err != nil {
+		t.Fatal(sleep.Err)
 	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		if err := server.Serve(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
-			tb.Errorf("server: %v", err)
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"sleep", "a", "b", "c"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("got events %v, want %v", events, want)
+	}
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t, WithHandlerConcurrency(2))
+	defer cleanup()
+
+	started := make(chan int)
+	release := make(chan struct{})
+	if err := server.Register("block", func(i int) (int, error) {
+		started <- i
+		<-release
+		return i, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Register("ping", func() (string, error) { return "pong", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	calls := make([]*Call, n)
+	for i := range calls {
+		calls[i] = client.Go("block", make(chan *Call, 1), nil, i)
+	}
+
+	// Two handlers run concurrently.
+	first := map[int]bool{<-started: true, <-started: true}
+	if !first[0] || !first[1] {
+		t.Fatalf("got first handlers %v, want 0 and 1", first)
+	}
+	select {
+	case i := <-started:
+		t.Fatalf("handler %d started while 2 handlers are running", i)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Serve reads messages while requests wait for a handler.
+	var pong string
+	if err := server.Call("ping", &pong); err != nil {
+		t.Fatal(err)
+	}
+
+	// The waiting requests run in the order they were received.
+	for want := 2; want < n; want++ {
+		release <- struct{}{}
+		if i := <-started; i != want {
+			t.Fatalf("got handler %d, want %d", i, want)
 		}
-		wg.Done()
-	}()
+	}
+	for i := 0; i < 2; i++ {
+		release <- struct{}{}
+	}
 
-	wg.Add(1)
-	go func() {
-		if err := client.Serve(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
-			tb.Errorf("server: %v", err)
+	for i, call := range calls {
+		<-call.Done
+		if call.Err != nil {
+			t.Fatalf("call %d: %v", i, call.Err)
 		}
-		wg.Done()
-	}()
+	}
+}
 
-	if tb.Failed() {
-		tb.FailNow()
+type countingBufferPool struct {
+	mu   sync.Mutex
+	gets int
+	puts int
+}
+
+func (p *countingBufferPool) Get(n int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.gets++
+	return make([]byte, n)
+}
+
+func (p *countingBufferPool) Put(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.puts++
+}
+
+func TestBufferPool(t *testing.T) {
+	t.Parallel()
+
+	pool := &countingBufferPool{}
+	client, server, cleanup := testClientServer(t, WithBufferPool(pool))
+	defer cleanup()
+
+	if err := server.Register("echo", func(s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
 	}
 
-	cleanup = func() {
-		client.Close()
-		server.Close()
-		wg.Wait()
+	want := strings.Repeat("x", 10000)
+	var got string
+	if err := client.Call("echo", &got, want); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %d bytes from echo, want %d", len(got), len(want))
 	}
 
-	return client, server, cleanup
+	pool.mu.Lock()
+	gets := pool.gets
+	pool.mu.Unlock()
+	if gets != 2 {
+		t.Fatalf("got %d buffers from pool, want 2", gets)
+	}
 }
 
-func TestEndpoint(t *testing.T) {
+func TestMaxMessageSize(t *testing.T) {
 	t.Parallel()
 
-	client, server, cleanup := testClientServer(t)
-	defer cleanup()
+	serverConn, clientConn := net.Pipe()
 
-	addFn := func(a, b int) (int, error) { return a + b, nil }
-	if err := server.Register("add", addFn); err != nil {
+	server, err := NewEndpoint(serverConn, serverConn, serverConn, WithLogf(t.Logf), WithMaxMessageSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewEndpoint(clientConn, clientConn, clientConn, WithLogf(t.Logf))
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	// call
-	var sum int
-	if err := client.Call("add", &sum, 1, 2); err != nil {
+	if err := server.Register("echo", func(s string) (string, error) { return s, nil }); err != nil {
 		t.Fatal(err)
 	}
-	if sum != 3 {
-		t.Fatalf("sum = %d, want %d", sum, 3)
+
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Serve() }()
+	go client.Serve()
+	defer client.Close()
+
+	var reply string
+	if err := client.Call("echo", &reply, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "hello" {
+		t.Fatalf("got %q, want %q", reply, "hello")
 	}
 
-	// notification
-	notifCh := make(chan string)
-	n1Fn := func(s string) { notifCh <- s }
-	if err := server.Register("n1", n1Fn); err != nil {
+	if err := client.Call("echo", &reply, strings.Repeat("x", 1024)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	err = <-serverErr
+	if !errors.Is(err, msgpack.ErrLimitExceeded) {
+		t.Fatalf("got %v error, want %v", err, msgpack.ErrLimitExceeded)
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	t.Parallel()
+
+	const (
+		numSenders  = 4
+		numMessages = 100
+	)
+
+	serverConn, clientConn := net.Pipe()
+	client, err := NewEndpoint(clientConn, clientConn, clientConn, WithLogf(t.Logf))
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer client.Close()
 
-	const n = 10
-	for i := 0; i < i; i++ {
-		for j := 0; j < n; j++ {
-			if err := client.Notify("n1", fmt.Sprintf("notif %d,%d", i, j)); err != nil {
-				t.Fatal(err)
+	// Each sender interleaves requests and notifications without waiting for
+	// replies. Messages from a single sender must be written in order, but the
+	// messages of different senders may be written in any order.
+	var wg sync.WaitGroup
+	for sender := 0; sender < numSenders; sender++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for i := 0; i < numMessages; i++ {
+				if i%2 == 0 {
+					client.Go("call", nil, nil, sender, i)
+				} else if err := client.Notify("notify", sender, i); err != nil {
+					t.Error(err)
+					return
+				}
 			}
+		}(sender)
+	}
+
+	next := make([]int64, numSenders)
+	dec := msgpack.NewDecoder(serverConn)
+	for n := 0; n < numSenders*numMessages; n++ {
+		var message []interface{}
+		if err := dec.Decode(&message); err != nil {
+			t.Fatal(err)
 		}
-		for j := 0; j < n; j++ {
-			got := <-notifCh
-			want := fmt.Sprintf("notif %d,%d", i, j)
-			if got != want {
-				t.Fatalf("got %q, want %q", got, want)
-			}
+		var (
+			method string
+			args   []interface{}
+		)
+		switch len(message) {
+		case 4:
+			method, _ = message[2].(string)
+			args, _ = message[3].([]interface{})
+		case 3:
+			method, _ = message[1].(string)
+			args, _ = message[2].([]interface{})
+		}
+		if len(args) != 2 {
+			t.Fatalf("message %d: unexpected message %v", n, message)
 		}
+		sender, _ := args[0].(int64)
+		i, _ := args[1].(int64)
+		if want := next[sender]; i != want {
+			t.Fatalf("message %d: got %s %d from sender %d but want %d", n, method, i, sender, want)
+		}
+		next[sender]++
 	}
+
+	wg.Wait()
 }
 
 func TestArgs(t *testing.T) {
@@ -206,6 +1149,244 @@ func TestArgs(t *testing.T) {
 	}
 }
 
+func TestUnregister(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	if err := server.Register("echo", func(s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := client.Call("echo", &s, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Unregister("echo")
+	if err := client.Call("echo", &s, "hello"); err == nil {
+		t.Fatal("call to unregistered method returned nil error")
+	}
+}
+
+type testHandle int
+
+func (h testHandle) MarshalMsgPack(enc *msgpack.Encoder) error {
+	return enc.PackExtension(5, []byte{byte(h)})
+}
+
+func TestRegisterValidation(t *testing.T) {
+	t.Parallel()
+
+	client, _, cleanup := testClientServer(t)
+	defer cleanup()
+
+	tests := map[string]struct {
+		fn      interface{}
+		wantErr error
+	}{
+		"Nil":             {fn: nil, wantErr: ErrHandlerNotFunction},
+		"NotFunction":     {fn: "hello", wantErr: ErrHandlerNotFunction},
+		"TwoValues":       {fn: func() (int, int) { return 0, 0 }, wantErr: ErrInvalidHandlerReturn},
+		"NoError":         {fn: func() string { return "" }, wantErr: ErrInvalidHandlerReturn},
+		"ThreeResults":    {fn: func() (int, int, error) { return 0, 0, nil }, wantErr: ErrInvalidHandlerReturn},
+		"ChanResult":      {fn: func() (chan int, error) { return nil, nil }, wantErr: ErrInvalidHandlerReturn},
+		"FuncArg":         {fn: func(f func()) error { return nil }, wantErr: ErrInvalidHandlerArg},
+		"VariadicChanArg": {fn: func(s string, c ...chan int) {}, wantErr: ErrInvalidHandlerArg},
+		"NoResult":        {fn: func(s string) {}},
+		"Error":           {fn: func(s string) error { return nil }},
+		"Value":           {fn: func(s ...string) (int, error) { return 0, nil }},
+	}
+	for name, tt := range tests {
+		err := client.Register("method", tt.fn)
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s: Register returned error %v", name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", name, err, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), "method method") {
+			t.Errorf("%s: error %q does not name the method", name, err)
+		}
+	}
+}
+
+func TestRegisterExtension(t *testing.T) {
+	t.Parallel()
+
+	client, server, cleanup := testClientServer(t)
+	defer cleanup()
+
+	if err := server.Register("type", func(x interface{}) (string, error) {
+		return fmt.Sprintf("%T %v", x, x), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err := client.Call("type", &s, testHandle(3)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(s, "rpc.testHandle") {
+		t.Fatalf("unregistered extension decoded to %s", s)
+	}
+
+	decode := func(p []byte) (interface{}, error) {
+		return testHandle(p[0]), nil
+	}
+	if err := server.RegisterExtension(5, decode); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterExtension(5, decode); err == nil {
+		t.Fatal("duplicate registration returned nil error")
+	}
+
+	if err := client.Call("type", &s, testHandle(3)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "rpc.testHandle 3"; s != want {
+		t.Fatalf("got %q, want %q", s, want)
+	}
+}
+
+func TestOnDisconnect(t *testing.T) {
+	t.Parallel()
+
+	serverConn, clientConn := net.Pipe()
+
+	serverErr := make(chan error, 1)
+	server, err := NewEndpoint(serverConn, serverConn, serverConn, WithLogf(t.Logf), WithOnDisconnect(func(err error) { serverErr <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientErr := make(chan error, 1)
+	client, err := NewEndpoint(clientConn, clientConn, clientConn, WithLogf(t.Logf), WithOnDisconnect(func(err error) { clientErr <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- server.Serve() }()
+	go client.Serve()
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer closed the connection.
+	if err := <-serveDone; !errors.Is(err, ErrPeerClosed) || !errors.Is(err, io.EOF) {
+		t.Errorf("server.Serve() = %v, want %v", err, ErrPeerClosed)
+	}
+	if err := <-serverErr; err != io.EOF {
+		t.Errorf("server disconnect error = %v, want %v", err, io.EOF)
+	}
+
+	// The endpoint was closed locally.
+	if err := <-clientErr; err != ErrClosed {
+		t.Errorf("client disconnect error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WaitHandlers", func(t *testing.T) {
+		t.Parallel()
+
+		client, server, cleanup := testClientServer(t)
+		defer cleanup()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		if err := server.Register("slow", func() (string, error) {
+			close(started)
+			<-release
+			return "done", nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Register("fast", func() error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		var reply string
+		slow := client.Go("slow", nil, &reply)
+		<-started
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+		// Requests are rejected once the shutdown started.
+		for i := 0; ; i++ {
+			err := client.Call("fast", nil)
+			if err != nil {
+				if !strings.Contains(err.Error(), ErrShutdown.Error()) {
+					t.Fatalf("got error %v, want %v", err, ErrShutdown)
+				}
+				break
+			}
+			if i == 100 {
+				t.Fatal("request during shutdown returned nil error")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		select {
+		case err := <-shutdown:
+			t.Fatalf("Shutdown returned %v before the handler returned", err)
+		default:
+		}
+
+		close(release)
+		if err := (<-slow.Done).Err; err != nil {
+			t.Fatal(err)
+		}
+		if reply != "done" {
+			t.Fatalf("got reply %q, want %q", reply, "done")
+		}
+		if err := <-shutdown; err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Call("fast", nil); err == nil {
+			t.Fatal("call after shutdown returned nil error")
+		}
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		t.Parallel()
+
+		client, server, cleanup := testClientServer(t)
+		defer cleanup()
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+		if err := server.Register("block", func() error {
+			close(started)
+			<-release
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		block := client.Go("block", nil, nil)
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if err := (<-block.Done).Err; err == nil {
+			t.Fatal("call to closed endpoint returned nil error")
+		}
+	})
+}
+
 func TestCallAfterClose(t *testing.T) {
 	t.Parallel()
 
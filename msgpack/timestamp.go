@@ -0,0 +1,44 @@
+package msgpack
+
+import "time"
+
+// Timestamp is a time that encodes as the MessagePack timestamp extension
+// (type -1). It implements Marshaler and Unmarshaler.
+//
+// Encode and Decode handle a time.Time as any other struct, so use Timestamp
+// for values that are exchanged as timestamps:
+//
+//	type event struct {
+//		At msgpack.Timestamp `msgpack:"at"`
+//	}
+//
+// Decoding a MessagePack nil stores the zero time.
+type Timestamp struct {
+	time.Time
+}
+
+// compile time check whether the Timestamp implements Marshaler and Unmarshaler interfaces.
+var (
+	_ Marshaler   = Timestamp{}
+	_ Unmarshaler = (*Timestamp)(nil)
+)
+
+// MarshalMsgPack implements Marshaler.
+func (t Timestamp) MarshalMsgPack(e *Encoder) error {
+	return e.PackTime(t.Time)
+}
+
+// UnmarshalMsgPack implements Unmarshaler.
+func (t *Timestamp) UnmarshalMsgPack(d *Decoder) error {
+	if d.Type() == Nil {
+		t.Time = time.Time{}
+		return nil
+	}
+	x, err := d.Time()
+	if err != nil {
+		d.Skip()
+		return err
+	}
+	t.Time = x
+	return nil
+}
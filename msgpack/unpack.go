@@ -2,10 +2,14 @@ package msgpack
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"reflect"
+	"time"
 )
 
 // Type represents the type of value in the MessagePack stream.
@@ -57,18 +61,40 @@ func (t Type) String() string {
 // ErrDataSizeTooLarge is the data size too large error.
 var ErrDataSizeTooLarge = errors.New("msgpack: data size too large")
 
+// ErrLimitExceeded is the read limit exceeded error.
+var ErrLimitExceeded = errors.New("msgpack: read limit exceeded")
+
 // Decoder reads MessagePack objects from an io.Reader.
 type Decoder struct {
 	extensions ExtensionMap
+	mapBuilder func() MapBuilder
+	pool       BufferPool
+	pooled     bool
+	limit      int64
+	emptyNil   bool
+	strict     bool
+	limited    bool
 	err        error
 	r          *bufio.Reader
 	n          uint64
 	p          []byte
 	t          Type
+	code       byte
 	peek       bool
+
+	// open is the number of values remaining in the arrays and maps of the
+	// current value.
+	open int
 }
 
-const bufioReaderSize = 4096
+const (
+	bufioReaderSize = 4096
+
+	// maxPreallocSize is the largest string, binary or extension value that
+	// is allocated in full before the data is read from the stream. It is
+	// also the largest value read into a buffer from the BufferPool.
+	maxPreallocSize = 1 << 20
+)
 
 // NewDecoder allocates and initializes a new decoder.
 func NewDecoder(r io.Reader) *Decoder {
@@ -90,6 +116,133 @@ func (d *Decoder) SetExtensions(extensions ExtensionMap) {
 	d.extensions = extensions
 }
 
+// MapBuilder builds the Go value for a MessagePack map decoded to an empty
+// interface.
+type MapBuilder interface {
+	// Set adds an entry to the map. Entries are added in stream order.
+	Set(key, value interface{})
+
+	// Map returns the built map.
+	Map() interface{}
+}
+
+// SetMapBuilder specifies the function for creating a MapBuilder when a
+// MessagePack map is decoded to an empty interface.
+//
+// By default, maps are decoded to map[string]interface{} and entries with a
+// key other than a string are skipped with a DecodeConvertError. When a map
+// builder is set, keys of any type are decoded to an empty interface and
+// passed to the builder.
+func (d *Decoder) SetMapBuilder(f func() MapBuilder) {
+	d.mapBuilder = f
+}
+
+// InterfaceKeyMapBuilder returns a MapBuilder that builds a
+// map[interface{}]interface{}. Binary keys are converted to strings.
+//
+// Array and map keys cannot be used as keys of a Go map. Decode skips entries
+// with such keys and returns a DecodeConvertError.
+func InterfaceKeyMapBuilder() MapBuilder {
+	return interfaceKeyMapBuilder(make(map[interface{}]interface{}))
+}
+
+type interfaceKeyMapBuilder map[interface{}]interface{}
+
+func (m interfaceKeyMapBuilder) Set(key, value interface{}) {
+	if !isHashable(key) {
+		return
+	}
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+	m[key] = value
+}
+
+// isHashable reports whether key can be used as a key of the map built by
+// interfaceKeyMapBuilder. Binary keys are hashable because Set converts them
+// to strings.
+func isHashable(key interface{}) bool {
+	if _, ok := key.([]byte); ok {
+		return true
+	}
+	return key == nil || reflect.TypeOf(key).Comparable()
+}
+
+func (m interfaceKeyMapBuilder) Map() interface{} {
+	return map[interface{}]interface{}(m)
+}
+
+// SetEmptyStringAsNil specifies whether Decode treats an empty MessagePack
+// string or binary value as nil when decoding into a pointer.
+//
+// Many Vimscript functions return an empty string to indicate that there is no
+// value. When enabled, Decode sets the pointer to nil for an empty string
+// instead of pointing it at the zero value. Values decoded into non-pointer
+// types are not affected.
+func (d *Decoder) SetEmptyStringAsNil(v bool) {
+	d.emptyNil = v
+}
+
+// DisallowUnknownFields causes Decode to return an error when a MessagePack
+// map decoded into a struct has a key that does not match a struct field.
+// The check applies to nested structs too. Keys captured by a field with the
+// "extra" option are not unknown.
+//
+// As with other conversion errors, Decode completes the decoding and returns
+// a DecodeConvertError with UnknownField set for the first unknown key.
+func (d *Decoder) DisallowUnknownFields() {
+	d.strict = true
+}
+
+// BufferPool provides the buffers used by a Decoder to read string, binary and
+// extension values. Get is only called for values of at most 1MB; larger
+// values are read into a buffer that grows as the data arrives and that is
+// never passed to Put.
+type BufferPool interface {
+	// Get returns a buffer with length n. n is never greater than 1MB.
+	Get(n int) []byte
+
+	// Put returns a buffer obtained from Get to the pool.
+	Put(p []byte)
+}
+
+// SetBufferPool specifies a pool for the buffers used to read string, binary
+// and extension values that do not fit in the decoder's read buffer. Values
+// over 1MB bypass the pool. A nil pool restores the default of allocating a new
+// buffer for each value.
+//
+// With a pool, the buffer of the current value is returned to the pool by the
+// next call to Unpack, so the data returned by BytesNoCopy must be copied out
+// before then. Bytes and String always return a copy. Smaller values are read
+// without allocation by BytesNoCopy with or without a pool.
+func (d *Decoder) SetBufferPool(pool BufferPool) {
+	d.pool = pool
+}
+
+// SetLimit limits the number of bytes read from the stream, counted from the
+// call to SetLimit, to n.
+//
+// Unpack returns ErrLimitExceeded before reading a value that does not fit in
+// the remaining limit. Arrays and maps are rejected when the remaining limit is
+// too small for the number of elements they declare. A negative n removes the
+// limit.
+func (d *Decoder) SetLimit(n int64) {
+	d.limit = n
+	d.limited = n >= 0
+}
+
+// consume subtracts n bytes from the remaining limit.
+func (d *Decoder) consume(n int64) error {
+	if !d.limited {
+		return nil
+	}
+	if n > d.limit {
+		return ErrLimitExceeded
+	}
+	d.limit -= n
+	return nil
+}
+
 // Type returns the type of the current value in the stream.
 func (d *Decoder) Type() Type {
 	return d.t
@@ -103,9 +256,13 @@ func (d *Decoder) Extension() int {
 // Bytes returns the current String, Binary or Extension value as a slice of
 // bytes.
 func (d *Decoder) Bytes() []byte {
-	if d.peek {
+	if d.peek || d.pooled {
 		p := make([]byte, len(d.p))
 		copy(p, d.p)
+		if d.pooled {
+			d.pool.Put(d.p)
+			d.pooled = false
+		}
 		d.p = p
 	}
 
@@ -153,14 +310,89 @@ func (d *Decoder) Float() float64 {
 	return math.Float64frombits(d.n)
 }
 
+// Time returns the current Extension value as a time. Time returns an error if
+// the value is not a valid timestamp extension (type -1). The time is in the
+// local location.
+func (d *Decoder) Time() (time.Time, error) {
+	if d.t != Extension || int8(d.n) != timestampExtension {
+		return time.Time{}, &DecodeConvertError{SrcType: d.t, DestType: timeType}
+	}
+
+	p := d.p
+	var sec int64
+	var nsec uint32
+	switch len(p) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(p))
+	case 8:
+		n := binary.BigEndian.Uint64(p)
+		sec = int64(n & (1<<34 - 1))
+		nsec = uint32(n >> 34)
+	case 12:
+		nsec = binary.BigEndian.Uint32(p)
+		sec = int64(binary.BigEndian.Uint64(p[4:]))
+	default:
+		return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", len(p))
+	}
+	if nsec >= 1e9 {
+		return time.Time{}, fmt.Errorf("msgpack: invalid timestamp nanoseconds %d", nsec)
+	}
+	return time.Unix(sec, int64(nsec)), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// More reports whether there is another value in the stream. Use More to
+// decode a stream of concatenated values:
+//
+//	for dec.More() {
+//		if err := dec.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//
+// More returns false at the end of the stream or if reading fails. If reading
+// fails with an error other than io.EOF, the next call to Unpack or Decode
+// returns the error. More may overwrite the data returned by BytesNoCopy.
+func (d *Decoder) More() bool {
+	if d.err != nil {
+		return false
+	}
+	if _, err := d.r.Peek(1); err != nil {
+		if err != io.EOF {
+			d.err = err
+		}
+		return false
+	}
+	return true
+}
+
 // Unpack reads the next value from the MessagePack stream. Call Type to get the
 // type of the current value. Call Bool, Uint, Int, Float, Bytes or Extension
 // to get the value.
 func (d *Decoder) Unpack() error {
+	if err := d.next(); err != nil {
+		return err
+	}
+	if d.open > 0 {
+		d.open--
+	}
+	d.open += d.skipCount()
+	return nil
+}
+
+// next reads the next value from the stream.
+func (d *Decoder) next() error {
 	if d.err != nil {
 		return d.err
 	}
 
+	if d.pooled {
+		d.pool.Put(d.p)
+		d.p = nil
+		d.pooled = false
+	}
+
 	code, err := d.r.ReadByte()
 	if err != nil {
 		// Don't call d.fatal here because we don't want io.EOF converted to
@@ -171,13 +403,26 @@ func (d *Decoder) Unpack() error {
 
 	f := formats[code]
 	d.t = f.t
+	d.code = code
 
 	d.n, err = f.n(d, code)
 	if err != nil {
 		return d.fatal(err)
 	}
 
+	if err := d.consume(1 + headerLen(code)); err != nil {
+		return d.fatal(err)
+	}
+
 	if !f.more {
+		if d.limited {
+			// Each element takes at least one byte.
+			switch {
+			case f.t == ArrayLen && d.n > uint64(d.limit),
+				f.t == MapLen && d.n > uint64(d.limit)/2:
+				return d.fatal(ErrLimitExceeded)
+			}
+		}
 		d.p = nil
 		return nil
 	}
@@ -187,6 +432,14 @@ func (d *Decoder) Unpack() error {
 		return d.fatal(ErrDataSizeTooLarge)
 	}
 
+	size := int64(nn)
+	if f.t == Extension {
+		size++
+	}
+	if err := d.consume(size); err != nil {
+		return d.fatal(err)
+	}
+
 	if f.t == Extension {
 		var b byte
 		b, err = d.r.ReadByte()
@@ -203,13 +456,32 @@ func (d *Decoder) Unpack() error {
 			return d.fatal(err)
 		}
 		d.r.Discard(nn)
-	} else {
+	} else if nn <= maxPreallocSize {
 		d.peek = false
-		d.p = make([]byte, nn)
+		if d.pool != nil {
+			d.p = d.pool.Get(nn)[:nn]
+			d.pooled = true
+		} else {
+			d.p = make([]byte, nn)
+		}
 		_, err := io.ReadFull(d.r, d.p)
 		if err != nil {
 			return d.fatal(err)
 		}
+	} else {
+		// Grow the buffer as data arrives so that a corrupt length does not
+		// allocate a large buffer for a short stream. The buffer pool is not
+		// used for these values.
+		d.peek = false
+		var buf bytes.Buffer
+		_, err := io.CopyN(&buf, d.r, int64(nn))
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return d.fatal(err)
+		}
+		d.p = buf.Bytes()
 	}
 
 	return nil
@@ -230,6 +502,47 @@ func (d *Decoder) Skip() error {
 	return nil
 }
 
+// appendRaw appends the encoding of the current value, including the nested
+// values of an array or a map, to p. The values are read from the stream as
+// with Skip.
+func (d *Decoder) appendRaw(p []byte) ([]byte, error) {
+	p = d.appendRawValue(p)
+	n := d.skipCount()
+
+	for n > 0 {
+		n--
+		if err := d.Unpack(); err != nil {
+			return p, err
+		}
+		p = d.appendRawValue(p)
+		n += d.skipCount()
+	}
+
+	return p, nil
+}
+
+// appendRawValue appends the encoding of the current value, without nested
+// values, to p. The encoding is the same as the encoding read from the stream.
+func (d *Decoder) appendRawValue(p []byte) []byte {
+	p = append(p, d.code)
+	n := d.n
+	switch d.code {
+	case ext8Code, ext16Code, ext32Code:
+		// d.n holds the extension type.
+		n = uint64(len(d.p))
+	case float32Code:
+		// d.n holds the value converted to float64.
+		n = uint64(math.Float32bits(float32(math.Float64frombits(d.n))))
+	}
+	for i := headerLen(d.code) - 1; i >= 0; i-- {
+		p = append(p, byte(n>>(8*uint(i))))
+	}
+	if d.t == Extension {
+		p = append(p, byte(d.n))
+	}
+	return append(p, d.p...)
+}
+
 func (d *Decoder) skipCount() int {
 	switch d.Type() {
 	case ArrayLen:
@@ -447,6 +760,23 @@ func init() {
 	}
 }
 
+// headerLen returns the number of bytes following the format code in the
+// header of a value.
+func headerLen(code byte) int64 {
+	switch code {
+	case uint8Code, int8Code, binary8Code, string8Code, ext8Code:
+		return 1
+	case uint16Code, int16Code, binary16Code, string16Code, ext16Code, array16Code, map16Code:
+		return 2
+	case uint32Code, int32Code, float32Code, binary32Code, string32Code, ext32Code, array32Code, map32Code:
+		return 4
+	case uint64Code, int64Code, float64Code:
+		return 8
+	default:
+		return 0
+	}
+}
+
 func (d *Decoder) fatal(err error) error {
 	if err == io.EOF {
 		err = io.ErrUnexpectedEOF
@@ -3,7 +3,9 @@ package msgpack
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
+	"reflect"
 	"testing"
 )
 
@@ -650,3 +652,256 @@ func TestUnpackEOF(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoderMore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Stream", func(t *testing.T) {
+		t.Parallel()
+
+		p, err := pack("hello", int64(1), arrayLen(2), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []interface{}
+		d := NewDecoder(bytes.NewReader(p))
+		for d.More() {
+			var v interface{}
+			if err := d.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+		want := []interface{}{"hello", int64(1), []interface{}{true, nil}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+		if err := d.Unpack(); err != io.EOF {
+			t.Fatalf("got error %v after More returned false, want %v", err, io.EOF)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+
+		d := NewDecoder(bytes.NewReader(nil))
+		if d.More() {
+			t.Fatal("More returned true for an empty stream")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+
+		errRead := errors.New("read error")
+		d := NewDecoder(errReader{errRead})
+		if d.More() {
+			t.Fatal("More returned true for a failing reader")
+		}
+		var v interface{}
+		if err := d.Decode(&v); err != errRead {
+			t.Fatalf("got error %v, want %v", err, errRead)
+		}
+	})
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+func TestUnpackLimit(t *testing.T) {
+	t.Parallel()
+
+	for name, tt := range unpackTests {
+		if tt.typ == Invalid || tt.typ == ArrayLen || tt.typ == MapLen {
+			continue
+		}
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, h := range tt.hs {
+				p, err := hex.DecodeString(h)
+				if err != nil {
+					t.Fatalf("decode(%s) returned error %v", h, err)
+				}
+
+				d := NewDecoder(bytes.NewReader(p))
+				d.SetLimit(int64(len(p)))
+				if err := d.Unpack(); err != nil {
+					t.Fatalf("unpack(%s) with limit %d returned %v", h, len(p), err)
+				}
+
+				d = NewDecoder(bytes.NewReader(p))
+				d.SetLimit(int64(len(p) - 1))
+				if err := d.Unpack(); err != ErrLimitExceeded {
+					t.Fatalf("unpack(%s) with limit %d returned %v, want %v", h, len(p)-1, err, ErrLimitExceeded)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackLimitContainer(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		hs      string
+		limit   int64
+		wantErr error
+	}{
+		"Array": {
+			hs:    "93010203",
+			limit: 4,
+		},
+		"ArrayTooLong": {
+			hs:      "dd7fffffff",
+			limit:   1024,
+			wantErr: ErrLimitExceeded,
+		},
+		"Map": {
+			hs:    "82010203a0",
+			limit: 5,
+		},
+		"MapTooLong": {
+			hs:      "83010203040506",
+			limit:   6,
+			wantErr: ErrLimitExceeded,
+		},
+		"NoLimit": {
+			hs:    "dc0010",
+			limit: -1,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := hex.DecodeString(tt.hs)
+			if err != nil {
+				t.Fatalf("decode(%s) returned error %v", tt.hs, err)
+			}
+
+			d := NewDecoder(bytes.NewReader(p))
+			d.SetLimit(tt.limit)
+			if err := d.Unpack(); err != tt.wantErr {
+				t.Fatalf("unpack(%s) with limit %d returned %v, want %v", tt.hs, tt.limit, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type testBufferPool struct {
+	free [][]byte
+	gets int
+	puts int
+}
+
+func (p *testBufferPool) Get(n int) []byte {
+	p.gets++
+	for i, b := range p.free {
+		if cap(b) >= n {
+			p.free = append(p.free[:i], p.free[i+1:]...)
+			return b[:n]
+		}
+	}
+	return make([]byte, n)
+}
+
+func (p *testBufferPool) Put(b []byte) {
+	p.puts++
+	p.free = append(p.free, b)
+}
+
+func TestDecoderBufferPool(t *testing.T) {
+	t.Parallel()
+
+	large := func(c byte) string {
+		return string(bytes.Repeat([]byte{c}, bufioReaderSize+1))
+	}
+
+	p, err := pack(large('a'), "small", large('b'), large('c'), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := &testBufferPool{}
+	d := NewDecoder(bytes.NewReader(p))
+	d.SetBufferPool(pool)
+
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(d.BytesNoCopy()); got != large('a') {
+		t.Fatalf("got %.10q..., want %.10q...", got, large('a'))
+	}
+	first := d.BytesNoCopy()
+
+	// Small values are read from the decoder's buffer and the buffer of the
+	// previous value is returned to the pool.
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(d.BytesNoCopy()); got != "small" {
+		t.Fatalf("got %q, want %q", got, "small")
+	}
+	if pool.gets != 1 || pool.puts != 1 {
+		t.Fatalf("got %d gets and %d puts, want 1 and 1", pool.gets, pool.puts)
+	}
+
+	// The next large value reuses the buffer.
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.BytesNoCopy(); &got[0] != &first[0] {
+		t.Fatal("buffer from pool was not reused")
+	}
+
+	// Bytes returns a copy that is not overwritten by the next value.
+	b := d.Bytes()
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != large('b') {
+		t.Fatalf("got %.10q..., want %.10q...", got, large('b'))
+	}
+	if got := string(d.BytesNoCopy()); got != large('c') {
+		t.Fatalf("got %.10q..., want %.10q...", got, large('c'))
+	}
+
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.gets != pool.puts {
+		t.Fatalf("got %d gets and %d puts, want equal counts", pool.gets, pool.puts)
+	}
+}
+
+func TestDecoderBufferPoolOverLimit(t *testing.T) {
+	t.Parallel()
+
+	large := string(bytes.Repeat([]byte{'a'}, maxPreallocSize+1))
+	p, err := pack(large, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := &testBufferPool{}
+	d := NewDecoder(bytes.NewReader(p))
+	d.SetBufferPool(pool)
+
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(d.BytesNoCopy()); got != large {
+		t.Fatalf("got %d bytes, want %d", len(got), len(large))
+	}
+	if err := d.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if pool.gets != 0 || pool.puts != 0 {
+		t.Fatalf("got %d gets and %d puts for a value over the limit, want 0 and 0", pool.gets, pool.puts)
+	}
+}
@@ -53,6 +53,12 @@ func (x Buffer) String() string {
 	return fmt.Sprintf("Buffer:%d", int(x))
 }
 
+// Int returns the handle of the Buffer as an integer. The handle is
+// independent of the string representation and the MessagePack encoding.
+func (x Buffer) Int() int {
+	return int(x)
+}
+
 // Tabpage represents a Nvim tabpage.
 type Tabpage int
 
@@ -73,6 +79,12 @@ func (x Tabpage) String() string {
 	return fmt.Sprintf("Tabpage:%d", int(x))
 }
 
+// Int returns the handle of the Tabpage as an integer. The handle is
+// independent of the string representation and the MessagePack encoding.
+func (x Tabpage) Int() int {
+	return int(x)
+}
+
 // Window represents a Nvim window.
 type Window int
 
@@ -93,6 +105,12 @@ func (x Window) String() string {
 	return fmt.Sprintf("Window:%d", int(x))
 }
 
+// Int returns the handle of the Window as an integer. The handle is
+// independent of the string representation and the MessagePack encoding.
+func (x Window) Int() int {
+	return int(x)
+}
+
 // Exec executes Vimscript (multiline block of Ex-commands), like anonymous source.
 //
 // Unlike Command, this function supports heredocs, script-scope (s:), etc.
@@ -760,6 +778,44 @@ func (b *Batch) OptionInfo(name string, opinfo *OptionInfo) {
 	b.call("nvim_get_option_info", opinfo, name)
 }
 
+// OptionInfo2 gets the option information for one option from arbitrary buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+//
+// Resulting dictionary has the same keys as OptionInfo.
+func (v *Nvim) OptionInfo2(name string, opts map[string]interface{}) (opinfo *OptionInfo, err error) {
+	var result OptionInfo
+	err = v.call("nvim_get_option_info2", &result, name, opts)
+	return &result, err
+}
+
+// OptionInfo2 gets the option information for one option from arbitrary buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+//
+// Resulting dictionary has the same keys as OptionInfo.
+func (b *Batch) OptionInfo2(name string, opts map[string]interface{}, opinfo *OptionInfo) {
+	b.call("nvim_get_option_info2", opinfo, name, opts)
+}
+
 // SetOption sets an option value.
 func (v *Nvim) SetOption(name string, value interface{}) error {
 	return v.call("nvim_set_option", nil, name, value)
@@ -770,6 +826,84 @@ func (b *Batch) SetOption(name string, value interface{}) {
 	b.call("nvim_set_option", nil, name, value)
 }
 
+// OptionValue gets the value of an option.
+//
+// The behavior of this function matches that of ":set": the local value of an option is returned if it exists;
+// otherwise, the global value is returned.
+// Local values always correspond to the current buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+func (v *Nvim) OptionValue(name string, opts map[string]interface{}, result interface{}) error {
+	return v.call("nvim_get_option_value", result, name, opts)
+}
+
+// OptionValue gets the value of an option.
+//
+// The behavior of this function matches that of ":set": the local value of an option is returned if it exists;
+// otherwise, the global value is returned.
+// Local values always correspond to the current buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+func (b *Batch) OptionValue(name string, opts map[string]interface{}, result interface{}) {
+	b.call("nvim_get_option_value", &result, name, opts)
+}
+
+// SetOptionValue sets the value of an option.
+//
+// The behavior of this function matches that of ":set": for global-local options, both the global and local value are set
+// unless otherwise specified with scope.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for setting window local options.
+//
+//  buf
+// Used for setting buffer local options. Implies scope is "local".
+func (v *Nvim) SetOptionValue(name string, value interface{}, opts map[string]interface{}) error {
+	return v.call("nvim_set_option_value", nil, name, value, opts)
+}
+
+// SetOptionValue sets the value of an option.
+//
+// The behavior of this function matches that of ":set": for global-local options, both the global and local value are set
+// unless otherwise specified with scope.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for setting window local options.
+//
+//  buf
+// Used for setting buffer local options. Implies scope is "local".
+func (b *Batch) SetOptionValue(name string, value interface{}, opts map[string]interface{}) {
+	b.call("nvim_set_option_value", nil, name, value, opts)
+}
+
 // Echo echo a message.
 //
 // The chunks is a list of [text, hl_group] arrays, each representing a
@@ -964,6 +1098,32 @@ func (b *Batch) OpenTerm(buffer Buffer, opts map[string]interface{}, channel *in
 	b.call("nvim_open_term", channel, buffer, opts)
 }
 
+// ChanSend sends data to channel.
+//
+// For a job, it writes data to the stdin of the process. For the stdio channel,
+// it writes to Nvim's stdout. For an internal terminal instance (see OpenTerm)
+// it writes directly to terminal output.
+//
+// This function writes raw data, not RPC messages. If the channel was created
+// with "rpc=true" then the channel expects RPC messages, use rpcnotify() and
+// rpcrequest() instead.
+func (v *Nvim) ChanSend(channel int, data string) error {
+	return v.call("nvim_chan_send", nil, channel, data)
+}
+
+// ChanSend sends data to channel.
+//
+// For a job, it writes data to the stdin of the process. For the stdio channel,
+// it writes to Nvim's stdout. For an internal terminal instance (see OpenTerm)
+// it writes directly to terminal output.
+//
+// This function writes raw data, not RPC messages. If the channel was created
+// with "rpc=true" then the channel expects RPC messages, use rpcnotify() and
+// rpcrequest() instead.
+func (b *Batch) ChanSend(channel int, data string) {
+	b.call("nvim_chan_send", nil, channel, data)
+}
+
 // OpenWindow open a new window.
 //
 // Currently this is used to open floating and external windows.
@@ -1578,6 +1738,53 @@ func (b *Batch) ParseExpression(expr string, flags string, highlight bool, expre
 	b.call("nvim_parse_expression", expression, expr, flags, highlight)
 }
 
+// ParseCmd parses the command line str into its structured components.
+//
+// Doesn't check the validity of command arguments.
+//
+// The opts arg is optional parameters. Reserved for future use.
+func (v *Nvim) ParseCmd(str string, opts map[string]interface{}) (cmd *ParsedCmd, err error) {
+	var result ParsedCmd
+	err = v.call("nvim_parse_cmd", &result, str, opts)
+	return &result, err
+}
+
+// ParseCmd parses the command line str into its structured components.
+//
+// Doesn't check the validity of command arguments.
+//
+// The opts arg is optional parameters. Reserved for future use.
+func (b *Batch) ParseCmd(str string, opts map[string]interface{}, cmd *ParsedCmd) {
+	b.call("nvim_parse_cmd", cmd, str, opts)
+}
+
+// Autocmds gets all autocommands matching all the criteria in opts.
+//
+// The opts arg is dict with the following keys:
+//
+//   - group: name or id of the autocommand group.
+//   - event: event or list of events.
+//   - pattern: pattern or list of patterns to match. Cannot be used with buffer.
+//   - buffer: buffer number or list of buffer numbers for buffer-local
+//     autocommands. Cannot be used with pattern.
+func (v *Nvim) Autocmds(opts map[string]interface{}) (result []*Autocmd, err error) {
+	err = v.call("nvim_get_autocmds", &result, opts)
+	return result, err
+}
+
+// Autocmds gets all autocommands matching all the criteria in opts.
+//
+// The opts arg is dict with the following keys:
+//
+//   - group: name or id of the autocommand group.
+//   - event: event or list of events.
+//   - pattern: pattern or list of patterns to match. Cannot be used with buffer.
+//   - buffer: buffer number or list of buffer numbers for buffer-local
+//     autocommands. Cannot be used with pattern.
+func (b *Batch) Autocmds(opts map[string]interface{}, result *[]*Autocmd) {
+	b.call("nvim_get_autocmds", result, opts)
+}
+
 // UIs gets a list of dictionaries representing attached UIs.
 func (v *Nvim) UIs() (uis []*UI, err error) {
 	err = v.call("nvim_list_uis", &uis)
@@ -344,11 +344,69 @@ func OptionInfo(name string) (opinfo OptionInfo) {
 	returnPtr()
 }
 
+// OptionInfo2 gets the option information for one option from arbitrary buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+//
+// Resulting dictionary has the same keys as OptionInfo.
+func OptionInfo2(name string, opts map[string]interface{}) (opinfo OptionInfo) {
+	name(nvim_get_option_info2)
+	returnPtr()
+}
+
 // SetOption sets an option value.
 func SetOption(name string, value interface{}) {
 	name(nvim_set_option)
 }
 
+// OptionValue gets the value of an option.
+//
+// The behavior of this function matches that of ":set": the local value of an option is returned if it exists;
+// otherwise, the global value is returned.
+// Local values always correspond to the current buffer or window.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for getting window local options.
+//
+//  buf
+// Used for getting buffer local options. Implies scope is "local".
+func OptionValue(name string, opts map[string]interface{}) (optionValue interface{}) {
+	name(nvim_get_option_value)
+}
+
+// SetOptionValue sets the value of an option.
+//
+// The behavior of this function matches that of ":set": for global-local options, both the global and local value are set
+// unless otherwise specified with scope.
+//
+// The opts arg is optional parameters.
+//
+//  scope
+// Analogous to ":setglobal" and ":setlocal", respectively. Must be a OptionValueScope.
+//
+//  win
+// Used for setting window local options.
+//
+//  buf
+// Used for setting buffer local options. Implies scope is "local".
+func SetOptionValue(name string, value interface{}, opts map[string]interface{}) {
+	name(nvim_set_option_value)
+}
+
 // Echo echo a message.
 //
 // The chunks is a list of [text, hl_group] arrays, each representing a
@@ -443,6 +501,19 @@ func OpenTerm(buffer Buffer, opts map[string]interface{}) (channel int) {
 	name(nvim_open_term)
 }
 
+// ChanSend sends data to channel.
+//
+// For a job, it writes data to the stdin of the process. For the stdio channel,
+// it writes to Nvim's stdout. For an internal terminal instance (see OpenTerm)
+// it writes directly to terminal output.
+//
+// This function writes raw data, not RPC messages. If the channel was created
+// with "rpc=true" then the channel expects RPC messages, use rpcnotify() and
+// rpcrequest() instead.
+func ChanSend(channel int, data string) {
+	name(nvim_chan_send)
+}
+
 // OpenWindow open a new window.
 //
 // Currently this is used to open floating and external windows.
@@ -743,6 +814,29 @@ func ParseExpression(expr, flags string, highlight bool) (expression map[string]
 	name(nvim_parse_expression)
 }
 
+// ParseCmd parses the command line str into its structured components.
+//
+// Doesn't check the validity of command arguments.
+//
+// The opts arg is optional parameters. Reserved for future use.
+func ParseCmd(str string, opts map[string]interface{}) (cmd ParsedCmd) {
+	name(nvim_parse_cmd)
+	returnPtr()
+}
+
+// Autocmds gets all autocommands matching all the criteria in opts.
+//
+// The opts arg is dict with the following keys:
+//
+//   - group: name or id of the autocommand group.
+//   - event: event or list of events.
+//   - pattern: pattern or list of patterns to match. Cannot be used with buffer.
+//   - buffer: buffer number or list of buffer numbers for buffer-local
+//     autocommands. Cannot be used with pattern.
+func Autocmds(opts map[string]interface{}) (result []*Autocmd) {
+	name(nvim_get_autocmds)
+}
+
 // UIs gets a list of dictionaries representing attached UIs.
 func UIs() (uis []*UI) {
 	name(nvim_list_uis)
@@ -236,6 +236,12 @@ func (x *{{$name}}) UnmarshalMsgPack(dec *msgpack.Decoder) error {
 func (x {{$name}}) String() string {
 	return fmt.Sprintf("{{$name}}:%d", int(x))
 }
+
+// Int returns the handle of the {{$name}} as an integer. The handle is
+// independent of the string representation and the MessagePack encoding.
+func (x {{$name}}) Int() int {
+	return int(x)
+}
 {{end}}
 
 {{range .Functions}}
@@ -1,8 +1,12 @@
 package nvim
 
 import (
+	"bytes"
 	"encoding/hex"
+	"fmt"
 	"testing"
+
+	"github.com/neovim/go-client/msgpack"
 )
 
 var decodeExtTests = []struct {
@@ -58,3 +62,32 @@ func TestEncodeExt(t *testing.T) {
 		}
 	}
 }
+
+func TestHandles(t *testing.T) {
+	tests := []struct {
+		x      interface{ Int() int }
+		n      int
+		s      string
+		encode string
+	}{
+		{Buffer(3), 3, "Buffer:3", "c70500d200000003"},
+		{Window(1000), 1000, "Window:1000", "c70501d2000003e8"},
+		{Tabpage(2), 2, "Tabpage:2", "c70502d200000002"},
+	}
+	for _, tt := range tests {
+		if n := tt.x.Int(); n != tt.n {
+			t.Errorf("%v.Int() = %d, want %d", tt.x, n, tt.n)
+		}
+		if s := fmt.Sprint(tt.x); s != tt.s {
+			t.Errorf("fmt.Sprint(%d) = %s, want %s", tt.n, s, tt.s)
+		}
+
+		var buf bytes.Buffer
+		if err := msgpack.NewEncoder(&buf).Encode(tt.x); err != nil {
+			t.Fatal(err)
+		}
+		if h := hex.EncodeToString(buf.Bytes()); h != tt.encode {
+			t.Errorf("Encode(%v) = %s, want %s", tt.x, h, tt.encode)
+		}
+	}
+}
@@ -1,7 +1,20 @@
 package nvim
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/neovim/go-client/msgpack"
 )
 
 type bufferReader struct {
@@ -55,3 +68,1996 @@ func (r *bufferReader) Read(p []byte) (n int, err error) {
 		r.lines[0] = line0[nn:]
 	}
 }
+
+// defaultLineChunk is the number of lines fetched at a time by a LineIterator
+// created with a chunk size <= 0.
+const defaultLineChunk = 1000
+
+// LineIterator iterates over the lines of a buffer without loading the whole
+// buffer. Create a LineIterator with BufferLineIterator.
+//
+// The iterator fetches the lines in chunks. While the application processes
+// the lines of a chunk, the next chunk is fetched in the background.
+type LineIterator struct {
+	v     *Nvim
+	b     Buffer
+	chunk int
+
+	next    int            // index of the first line of the next chunk
+	lines   [][]byte       // lines of the current chunk not returned yet
+	pending chan lineChunk // result of the chunk being fetched, if any
+	started bool
+	err     error
+}
+
+// lineChunk is the result of fetching a chunk of lines.
+type lineChunk struct {
+	lines [][]byte
+	err   error
+}
+
+// BufferLineIterator returns an iterator over the lines of buffer. The lines
+// are fetched with BufferLines in chunks of chunk lines, or 1000 lines if
+// chunk <= 0. If buffer = 0, then the current buffer is used.
+//
+// Each chunk is fetched with the bounds of the buffer at the time of the
+// fetch, so the iterator does not fail if lines are added or deleted during
+// the iteration. The iteration ends at the first chunk with fewer lines than
+// chunk. Lines added or deleted before the current position shift the lines
+// returned for the following chunks.
+func (v *Nvim) BufferLineIterator(buffer Buffer, chunk int) *LineIterator {
+	if chunk <= 0 {
+		chunk = defaultLineChunk
+	}
+	return &LineIterator{v: v, b: buffer, chunk: chunk}
+}
+
+// Next returns the next line. Next returns false when there are no more lines
+// or when fetching the lines failed. Use Err to distinguish the two cases.
+func (it *LineIterator) Next() (line string, ok bool) {
+	if !it.started {
+		it.started = true
+		it.fetch()
+	}
+	for len(it.lines) == 0 {
+		if it.pending == nil {
+			return "", false
+		}
+		c := <-it.pending
+		it.pending = nil
+		if c.err != nil {
+			it.err = c.err
+			return "", false
+		}
+		it.lines = c.lines
+		if len(c.lines) == it.chunk {
+			it.fetch()
+		}
+	}
+	line = string(it.lines[0])
+	it.lines[0] = nil
+	it.lines = it.lines[1:]
+	return line, true
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *LineIterator) Err() error {
+	return it.err
+}
+
+// fetch starts fetching the next chunk of lines.
+func (it *LineIterator) fetch() {
+	start, end := it.next, it.next+it.chunk
+	it.next = end
+	ch := make(chan lineChunk, 1)
+	it.pending = ch
+	go func() {
+		lines, err := it.v.BufferLines(it.b, start, end, false)
+		ch <- lineChunk{lines: lines, err: err}
+	}()
+}
+
+// OptionWasSet reports whether the named option was explicitly set, as opposed
+// to still holding its default value.
+//
+// The scope arg selects the global or local value of a global-local option.
+// The empty scope resolves the value the same way as ":set".
+func (v *Nvim) OptionWasSet(name string, scope OptionValueScope) (bool, error) {
+	opts := make(map[string]interface{})
+	if scope != "" {
+		opts["scope"] = scope
+	}
+	info, err := v.OptionInfo2(name, opts)
+	if err != nil {
+		return false, err
+	}
+	return info.WasSet, nil
+}
+
+// Option gets the buffer-local value of the named option for buffer x.
+//
+// Option returns an error if the option is not a buffer option, instead of
+// returning the global value. A global-local option is a buffer option if its
+// local value is buffer-local.
+func (x Buffer) Option(v *Nvim, name string, result interface{}) error {
+	return localOption(v, name, "buf", x, result)
+}
+
+// SetOption sets the buffer-local value of the named option for buffer x.
+//
+// SetOption returns an error if the option is not a buffer option, instead of
+// setting the global value. A global-local option is a buffer option if its
+// local value is buffer-local.
+func (x Buffer) SetOption(v *Nvim, name string, value interface{}) error {
+	return setLocalOption(v, name, "buf", x, value)
+}
+
+// Option gets the window-local value of the named option for window x.
+//
+// Option returns an error if the option is not a window option, instead of
+// returning the global value. A global-local option is a window option if its
+// local value is window-local.
+func (x Window) Option(v *Nvim, name string, result interface{}) error {
+	return localOption(v, name, "win", x, result)
+}
+
+// SetOption sets the window-local value of the named option for window x.
+//
+// SetOption returns an error if the option is not a window option, instead of
+// setting the global value. A global-local option is a window option if its
+// local value is window-local.
+func (x Window) SetOption(v *Nvim, name string, value interface{}) error {
+	return setLocalOption(v, name, "win", x, value)
+}
+
+// localOption gets the value of the named option for the buffer or window
+// handle. The scope is "buf" or "win". The value is stored in result only if
+// the scope of the option matches.
+func localOption(v *Nvim, name, scope string, handle interface{}, result interface{}) error {
+	var (
+		info  OptionInfo
+		value msgpack.RawMessage
+	)
+	b := v.NewBatch()
+	b.OptionInfo(name, &info)
+	b.OptionValue(name, map[string]interface{}{scope: handle}, &value)
+	if err := b.Execute(); err != nil {
+		return err
+	}
+	if err := checkOptionScope(&info, scope); err != nil {
+		return err
+	}
+	return msgpack.Unmarshal(value, result)
+}
+
+// setLocalOption sets the value of the named option for the buffer or window
+// handle. The scope is "buf" or "win".
+func setLocalOption(v *Nvim, name, scope string, handle interface{}, value interface{}) error {
+	info, err := v.OptionInfo(name)
+	if err != nil {
+		return err
+	}
+	if err := checkOptionScope(info, scope); err != nil {
+		return err
+	}
+	return v.SetOptionValue(name, value, map[string]interface{}{scope: handle})
+}
+
+// checkOptionScope returns an error if the option described by info cannot be
+// accessed for scope. The scope of a global-local option is the scope of its
+// local value, and its global value can also be accessed for scope "global".
+func checkOptionScope(info *OptionInfo, scope string) error {
+	if info.Scope == scope || (scope == "global" && info.GlobalLocal) {
+		return nil
+	}
+	return fmt.Errorf("nvim: option %q has scope %q, not %q", info.Name, info.Scope, scope)
+}
+
+// Call calls fn with window x temporarily made the current window, like
+// nvim_win_call, and returns the result of fn.
+//
+// Nvim waits while fn runs, so the Nvim methods called by fn see x as the
+// current window. After fn returns, Nvim restores the current window. The
+// error returned by fn is returned as is.
+//
+// fn runs on a different goroutine than the caller. The client must be
+// serving incoming requests, see Serve.
+func (x Window) Call(v *Nvim, fn func() (interface{}, error)) (interface{}, error) {
+	return v.callIn("nvim_win_call", x, fn)
+}
+
+// Call calls fn with buffer x temporarily made the current buffer, like
+// nvim_buf_call, and returns the result of fn. See Window.Call for details.
+func (x Buffer) Call(v *Nvim, fn func() (interface{}, error)) (interface{}, error) {
+	return v.callIn("nvim_buf_call", x, fn)
+}
+
+// callbackMethod is the name of the request method used by Nvim to run the
+// function passed to Window.Call or Buffer.Call.
+const callbackMethod = "nvim_go_callback"
+
+// callIn calls fn from the callback passed to the Nvim API function named
+// apiFn with target. The callback sends a request to this client, which runs
+// fn before replying.
+func (v *Nvim) callIn(apiFn string, target interface{}, fn func() (interface{}, error)) (interface{}, error) {
+	v.callbacksOnce.Do(func() {
+		v.callbacks = make(map[int]func() error)
+		v.callbacksError = v.RegisterHandler(callbackMethod, v.runCallback)
+	})
+	if v.callbacksError != nil {
+		return nil, v.callbacksError
+	}
+	client, err := v.ChannelIDErr()
+	if err != nil {
+		return nil, err
+	}
+
+	type callResult struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan callResult, 1)
+
+	v.callbacksMu.Lock()
+	v.callbackID++
+	id := v.callbackID
+	v.callbacks[id] = func() error {
+		value, err := fn()
+		done <- callResult{value: value, err: err}
+		return err
+	}
+	v.callbacksMu.Unlock()
+
+	defer func() {
+		v.callbacksMu.Lock()
+		delete(v.callbacks, id)
+		v.callbacksMu.Unlock()
+	}()
+
+	const code = `
+local api_fn, target, client, method, id = ...
+vim.api[api_fn](target, function()
+  vim.rpcrequest(client, method, id)
+end)
+`
+	err = v.ExecLua(code, nil, apiFn, target, client, callbackMethod, id)
+	select {
+	case r := <-done:
+		if r.err != nil {
+			// The error is also raised in Nvim. Return the original error
+			// instead of the error reported by Nvim.
+			return nil, r.err
+		}
+		if err != nil {
+			return nil, err
+		}
+		return r.value, nil
+	default:
+		return nil, err
+	}
+}
+
+// runCallback runs the callback with id. It is called by Nvim with a request.
+func (v *Nvim) runCallback(id int) error {
+	v.callbacksMu.Lock()
+	fn, ok := v.callbacks[id]
+	v.callbacksMu.Unlock()
+	if !ok {
+		return fmt.Errorf("nvim: unknown callback %d", id)
+	}
+	return fn()
+}
+
+// ErrConflict is returned by Buffer.ModifyLines when the buffer changed
+// between reading and writing the lines.
+var ErrConflict = errors.New("nvim: buffer changed")
+
+// ModifyLines replaces the lines from start to end of buffer x with the lines
+// returned by modify. The start and end args are zero-based and end is
+// exclusive. Negative indices are interpreted as length+1+index.
+//
+// ModifyLines reads the lines and the buffer's changedtick, calls modify with
+// the lines and then, in a single batch, checks that the changedtick is
+// unchanged and sets the lines. If the buffer was changed in the meantime, the
+// lines are not set and ErrConflict is returned, so the caller can retry. An
+// error returned by modify and other errors, such as an invalid buffer, are
+// returned as is.
+func (x Buffer) ModifyLines(v *Nvim, start, end int, modify func(old []string) (new []string, err error)) error {
+	var (
+		lines [][]byte
+		tick  int
+	)
+	b := v.NewBatch()
+	b.BufferLines(x, start, end, true, &lines)
+	b.BufferChangedTick(x, &tick)
+	if err := b.Execute(); err != nil {
+		return err
+	}
+
+	old := make([]string, len(lines))
+	for i, line := range lines {
+		old[i] = string(line)
+	}
+	replacement, err := modify(old)
+	if err != nil {
+		return err
+	}
+	newLines := make([][]byte, len(replacement))
+	for i, line := range replacement {
+		newLines[i] = []byte(line)
+	}
+
+	// The batch stops at the first error, so the lines are only set when the
+	// changedtick check succeeds.
+	const checkTick = `
+local buffer, tick, mismatch = ...
+if vim.api.nvim_buf_get_changedtick(buffer) ~= tick then
+  error(mismatch, 0)
+end
+`
+	b = v.NewBatch()
+	b.ExecLua(checkTick, nil, x, tick, changedTickMismatch)
+	b.SetBufferLines(x, start, end, true, newLines)
+	return conflictError(b.Execute())
+}
+
+// changedTickMismatch is the message of the error raised by ModifyLines when
+// the changedtick check fails.
+const changedTickMismatch = "nvim-go: changedtick mismatch"
+
+// conflictError returns ErrConflict if err is the failure of the changedtick
+// check of ModifyLines and err otherwise.
+func conflictError(err error) error {
+	var batchErr *BatchError
+	var apiErr *APIError
+	if errors.As(err, &batchErr) && batchErr.Index == 0 &&
+		errors.As(err, &apiErr) && strings.Contains(apiErr.Message, changedTickMismatch) {
+		return ErrConflict
+	}
+	return err
+}
+
+// WithOption runs fn with the named option temporarily set to value.
+//
+// WithOption sets the option like ":set" for the current buffer and window,
+// calls fn and then restores the global and local values the option had before
+// the call. The values are restored even if fn returns an error or panics. If a
+// global-local option did not have a local value before the call, the local
+// value is removed again. Nvim reports a missing local value as an empty string
+// for string options, and as a sentinel number specific to the option for the
+// number and boolean options 'undolevels', 'scrolloff', 'sidescrolloff' and
+// 'autoread'.
+//
+// The error returned by fn takes precedence over an error restoring the option.
+func (v *Nvim) WithOption(name string, value interface{}, fn func() error) (err error) {
+	saved, err := v.saveOption(name)
+	if err != nil {
+		return err
+	}
+	if err := v.SetOptionValue(name, value, make(map[string]interface{})); err != nil {
+		return err
+	}
+
+	defer func() {
+		if errRestore := v.restoreOption(name, saved); err == nil {
+			err = errRestore
+		}
+	}()
+
+	return fn()
+}
+
+// savedOption represents the option values saved by WithOption.
+type savedOption struct {
+	info   *OptionInfo
+	global interface{}
+	local  interface{}
+}
+
+func (v *Nvim) saveOption(name string) (*savedOption, error) {
+	info, err := v.OptionInfo2(name, make(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	so := &savedOption{info: info}
+	b := v.NewBatch()
+	b.OptionValue(name, map[string]interface{}{"scope": GlobalScope}, &so.global)
+	if info.Scope != "global" {
+		b.OptionValue(name, map[string]interface{}{"scope": LocalScope}, &so.local)
+	}
+	if err := b.Execute(); err != nil {
+		return nil, err
+	}
+	return so, nil
+}
+
+func (v *Nvim) restoreOption(name string, so *savedOption) error {
+	b := v.NewBatch()
+	b.SetOptionValue(name, so.global, map[string]interface{}{"scope": GlobalScope})
+	if so.info.Scope != "global" {
+		if so.info.GlobalLocal && isUnsetLocalOption(so.info.Name, so.local) {
+			b.Command("set " + name + "<")
+		} else {
+			b.SetOptionValue(name, so.local, map[string]interface{}{"scope": LocalScope})
+		}
+	}
+	return b.Execute()
+}
+
+// unsetLocalNumbers holds the local value Nvim reports for the global-local
+// number and boolean options without a local value, by full option name. The
+// value is a valid global value of some options, for example -1 for
+// 'undolevels', so the sentinel is specific to each option.
+var unsetLocalNumbers = map[string]int64{
+	"undolevels":    -123456,
+	"scrolloff":     -1,
+	"sidescrolloff": -1,
+	"autoread":      -1,
+}
+
+// isUnsetLocalOption reports whether x is the local value Nvim reports for the
+// global-local option with the full name without a local value. An empty
+// local string value always means that the global value is used.
+func isUnsetLocalOption(name string, x interface{}) bool {
+	switch x := x.(type) {
+	case string:
+		return x == ""
+	case int64:
+		unset, ok := unsetLocalNumbers[name]
+		return ok && x == unset
+	default:
+		return false
+	}
+}
+
+// waitForModeInterval is the interval at which WaitForMode polls the mode.
+var waitForModeInterval = 20 * time.Millisecond
+
+// WaitForMode blocks until the current mode satisfies predicate or ctx is done.
+//
+// The mode is checked immediately and then polled with nvim_get_mode. If ctx
+// is done before the predicate is satisfied, WaitForMode returns ctx.Err().
+func (v *Nvim) WaitForMode(ctx context.Context, predicate func(Mode) bool) error {
+	ticker := time.NewTicker(waitForModeInterval)
+	defer ticker.Stop()
+
+	for {
+		mode, err := v.Mode()
+		if err != nil {
+			return err
+		}
+		if predicate(*mode) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ErrNoVisualSelection is returned by VisualSelection when there is no visual
+// selection in the current buffer.
+var ErrNoVisualSelection = errors.New("nvim: no visual selection")
+
+// visualModes maps the visual and select modes reported by nvim_get_mode to
+// the corresponding visualmode() value.
+var visualModes = map[string]string{
+	"v":    "v",
+	"V":    "V",
+	"\x16": "\x16",
+	"s":    "v",
+	"S":    "V",
+	"\x13": "\x16",
+}
+
+// VisualSelection returns the visual selection in the current buffer.
+//
+// If Nvim is in visual or select mode, the active selection is returned.
+// Otherwise the last selection, as reselected by "gv", is returned. The
+// 'selection' option is honored for characterwise and blockwise selections.
+//
+// For a blockwise selection, a character is selected when its first screen
+// column is inside the block. Tabs are expanded using 'tabstop' and every
+// other character is assumed to occupy one screen column. A blockwise
+// selection extended with "$" selects up to the end of each line.
+func (v *Nvim) VisualSelection() (*Selection, error) {
+	mode, err := v.Mode()
+	if err != nil {
+		return nil, err
+	}
+
+	vmode, active := visualModes[mode.Mode]
+	startMark, endMark := "'<", "'>"
+	if active {
+		startMark, endMark = "v", "."
+	} else {
+		if err := v.Call("visualmode", &vmode); err != nil {
+			return nil, err
+		}
+	}
+	if vmode == "" {
+		return nil, ErrNoVisualSelection
+	}
+
+	var toEOL bool
+	if vmode == "\x16" {
+		if toEOL, err = v.visualBlockToEOL(active); err != nil {
+			return nil, err
+		}
+	}
+
+	sel, err := v.markedText(vmode, startMark, endMark, true, toEOL)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return nil, ErrNoVisualSelection
+	}
+	return sel, nil
+}
+
+// maxCol is the cursor column Nvim reports for a cursor moved with "$".
+//
+//	:help v:maxcol
+const maxCol = 1<<31 - 1
+
+// visualBlockToEOL reports whether the active or, if active is false, the last
+// blockwise visual selection was extended to the end of the lines with "$".
+// The last selection is reselected with "gv" to get its cursor column, and
+// the view of the window is restored afterwards.
+func (v *Nvim) visualBlockToEOL(active bool) (bool, error) {
+	const code = `
+local active = ...
+if active then
+  return vim.fn.getcurpos()[5]
+end
+local view = vim.fn.winsaveview()
+vim.cmd('normal! gv')
+local curswant = vim.fn.getcurpos()[5]
+vim.cmd('normal! \27')
+vim.fn.winrestview(view)
+return curswant
+`
+	var curswant int
+	if err := v.ExecLua(code, &curswant, active); err != nil {
+		return false, err
+	}
+	return curswant == maxCol, nil
+}
+
+// ErrNoMotion is returned by MotionText when the '[ and '] marks are not set
+// in the current buffer.
+var ErrNoMotion = errors.New("nvim: no motion text")
+
+// motionModes maps the motion types passed to an 'operatorfunc' to the
+// corresponding visualmode() value.
+var motionModes = map[string]string{
+	"char":  "v",
+	"line":  "V",
+	"block": "\x16",
+}
+
+// MotionText returns the text of the last operator motion in the current
+// buffer, the text between the '[ and '] marks. The motionType is "char",
+// "line" or "block", the argument Nvim passes to the 'operatorfunc'. The
+// returned Selection's Mode is "v", "V" or "\x16" respectively.
+//
+// The text is computed in the same way as VisualSelection, except that the
+// '] mark is always inclusive.
+//
+//	:help 'operatorfunc'
+func (v *Nvim) MotionText(motionType string) (*Selection, error) {
+	vmode, ok := motionModes[motionType]
+	if !ok {
+		return nil, fmt.Errorf("nvim: invalid motion type %q", motionType)
+	}
+	sel, err := v.markedText(vmode, "'[", "']", false, false)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return nil, ErrNoMotion
+	}
+	return sel, nil
+}
+
+// markedText returns the text between the positions startMark and endMark in
+// the current buffer, selected as in visual mode vmode. The 'selection' option
+// is honored when useSelection is true. A blockwise selection extends to the
+// end of each line when toEOL is true. If a mark is not set, markedText
+// returns a nil Selection.
+func (v *Nvim) markedText(vmode, startMark, endMark string, useSelection, toEOL bool) (*Selection, error) {
+	var (
+		startPos, endPos []int
+		selection        string
+		tabstop          int
+	)
+	b := v.NewBatch()
+	b.Call("getpos", &startPos, startMark)
+	b.Call("getpos", &endPos, endMark)
+	if useSelection {
+		b.Option("selection", &selection)
+	}
+	b.BufferOption(0, "tabstop", &tabstop)
+	if err := b.Execute(); err != nil {
+		return nil, err
+	}
+	if len(startPos) < 3 || len(endPos) < 3 || startPos[1] == 0 || endPos[1] == 0 {
+		return nil, nil
+	}
+
+	sel := &Selection{
+		Mode:  vmode,
+		Start: [2]int{startPos[1], startPos[2] - 1},
+		End:   [2]int{endPos[1], endPos[2] - 1},
+	}
+	if sel.End[0] < sel.Start[0] || (sel.End[0] == sel.Start[0] && sel.End[1] < sel.Start[1]) {
+		sel.Start, sel.End = sel.End, sel.Start
+	}
+
+	lines, err := v.BufferLines(0, sel.Start[0]-1, sel.End[0], true)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	first, last := 0, len(lines)-1
+	exclusive := selection == "exclusive"
+
+	switch vmode {
+	case "V":
+		sel.Start[1] = 0
+		sel.End[1] = len(lines[last])
+
+	case "v":
+		sel.Start[1] = clampCol(sel.Start[1], len(lines[first]))
+		sel.End[1] = charEnd(lines[last], sel.End[1], exclusive)
+		lines[last] = lines[last][:sel.End[1]]
+		lines[first] = lines[first][clampCol(sel.Start[1], len(lines[first])):]
+
+	default:
+		left, right := blockColumns(lines[first], sel.Start[1], lines[last], sel.End[1], tabstop, exclusive)
+		if toEOL {
+			right = maxCol
+		}
+		for i, line := range lines {
+			start, end := blockByteRange(line, left, right, tabstop)
+			if i == first {
+				sel.Start[1] = start
+			}
+			if i == last {
+				sel.End[1] = end
+			}
+			lines[i] = line[start:end]
+		}
+	}
+	sel.Lines = lines
+
+	return sel, nil
+}
+
+// WordUnderCursor returns the word under or after the cursor in the current
+// window, as defined by the 'iskeyword' option. An empty string is returned
+// when there is no word on the cursor line at or after the cursor.
+//
+//	:help <cword>
+func (v *Nvim) WordUnderCursor() (string, error) {
+	return v.expandCursorText("<cword>")
+}
+
+// BigWordUnderCursor returns the WORD under or after the cursor in the
+// current window. A WORD is a sequence of non-blank characters. An empty
+// string is returned when there is no WORD on the cursor line at or after the
+// cursor.
+//
+//	:help <cWORD>
+func (v *Nvim) BigWordUnderCursor() (string, error) {
+	return v.expandCursorText("<cWORD>")
+}
+
+// expandCursorText expands the special word pattern pat.
+func (v *Nvim) expandCursorText(pat string) (string, error) {
+	var text string
+	err := v.Call("expand", &text, pat)
+	return text, err
+}
+
+// clampCol clamps the zero based byte column col to [0, n].
+func clampCol(col, n int) int {
+	if col < 0 {
+		return 0
+	}
+	if col > n {
+		return n
+	}
+	return col
+}
+
+// charEnd returns the byte offset just past the selection ending at col in
+// line. The character at col is excluded when exclusive is true.
+func charEnd(line []byte, col int, exclusive bool) int {
+	col = clampCol(col, len(line))
+	if exclusive || col == len(line) {
+		return col
+	}
+	_, n := utf8.DecodeRune(line[col:])
+	return col + n
+}
+
+// screenSpan returns the first and last zero based screen columns occupied by
+// the character at byte col in line.
+func screenSpan(line []byte, col, tabstop int) (start, end int) {
+	if tabstop <= 0 {
+		tabstop = 8
+	}
+	vcol := 0
+	for i := 0; i < len(line); {
+		r, n := utf8.DecodeRune(line[i:])
+		w := 1
+		if r == '\t' {
+			w = tabstop - vcol%tabstop
+		}
+		if col < i+n {
+			return vcol, vcol + w - 1
+		}
+		vcol += w
+		i += n
+	}
+	return vcol, vcol
+}
+
+// blockColumns returns the first and last screen columns of the block with
+// corners at byte startCol in first and byte endCol in last.
+func blockColumns(first []byte, startCol int, last []byte, endCol, tabstop int, exclusive bool) (left, right int) {
+	s1, e1 := screenSpan(first, startCol, tabstop)
+	s2, e2 := screenSpan(last, endCol, tabstop)
+
+	left, right = s1, e1
+	if s2 < left {
+		left = s2
+	}
+	if e2 > right {
+		right = e2
+	}
+	if exclusive {
+		right = s1
+		if s2 > right {
+			right = s2
+		}
+		right--
+	}
+	return left, right
+}
+
+// blockByteRange returns the byte range of the characters in line whose first
+// screen column is in [left, right].
+func blockByteRange(line []byte, left, right, tabstop int) (start, end int) {
+	if tabstop <= 0 {
+		tabstop = 8
+	}
+	start = len(line)
+	vcol := 0
+	for i := 0; i < len(line); {
+		if vcol > right {
+			if start > i {
+				start = i
+			}
+			return start, i
+		}
+		if vcol >= left && start == len(line) {
+			start = i
+		}
+		r, n := utf8.DecodeRune(line[i:])
+		if r == '\t' {
+			vcol += tabstop - vcol%tabstop
+		} else {
+			vcol++
+		}
+		i += n
+	}
+	return start, len(line)
+}
+
+// maxInputRetries is the number of consecutive Input calls that consume no
+// bytes before InputAll gives up.
+var maxInputRetries = 10
+
+// inputRetryInterval is the wait before the first retry of InputAll. The wait
+// grows by inputRetryInterval with each consecutive retry.
+var inputRetryInterval = 10 * time.Millisecond
+
+// InputAll queues all of keys as raw user-input.
+//
+// Input may consume only part of keys when the input buffer is full. InputAll
+// sends the unconsumed remainder until all of keys has been consumed. When
+// Nvim consumes no bytes, InputAll waits before it retries, a little longer
+// for each consecutive retry, to give Nvim time to process the queued input.
+// It returns an error if Nvim consumes no bytes in maxInputRetries consecutive
+// calls.
+func (v *Nvim) InputAll(keys string) error {
+	retries := 0
+	for len(keys) > 0 {
+		written, err := v.Input(keys)
+		if err != nil {
+			return err
+		}
+		if written <= 0 {
+			retries++
+			if retries >= maxInputRetries {
+				return fmt.Errorf("nvim: input not consumed after %d retries, %d bytes remaining", retries, len(keys))
+			}
+			time.Sleep(time.Duration(retries) * inputRetryInterval)
+			continue
+		}
+		retries = 0
+		if written > len(keys) {
+			written = len(keys)
+		}
+		keys = keys[written:]
+	}
+	return nil
+}
+
+// TermcodesExpand replaces the key notation in keys, such as <CR> and <C-a>,
+// with Nvim's internal representation of the keys.
+//
+// TermcodesExpand is a shorthand for ReplaceTermcodes(keys, true, true, true).
+func (v *Nvim) TermcodesExpand(keys string) (string, error) {
+	return v.ReplaceTermcodes(keys, true, true, true)
+}
+
+// TermcodesExpand replaces the key notation in keys, such as <CR> and <C-a>,
+// with Nvim's internal representation of the keys.
+//
+// TermcodesExpand is a shorthand for
+// ReplaceTermcodes(keys, true, true, true, expanded).
+func (b *Batch) TermcodesExpand(keys string, expanded *string) {
+	b.ReplaceTermcodes(keys, true, true, true, expanded)
+}
+
+// NormalizeKeys returns the canonical key notation for keys.
+//
+// The keys are expanded with TermcodesExpand and converted back to key
+// notation with keytrans(), so different spellings of the same keys have the
+// same canonical form. For example, "<c-a>", "<C-a>" and "<C-A>" all
+// normalize to "<C-A>", and " " and "<space>" normalize to "<Space>".
+//
+// Modifiers that change the key, such as <M-a> and <M-A>, are preserved.
+func (v *Nvim) NormalizeKeys(keys string) (string, error) {
+	var normalized string
+	err := v.ExecLua(normalizeKeysCode, &normalized, keys)
+	return normalized, err
+}
+
+// NormalizeKeys returns the canonical key notation for keys. See
+// Nvim.NormalizeKeys.
+func (b *Batch) NormalizeKeys(keys string, normalized *string) {
+	b.ExecLua(normalizeKeysCode, normalized, keys)
+}
+
+const normalizeKeysCode = "return vim.fn.keytrans(vim.api.nvim_replace_termcodes(..., true, true, true))"
+
+// ErrNoCmdline is returned by CmdlineSetText and CmdlineSetPos when the
+// command line is not being edited.
+var ErrNoCmdline = errors.New("nvim: command line is not active")
+
+// CmdlineState returns the state of the command line. The returned state has
+// an empty Type if the command line is not being edited.
+//
+// CmdlineState is intended for UIs with ext_cmdline that need to know the
+// current command line outside of cmdline_show events.
+func (v *Nvim) CmdlineState() (*CmdlineState, error) {
+	var state CmdlineState
+	if err := v.ExecLua(cmdlineStateCode, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// CmdlineState returns the state of the command line. The returned state has
+// an empty Type if the command line is not being edited.
+func (b *Batch) CmdlineState(state *CmdlineState) {
+	b.ExecLua(cmdlineStateCode, state)
+}
+
+const cmdlineStateCode = `
+local pos = vim.fn.getcmdpos()
+return {type = vim.fn.getcmdtype(), text = vim.fn.getcmdline(), pos = pos > 0 and pos - 1 or 0}
+`
+
+// CmdlineSetText replaces the contents of the command line with text and
+// moves the cursor to the zero-based byte position pos. If pos is negative,
+// the cursor is moved to the end of text.
+//
+// CmdlineSetText returns ErrNoCmdline if the command line is not being
+// edited.
+//
+//	:help setcmdline()
+func (v *Nvim) CmdlineSetText(text string, pos int) error {
+	args := []interface{}{text}
+	if pos >= 0 {
+		args = append(args, pos+1)
+	}
+	var result int
+	if err := v.Call("setcmdline", &result, args...); err != nil {
+		return err
+	}
+	if result != 0 {
+		return ErrNoCmdline
+	}
+	return nil
+}
+
+// CmdlineSetPos moves the cursor in the command line to the zero-based byte
+// position pos.
+//
+// CmdlineSetPos returns ErrNoCmdline if the command line is not being edited.
+//
+//	:help setcmdpos()
+func (v *Nvim) CmdlineSetPos(pos int) error {
+	var result int
+	if err := v.Call("setcmdpos", &result, pos+1); err != nil {
+		return err
+	}
+	if result != 0 {
+		return ErrNoCmdline
+	}
+	return nil
+}
+
+// SetupStep represents a step executed by Setup.
+//
+// Exactly one of Lua and Command must be set.
+type SetupStep struct {
+	// Lua is Lua code executed like ExecLua.
+	Lua string
+
+	// Args are the arguments passed to Lua code as "...".
+	Args []interface{}
+
+	// Command is an Ex command executed like Command.
+	Command string
+}
+
+// Setup executes steps in order, atomically in a single batch.
+//
+// If a step fails, the following steps are not executed and a *BatchError with
+// the index of the failed step is returned.
+func (v *Nvim) Setup(steps []SetupStep) error {
+	b := v.NewBatch()
+	for i, step := range steps {
+		switch {
+		case step.Lua != "" && step.Command == "":
+			b.ExecLua(step.Lua, nil, step.Args...)
+		case step.Command != "" && step.Lua == "":
+			b.Command(step.Command)
+		default:
+			return fmt.Errorf("nvim: setup step %d must set exactly one of Lua or Command", i)
+		}
+	}
+	return b.Execute()
+}
+
+type terminalStream struct {
+	v       *Nvim
+	channel int
+
+	// methods are the notification methods registered for the stream.
+	methods []string
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+// compile time check whether the terminalStream implements io.ReadWriteCloser interface.
+var _ io.ReadWriteCloser = (*terminalStream)(nil)
+
+// terminalStreamMethodPrefix is the prefix of the TerminalStreamMethod names.
+const terminalStreamMethodPrefix = "nvim_go_terminal_stream_"
+
+// TerminalStreamMethod returns the name of the notification method that
+// delivers data to the stream returned by TerminalStream for channel.
+func TerminalStreamMethod(channel int) string {
+	return terminalStreamMethodPrefix + strconv.Itoa(channel)
+}
+
+// TerminalStream returns a stream bound to the terminal channel.
+//
+// Writes to the stream are sent to the terminal with ChanSend. Reads return the
+// data sent by Nvim to the client with the notification method returned by
+// TerminalStreamMethod, typically from the on_input callback of the terminal:
+//
+//	vim.rpcnotify(client_channel, method, data)
+//
+// Data is buffered from the time TerminalStream returns until read. Read blocks
+// until data is available or the stream is closed. Close unregisters the
+// notification method. After Close, Read returns io.EOF and Write returns
+// io.ErrClosedPipe.
+//
+// Use OpenTermStream to open a terminal with the on_input callback set up.
+func (v *Nvim) TerminalStream(channel int) (io.ReadWriteCloser, error) {
+	s := newTerminalStream(v, channel)
+	if err := s.register(TerminalStreamMethod(channel)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newTerminalStream(v *Nvim, channel int) *terminalStream {
+	s := &terminalStream{v: v, channel: channel}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// register registers method as a notification method delivering data to s.
+func (s *terminalStream) register(method string) error {
+	if err := s.v.RegisterHandler(method, s.input); err != nil {
+		return err
+	}
+	s.methods = append(s.methods, method)
+	return nil
+}
+
+// openTermStreamID is the last ID used in the name of the notification method
+// of a stream opened by OpenTermStream.
+var openTermStreamID uint64
+
+// OpenTermStream opens a terminal in buffer like OpenTerm and returns the
+// terminal channel and a stream bound to it.
+//
+// The input sent to the terminal, for example keys typed in Terminal mode, is
+// read from the stream. The stream is registered before the terminal is
+// opened, so no input is lost. See TerminalStream for details.
+func (v *Nvim) OpenTermStream(buffer Buffer) (channel int, stream io.ReadWriteCloser, err error) {
+	const code = `
+local buffer, client, method = ...
+return vim.api.nvim_open_term(buffer, {
+  on_input = function(_, _, _, data)
+    vim.rpcnotify(client, method, data)
+  end,
+})
+`
+	client, err := v.ChannelIDErr()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// The channel is not known until the terminal is open, so the on_input
+	// callback notifies a method with a unique name registered beforehand.
+	s := newTerminalStream(v, 0)
+	method := fmt.Sprintf("%sopen_%d", terminalStreamMethodPrefix, atomic.AddUint64(&openTermStreamID, 1))
+	if err := s.register(method); err != nil {
+		return 0, nil, err
+	}
+	if err := v.ExecLua(code, &channel, buffer, client, method); err != nil {
+		s.Close()
+		return 0, nil, err
+	}
+	s.channel = channel
+	if err := s.register(TerminalStreamMethod(channel)); err != nil {
+		s.Close()
+		return 0, nil, err
+	}
+	return channel, s, nil
+}
+
+func (s *terminalStream) input(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.buf.Write(data)
+	s.cond.Broadcast()
+}
+
+// Read implements io.Reader.
+func (s *terminalStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.buf.Len() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+// Write implements io.Writer.
+func (s *terminalStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := s.v.ChanSend(s.channel, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements io.Closer.
+func (s *terminalStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.buf.Reset()
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	for _, method := range s.methods {
+		s.v.unregister(method)
+	}
+	return nil
+}
+
+// SetDiagnostic shows a diagnostic with severity and message on the
+// zero-based line of buffer x using an extmark in namespace nsID, and returns
+// the ID of the extmark.
+//
+// The diagnostic is shown as virtual text at the end of the line, a sign and
+// an underline, highlighted with the Diagnostic* highlight groups for the
+// severity, like DiagnosticVirtualTextError, DiagnosticSignError and
+// DiagnosticUnderlineError. If opts is nil, the defaults of
+// DiagnosticOptions are used.
+//
+// Remove the diagnostic with DeleteBufferExtmark, or all diagnostics in the
+// namespace with ClearDiagnostics.
+//
+//	:help diagnostic-highlights
+func (x Buffer) SetDiagnostic(v *Nvim, nsID, line int, severity DiagnosticSeverity, message string, opts *DiagnosticOptions) (int, error) {
+	if severity < DiagnosticError || severity > DiagnosticHint {
+		return 0, fmt.Errorf("nvim: invalid diagnostic severity %d", int(severity))
+	}
+	if opts == nil {
+		opts = &DiagnosticOptions{}
+	}
+
+	name := severity.String()
+	extmarkOpts := make(map[string]interface{})
+	if opts.Priority != 0 {
+		extmarkOpts["priority"] = opts.Priority
+	}
+	if !opts.NoVirtualText {
+		prefix := opts.Prefix
+		if prefix == "" {
+			prefix = "■ "
+		}
+		// Virtual text cannot contain line breaks.
+		text := prefix + strings.Join(strings.Fields(message), " ")
+		extmarkOpts["virt_text"] = [][]string{{text, "DiagnosticVirtualText" + name}}
+		extmarkOpts["virt_text_pos"] = "eol"
+	}
+	if !opts.NoSign {
+		extmarkOpts["sign_text"] = name[:1]
+		extmarkOpts["sign_hl_group"] = "DiagnosticSign" + name
+	}
+	if !opts.NoUnderline {
+		endCol := opts.EndCol
+		if endCol == 0 {
+			lines, err := v.BufferLines(x, line, line+1, true)
+			if err != nil {
+				return 0, err
+			}
+			endCol = len(lines[0])
+		}
+		if endCol > opts.Col {
+			extmarkOpts["end_row"] = line
+			extmarkOpts["end_col"] = endCol
+			extmarkOpts["hl_group"] = "DiagnosticUnderline" + name
+		}
+	}
+
+	return v.SetBufferExtmark(x, nsID, line, opts.Col, extmarkOpts)
+}
+
+// ClearDiagnostics removes the diagnostics set with SetDiagnostic in
+// namespace nsID from buffer x.
+func (x Buffer) ClearDiagnostics(v *Nvim, nsID int) error {
+	return v.ClearBufferNamespace(x, nsID, 0, -1)
+}
+
+// SetExtmark creates or updates an extmark at the zero-based line and byte col
+// of buffer in namespace nsID and returns the ID of the extmark. SetExtmark
+// returns an error without calling Nvim if opts is not valid, for example if
+// a chunk of virtual text is not a [text, hlgroup] pair.
+//
+//	:help nvim_buf_set_extmark()
+func (v *Nvim) SetExtmark(buffer Buffer, nsID, line, col int, opts *ExtmarkOptions) (id int, err error) {
+	if opts == nil {
+		opts = &ExtmarkOptions{}
+	}
+	if err := opts.validate(); err != nil {
+		return 0, err
+	}
+	err = v.call("nvim_buf_set_extmark", &id, buffer, nsID, line, col, opts)
+	return id, err
+}
+
+// SetClient identifies the client to Nvim with the name, version, type,
+// methods and attributes in c. The client information is shown by
+// ChannelInfo and :checkhealth. SetClient returns an error without calling
+// Nvim if c.Type is not one of the ClientType values.
+//
+//	:help nvim_set_client_info()
+func (v *Nvim) SetClient(c *Client) error {
+	if !c.Type.valid() {
+		return fmt.Errorf("nvim: invalid client type %q", c.Type)
+	}
+	return v.SetClientInfo(c.Name, &c.Version, string(c.Type), c.Methods, c.Attributes)
+}
+
+// SetClient identifies the client to Nvim with the name, version, type,
+// methods and attributes in c. An invalid c.Type is reported when the batch
+// is executed.
+//
+//	:help nvim_set_client_info()
+func (b *Batch) SetClient(c *Client) {
+	if !c.Type.valid() {
+		if b.err == nil {
+			b.err = fmt.Errorf("nvim: invalid client type %q", c.Type)
+		}
+		return
+	}
+	b.SetClientInfo(c.Name, &c.Version, string(c.Type), c.Methods, c.Attributes)
+}
+
+// Map sets a global mapping of lhs to rhs in mode with the options in opts,
+// which may be nil. If opts.Callback is set, rhs must be empty and the
+// mapping calls the RPC method opts.Callback instead. Use KeyMap to list the
+// mappings.
+//
+// Map is SetKeyMap with typed options.
+//
+//	:help nvim_set_keymap()
+func (v *Nvim) Map(mode, lhs, rhs string, opts *KeyMapOptions) error {
+	rhs, err := v.mapRHS(rhs, opts)
+	if err != nil {
+		return err
+	}
+	return v.call("nvim_set_keymap", nil, mode, lhs, rhs, keyMapOptions(opts))
+}
+
+// BufferMap sets a mapping local to buffer like Map. Use BufferKeyMap to list
+// the mappings of the buffer.
+//
+//	:help nvim_buf_set_keymap()
+func (v *Nvim) BufferMap(buffer Buffer, mode, lhs, rhs string, opts *KeyMapOptions) error {
+	rhs, err := v.mapRHS(rhs, opts)
+	if err != nil {
+		return err
+	}
+	return v.call("nvim_buf_set_keymap", nil, buffer, mode, lhs, rhs, keyMapOptions(opts))
+}
+
+func keyMapOptions(opts *KeyMapOptions) *KeyMapOptions {
+	if opts == nil {
+		return &KeyMapOptions{}
+	}
+	return opts
+}
+
+// mapRHS returns the {rhs} of a mapping that calls the RPC method
+// opts.Callback on this channel, or rhs if there is no callback.
+func (v *Nvim) mapRHS(rhs string, opts *KeyMapOptions) (string, error) {
+	if opts == nil || opts.Callback == "" {
+		return rhs, nil
+	}
+	if rhs != "" {
+		return "", fmt.Errorf("nvim: mapping has both rhs %q and callback %q", rhs, opts.Callback)
+	}
+	channel, err := v.ChannelIDErr()
+	if err != nil {
+		return "", err
+	}
+	// Quote the method for Vimscript and keep "<" from starting a key code.
+	method := strings.NewReplacer("'", "''", "<", "<lt>").Replace(opts.Callback)
+	if opts.Expr {
+		return fmt.Sprintf("rpcrequest(%d, '%s')", channel, method), nil
+	}
+	return fmt.Sprintf("<Cmd>call rpcnotify(%d, '%s')<CR>", channel, method), nil
+}
+
+// BufferExtmarkDetails returns the extmarks in namespace nsID of buffer with
+// their options, in the region from start to end like BufferExtmarks. If limit
+// is greater than 0, at most limit extmarks are returned.
+func (v *Nvim) BufferExtmarkDetails(buffer Buffer, nsID int, start, end interface{}, limit int) ([]*ExtmarkDetails, error) {
+	opts := map[string]interface{}{"details": true}
+	if limit > 0 {
+		opts["limit"] = limit
+	}
+	var marks []*ExtmarkDetails
+	err := v.call("nvim_buf_get_extmarks", &marks, buffer, nsID, start, end, opts)
+	return marks, err
+}
+
+// validate returns an error if the options are not valid.
+func (o *ExtmarkOptions) validate() error {
+	if err := validateChunks("virtual text", o.VirtText); err != nil {
+		return err
+	}
+	for i, line := range o.VirtLines {
+		if err := validateChunks(fmt.Sprintf("virtual line %d", i), line); err != nil {
+			return err
+		}
+	}
+	switch o.VirtTextPos {
+	case "", "eol", "overlay", "right_align", "inline":
+	default:
+		return fmt.Errorf("nvim: invalid virtual text position %q", o.VirtTextPos)
+	}
+	switch o.HLMode {
+	case "", "replace", "combine", "blend":
+	default:
+		return fmt.Errorf("nvim: invalid highlight mode %q", o.HLMode)
+	}
+	return nil
+}
+
+// validateChunks returns an error if a chunk of virtual text is not a
+// [text, hlgroup] pair or [text].
+func validateChunks(what string, chunks [][]string) error {
+	for i, chunk := range chunks {
+		if len(chunk) != 1 && len(chunk) != 2 {
+			return fmt.Errorf("nvim: %s chunk %d has %d elements, want [text, hlgroup]", what, i, len(chunk))
+		}
+	}
+	return nil
+}
+
+// InspectPos returns the treesitter captures, syntax highlight groups,
+// extmarks and semantic tokens at the zero-based row and byte col of buffer.
+// If opts is nil, all items are included, except extmarks without a
+// highlight group.
+//
+//	:help vim.inspect_pos()
+func (v *Nvim) InspectPos(buffer Buffer, row, col int, opts *InspectPosOptions) (*InspectResult, error) {
+	var filter map[string]interface{}
+	if opts != nil {
+		extmarks := interface{}(opts.Extmarks)
+		if opts.AllExtmarks {
+			extmarks = "all"
+		}
+		filter = map[string]interface{}{
+			"syntax":          opts.Syntax,
+			"treesitter":      opts.Treesitter,
+			"extmarks":        extmarks,
+			"semantic_tokens": opts.SemanticTokens,
+		}
+	}
+	const code = `
+local buffer, row, col, filter = ...
+if filter == vim.NIL then
+  filter = nil
+end
+local result = vim.inspect_pos(buffer, row, col, filter)
+-- Remove the tables that may be empty and would be encoded as arrays.
+for _, item in ipairs(result.treesitter) do
+  item.metadata = nil
+end
+return result
+`
+	var result InspectResult
+	if err := v.ExecLua(code, &result, buffer, row, col, filter); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Snapshot returns the mode, working directory, current file, cursor and
+// buffer state of a window, fetched with a single batch. If opts is nil, the
+// current window is used.
+func (v *Nvim) Snapshot(opts *SnapshotOptions) (*EditorSnapshot, error) {
+	var window Window
+	if opts != nil {
+		window = opts.Window
+	}
+
+	const code = `
+local win = ...
+if win == 0 then
+  win = vim.api.nvim_get_current_win()
+end
+local buf = vim.api.nvim_win_get_buf(win)
+return {
+  window = win,
+  buffer = buf,
+  name = vim.api.nvim_buf_get_name(buf),
+  changedtick = vim.api.nvim_buf_get_changedtick(buf),
+  modified = vim.api.nvim_buf_get_option(buf, 'modified'),
+  filetype = vim.api.nvim_buf_get_option(buf, 'filetype'),
+}
+`
+	var state struct {
+		Window      int    `msgpack:"window"`
+		Buffer      int    `msgpack:"buffer"`
+		Name        string `msgpack:"name"`
+		ChangedTick int    `msgpack:"changedtick"`
+		Modified    bool   `msgpack:"modified"`
+		Filetype    string `msgpack:"filetype"`
+	}
+
+	s := &EditorSnapshot{}
+	b := v.NewBatch()
+	b.Mode(&s.Mode)
+	b.Call("getcwd", &s.Cwd, int(window))
+	b.WindowCursor(window, &s.Cursor)
+	b.ExecLua(code, &state, int(window))
+	if err := b.Execute(); err != nil {
+		return nil, err
+	}
+
+	s.Window = Window(state.Window)
+	s.Buffer = Buffer(state.Buffer)
+	s.Name = state.Name
+	s.ChangedTick = state.ChangedTick
+	s.Modified = state.Modified
+	s.Filetype = state.Filetype
+	return s, nil
+}
+
+// Search returns the matches of the Vim regular expression pattern in buffer.
+// The search is executed by Nvim in the context of the buffer, as for the
+// / command, so the 'ignorecase', 'smartcase' and 'magic' options apply.
+// Matches do not overlap, and a match starting on the last line may extend
+// past EndLine. The cursor and view of the windows are not changed.
+//
+//	:help pattern
+//	:help searchpos()
+func (x Buffer) Search(v *Nvim, pattern string, opts *SearchOptions) ([]Match, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	const code = `
+local buf, pattern, start, stop, max = ...
+return vim.api.nvim_buf_call(buf, function()
+  local view = vim.fn.winsaveview()
+  local matches = {}
+  vim.fn.cursor(math.max(start, 1), 1)
+  local flags = 'cW'
+  while max == 0 or #matches < max do
+    local s = vim.fn.searchpos(pattern, flags, stop)
+    if s[1] == 0 then
+      break
+    end
+    local e = vim.fn.searchpos(pattern, 'cenW')
+    table.insert(matches, {start = {s[1], s[2] - 1}, ['end'] = {e[1], e[2] - 1}})
+    vim.fn.cursor(e[1], e[2])
+    flags = 'W'
+  end
+  vim.fn.winrestview(view)
+  return #matches > 0 and matches or nil
+end)
+`
+	var matches []Match
+	if err := v.ExecLua(code, &matches, int(x), pattern, opts.StartLine, opts.EndLine, opts.MaxMatches); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// JumpList returns the jumplist of window. If window is 0, the current window
+// is used.
+//
+//	:help getjumplist()
+func (v *Nvim) JumpList(window Window) (*JumpList, error) {
+	var result struct {
+		Jumps []struct {
+			Buffer   int    `msgpack:"bufnr"`
+			Filename string `msgpack:"filename"`
+			LNum     int    `msgpack:"lnum"`
+			Col      int    `msgpack:"col"`
+			ColAdd   int    `msgpack:"coladd"`
+		} `msgpack:",array"`
+		Current int
+	}
+	if err := v.ExecLua("return vim.api.nvim_win_call(..., vim.fn.getjumplist)", &result, int(window)); err != nil {
+		return nil, err
+	}
+
+	list := &JumpList{
+		Jumps:   make([]*Jump, len(result.Jumps)),
+		Current: result.Current,
+	}
+	for i, j := range result.Jumps {
+		list.Jumps[i] = &Jump{
+			Buffer:   Buffer(j.Buffer),
+			Filename: j.Filename,
+			LNum:     j.LNum,
+			Col:      j.Col,
+			ColAdd:   j.ColAdd,
+		}
+	}
+	return list, nil
+}
+
+// ChangeList returns the changelist of buffer. If buffer is 0, the current
+// buffer is used.
+//
+//	:help getchangelist()
+func (v *Nvim) ChangeList(buffer Buffer) (*ChangeList, error) {
+	const code = `
+local buf = ...
+if buf == 0 then
+  buf = vim.api.nvim_get_current_buf()
+end
+return vim.fn.getchangelist(buf)
+`
+	var result struct {
+		Changes []*Change `msgpack:",array"`
+		Current int
+	}
+	if err := v.ExecLua(code, &result, int(buffer)); err != nil {
+		return nil, err
+	}
+	return &ChangeList{Changes: result.Changes, Current: result.Current}, nil
+}
+
+// EffectiveCwd returns the working directory used by file operations in
+// window and the scope of the directory. A window-local directory overrides
+// the tabpage-local directory of the window's tabpage, which overrides the
+// global directory.
+//
+//	:help current-directory
+func (v *Nvim) EffectiveCwd(window Window) (dir string, scope CwdScope, err error) {
+	const code = `
+return vim.api.nvim_win_call(..., function()
+  local global = vim.fn.getcwd(-1, -1)
+  local tab = vim.fn.haslocaldir(-1, 0) == 1 and vim.fn.getcwd(-1, 0) or ''
+  local win = vim.fn.haslocaldir() == 1 and vim.fn.getcwd() or ''
+  return {global, tab, win}
+end)
+`
+	var dirs [3]string
+	if err := v.ExecLua(code, &dirs, window); err != nil {
+		return "", "", err
+	}
+	dir, scope = resolveCwd(dirs[0], dirs[1], dirs[2])
+	return dir, scope, nil
+}
+
+// resolveCwd returns the effective directory of a window with the global
+// directory global and, if set, the tabpage-local directory tab and the
+// window-local directory win.
+func resolveCwd(global, tab, win string) (string, CwdScope) {
+	switch {
+	case win != "":
+		return win, WindowCwdScope
+	case tab != "":
+		return tab, TabpageCwdScope
+	default:
+		return global, GlobalCwdScope
+	}
+}
+
+// BufferFilter selects buffers for SelectBuffers.
+type BufferFilter interface {
+	// Select reports for each buffer in bufs whether the buffer is selected.
+	Select(v *Nvim, bufs []Buffer) ([]bool, error)
+}
+
+// BufferPredicate is a BufferFilter that calls the function for each buffer.
+type BufferPredicate func(Buffer) (bool, error)
+
+// Select implements BufferFilter. It stops and returns the error if the
+// function returns an error.
+func (pred BufferPredicate) Select(v *Nvim, bufs []Buffer) ([]bool, error) {
+	selected := make([]bool, len(bufs))
+	for i, buf := range bufs {
+		ok, err := pred(buf)
+		if err != nil {
+			return nil, err
+		}
+		selected[i] = ok
+	}
+	return selected, nil
+}
+
+// FilterBuffers returns the buffers for which pred returns true, in the order
+// returned by Buffers. FilterBuffers stops and returns the error if pred
+// returns an error.
+//
+// Use SelectBuffers with ListedBuffer, LoadedBuffer or FiletypeBuffer to
+// fetch the properties of all buffers in one batch instead of calling Nvim
+// for each buffer from pred.
+func (v *Nvim) FilterBuffers(pred func(Buffer) (bool, error)) ([]Buffer, error) {
+	return v.SelectBuffers(BufferPredicate(pred))
+}
+
+// SelectBuffers returns the buffers selected by filter, in the order returned
+// by Buffers.
+//
+// The filters returned by ListedBuffer, LoadedBuffer and FiletypeBuffer fetch
+// the properties of all buffers in one batch.
+//
+//	bufs, err := v.SelectBuffers(v.FiletypeBuffer("go"))
+func (v *Nvim) SelectBuffers(filter BufferFilter) ([]Buffer, error) {
+	bufs, err := v.Buffers()
+	if err != nil {
+		return nil, err
+	}
+	selected, err := filter.Select(v, bufs)
+	if err != nil {
+		return nil, err
+	}
+	var result []Buffer
+	for i, buf := range bufs {
+		if selected[i] {
+			result = append(result, buf)
+		}
+	}
+	return result, nil
+}
+
+// ListedBuffer returns a filter for SelectBuffers that selects the listed
+// buffers.
+//
+//	:help buflisted
+func (v *Nvim) ListedBuffer() BufferFilter {
+	return batchedBufferFilter(func(b *Batch, buffer Buffer) func() bool {
+		var listed bool
+		b.BufferOption(buffer, "buflisted", &listed)
+		return func() bool { return listed }
+	})
+}
+
+// LoadedBuffer returns a filter for SelectBuffers that selects the loaded
+// buffers.
+func (v *Nvim) LoadedBuffer() BufferFilter {
+	return batchedBufferFilter(func(b *Batch, buffer Buffer) func() bool {
+		var loaded bool
+		b.IsBufferLoaded(buffer, &loaded)
+		return func() bool { return loaded }
+	})
+}
+
+// FiletypeBuffer returns a filter for SelectBuffers that selects the buffers
+// with filetype.
+//
+//	:help 'filetype'
+func (v *Nvim) FiletypeBuffer(filetype string) BufferFilter {
+	return batchedBufferFilter(func(b *Batch, buffer Buffer) func() bool {
+		var ft string
+		b.BufferOption(buffer, "filetype", &ft)
+		return func() bool { return ft == filetype }
+	})
+}
+
+// batchedBufferFilter is a BufferFilter that fetches the properties of the
+// buffers in one batch. The function adds the calls for buffer to the batch
+// and returns a function that reports the result after the batch is executed.
+type batchedBufferFilter func(b *Batch, buffer Buffer) func() bool
+
+func (fetch batchedBufferFilter) Select(v *Nvim, bufs []Buffer) ([]bool, error) {
+	b := v.NewBatch()
+	fns := make([]func() bool, len(bufs))
+	for i, buf := range bufs {
+		fns[i] = fetch(b, buf)
+	}
+	if err := b.Execute(); err != nil {
+		return nil, err
+	}
+	selected := make([]bool, len(bufs))
+	for i, fn := range fns {
+		selected[i] = fn()
+	}
+	return selected, nil
+}
+
+// BufferAutocmds returns the buffer-local autocmds of buffer. Use
+// Autocmd.HasLuaCallback to distinguish autocmds calling Lua functions from
+// autocmds executing commands.
+func (v *Nvim) BufferAutocmds(buffer Buffer) ([]*Autocmd, error) {
+	// Pass the buffer number as an integer, as documented for
+	// nvim_get_autocmds, instead of a buffer handle.
+	return v.Autocmds(map[string]interface{}{"buffer": int(buffer)})
+}
+
+// BufferAutocmds returns the buffer-local autocmds of buffer.
+func (b *Batch) BufferAutocmds(buffer Buffer, result *[]*Autocmd) {
+	b.Autocmds(map[string]interface{}{"buffer": int(buffer)}, result)
+}
+
+// Digraphs returns the digraphs, including the default digraphs and the
+// digraphs defined by the user.
+//
+//	:help digraph_getlist()
+func (v *Nvim) Digraphs() ([]Digraph, error) {
+	var digraphs []Digraph
+	err := v.Call("digraph_getlist", &digraphs, true)
+	return digraphs, err
+}
+
+// Digraphs returns the digraphs, including the default digraphs and the
+// digraphs defined by the user.
+//
+//	:help digraph_getlist()
+func (b *Batch) Digraphs(digraphs *[]Digraph) {
+	b.Call("digraph_getlist", digraphs, true)
+}
+
+// SetDigraph defines the digraph chars, which must be two characters, for
+// the character with the Unicode code point codepoint.
+//
+//	:help digraph_set()
+func (v *Nvim) SetDigraph(chars string, codepoint int) error {
+	if err := checkDigraph(chars, codepoint); err != nil {
+		return err
+	}
+	return v.Call("digraph_set", nil, chars, string(rune(codepoint)))
+}
+
+// SetDigraph defines the digraph chars, which must be two characters, for
+// the character with the Unicode code point codepoint. An invalid digraph is
+// reported by Execute.
+//
+//	:help digraph_set()
+func (b *Batch) SetDigraph(chars string, codepoint int) {
+	if err := checkDigraph(chars, codepoint); err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return
+	}
+	b.Call("digraph_set", nil, chars, string(rune(codepoint)))
+}
+
+func checkDigraph(chars string, codepoint int) error {
+	if utf8.RuneCountInString(chars) != 2 {
+		return fmt.Errorf("nvim: digraph %q must be two characters", chars)
+	}
+	if !utf8.ValidRune(rune(codepoint)) {
+		return fmt.Errorf("nvim: invalid digraph code point %#x", codepoint)
+	}
+	return nil
+}
+
+// FloatPreviewOptions specifies options for ShowFloatPreview.
+type FloatPreviewOptions struct {
+	// MaxWidth is the maximum width of the window. The default is 80.
+	MaxWidth int
+
+	// MaxHeight is the maximum height of the window. The default is 20.
+	MaxHeight int
+
+	// Border is the window border. See WindowConfig.Border. A border is
+	// assumed to occupy one cell on each side of the window.
+	Border []string
+
+	// Filetype is the 'filetype' of the preview buffer, for example
+	// "markdown".
+	Filetype string
+
+	// Enter enters the window.
+	Enter bool
+}
+
+const (
+	defaultFloatPreviewMaxWidth  = 80
+	defaultFloatPreviewMaxHeight = 20
+)
+
+// ShowFloatPreview shows lines in a floating window next to the cursor, as
+// used for hover documentation and previews. The opts argument may be nil.
+//
+// The lines are set in a new scratch buffer that is wiped out when the window
+// is closed. The window is sized to fit the lines, up to opts.MaxWidth and
+// opts.MaxHeight, and is placed below the cursor line if there is room,
+// otherwise above it. The window is shifted left to stay on the screen. The
+// window uses the "minimal" style and 'wrap' is off.
+func (v *Nvim) ShowFloatPreview(lines []string, opts *FloatPreviewOptions) (Window, Buffer, error) {
+	if opts == nil {
+		opts = &FloatPreviewOptions{}
+	}
+
+	const code = `
+local pos = vim.fn.screenpos(0, vim.fn.line('.'), vim.fn.col('.'))
+return {pos.row - 1, pos.col - 1, vim.o.lines - vim.o.cmdheight, vim.o.columns}
+`
+	var screen [4]int
+	widths := make([]int, len(lines))
+	b := v.NewBatch()
+	b.ExecLua(code, &screen)
+	for i, line := range lines {
+		b.StringWidth(line, &widths[i])
+	}
+	if err := b.Execute(); err != nil {
+		return 0, 0, err
+	}
+	config := floatPreviewConfig(widths, opts, screen[0], screen[1], screen[2], screen[3])
+
+	buffer, err := v.CreateBuffer(false, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	replacement := make([][]byte, len(lines))
+	for i, line := range lines {
+		replacement[i] = []byte(line)
+	}
+
+	var window Window
+	b = v.NewBatch()
+	b.SetBufferLines(buffer, 0, -1, true, replacement)
+	b.SetBufferOption(buffer, "bufhidden", "wipe")
+	if opts.Filetype != "" {
+		b.SetBufferOption(buffer, "filetype", opts.Filetype)
+	}
+	b.SetBufferOption(buffer, "modifiable", false)
+	b.OpenWindow(buffer, opts.Enter, config, &window)
+	if err := b.Execute(); err != nil {
+		v.DeleteBuffer(buffer, map[string]bool{"force": true})
+		return 0, 0, err
+	}
+
+	if err := v.SetWindowOption(window, "wrap", false); err != nil {
+		v.CloseWindow(window, true)
+		return 0, 0, err
+	}
+
+	return window, buffer, nil
+}
+
+// floatPreviewConfig returns the configuration of a preview window showing
+// lines with the display widths in widths. The cursor is at the zero based
+// screen position cursorRow, cursorCol on a screen with screenRows rows
+// available for windows and screenCols columns.
+func floatPreviewConfig(widths []int, opts *FloatPreviewOptions, cursorRow, cursorCol, screenRows, screenCols int) *WindowConfig {
+	maxWidth := opts.MaxWidth
+	if maxWidth <= 0 {
+		maxWidth = defaultFloatPreviewMaxWidth
+	}
+	maxHeight := opts.MaxHeight
+	if maxHeight <= 0 {
+		maxHeight = defaultFloatPreviewMaxHeight
+	}
+	border := 0
+	if len(opts.Border) > 0 {
+		border = 2
+	}
+
+	width := 1
+	for _, w := range widths {
+		if w > width {
+			width = w
+		}
+	}
+	if width > maxWidth {
+		width = maxWidth
+	}
+	if width > screenCols-border {
+		width = screenCols - border
+	}
+
+	height := len(widths)
+	if height > maxHeight {
+		height = maxHeight
+	}
+
+	config := &WindowConfig{
+		Relative:  "cursor",
+		Focusable: true,
+		Style:     "minimal",
+		Border:    opts.Border,
+	}
+
+	// Prefer the space below the cursor line. Use the space above when the
+	// window does not fit below and there is more room above.
+	below := screenRows - cursorRow - 1
+	above := cursorRow
+	space := below
+	if height+border <= below || below >= above {
+		config.Anchor = "NW"
+		config.Row = 1
+	} else {
+		config.Anchor = "SW"
+		space = above
+	}
+	if height > space-border {
+		height = space - border
+	}
+
+	// Shift the window left when it extends past the right edge of the screen.
+	if over := cursorCol + width + border - screenCols; over > 0 {
+		config.Col = -float64(over)
+	}
+
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	config.Width = width
+	config.Height = height
+
+	return config
+}
+
+// ExecCommandsError is returned by ExecCommands when a command fails.
+type ExecCommandsError struct {
+	// Err is the error returned by Nvim.
+	Err error
+
+	// Command is the command that failed.
+	Command string
+
+	// Index is the zero-based index of the command that failed.
+	Index int
+}
+
+// Error implements the error interface.
+func (e *ExecCommandsError) Error() string {
+	return fmt.Sprintf("nvim: command %d %q: %v", e.Index, e.Command, e.Err)
+}
+
+// Unwrap returns the error returned by Nvim.
+func (e *ExecCommandsError) Unwrap() error {
+	return e.Err
+}
+
+// execLineRe matches the script line number in errors from nvim_exec.
+var execLineRe = regexp.MustCompile(`\bline (\d+):`)
+
+// ExecCommands executes the Ex commands cmds in a single round trip to Nvim.
+// The commands are joined into a script executed with Exec with the output
+// disabled. The commands after a failed command are not executed.
+//
+// If a command fails and the error from Nvim reports the line of the script,
+// ExecCommands returns an *ExecCommandsError that identifies the command.
+// Otherwise the error from Nvim is returned as is. ExecCommands does nothing
+// if cmds is empty.
+//
+// ExecCommands is not named Commands because Commands returns the user
+// commands with nvim_get_commands.
+func (v *Nvim) ExecCommands(cmds []string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	_, err := v.Exec(strings.Join(cmds, "\n"), false)
+	if err != nil {
+		return commandsError(cmds, err)
+	}
+	return nil
+}
+
+// ExecCommands executes the Ex commands cmds in a single Exec call. The
+// commands after a failed command are not executed. ExecCommands does not add
+// a call to the batch if cmds is empty.
+func (b *Batch) ExecCommands(cmds []string) {
+	if len(cmds) == 0 {
+		return
+	}
+	b.Exec(strings.Join(cmds, "\n"), false, nil)
+}
+
+// commandsError returns an *ExecCommandsError for the command at the script
+// line reported in err, or err if the line is not known. A command may span
+// several lines, for example a heredoc.
+func commandsError(cmds []string, err error) error {
+	m := execLineRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[1])
+	for i, cmd := range cmds {
+		line -= strings.Count(cmd, "\n") + 1
+		if line <= 0 {
+			return &ExecCommandsError{Err: err, Command: cmd, Index: i}
+		}
+	}
+	return err
+}
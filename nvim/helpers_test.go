@@ -2,9 +2,13 @@ package nvim
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/neovim/go-client/msgpack"
 )
 
 var readerData = []string{
@@ -41,3 +45,545 @@ func TestBufferReader(t *testing.T) {
 		}
 	}
 }
+
+func TestIsUnsetLocalOption(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		name string
+		x    interface{}
+		want bool
+	}{
+		"EmptyString": {
+			name: "tags",
+			x:    "",
+			want: true,
+		},
+		"String": {
+			name: "tags",
+			x:    "tags",
+			want: false,
+		},
+		"UndolevelsUnset": {
+			name: "undolevels",
+			x:    int64(-123456),
+			want: true,
+		},
+		"UndolevelsNoUndo": {
+			// -1 is a valid 'undolevels' value that disables undo.
+			name: "undolevels",
+			x:    int64(-1),
+			want: false,
+		},
+		"ScrolloffUnset": {
+			name: "scrolloff",
+			x:    int64(-1),
+			want: true,
+		},
+		"AutoreadUnset": {
+			name: "autoread",
+			x:    int64(-1),
+			want: true,
+		},
+		"OtherNumber": {
+			name: "textwidth",
+			x:    int64(-1),
+			want: false,
+		},
+		"Number": {
+			name: "scrolloff",
+			x:    int64(0),
+			want: false,
+		},
+		"Bool": {
+			name: "autoread",
+			x:    false,
+			want: false,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isUnsetLocalOption(tt.name, tt.x); got != tt.want {
+				t.Fatalf("isUnsetLocalOption(%q, %#v) = %v, want %v", tt.name, tt.x, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCharEnd(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		line      string
+		col       int
+		exclusive bool
+		want      int
+	}{
+		"ASCII": {
+			line: "hello",
+			col:  1,
+			want: 2,
+		},
+		"MultiByte": {
+			line: "aäb",
+			col:  1,
+			want: 3,
+		},
+		"Exclusive": {
+			line:      "aäb",
+			col:       1,
+			exclusive: true,
+			want:      1,
+		},
+		"PastEnd": {
+			line: "abc",
+			col:  2147483646,
+			want: 3,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := charEnd([]byte(tt.line), tt.col, tt.exclusive); got != tt.want {
+				t.Fatalf("charEnd(%q, %d, %v) = %d, want %d", tt.line, tt.col, tt.exclusive, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockByteRange(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		line        string
+		left, right int
+		want        string
+	}{
+		"ASCII": {
+			line:  "abcdef",
+			left:  1,
+			right: 3,
+			want:  "bcd",
+		},
+		"Short": {
+			line:  "ab",
+			left:  1,
+			right: 3,
+			want:  "b",
+		},
+		"Outside": {
+			line:  "ab",
+			left:  3,
+			right: 4,
+			want:  "",
+		},
+		"Tab": {
+			line:  "\tabc",
+			left:  8,
+			right: 9,
+			want:  "ab",
+		},
+		"InsideTab": {
+			line:  "a\tb",
+			left:  2,
+			right: 4,
+			want:  "",
+		},
+		"MultiByte": {
+			line:  "aäöb",
+			left:  1,
+			right: 2,
+			want:  "äö",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			line := []byte(tt.line)
+			start, end := blockByteRange(line, tt.left, tt.right, 8)
+			if got := string(line[start:end]); got != tt.want {
+				t.Fatalf("blockByteRange(%q, %d, %d) = %q, want %q", tt.line, tt.left, tt.right, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockColumns(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		first, last       string
+		startCol, endCol  int
+		exclusive         bool
+		wantLeft, wantEnd int
+	}{
+		"Inclusive": {
+			first:    "abcdef",
+			startCol: 1,
+			last:     "abcdef",
+			endCol:   3,
+			wantLeft: 1,
+			wantEnd:  3,
+		},
+		"Reversed": {
+			first:    "abcdef",
+			startCol: 3,
+			last:     "abcdef",
+			endCol:   1,
+			wantLeft: 1,
+			wantEnd:  3,
+		},
+		"Exclusive": {
+			first:     "abcdef",
+			startCol:  1,
+			last:      "abcdef",
+			endCol:    3,
+			exclusive: true,
+			wantLeft:  1,
+			wantEnd:   2,
+		},
+		"Tab": {
+			first:    "\tab",
+			startCol: 0,
+			last:     "abcdefghij",
+			endCol:   2,
+			wantLeft: 0,
+			wantEnd:  7,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			left, right := blockColumns([]byte(tt.first), tt.startCol, []byte(tt.last), tt.endCol, 8, tt.exclusive)
+			if left != tt.wantLeft || right != tt.wantEnd {
+				t.Fatalf("blockColumns() = (%d, %d), want (%d, %d)", left, right, tt.wantLeft, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestFloatPreviewConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		widths               []int
+		opts                 FloatPreviewOptions
+		cursorRow, cursorCol int
+		want                 WindowConfig
+	}{
+		"Below": {
+			widths:    []int{10, 20, 5},
+			cursorRow: 5,
+			cursorCol: 10,
+			want:      WindowConfig{Anchor: "NW", Row: 1, Width: 20, Height: 3},
+		},
+		"Above": {
+			widths:    []int{10, 20, 5},
+			cursorRow: 22,
+			cursorCol: 10,
+			want:      WindowConfig{Anchor: "SW", Width: 20, Height: 3},
+		},
+		"BelowClamped": {
+			widths:    make([]int, 30),
+			cursorRow: 10,
+			want:      WindowConfig{Anchor: "NW", Row: 1, Width: 1, Height: 13},
+		},
+		"AboveClamped": {
+			widths:    make([]int, 30),
+			cursorRow: 15,
+			want:      WindowConfig{Anchor: "SW", Width: 1, Height: 15},
+		},
+		"MaxSize": {
+			widths:    []int{100, 100, 100, 100},
+			opts:      FloatPreviewOptions{MaxWidth: 40, MaxHeight: 2},
+			cursorRow: 0,
+			want:      WindowConfig{Anchor: "NW", Row: 1, Width: 40, Height: 2},
+		},
+		"DefaultMaxWidth": {
+			widths: []int{100},
+			want:   WindowConfig{Anchor: "NW", Row: 1, Width: 80, Height: 1},
+		},
+		"ShiftLeft": {
+			widths:    []int{30},
+			cursorCol: 70,
+			want:      WindowConfig{Anchor: "NW", Row: 1, Col: -20, Width: 30, Height: 1},
+		},
+		"Border": {
+			widths:    []int{30, 30},
+			opts:      FloatPreviewOptions{Border: []string{"+", "-", "+", "|", "+", "-", "+", "|"}},
+			cursorRow: 20,
+			cursorCol: 60,
+			want: WindowConfig{
+				Anchor: "SW",
+				Col:    -12,
+				Width:  30,
+				Height: 2,
+				Border: []string{"+", "-", "+", "|", "+", "-", "+", "|"},
+			},
+		},
+		"Empty": {
+			want: WindowConfig{Anchor: "NW", Row: 1, Width: 1, Height: 1},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.want.Relative = "cursor"
+			tt.want.Focusable = true
+			tt.want.Style = "minimal"
+
+			got := floatPreviewConfig(tt.widths, &tt.opts, tt.cursorRow, tt.cursorCol, 24, 80)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("got %+v but want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCwd(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		global, tab, win string
+		want             string
+		wantScope        CwdScope
+	}{
+		"Global": {
+			global:    "/global",
+			want:      "/global",
+			wantScope: GlobalCwdScope,
+		},
+		"Tabpage": {
+			global:    "/global",
+			tab:       "/tab",
+			want:      "/tab",
+			wantScope: TabpageCwdScope,
+		},
+		"Window": {
+			global:    "/global",
+			tab:       "/tab",
+			win:       "/win",
+			want:      "/win",
+			wantScope: WindowCwdScope,
+		},
+		"WindowWithoutTabpage": {
+			global:    "/global",
+			win:       "/win",
+			want:      "/win",
+			wantScope: WindowCwdScope,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, scope := resolveCwd(tt.global, tt.tab, tt.win)
+			if got != tt.want || scope != tt.wantScope {
+				t.Fatalf("resolveCwd(%q, %q, %q) = (%q, %q), want (%q, %q)", tt.global, tt.tab, tt.win, got, scope, tt.want, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestCommandsError(t *testing.T) {
+	t.Parallel()
+
+	cmds := []string{"set nowrap", "let x =<< END\na\nEND", "bogus", "echo 1"}
+	tests := map[string]struct {
+		err       string
+		wantIndex int // -1 for the error from Nvim
+	}{
+		"First":   {err: "nvim_exec2(), line 1: Vim(set):E518: Unknown option: x", wantIndex: 0},
+		"Heredoc": {err: "nvim_exec2(), line 3: Vim(let):E990: Missing end marker 'END'", wantIndex: 1},
+		"After":   {err: "nvim_exec2(), line 5: Vim:E492: Not an editor command: bogus", wantIndex: 2},
+		"Last":    {err: "nvim_exec2(), line 6: Vim(echo):E121: Undefined variable", wantIndex: 3},
+		"NoLine":  {err: "Vim:E492: Not an editor command: bogus", wantIndex: -1},
+		"Outside": {err: "nvim_exec2(), line 7: E000", wantIndex: -1},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			nvimErr := errors.New(tt.err)
+			err := commandsError(cmds, nvimErr)
+			if tt.wantIndex < 0 {
+				if err != nvimErr {
+					t.Fatalf("got error %v, want the error from Nvim", err)
+				}
+				return
+			}
+			var cerr *ExecCommandsError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("got error %v, want *ExecCommandsError", err)
+			}
+			if cerr.Index != tt.wantIndex || cerr.Command != cmds[tt.wantIndex] {
+				t.Fatalf("got command %d %q, want %d %q", cerr.Index, cerr.Command, tt.wantIndex, cmds[tt.wantIndex])
+			}
+			if !errors.Is(err, nvimErr) {
+				t.Fatal("error does not wrap the error from Nvim")
+			}
+		})
+	}
+}
+
+func TestWindowConfigMsgPack(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config *WindowConfig
+		want   map[string]interface{}
+	}{
+		"Split": {
+			config: &WindowConfig{Width: 10, Height: 5},
+			want: map[string]interface{}{
+				"width":  int64(10),
+				"height": int64(5),
+			},
+		},
+		"FloatAtOrigin": {
+			config: &WindowConfig{Relative: "editor", Width: 10, Height: 5, ZIndex: 100},
+			want: map[string]interface{}{
+				"relative": "editor",
+				"width":    int64(10),
+				"height":   int64(5),
+				"row":      float64(0),
+				"col":      float64(0),
+				"zindex":   int64(100),
+			},
+		},
+		"BorderStyle": {
+			config: &WindowConfig{Relative: "cursor", Row: 1, Width: 1, Height: 1, Border: BorderStyle("rounded")},
+			want: map[string]interface{}{
+				"relative": "cursor",
+				"width":    int64(1),
+				"height":   int64(1),
+				"row":      float64(1),
+				"col":      float64(0),
+				"border":   "rounded",
+			},
+		},
+		"BorderChars": {
+			config: &WindowConfig{Relative: "cursor", Width: 1, Height: 1, Border: WindowBorder{"+", "-"}},
+			want: map[string]interface{}{
+				"relative": "cursor",
+				"width":    int64(1),
+				"height":   int64(1),
+				"row":      float64(0),
+				"col":      float64(0),
+				"border":   []interface{}{"+", "-"},
+			},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := msgpack.NewEncoder(&buf).Encode(tt.config); err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := msgpack.NewDecoder(&buf).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			delete(got, "focusable")
+			delete(got, "bufpos")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowBorderUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		in   interface{}
+		want WindowBorder
+	}{
+		"Nil":   {in: nil, want: nil},
+		"Style": {in: "double", want: BorderStyle("double")},
+		"Chars": {in: []string{"a", "b"}, want: WindowBorder{"a", "b"}},
+		"Highlight": {
+			in:   []interface{}{[]string{"a", "Normal"}, "b", []string{"c"}},
+			want: WindowBorder{"a", "b", "c"},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := msgpack.NewEncoder(&buf).Encode(tt.in); err != nil {
+				t.Fatal(err)
+			}
+			got := WindowBorder{"x"}
+			if err := msgpack.NewDecoder(&buf).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := msgpack.NewEncoder(&buf).Encode(1); err != nil {
+		t.Fatal(err)
+	}
+	dec := msgpack.NewDecoder(&buf)
+	if err := dec.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	var got WindowBorder
+	if err := got.UnmarshalMsgPack(dec); err == nil {
+		t.Fatal("expected error decoding an integer as a border")
+	}
+}
+
+func TestWindowConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config  WindowConfig
+		wantErr bool
+	}{
+		"Split":          {config: WindowConfig{}},
+		"Float":          {config: WindowConfig{Relative: "editor", Width: 1, Height: 1, Anchor: "SE"}},
+		"BufPos":         {config: WindowConfig{Relative: "win", BufPos: [2]int{1, 2}, Width: 1, Height: 1}},
+		"Style":          {config: WindowConfig{Relative: "cursor", Width: 1, Height: 1, Border: BorderStyle("shadow")}},
+		"SplitPosition":  {config: WindowConfig{Row: 1}, wantErr: true},
+		"BadRelative":    {config: WindowConfig{Relative: "screen", Width: 1, Height: 1}, wantErr: true},
+		"BadAnchor":      {config: WindowConfig{Relative: "editor", Width: 1, Height: 1, Anchor: "N"}, wantErr: true},
+		"BufPosNotWin":   {config: WindowConfig{Relative: "editor", BufPos: [2]int{1, 2}, Width: 1, Height: 1}, wantErr: true},
+		"FloatNoSize":    {config: WindowConfig{Relative: "editor", Width: 1}, wantErr: true},
+		"BadBorderChars": {config: WindowConfig{Relative: "editor", Width: 1, Height: 1, Border: WindowBorder{"a", "b", "c"}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
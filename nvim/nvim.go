@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
 	"os/exec"
 	"reflect"
 	"strings"
@@ -23,20 +24,68 @@ import (
 
 var embedProcAttr *syscall.SysProcAttr
 
+// defaultShutdownSignals are the signals sent to a child process that does not
+// exit when the client is closed. Windows supports os.Kill only.
+var defaultShutdownSignals = []os.Signal{syscall.SIGTERM, os.Kill}
+
+// defaultShutdownTimeout is the time to wait for a child process to exit
+// before sending the next shutdown signal.
+const defaultShutdownTimeout = 10 * time.Second
+
+// dialPipe dials a named pipe. It is set on Windows only.
+var dialPipe func(ctx context.Context, address string) (net.Conn, error)
+
+// namedPipePrefix is the prefix of the named pipe addresses used by Nvim on
+// Windows.
+const namedPipePrefix = `\\.\pipe\`
+
 // Nvim represents a remote instance of Nvim. It is safe to call Nvim methods
 // concurrently.
 type Nvim struct {
-	ep *rpc.Endpoint
+	ep         *rpc.Endpoint
+	epMu       sync.RWMutex
+	generation int
+	closed     bool
+
+	// registrations are the functions that registered the handlers on ep, by
+	// method. They are run again on the endpoint for a new connection.
+	registrations map[string]func(ep *rpc.Endpoint) error
+
+	// subscriptions counts the active SubscribeHandler subscriptions by
+	// event.
+	subscriptions   map[string]int
+	subscriptionsMu sync.Mutex
+
+	// extensions are the extensions registered with RegisterExtension. They
+	// are registered again on the endpoint for a new connection.
+	extensions msgpack.ExtensionMap
+
+	// reconnect is the reconnection configuration for Dial, if any.
+	reconnect *reconnector
 
 	// cmd is the child process, if any.
 	cmd         *exec.Cmd
+	cmdState    *os.ProcessState // set under epMu when cmd exits
+	stopWatch   chan struct{}    // closed by close to stop watchContext
 	serveCh     chan error
 	channelID   int
 	channelIDMu sync.Mutex
 
+	// shutdownSignals are sent to cmd one by one, shutdownTimeout apart, if
+	// cmd does not exit when the client is closed.
+	shutdownSignals []os.Signal
+	shutdownTimeout time.Duration
+
 	// readMu prevents concurrent calls to read on the child process stdout pipe and
 	// calls to cmd.Wait().
 	readMu sync.Mutex
+
+	// callbacks are the functions run by Window.Call and Buffer.Call, by ID.
+	callbacks      map[int]func() error
+	callbackID     int
+	callbacksMu    sync.Mutex
+	callbacksOnce  sync.Once
+	callbacksError error
 }
 
 // Serve serves incoming mesages from the peer. Serve blocks until Nvim
@@ -44,10 +93,65 @@ type Nvim struct {
 //
 // By default, the NewChildProcess and Dial functions start a goroutine to run Serve().
 // Callers of the low-level New function are responsible for running Serve().
+//
+// Serve returns an error wrapping rpc.ErrPeerClosed if Nvim closes the
+// connection, for example when Nvim exits, and nil if the client is closed
+// with Close or Shutdown.
+//
+// If the client was created by Dial with the DialReconnect or DialReconnectIf
+// option, Serve reconnects to Nvim when the connection fails and returns when
+// the reconnection attempts are exhausted.
 func (v *Nvim) Serve() error {
 	v.readMu.Lock()
 	defer v.readMu.Unlock()
-	return v.ep.Serve()
+	if v.reconnect != nil {
+		return v.serveReconnect()
+	}
+	return v.endpoint().Serve()
+}
+
+// endpoint returns the endpoint for the current connection.
+func (v *Nvim) endpoint() *rpc.Endpoint {
+	v.epMu.RLock()
+	defer v.epMu.RUnlock()
+	return v.ep
+}
+
+// register runs f to register a handler for method on the endpoint. The
+// handler is registered again with f after a reconnect.
+func (v *Nvim) register(method string, f func(ep *rpc.Endpoint) error) error {
+	v.epMu.Lock()
+	defer v.epMu.Unlock()
+	if err := f(v.ep); err != nil {
+		return err
+	}
+	if v.registrations == nil {
+		v.registrations = make(map[string]func(ep *rpc.Endpoint) error)
+	}
+	v.registrations[method] = f
+	return nil
+}
+
+// unregister removes the handler for method registered with register.
+func (v *Nvim) unregister(method string) {
+	v.epMu.Lock()
+	defer v.epMu.Unlock()
+	v.ep.Unregister(method)
+	delete(v.registrations, method)
+}
+
+// Generation returns the number of times the client reconnected to Nvim. It
+// is always 0 for clients created without the DialReconnect and
+// DialReconnectIf options.
+//
+// Callers can compare the generation before and after an operation to detect
+// that the connection was replaced. The state of the previous connection,
+// such as attached UIs, buffer updates and the channel id, is lost in a
+// reconnect, see DialOnReconnect.
+func (v *Nvim) Generation() int {
+	v.epMu.RLock()
+	defer v.epMu.RUnlock()
+	return v.generation
 }
 
 func (v *Nvim) startServe() {
@@ -60,23 +164,84 @@ func (v *Nvim) startServe() {
 
 // Close releases the resources used the client.
 func (v *Nvim) Close() error {
-	if v.cmd != nil && v.cmd.Process != nil {
-		// The child process should exit cleanly on call to v.ep.Close(). Kill
-		// the process if it does not exit as expected.
-		t := time.AfterFunc(10*time.Second, func() { v.cmd.Process.Kill() })
-		defer t.Stop()
+	return v.close((*rpc.Endpoint).Close)
+}
+
+// Shutdown gracefully closes the client. Shutdown stops running handlers for
+// new requests and notifications from Nvim, waits for the running handlers to
+// return and then releases the resources used by the client as Close does.
+// Requests from Nvim received during the shutdown fail with an error.
+//
+// If ctx is done before the handlers return, Shutdown closes the connection
+// without waiting further and returns the context's error.
+func (v *Nvim) Shutdown(ctx context.Context) error {
+	return v.close(func(ep *rpc.Endpoint) error {
+		return ep.Shutdown(ctx)
+	})
+}
+
+// ProcessState returns the state of the child process started by
+// NewChildProcess after the process exited. The state is available after Close
+// or Shutdown returns. ProcessState returns nil if the client has no child
+// process, such as a client created with Dial, or if the process has not been
+// waited for.
+//
+// Use ProcessState to find out why Nvim exited, for example the signal that
+// terminated the process from ProcessState().Sys().
+func (v *Nvim) ProcessState() *os.ProcessState {
+	v.epMu.RLock()
+	defer v.epMu.RUnlock()
+	return v.cmdState
+}
+
+// ExitCode returns the exit code of the child process started by
+// NewChildProcess, or -1 if the process was terminated by a signal or if
+// ProcessState returns nil.
+func (v *Nvim) ExitCode() int {
+	ps := v.ProcessState()
+	if ps == nil {
+		return -1
+	}
+	return ps.ExitCode()
+}
+
+// close closes the endpoint with closeEndpoint and releases the other
+// resources used by the client.
+func (v *Nvim) close(closeEndpoint func(ep *rpc.Endpoint) error) error {
+	v.epMu.Lock()
+	v.closed = true
+	ep := v.ep
+	if v.stopWatch != nil {
+		close(v.stopWatch)
+		v.stopWatch = nil
+	}
+	v.epMu.Unlock()
+	if v.reconnect != nil {
+		v.reconnect.cancel()
 	}
 
-	err := v.ep.Close()
+	err := closeEndpoint(ep)
+
+	if v.cmd != nil && v.cmd.Process != nil {
+		// The child process should exit cleanly when the endpoint is closed.
+		// Signal the process if it does not exit as expected.
+		exited := make(chan struct{})
+		defer close(exited)
+		go signalProcess(v.cmd.Process, v.shutdownSignals, v.shutdownTimeout, exited)
+	}
 
 	if v.cmd != nil {
+		// Release readMu before waiting for Serve below. Serve may not have
+		// acquired it yet if the client is closed right after it is created.
 		v.readMu.Lock()
-		defer v.readMu.Unlock()
-
 		errWait := v.cmd.Wait()
+		v.readMu.Unlock()
 		if err == nil {
 			err = errWait
 		}
+		v.epMu.Lock()
+		v.cmdState = v.cmd.ProcessState
+		v.epMu.Unlock()
 	}
 
 	if v.serveCh != nil {
@@ -86,6 +251,10 @@ func (v *Nvim) Close() error {
 		case <-time.After(10 * time.Second):
 			errServe = errors.New("nvim: Serve did not exit")
 		}
+		if errors.Is(errServe, rpc.ErrPeerClosed) {
+			// Nvim may exit before Serve sees that the client is closed.
+			errServe = nil
+		}
 		if err == nil {
 			err = errServe
 		}
@@ -94,6 +263,53 @@ func (v *Nvim) Close() error {
 	return err
 }
 
+// signalProcess sends signals to p one by one, waiting timeout before each
+// signal, until exited is closed. The process is killed if sending a signal
+// fails, for example because the platform does not support the signal, or
+// if the process does not exit after the last signal.
+func signalProcess(p *os.Process, signals []os.Signal, timeout time.Duration, exited <-chan struct{}) {
+	if len(signals) == 0 || signals[len(signals)-1] != os.Kill {
+		signals = append(signals[:len(signals):len(signals)], os.Kill)
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for _, sig := range signals {
+		select {
+		case <-exited:
+			return
+		case <-t.C:
+		}
+		if err := p.Signal(sig); err != nil {
+			p.Kill()
+			return
+		}
+		t.Reset(timeout)
+	}
+}
+
+// watchContext closes the client when done is closed before Close is called.
+// The context of exec.CommandContext kills the child process, but the stdout
+// pipe stays open if the process started other processes that inherited it.
+// Closing stdout makes Serve return without waiting for those processes.
+func (v *Nvim) watchContext(done <-chan struct{}, stdout io.Closer, stop <-chan struct{}) {
+	select {
+	case <-done:
+	case <-stop:
+		return
+	}
+	v.epMu.Lock()
+	if v.closed {
+		v.epMu.Unlock()
+		return
+	}
+	v.closed = true
+	ep := v.ep
+	v.epMu.Unlock()
+
+	ep.Close()
+	stdout.Close()
+}
+
 // New creates an Nvim client. When connecting to Nvim over stdio, use stdin as
 // r and stdout as w and c, When connecting to Nvim over a network connection,
 // use the connection for r, w and c.
@@ -105,7 +321,100 @@ func (v *Nvim) Close() error {
 //
 //  :help rpc-connecting
 func New(r io.Reader, w io.Writer, c io.Closer, logf func(string, ...interface{})) (*Nvim, error) {
-	ep, err := rpc.NewEndpoint(r, w, c, rpc.WithLogf(logf), withExtensions())
+	return newNvim(r, w, c, endpointConfig{logf: logf})
+}
+
+func newNvim(r io.Reader, w io.Writer, c io.Closer, config endpointConfig) (*Nvim, error) {
+	ep, err := rpc.NewEndpoint(r, w, c, config.options()...)
+	if err != nil {
+		return nil, err
+	}
+	return &Nvim{ep: ep}, nil
+}
+
+// endpointConfig is the configuration of the endpoints of a client.
+type endpointConfig struct {
+	logf         func(string, ...interface{})
+	logger       rpc.Logger // overrides logf
+	onDisconnect func(error)
+	countBytes   bool
+}
+
+// options returns the options for an endpoint.
+func (c *endpointConfig) options() []rpc.Option {
+	options := []rpc.Option{rpc.WithLogf(c.logf), withExtensions()}
+	if c.logger != nil {
+		options = append(options, rpc.WithLogger(c.logger))
+	}
+	if c.onDisconnect != nil {
+		options = append(options, rpc.WithOnDisconnect(c.onDisconnect))
+	}
+	if c.countBytes {
+		options = append(options, rpc.WithByteCounters())
+	}
+	return options
+}
+
+// infof logs a message with the logger or the log function.
+func (c *endpointConfig) infof(format string, args ...interface{}) {
+	switch {
+	case c.logger != nil:
+		c.logger.Infof(format, args...)
+	case c.logf != nil:
+		c.logf(format, args...)
+	}
+}
+
+// FramedOption specifies an option for NewFramed.
+type FramedOption struct {
+	f func(*endpointConfig)
+}
+
+// FramedLogf specifies function for logging output. The log.Printf function
+// is used by default.
+func FramedLogf(logf func(string, ...interface{})) FramedOption {
+	return FramedOption{func(c *endpointConfig) {
+		c.logf = logf
+	}}
+}
+
+// FramedLogger specifies a leveled logger for the client's endpoint
+// messages and errors. The logger replaces the function set with FramedLogf.
+func FramedLogger(logger rpc.Logger) FramedOption {
+	return FramedOption{func(c *endpointConfig) {
+		c.logger = logger
+	}}
+}
+
+// FramedOnDisconnect specifies a function called when Serve stops serving
+// the transport. The function is called with io.EOF if the frame reader
+// returned io.EOF, with rpc.ErrClosed if the client was closed with Close or
+// Shutdown, and with the error that stopped Serve otherwise.
+func FramedOnDisconnect(f func(err error)) FramedOption {
+	return FramedOption{func(c *endpointConfig) {
+		c.onDisconnect = f
+	}}
+}
+
+// FramedByteCounters enables counting the bytes exchanged with Nvim in the
+// BytesRead and BytesWritten fields of Stats.
+func FramedByteCounters() FramedOption {
+	return FramedOption{func(c *endpointConfig) {
+		c.countBytes = true
+	}}
+}
+
+// NewFramed creates an Nvim client that exchanges messages with Nvim using a
+// transport with its own framing, for example a multiplexer running several
+// sessions over a single connection. See rpc.FrameReader and rpc.FrameWriter.
+//
+// The application must call Serve() to handle RPC requests and responses.
+func NewFramed(r rpc.FrameReader, w rpc.FrameWriter, c io.Closer, options ...FramedOption) (*Nvim, error) {
+	config := endpointConfig{logf: log.Printf}
+	for _, o := range options {
+		o.f(&config)
+	}
+	ep, err := rpc.NewFramedEndpoint(r, w, c, config.options()...)
 	if err != nil {
 		return nil, err
 	}
@@ -118,13 +427,16 @@ type ChildProcessOption struct {
 }
 
 type childProcessOptions struct {
-	ctx     context.Context
-	logf    func(string, ...interface{})
-	command string
-	dir     string
-	args    []string
-	env     []string
-	serve   bool
+	endpointConfig
+
+	ctx             context.Context
+	command         string
+	dir             string
+	args            []string
+	env             []string
+	serve           bool
+	shutdownSignals []os.Signal
+	shutdownTimeout time.Duration
 }
 
 // ChildProcessArgs specifies the command line arguments. The application must
@@ -144,8 +456,13 @@ func ChildProcessCommand(command string) ChildProcessOption {
 	}}
 }
 
-// ChildProcessContext specifies the context to use when starting the command.
-// The background context is used by defaullt.
+// ChildProcessContext specifies the context of the child process. The
+// background context is used by default.
+//
+// When the context is done before the client is closed, the process is killed
+// and the client is closed: Serve returns, calls to Nvim fail with
+// rpc.ErrClosed and Close or Shutdown waits for the process and returns the
+// error that reports how the process exited.
 func ChildProcessContext(ctx context.Context) ChildProcessOption {
 	return ChildProcessOption{func(cpos *childProcessOptions) {
 		cpos.ctx = ctx
@@ -184,14 +501,68 @@ func ChildProcessLogf(logf func(string, ...interface{})) ChildProcessOption {
 	}}
 }
 
+// ChildProcessLogger specifies the logger for the messages exchanged with Nvim
+// and for errors. The logger replaces the function set with ChildProcessLogf.
+func ChildProcessLogger(logger rpc.Logger) ChildProcessOption {
+	return ChildProcessOption{func(cpos *childProcessOptions) {
+		cpos.logger = logger
+	}}
+}
+
+// ChildProcessOnDisconnect specifies a function called when Serve stops
+// serving the connection to the child process. The function is called with
+// io.EOF if Nvim closed the connection, for example when Nvim exits, with
+// rpc.ErrClosed if the client was closed with Close or Shutdown or by the
+// context of ChildProcessContext, and with the error that stopped Serve
+// otherwise.
+func ChildProcessOnDisconnect(f func(err error)) ChildProcessOption {
+	return ChildProcessOption{func(cpos *childProcessOptions) {
+		cpos.onDisconnect = f
+	}}
+}
+
+// ChildProcessShutdownSignals specifies the signals sent to the child process
+// if it does not exit when the client is closed. Nvim exits when the
+// connection is closed, so the signals are only needed if Nvim is stuck.
+//
+// Close and Shutdown wait for the timeout set with ChildProcessShutdownTimeout
+// before sending each signal in turn. The process is killed if it does not
+// exit after the last signal, or if a signal is not supported by the platform.
+// The default is syscall.SIGTERM followed by os.Kill, which lets Nvim remove
+// its swap files before it is killed. On Windows, the default is os.Kill.
+func ChildProcessShutdownSignals(signals ...os.Signal) ChildProcessOption {
+	return ChildProcessOption{func(cpos *childProcessOptions) {
+		cpos.shutdownSignals = signals
+	}}
+}
+
+// ChildProcessShutdownTimeout specifies how long Close and Shutdown wait for
+// the child process to exit before sending each of the signals set with
+// ChildProcessShutdownSignals. The default is 10 seconds.
+func ChildProcessShutdownTimeout(timeout time.Duration) ChildProcessOption {
+	return ChildProcessOption{func(cpos *childProcessOptions) {
+		cpos.shutdownTimeout = timeout
+	}}
+}
+
+// ChildProcessByteCounters enables counting the bytes exchanged with Nvim in
+// the BytesRead and BytesWritten fields of Stats.
+func ChildProcessByteCounters() ChildProcessOption {
+	return ChildProcessOption{func(cpos *childProcessOptions) {
+		cpos.countBytes = true
+	}}
+}
+
 // NewChildProcess returns a client connected to stdin and stdout of a new
 // child process.
 func NewChildProcess(options ...ChildProcessOption) (*Nvim, error) {
 	cpos := &childProcessOptions{
-		serve:   true,
-		logf:    log.Printf,
-		command: "nvim",
-		ctx:     context.Background(),
+		endpointConfig:  endpointConfig{logf: log.Printf},
+		serve:           true,
+		command:         "nvim",
+		ctx:             context.Background(),
+		shutdownSignals: defaultShutdownSignals,
+		shutdownTimeout: defaultShutdownTimeout,
 	}
 	for _, cpo := range options {
 		cpo.f(cpos)
@@ -218,8 +589,14 @@ func NewChildProcess(options ...ChildProcessOption) (*Nvim, error) {
 		return nil, err
 	}
 
-	v, _ := New(outr, inw, inw, cpos.logf)
+	v, _ := newNvim(outr, inw, inw, cpos.endpointConfig)
 	v.cmd = cmd
+	v.shutdownSignals = cpos.shutdownSignals
+	v.shutdownTimeout = cpos.shutdownTimeout
+	if done := cpos.ctx.Done(); done != nil {
+		v.stopWatch = make(chan struct{})
+		go v.watchContext(done, outr, v.stopWatch)
+	}
 
 	if cpos.serve {
 		v.startServe()
@@ -280,10 +657,16 @@ type DialOption struct {
 }
 
 type dialOptions struct {
-	ctx     context.Context
-	logf    func(string, ...interface{})
-	netDial func(ctx context.Context, network, address string) (net.Conn, error)
-	serve   bool
+	endpointConfig
+
+	ctx         context.Context
+	netDial     func(ctx context.Context, network, address string) (net.Conn, error)
+	reconnect   bool
+	maxRetries  int
+	backoff     func(attempt int) time.Duration
+	reconnectIf func(error) bool
+	onReconnect func(v *Nvim) error
+	serve       bool
 }
 
 // DialContext specifies the context to use when starting the command.
@@ -295,7 +678,9 @@ func DialContext(ctx context.Context) DialOption {
 }
 
 // DialNetDial specifies a function used to dial a network connection. A
-// default net.Dialer DialContext method is used by default.
+// default net.Dialer DialContext method is used by default. On Windows, the
+// function is called with the network "pipe" for named pipe addresses, which
+// are dialed with the Windows named pipe API by default.
 func DialNetDial(f func(ctx context.Context, network, address string) (net.Conn, error)) DialOption {
 	return DialOption{func(dos *dialOptions) {
 		dos.netDial = f
@@ -317,18 +702,157 @@ func DialLogf(logf func(string, ...interface{})) DialOption {
 	}}
 }
 
+// DialLogger specifies the logger for the messages exchanged with Nvim and for
+// errors. The logger replaces the function set with DialLogf.
+func DialLogger(logger rpc.Logger) DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.logger = logger
+	}}
+}
+
+// DialOnDisconnect specifies a function called when Serve stops serving the
+// connection to Nvim. The function is called with io.EOF if Nvim closed the
+// connection, with rpc.ErrClosed if the client was closed with Close or
+// Shutdown, and with the error that stopped Serve otherwise.
+//
+// With DialReconnect, f is called for each connection, before Serve decides
+// whether to reconnect.
+func DialOnDisconnect(f func(err error)) DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.onDisconnect = f
+	}}
+}
+
+// DialByteCounters enables counting the bytes exchanged with Nvim in the
+// BytesRead and BytesWritten fields of Stats. The counters start over for each
+// connection made with DialReconnect.
+func DialByteCounters() DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.countBytes = true
+	}}
+}
+
+// DialReconnect specifies that Serve reconnects to the same address when the
+// connection to Nvim fails, instead of returning the error. Serve makes at most
+// maxRetries attempts to reconnect before it gives up and returns an error. If
+// maxRetries is negative, Serve tries to reconnect until the client is closed.
+// The number of attempts starts over after each successful reconnect.
+//
+// Before attempt n (starting from 1), Serve waits for backoff(n). If backoff is
+// nil, the attempts are made without waiting.
+//
+// Calls made while the connection is down fail. The handlers registered with
+// RegisterHandler and RegisterRedrawHandler are registered again for the new
+// connection, but other state in Nvim, such as attached UIs and buffer
+// updates, must be restored by the function specified with DialOnReconnect.
+// Use Generation to detect that a reconnect happened.
+func DialReconnect(maxRetries int, backoff func(attempt int) time.Duration) DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.reconnect = true
+		dos.maxRetries = maxRetries
+		dos.backoff = backoff
+	}}
+}
+
+// DialOnReconnect specifies a function called after Serve reconnects to Nvim
+// to restore the state of the client, for example to attach a UI again. The
+// function is called on the goroutine running Serve while the new connection
+// is served, so it can call Nvim. If f returns an error, the connection is
+// closed and the reconnect attempt counts as failed.
+//
+// DialOnReconnect has no effect without DialReconnect.
+func DialOnReconnect(f func(v *Nvim) error) DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.onReconnect = f
+	}}
+}
+
+// DialReconnectIf specifies that Serve reconnects to the same address when the
+// connection to Nvim fails with an error for which f returns true. Errors for
+// which f returns false are returned from Serve as fatal errors, so permanent
+// failures do not cause a reconnect loop.
+//
+// Without DialReconnect, Serve makes at most 5 attempts to reconnect and waits
+// 100ms before the first attempt, doubling the wait for each further attempt.
+// Use DialReconnect to change the number of attempts and the wait.
+//
+// If DialReconnectIf is not specified, DialReconnect retries after I/O and
+// network errors, such as a connection reset by the peer, and not after other
+// errors, such as MessagePack RPC protocol errors.
+func DialReconnectIf(f func(err error) bool) DialOption {
+	return DialOption{func(dos *dialOptions) {
+		dos.reconnect = true
+		dos.reconnectIf = f
+	}}
+}
+
+const (
+	// defaultReconnectRetries is the number of reconnect attempts when the
+	// reconnection is enabled with DialReconnectIf only.
+	defaultReconnectRetries = 5
+
+	// maxReconnectBackoff is the longest wait of defaultReconnectBackoff.
+	maxReconnectBackoff = 5 * time.Second
+)
+
+// defaultReconnectBackoff is the wait before a reconnect attempt when the
+// reconnection is enabled with DialReconnectIf only.
+func defaultReconnectBackoff(attempt int) time.Duration {
+	d := 100 * time.Millisecond
+	for i := 1; i < attempt && d < maxReconnectBackoff; i++ {
+		d *= 2
+	}
+	if d > maxReconnectBackoff {
+		d = maxReconnectBackoff
+	}
+	return d
+}
+
+// dialNet is the default function for DialNetDial.
+func dialNet(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "pipe" {
+		return dialPipe(ctx, address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// isReconnectable is the default function for DialReconnectIf. Protocol and
+// decoding errors are not retried. Other I/O and network errors, including
+// platform errors such as WSAECONNRESET on Windows, are retried.
+func isReconnectable(err error) bool {
+	var convertErr *msgpack.DecodeConvertError
+	if errors.Is(err, rpc.ErrInvalidMessage) ||
+		errors.Is(err, msgpack.ErrLimitExceeded) ||
+		errors.Is(err, msgpack.ErrDataSizeTooLarge) ||
+		errors.Is(err, msgpack.ErrMaxDepthExceeded) ||
+		errors.As(err, &convertErr) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) {
+		return true
+	}
+	var errno syscall.Errno
+	var netErr net.Error
+	return errors.As(err, &errno) || errors.As(err, &netErr)
+}
+
 // Dial dials an Nvim instance given an address in the format used by
-// $NVIM_LISTEN_ADDRESS.
+// $NVIM_LISTEN_ADDRESS. Addresses containing a colon are dialed with TCP and
+// other addresses are dialed as Unix domain sockets. On Windows, addresses
+// starting with \\.\pipe\ are dialed as named pipes.
 //
 //  :help rpc-connecting
 //  :help $NVIM_LISTEN_ADDRESS
 func Dial(address string, options ...DialOption) (*Nvim, error) {
-	var d net.Dialer
 	dos := &dialOptions{
-		ctx:     context.Background(),
-		logf:    log.Printf,
-		netDial: d.DialContext,
-		serve:   true,
+		endpointConfig: endpointConfig{logf: log.Printf},
+		ctx:            context.Background(),
+		netDial:        dialNet,
+		maxRetries:     defaultReconnectRetries,
+		backoff:        defaultReconnectBackoff,
+		reconnectIf:    isReconnectable,
+		serve:          true,
 	}
 
 	for _, do := range options {
@@ -336,7 +860,10 @@ func Dial(address string, options ...DialOption) (*Nvim, error) {
 	}
 
 	network := "unix"
-	if strings.Contains(address, ":") {
+	switch {
+	case dialPipe != nil && strings.HasPrefix(address, namedPipePrefix):
+		network = "pipe"
+	case strings.Contains(address, ":"):
 		network = "tcp"
 	}
 
@@ -345,18 +872,177 @@ func Dial(address string, options ...DialOption) (*Nvim, error) {
 		return nil, err
 	}
 
-	v, err := New(c, c, c, dos.logf)
+	v, err := newNvim(c, c, c, dos.endpointConfig)
 	if err != nil {
 		c.Close()
 		return nil, err
 	}
 
+	if dos.reconnect {
+		ctx, cancel := context.WithCancel(context.Background())
+		v.reconnect = &reconnector{
+			ctx:    ctx,
+			cancel: cancel,
+			dial: func(ctx context.Context) (net.Conn, error) {
+				return dos.netDial(ctx, network, address)
+			},
+			config:      dos.endpointConfig,
+			maxRetries:  dos.maxRetries,
+			backoff:     dos.backoff,
+			reconnectIf: dos.reconnectIf,
+			onReconnect: dos.onReconnect,
+		}
+	}
+
 	if dos.serve {
 		v.startServe()
 	}
 	return v, err
 }
 
+// reconnector reconnects a client created by Dial with the DialReconnect or
+// DialReconnectIf option.
+type reconnector struct {
+	// ctx is canceled when the client is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	dial        func(ctx context.Context) (net.Conn, error)
+	config      endpointConfig
+	maxRetries  int
+	backoff     func(attempt int) time.Duration
+	reconnectIf func(error) bool
+	onReconnect func(v *Nvim) error
+}
+
+// wait waits before the reconnect attempt. It returns false if the client is
+// closed.
+func (r *reconnector) wait(attempt int) bool {
+	if r.ctx.Err() != nil {
+		return false
+	}
+	var d time.Duration
+	if r.backoff != nil {
+		d = r.backoff(attempt)
+	}
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
+
+// serveReconnect serves the connection and reconnects when it fails.
+func (v *Nvim) serveReconnect() error {
+	r := v.reconnect
+	errc := serveEndpoint(v.endpoint())
+	for {
+		err := <-errc
+		if v.isClosed() {
+			return err
+		}
+		cause := err
+		if !r.reconnectIf(cause) {
+			return err
+		}
+
+		for attempt := 1; ; attempt++ {
+			if r.maxRetries >= 0 && attempt > r.maxRetries {
+				return fmt.Errorf("nvim: reconnect failed after %d attempts: %w", r.maxRetries, cause)
+			}
+			if !r.wait(attempt) {
+				return nil
+			}
+			r.config.infof("nvim: reconnecting after %v, attempt %d", cause, attempt)
+
+			ep, err := v.connect()
+			if err != nil {
+				cause = err
+				continue
+			}
+			errc = serveEndpoint(ep)
+			if r.onReconnect != nil {
+				if err := r.onReconnect(v); err != nil {
+					ep.Close()
+					<-errc
+					cause = err
+					continue
+				}
+			}
+			break
+		}
+	}
+}
+
+// serveEndpoint runs ep.Serve in a goroutine and returns a channel that
+// receives the result.
+func serveEndpoint(ep *rpc.Endpoint) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ep.Serve()
+	}()
+	return errc
+}
+
+// connect dials a new connection, registers the handlers on it and makes it
+// the current connection.
+func (v *Nvim) connect() (*rpc.Endpoint, error) {
+	r := v.reconnect
+	c, err := r.dial(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := rpc.NewEndpoint(c, c, c, r.config.options()...)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	v.epMu.Lock()
+	if v.closed {
+		v.epMu.Unlock()
+		ep.Close()
+		return nil, rpc.ErrClosed
+	}
+	for _, f := range v.registrations {
+		if err := f(ep); err != nil {
+			v.epMu.Unlock()
+			ep.Close()
+			return nil, err
+		}
+	}
+	for id, fn := range v.extensions {
+		if err := ep.RegisterExtension(id, fn); err != nil {
+			v.epMu.Unlock()
+			ep.Close()
+			return nil, err
+		}
+	}
+	v.ep = ep
+	v.generation++
+	v.epMu.Unlock()
+
+	// The new connection is a new channel in Nvim.
+	v.channelIDMu.Lock()
+	v.channelID = 0
+	v.channelIDMu.Unlock()
+
+	return ep, nil
+}
+
+// isClosed reports whether the client was closed.
+func (v *Nvim) isClosed() bool {
+	v.epMu.RLock()
+	defer v.epMu.RUnlock()
+	return v.closed
+}
+
 // RegisterHandler registers fn as a MessagePack RPC handler for the named
 // method. The function signature for fn is one of
 //
@@ -371,42 +1057,194 @@ func Dial(address string, options ...DialOption) (*Nvim, error) {
 //  :help rpcrequest()
 //  :help rpcnotify()
 //
+// RegisterHandler returns an error naming the method and the signature if fn
+// does not have one of these signatures.
+//
 // Plugin applications should use the Handler* methods in the ./plugin package
 // to register handlers instead of this method.
 func (v *Nvim) RegisterHandler(method string, fn interface{}) error {
 	var args []interface{}
 	t := reflect.TypeOf(fn)
-	if t.Kind() == reflect.Func && t.NumIn() > 0 && t.In(0) == reflect.TypeOf(v) {
+	if t != nil && t.Kind() == reflect.Func && t.NumIn() > 0 && t.In(0) == reflect.TypeOf(v) {
 		args = append(args, v)
 	}
-	return v.ep.Register(method, fn, args...)
+	return v.register(method, func(ep *rpc.Endpoint) error {
+		return ep.Register(method, fn, args...)
+	})
 }
 
-// ChannelID returns Nvim's channel id for this client.
+// SubscribeHandler registers fn as the handler for the event and subscribes to
+// the event with Subscribe, so that Nvim sends the event when it is broadcast
+// with rpcnotify() on channel 0. The function signature for fn is the same as
+// for RegisterHandler. If the subscription fails, the handler is removed.
+//
+// Subscriptions are counted by event. Calling SubscribeHandler again for the
+// same event replaces the handler but does not subscribe again. The returned
+// unsubscribe function releases the subscription; the last one to be released
+// unsubscribes from the event and removes the handler. Calls to unsubscribe
+// after the first one do nothing.
+//
+//	:help rpcnotify()
+func (v *Nvim) SubscribeHandler(event string, fn interface{}) (unsubscribe func() error, err error) {
+	v.subscriptionsMu.Lock()
+	defer v.subscriptionsMu.Unlock()
+
+	if err := v.RegisterHandler(event, fn); err != nil {
+		return nil, err
+	}
+	if v.subscriptions[event] == 0 {
+		if err := v.Subscribe(event); err != nil {
+			v.unregister(event)
+			return nil, err
+		}
+	}
+	if v.subscriptions == nil {
+		v.subscriptions = make(map[string]int)
+	}
+	v.subscriptions[event]++
+
+	var once sync.Once
+	return func() error {
+		var err error
+		once.Do(func() {
+			err = v.releaseSubscription(event)
+		})
+		return err
+	}, nil
+}
+
+// releaseSubscription releases a subscription to event made with
+// SubscribeHandler. It unsubscribes from the event and removes the handler
+// when no subscriptions remain.
+func (v *Nvim) releaseSubscription(event string) error {
+	v.subscriptionsMu.Lock()
+	defer v.subscriptionsMu.Unlock()
+
+	v.subscriptions[event]--
+	if v.subscriptions[event] > 0 {
+		return nil
+	}
+	delete(v.subscriptions, event)
+	err := v.Unsubscribe(event)
+	v.unregister(event)
+	return err
+}
+
+// RegisterExtension registers fn to convert the MessagePack extension with
+// type id received from Nvim to a Go value. Use it to exchange
+// application-specific types with a cooperating Lua side. The Go value is
+// returned when decoding to an interface{} value. Types implementing
+// msgpack.Marshaler and msgpack.Unmarshaler do not need a registration.
+//
+// RegisterExtension returns an error if id is the type of a built-in handle
+// (Buffer, Window or Tabpage) or is already registered.
+func (v *Nvim) RegisterExtension(id int, fn func([]byte) (interface{}, error)) error {
+	v.epMu.Lock()
+	defer v.epMu.Unlock()
+	if err := v.ep.RegisterExtension(id, fn); err != nil {
+		return err
+	}
+	if v.extensions == nil {
+		v.extensions = make(msgpack.ExtensionMap)
+	}
+	v.extensions[id] = fn
+	return nil
+}
+
+// OnError registers fn as the handler for the nvim_error_event notification.
+//
+// Nvim sends the notification when it fails to execute a notification from
+// this client, because the client cannot receive the error in a response.
+// The errType arg is 0 for an exception and 1 for a validation error.
+//
+// Calling OnError again replaces the previous handler.
+func (v *Nvim) OnError(fn func(errType int, msg string)) error {
+	return v.RegisterHandler("nvim_error_event", fn)
+}
+
+// Stats returns a snapshot of the RPC endpoint counters. The byte counts are
+// only tracked for clients created with the ChildProcessByteCounters or
+// DialByteCounters option.
+func (v *Nvim) Stats() rpc.Stats {
+	return v.endpoint().Stats()
+}
+
+// ChannelID returns Nvim's channel id for this client. ChannelID returns 0 if
+// the channel id cannot be requested from Nvim. Use ChannelIDErr to get the
+// error.
 func (v *Nvim) ChannelID() int {
+	id, _ := v.ChannelIDErr()
+	return id
+}
+
+// ChannelIDErr returns Nvim's channel id for this client. The channel id is
+// requested from Nvim on the first call, unless it was set with SetChannelID.
+func (v *Nvim) ChannelIDErr() (int, error) {
 	v.channelIDMu.Lock()
 	defer v.channelIDMu.Unlock()
 	if v.channelID != 0 {
-		return v.channelID
+		return v.channelID, nil
 	}
 	var info struct {
 		ChannelID int         `msgpack:",array"`
 		Info      interface{} `msgpack:"-"`
 	}
-	if err := v.ep.Call("nvim_get_api_info", &info); err != nil {
-		// TODO: log error and exit process?
+	if err := v.call("nvim_get_api_info", &info); err != nil {
+		return 0, err
 	}
 	v.channelID = info.ChannelID
-	return v.channelID
+	return v.channelID, nil
+}
+
+// SetChannelID sets the channel id returned by ChannelID and ChannelIDErr, so
+// the channel id is not requested from Nvim. Use it when the channel id is
+// already known, for example from the handshake of a plugin host. The channel
+// id is reset when the client reconnects, see DialReconnect.
+func (v *Nvim) SetChannelID(id int) {
+	v.channelIDMu.Lock()
+	v.channelID = id
+	v.channelIDMu.Unlock()
+}
+
+// APISchema returns the API metadata of the connected Nvim decoded to an
+// APISchema. Use APIInfo to get the metadata as generic values.
+func (v *Nvim) APISchema() (*APISchema, error) {
+	var info struct {
+		ChannelID int `msgpack:",array"`
+		Schema    *APISchema
+	}
+	if err := v.call("nvim_get_api_info", &info); err != nil {
+		return nil, err
+	}
+	return info.Schema, nil
 }
 
 func (v *Nvim) call(sm string, result interface{}, args ...interface{}) error {
-	return fixError(sm, v.ep.Call(sm, result, args...))
+	return fixError(sm, v.endpoint().Call(sm, result, args...))
+}
+
+func (v *Nvim) callContext(ctx context.Context, sm string, result interface{}, args ...interface{}) error {
+	return fixError(sm, v.endpoint().CallContext(ctx, sm, result, args...))
+}
+
+// SendNotification sends a notification for the named API method with args to
+// Nvim. SendNotification returns when the message is written, without
+// waiting for Nvim to execute the method. Use it for frequent calls where the
+// result is not needed.
+//
+// Nvim executes messages from a client in order, so the notification is
+// executed before the calls sent after it. Errors from executing the method
+// are reported with the nvim_error_event notification, see OnError. The
+// returned error is an error writing the message.
+//
+// SendNotification is safe to call concurrently with other methods.
+func (v *Nvim) SendNotification(method string, args ...interface{}) error {
+	return v.endpoint().Notify(method, args...)
 }
 
 // NewBatch creates a new batch.
 func (v *Nvim) NewBatch() *Batch {
-	b := &Batch{ep: v.ep}
+	b := &Batch{v: v}
 	b.enc = msgpack.NewEncoder(&b.buf)
 	return b
 }
@@ -423,28 +1261,41 @@ func (v *Nvim) NewBatch() *Batch {
 // API function call fails, all results proceeding the call are set and a
 // *BatchError is returned.
 //
-// A Batch does not support concurrent calls by the application.
+// A batch can mix any API function calls, for example ExecLua, Call and
+// Command. The calls are executed in the order they are added to the batch.
+//
+// A Batch does not support concurrent calls by the application. Different
+// batches, including batches created from the same Nvim, are independent and
+// can be built and executed concurrently from different goroutines. Each call
+// to Execute is sent as a single atomic request, so the calls in two batches
+// are never interleaved.
+//
+// A batch can be reused after Execute or Reset. The batch keeps the capacity
+// of its buffers, so adding the same calls to a reused batch does not
+// allocate memory for the batch itself, although converting the arguments of
+// a call to interface values may allocate. A batch may be reused from another
+// goroutine if the goroutines synchronize the handover.
 type Batch struct {
 	err     error
-	ep      *rpc.Endpoint
+	v       *Nvim
 	enc     *msgpack.Encoder
 	sms     []string
 	results []interface{}
 	buf     bytes.Buffer
 }
 
-// Execute executes the API function calls in the batch.
+// Execute executes the API function calls in the batch and resets the batch
+// for reuse. Execute returns nil without sending a request to Nvim if the
+// batch is empty.
 func (b *Batch) Execute() error {
-	defer func() {
-		b.buf.Reset()
-		b.sms = b.sms[:0]
-		b.results = b.results[:0]
-		b.err = nil
-	}()
+	defer b.Reset()
 
 	if b.err != nil {
 		return b.err
 	}
+	if len(b.sms) == 0 {
+		return nil
+	}
 
 	result := struct {
 		Results []interface{} `msgpack:",array"`
@@ -458,7 +1309,7 @@ func (b *Batch) Execute() error {
 		nil,
 	}
 
-	err := b.ep.Call("nvim_call_atomic", &result, &batchArg{n: len(b.sms), p: b.buf.Bytes()})
+	err := b.v.endpoint().Call("nvim_call_atomic", &result, &batchArg{n: len(b.sms), p: b.buf.Bytes()})
 	if err != nil {
 		return err
 	}
@@ -472,31 +1323,45 @@ func (b *Batch) Execute() error {
 		(e.Type != exceptionError && e.Type != validationError) {
 		return fmt.Errorf("nvim:nvim_call_atomic %d %d %s", e.Index, e.Type, e.Message)
 	}
-	errorType := "exception"
-	if e.Type == validationError {
-		errorType = "validation"
-	}
 	return &BatchError{
 		Index: e.Index,
-		Err:   fmt.Errorf("nvim:%s %s: %s", b.sms[e.Index], errorType, e.Message),
+		Err: &APIError{
+			Method:  b.sms[e.Index],
+			Kind:    ErrorKind(e.Type),
+			Message: e.Message,
+		},
 	}
 }
 
-// emptyArgs represents a empty interface slice which use to empty args.
-var emptyArgs = []interface{}{}
+// Reset discards the API function calls in the batch and the error from
+// adding them, if any, so the batch can be reused. Reset keeps the capacity of
+// the buffers in the batch.
+func (b *Batch) Reset() {
+	b.buf.Reset()
+	b.sms = b.sms[:0]
+	for i := range b.results {
+		b.results[i] = nil
+	}
+	b.results = b.results[:0]
+	b.err = nil
+}
 
 func (b *Batch) call(sm string, result interface{}, args ...interface{}) {
 	if b.err != nil {
 		return
 	}
-	if args == nil {
-		args = emptyArgs
-	}
 	b.sms = append(b.sms, sm)
 	b.results = append(b.results, result)
 	b.enc.PackArrayLen(2)
 	b.enc.PackString(sm)
-	b.err = b.enc.Encode(args)
+	// Encode the arguments one by one to avoid converting args to an
+	// interface value, which allocates.
+	b.enc.PackArrayLen(int64(len(args)))
+	for _, arg := range args {
+		if b.err = b.enc.Encode(arg); b.err != nil {
+			return
+		}
+	}
 }
 
 // batchArg represents a batch call arguments.
@@ -514,6 +1379,76 @@ func (a *batchArg) MarshalMsgPack(enc *msgpack.Encoder) error {
 	return enc.PackRaw(a.p)
 }
 
+// Pipeline creates a new pipeline.
+func (v *Nvim) Pipeline() *Pipeline {
+	return &Pipeline{v: v}
+}
+
+// Pipeline sends API function calls as separate requests without waiting for
+// the results, to hide the latency of the connection when making many
+// independent calls.
+//
+// Each call is sent to Nvim when it is added to the pipeline. Call the Wait
+// method to wait for the results. Result parameters are set when Wait
+// returns.
+//
+// Unlike a Batch, a pipeline is not atomic. Nvim executes the calls one at a
+// time in the order they are added, but requests from other clients, redraws
+// and user input may be processed between two calls. The calls are
+// independent: a failed call does not stop the calls after it, and Wait
+// returns the error of each call separately.
+//
+// A Pipeline does not support concurrent calls by the application. Different
+// pipelines, including pipelines created from the same Nvim, are independent
+// and can be used concurrently from different goroutines, but the order of
+// calls in different pipelines is not defined.
+type Pipeline struct {
+	v     *Nvim
+	calls []*rpc.Call
+}
+
+// Request sends a call to the API method procedure with args. The result is
+// decoded to result when the response arrives.
+func (p *Pipeline) Request(procedure string, result interface{}, args ...interface{}) {
+	p.calls = append(p.calls, p.v.endpoint().Go(procedure, nil, result, args...))
+}
+
+// Call calls the VimL function fname with args. See Nvim.Call.
+func (p *Pipeline) Call(fname string, result interface{}, args ...interface{}) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	p.Request("nvim_call_function", result, fname, args)
+}
+
+// ExecLua executes Lua code with args. See Nvim.ExecLua.
+func (p *Pipeline) ExecLua(code string, result interface{}, args ...interface{}) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	p.Request("nvim_exec_lua", result, code, args)
+}
+
+// Len returns the number of calls that Wait waits for.
+func (p *Pipeline) Len() int {
+	return len(p.calls)
+}
+
+// Wait waits for the results of the calls in the pipeline and resets the
+// pipeline for reuse. Wait returns the errors of the calls in the order the
+// calls were added, with a nil error for each call that succeeded. The errors
+// are reported like the errors of the API methods of Nvim.
+func (p *Pipeline) Wait() []error {
+	errs := make([]error, len(p.calls))
+	for i, c := range p.calls {
+		<-c.Done
+		errs[i] = fixError(c.Method, c.Err)
+		p.calls[i] = nil
+	}
+	p.calls = p.calls[:0]
+	return errs
+}
+
 // BatchError represents an error from a API function call in a Batch.
 type BatchError struct {
 	// Err is the error.
@@ -529,15 +1464,72 @@ func (e *BatchError) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap returns the error of the function call.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind is the kind of an error returned by an API function.
+type ErrorKind int
+
+// list of ErrorKinds.
+const (
+	// ExceptionError is an error raised while executing the function, for
+	// example an error from an Ex command or a Lua error.
+	ExceptionError ErrorKind = exceptionError
+
+	// ValidationError is an error in the arguments of the function.
+	ValidationError ErrorKind = validationError
+)
+
+// String returns a string representation of the ErrorKind.
+func (k ErrorKind) String() string {
+	switch k {
+	case ExceptionError:
+		return "exception"
+	case ValidationError:
+		return "validation"
+	default:
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+}
+
+// APIError represents an error returned by an API function. Use errors.As to
+// get the kind of the error:
+//
+//	var apiErr *nvim.APIError
+//	if errors.As(err, &apiErr) && apiErr.Kind == nvim.ValidationError {
+//		...
+//	}
+type APIError struct {
+	// Method is the name of the API function, such as "nvim_buf_get_lines".
+	Method string
+
+	// Kind is the kind of the error.
+	Kind ErrorKind
+
+	// Message is the error message from Nvim.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("nvim:%s %s: %s", e.Method, e.Kind, e.Message)
+}
+
 func fixError(sm string, err error) error {
 	if e, ok := err.(rpc.Error); ok {
 		if a, ok := e.Value.([]interface{}); ok && len(a) == 2 {
+			var kind ErrorKind
 			switch a[0] {
 			case int64(exceptionError), uint64(exceptionError):
-				return fmt.Errorf("nvim:%s exception: %v", sm, a[1])
+				kind = ExceptionError
 			case int64(validationError), uint64(validationError):
-				return fmt.Errorf("nvim:%s validation: %v", sm, a[1])
+				kind = ValidationError
+			default:
+				return err
 			}
+			return &APIError{Method: sm, Kind: kind, Message: fmt.Sprint(a[1])}
 		}
 	}
 	return err
@@ -556,7 +1548,25 @@ func (v *Nvim) Request(procedure string, result interface{}, args ...interface{}
 	return v.call(procedure, result, args...)
 }
 
+// RawCall calls the API method with args and decodes the result to result,
+// like the generated API methods. Use RawCall for API methods that do not
+// have a method in this package yet, for example methods added in a nightly
+// build of Nvim. Errors returned by Nvim are reported like the errors of the
+// generated methods.
+//
+// The args are sent as the argument array of the method without conversion.
+// A call without args sends an empty argument array, but a nil slice or map
+// passed as an argument is sent as nil. Pass an empty []interface{} or
+// map[string]interface{} for an empty Array or Dictionary argument.
+//
+// Use Batch.Request to call the method in a batch.
+func (v *Nvim) RawCall(method string, result interface{}, args ...interface{}) error {
+	return v.call(method, result, args...)
+}
+
 // Request makes a any RPC request atomically as a part of batch request.
+//
+// Use Request to add API methods without a Batch method to the batch.
 func (b *Batch) Request(procedure string, result interface{}, args ...interface{}) {
 	b.call(procedure, result, args...)
 }
@@ -577,6 +1587,16 @@ func (v *Nvim) Call(fname string, result interface{}, args ...interface{}) error
 	return v.call("nvim_call_function", result, fname, args)
 }
 
+// CallContext is like Call, but stops waiting for the result when ctx is done
+// and returns ctx.Err(). The function continues to run in Nvim and its result
+// is discarded.
+func (v *Nvim) CallContext(ctx context.Context, fname string, result interface{}, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	return v.callContext(ctx, "nvim_call_function", result, fname, args)
+}
+
 // Call calls a VimL function with the given arguments.
 //
 // On execution error: fails with VimL error, does not update v:errmsg.
@@ -609,6 +1629,15 @@ func (v *Nvim) CallDict(dict []interface{}, fname string, result interface{}, ar
 	return v.call("nvim_call_dict_function", result, fname, dict, args)
 }
 
+// CallDictContext is like CallDict, but stops waiting for the result when ctx
+// is done and returns ctx.Err(). See CallContext.
+func (v *Nvim) CallDictContext(ctx context.Context, dict []interface{}, fname string, result interface{}, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	return v.callContext(ctx, "nvim_call_dict_function", result, fname, dict, args)
+}
+
 // CallDict calls a VimL Dictionary function with the given arguments.
 //
 // The dict arg is Dictionary, or String evaluating to a VimL "self" dict.
@@ -639,6 +1668,15 @@ func (v *Nvim) ExecLua(code string, result interface{}, args ...interface{}) err
 	return v.call("nvim_exec_lua", result, code, args)
 }
 
+// ExecLuaContext is like ExecLua, but stops waiting for the result when ctx
+// is done and returns ctx.Err(). See CallContext.
+func (v *Nvim) ExecLuaContext(ctx context.Context, code string, result interface{}, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	return v.callContext(ctx, "nvim_exec_lua", result, code, args)
+}
+
 // ExecLua execute Lua code.
 //
 // The code arg is Lua code to execute.
@@ -655,22 +1693,22 @@ func (b *Batch) ExecLua(code string, result interface{}, args ...interface{}) {
 
 // ExecuteLua executes a Lua block.
 //
+// ExecuteLua calls ExecLua, which uses nvim_exec_lua instead of the deprecated
+// nvim_execute_lua.
+//
 // Deprecated: Use ExecLua() instead.
 func (v *Nvim) ExecuteLua(code string, result interface{}, args ...interface{}) error {
-	if args == nil {
-		args = []interface{}{}
-	}
-	return v.call("nvim_execute_lua", result, code, args)
+	return v.ExecLua(code, result, args...)
 }
 
 // ExecuteLua executes a Lua block.
 //
+// ExecuteLua calls ExecLua, which uses nvim_exec_lua instead of the deprecated
+// nvim_execute_lua.
+//
 // Deprecated: Use ExecLua() instead.
 func (b *Batch) ExecuteLua(code string, result interface{}, args ...interface{}) {
-	if args == nil {
-		args = []interface{}{}
-	}
-	b.call("nvim_execute_lua", result, code, args)
+	b.ExecLua(code, result, args...)
 }
 
 // Notify the user with a message.
@@ -2,19 +2,30 @@ package nvim
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/neovim/go-client/msgpack"
+	"github.com/neovim/go-client/msgpack/rpc"
 )
 
 func newChildProcess(tb testing.TB) (v *Nvim, cleanup func()) {
@@ -87,6 +98,7 @@ func TestAPI(t *testing.T) {
 
 	t.Run("BufAttach", testBufAttach(v))
 	t.Run("SimpleHandler", testSimpleHandler(v))
+	t.Run("OnError", testOnError(v))
 	t.Run("Buffer", testBuffer(v))
 	t.Run("Window", testWindow(v))
 	t.Run("Tabpage", testTabpage(v))
@@ -96,18 +108,51 @@ func TestAPI(t *testing.T) {
 	t.Run("StructValue", testStructValue(v))
 	t.Run("Eval", testEval(v))
 	t.Run("Batch", testBatch(v))
+	t.Run("ConcurrentBatches", testConcurrentBatches(v))
 	t.Run("CallWithNoArgs", testCallWithNoArgs(v))
 	t.Run("Mode", testMode(v))
+	t.Run("KeyMap", testKeyMap(v))
 	t.Run("ExecLua", testExecLua(v))
 	t.Run("Highlight", testHighlight(v))
 	t.Run("VirtualText", testVirtualText(v))
+	t.Run("Namespaces", testNamespaces(v))
 	t.Run("FloatingWindow", testFloatingWindow(v))
 	t.Run("Context", testContext(v))
 	t.Run("Extmarks", testExtmarks(v))
 	t.Run("RuntimeFiles", testRuntimeFiles(v))
 	t.Run("AllOptionsInfo", testAllOptionsInfo(v))
 	t.Run("OptionsInfo", testOptionsInfo(v))
+	t.Run("OptionWasSet", testOptionWasSet(v))
+	t.Run("WithOption", testWithOption(v))
+	t.Run("WaitForMode", testWaitForMode(v))
+	t.Run("VisualSelection", testVisualSelection(v))
+	t.Run("WordUnderCursor", testWordUnderCursor(v))
+	t.Run("MotionText", testMotionText(v))
+	t.Run("ShowFloatPreview", testShowFloatPreview(v))
+	t.Run("Digraphs", testDigraphs(v))
+	t.Run("ParseCmd", testParseCmd(v))
+	t.Run("LocalOption", testLocalOption(v))
+	t.Run("Autocmds", testAutocmds(v))
+	t.Run("ModifyLines", testModifyLines(v))
+	t.Run("EffectiveCwd", testEffectiveCwd(v))
+	t.Run("FilterBuffers", testFilterBuffers(v))
+	t.Run("Call", testCall(v))
+	t.Run("InspectPos", testInspectPos(v))
+	t.Run("Diagnostic", testDiagnostic(v))
+	t.Run("Snapshot", testSnapshot(v))
+	t.Run("CallContext", testCallContext(v))
+	t.Run("SendNotification", testSendNotification(v))
+	t.Run("Cmdline", testCmdline(v))
+	t.Run("JumpList", testJumpList(v))
+	t.Run("BufferSearch", testBufferSearch(v))
+	t.Run("SubscribeHandler", testSubscribeHandler(v))
+	t.Run("APISchema", testAPISchema(v))
+	t.Run("BatchHelpers", testBatchHelpers(v))
+	t.Run("InputAll", testInputAll(v))
+	t.Run("NormalizeKeys", testNormalizeKeys(v))
+	t.Run("Setup", testSetup(v))
 	t.Run("OpenTerm", testTerm(v))
+	t.Run("TerminalStream", testTerminalStream(v))
 }
 
 func testBufAttach(v *Nvim) func(*testing.T) {
@@ -260,6 +305,37 @@ func testSimpleHandler(v *Nvim) func(*testing.T) {
 	}
 }
 
+func testOnError(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		type errorEvent struct {
+			errType int
+			msg     string
+		}
+		errCh := make(chan errorEvent, 1)
+		if err := v.OnError(func(errType int, msg string) {
+			errCh <- errorEvent{errType: errType, msg: msg}
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := v.ep.Notify("nvim_command", "NotAnEditorCommand"); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case ev := <-errCh:
+			if ev.errType != exceptionError {
+				t.Fatalf("got %d error type but want %d", ev.errType, exceptionError)
+			}
+			if !strings.Contains(ev.msg, "E492") {
+				t.Fatalf("got %q error message but want E492 error", ev.msg)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for nvim_error_event")
+		}
+	}
+}
+
 func testBuffer(v *Nvim) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("Nvim", func(t *testing.T) {
@@ -1288,6 +1364,10 @@ func testBatch(v *Nvim) func(*testing.T) {
 		if e, ok := err.(*BatchError); !ok || e.Index != errorIndex {
 			t.Fatalf("unxpected error %T %v", e, e)
 		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Method != "nvim_get_var" {
+			t.Fatalf("got error %#v, want *APIError for nvim_get_var", err)
+		}
 		// Expect results proceeding error.
 		for i := 0; i < errorIndex; i++ {
 			if results[i] != i {
@@ -1318,6 +1398,53 @@ func testBatch(v *Nvim) func(*testing.T) {
 	}
 }
 
+func testConcurrentBatches(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		const (
+			numBatches = 8
+			numCalls   = 32
+		)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, numBatches)
+		for n := 0; n < numBatches; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+
+				// All batches set and read the same variable. The calls in a
+				// batch are atomic, so each batch must read its own values.
+				b := v.NewBatch()
+				results := make([]int, numCalls)
+				for i := range results {
+					b.SetVar("concurrent_batch", n*numCalls+i)
+					b.Var("concurrent_batch", &results[i])
+				}
+				if err := b.Execute(); err != nil {
+					errs <- err
+					return
+				}
+				for i, result := range results {
+					if want := n*numCalls + i; result != want {
+						errs <- fmt.Errorf("batch %d: results[%d] = %d, want %d", n, i, result, want)
+						return
+					}
+				}
+			}(n)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Error(err)
+		}
+
+		if err := v.DeleteVar("concurrent_batch"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func testCallWithNoArgs(v *Nvim) func(*testing.T) {
 	return func(t *testing.T) {
 		var wd string
@@ -1340,6 +1467,84 @@ func testMode(v *Nvim) func(*testing.T) {
 	}
 }
 
+func testKeyMap(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		const setup = `
+vim.keymap.set('n', '<F2>', ':echo "go-client"<CR>', {desc = 'rhs mapping', silent = true})
+vim.keymap.set('n', '<F3>', function() end, {desc = 'callback mapping'})
+`
+		if err := v.ExecLua(setup, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			for _, lhs := range []string{"<F2>", "<F3>"} {
+				if err := v.DeleteKeyMap("n", lhs); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}()
+
+		find := func(t *testing.T, maps []*Mapping, lhs string) *Mapping {
+			t.Helper()
+			for _, m := range maps {
+				if m.LHS == lhs {
+					return m
+				}
+			}
+			t.Fatalf("mapping %s not found", lhs)
+			return nil
+		}
+
+		check := func(t *testing.T, maps []*Mapping) {
+			t.Helper()
+
+			rhs := find(t, maps, "<F2>")
+			if rhs.Desc != "rhs mapping" {
+				t.Fatalf("got %q desc but want %q", rhs.Desc, "rhs mapping")
+			}
+			if rhs.Silent != 1 {
+				t.Fatalf("got %d silent but want 1", rhs.Silent)
+			}
+			if rhs.RHS == "" {
+				t.Fatalf("%s mapping has no rhs", rhs.LHS)
+			}
+			if rhs.ModeBits == 0 {
+				t.Fatalf("%s mapping has no mode bits", rhs.LHS)
+			}
+			if rhs.Mode != "n" {
+				t.Fatalf("got %q mode but want %q", rhs.Mode, "n")
+			}
+
+			callback := find(t, maps, "<F3>")
+			if callback.Desc != "callback mapping" {
+				t.Fatalf("got %q desc but want %q", callback.Desc, "callback mapping")
+			}
+			if callback.RHS != "" {
+				t.Fatalf("got %q rhs for %s callback mapping", callback.RHS, callback.LHS)
+			}
+		}
+
+		t.Run("Nvim", func(t *testing.T) {
+			maps, err := v.KeyMap("n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, maps)
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			b := v.NewBatch()
+
+			var maps []*Mapping
+			b.KeyMap("n", &maps)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			check(t, maps)
+		})
+	}
+}
+
 func testExecLua(v *Nvim) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("Nvim", func(t *testing.T) {
@@ -1369,6 +1574,39 @@ func testExecLua(v *Nvim) func(*testing.T) {
 				t.Fatalf("Mode() returned %v, want 3", n)
 			}
 		})
+
+		t.Run("NoArgs", func(t *testing.T) {
+			t.Parallel()
+
+			var n int
+			if err := v.ExecLua("return select('#', ...)", &n); err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 {
+				t.Fatalf("got %d args, want 0", n)
+			}
+		})
+
+		t.Run("ExecuteLua", func(t *testing.T) {
+			t.Parallel()
+
+			var n int
+			if err := v.ExecuteLua("local a, b = ... return a + b", &n, 1, 2); err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Fatalf("ExecuteLua() returned %v, want 3", n)
+			}
+
+			b := v.NewBatch()
+			b.ExecuteLua("return select('#', ...)", &n)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if n != 0 {
+				t.Fatalf("got %d args, want 0", n)
+			}
+		})
 	}
 }
 
@@ -1586,6 +1824,54 @@ func testHighlight(v *Nvim) func(*testing.T) {
 	}
 }
 
+func testNamespaces(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Nvim", func(t *testing.T) {
+			nsID, err := v.CreateNamespace("test_namespaces_nvim")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			namespaces, err := v.Namespaces()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := namespaces["test_namespaces_nvim"]; !ok || got != nsID {
+				t.Fatalf("got %d namespace id (found: %t) but want %d", got, ok, nsID)
+			}
+
+			anonID, err := v.CreateNamespace("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			namespaces, err = v.Namespaces()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, id := range namespaces {
+				if id == anonID {
+					t.Fatalf("anonymous namespace %d listed as %q", anonID, name)
+				}
+			}
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			b := v.NewBatch()
+
+			var nsID int
+			b.CreateNamespace("test_namespaces_batch", &nsID)
+			var namespaces map[string]int
+			b.Namespaces(&namespaces)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := namespaces["test_namespaces_batch"]; !ok || got != nsID {
+				t.Fatalf("got %d namespace id (found: %t) but want %d", got, ok, nsID)
+			}
+		})
+	}
+}
+
 func testVirtualText(v *Nvim) func(*testing.T) {
 	return func(t *testing.T) {
 		clearBuffer(t, v, Buffer(0)) // clear curret buffer text
@@ -1927,113 +2213,4408 @@ func testOptionsInfo(v *Nvim) func(*testing.T) {
 	}
 }
 
-// TODO(zchee): correct testcase
-func testTerm(v *Nvim) func(*testing.T) {
+func testOptionWasSet(v *Nvim) func(*testing.T) {
 	return func(t *testing.T) {
-		t.Run("Nvim", func(t *testing.T) {
-			t.Parallel()
+		wasSet, err := v.OptionWasSet("report", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wasSet {
+			t.Fatal("expected report option is not set")
+		}
 
-			buf, err := v.CreateBuffer(true, true)
-			if err != nil {
+		var report int
+		if err := v.Option("report", &report); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.SetOption("report", report+1); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.SetOption("report", report); err != nil {
 				t.Fatal(err)
 			}
+		}()
 
-			cfg := &WindowConfig{
-				Relative: "editor",
-				Width:    79,
-				Height:   31,
-				Row:      1,
-				Col:      1,
+		wasSet, err = v.OptionWasSet("report", GlobalScope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !wasSet {
+			t.Fatal("expected report option is set")
+		}
+
+		b := v.NewBatch()
+		var info OptionInfo
+		b.OptionInfo2("report", map[string]interface{}{"scope": GlobalScope}, &info)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !info.WasSet {
+			t.Fatal("expected report option is set")
+		}
+		if info.LastSetChan == 0 {
+			t.Fatal("expected report option is set from channel")
+		}
+	}
+}
+
+func testWithOption(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Global", func(t *testing.T) {
+			var want int
+			if err := v.OptionValue("report", map[string]interface{}{}, &want); err != nil {
+				t.Fatal(err)
 			}
-			if _, err := v.OpenWindow(buf, false, cfg); err != nil {
+
+			errFn := errors.New("fn error")
+			err := v.WithOption("report", want+10, func() error {
+				var got int
+				if err := v.OptionValue("report", map[string]interface{}{}, &got); err != nil {
+					t.Fatal(err)
+				}
+				if got != want+10 {
+					t.Fatalf("got %d report but want %d", got, want+10)
+				}
+				return errFn
+			})
+			if !errors.Is(err, errFn) {
+				t.Fatalf("got %v error but want %v", err, errFn)
+			}
+
+			var got int
+			if err := v.OptionValue("report", map[string]interface{}{}, &got); err != nil {
 				t.Fatal(err)
 			}
+			if got != want {
+				t.Fatalf("got %d report but want %d", got, want)
+			}
+		})
 
-			termID, err := v.OpenTerm(buf, make(map[string]interface{}))
-			if err != nil {
+		t.Run("Panic", func(t *testing.T) {
+			var want int
+			if err := v.OptionValue("report", map[string]interface{}{}, &want); err != nil {
 				t.Fatal(err)
 			}
 
-			data := "\x1b[38;2;00;00;255mTRUECOLOR\x1b[0m"
-			if err := v.Call("chansend", nil, termID, data); err != nil {
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Fatal("expected panic")
+					}
+				}()
+				v.WithOption("report", want+10, func() error {
+					panic("fn panic")
+				})
+			}()
+
+			var got int
+			if err := v.OptionValue("report", map[string]interface{}{}, &got); err != nil {
 				t.Fatal(err)
 			}
+			if got != want {
+				t.Fatalf("got %d report but want %d", got, want)
+			}
 		})
 
-		t.Run("Batch", func(t *testing.T) {
-			t.Parallel()
-
-			b := v.NewBatch()
+		t.Run("BufferLocal", func(t *testing.T) {
+			if err := v.SetOptionValue("shiftwidth", 3, map[string]interface{}{"scope": LocalScope}); err != nil {
+				t.Fatal(err)
+			}
 
-			var buf Buffer
-			b.CreateBuffer(true, true, &buf)
-			if err := b.Execute(); err != nil {
+			if err := v.WithOption("shiftwidth", 7, func() error { return nil }); err != nil {
 				t.Fatal(err)
 			}
 
-			cfg := &WindowConfig{
-				Relative: "editor",
-				Width:    79,
-				Height:   31,
-				Row:      1,
-				Col:      1,
+			var local int
+			if err := v.OptionValue("shiftwidth", map[string]interface{}{"scope": LocalScope}, &local); err != nil {
+				t.Fatal(err)
 			}
-			var win Window
-			b.OpenWindow(buf, false, cfg, &win)
+			if local != 3 {
+				t.Fatalf("got %d local shiftwidth but want 3", local)
+			}
+		})
 
-			var termID int
-			b.OpenTerm(buf, make(map[string]interface{}), &termID)
+		t.Run("GlobalLocalUnset", func(t *testing.T) {
+			var global int
+			if err := v.OptionValue("undolevels", map[string]interface{}{"scope": GlobalScope}, &global); err != nil {
+				t.Fatal(err)
+			}
 
-			if err := b.Execute(); err != nil {
+			if err := v.WithOption("undolevels", 10, func() error { return nil }); err != nil {
 				t.Fatal(err)
 			}
 
-			data := "\x1b[38;2;00;00;255mTRUECOLOR\x1b[0m"
-			b.Call("chansend", nil, termID, data)
+			var local int
+			if err := v.OptionValue("undolevels", map[string]interface{}{"scope": LocalScope}, &local); err != nil {
+				t.Fatal(err)
+			}
+			if local != -123456 {
+				t.Fatalf("got %d local undolevels but want unset", local)
+			}
 
-			if err := b.Execute(); err != nil {
+			var got int
+			if err := v.OptionValue("undolevels", map[string]interface{}{"scope": GlobalScope}, &got); err != nil {
 				t.Fatal(err)
 			}
+			if got != global {
+				t.Fatalf("got %d global undolevels but want %d", got, global)
+			}
 		})
 	}
 }
 
-func TestDial(t *testing.T) {
-	if runtime.GOOS == "windows" {
-		t.Skip("not supported dial unix socket on windows GOOS")
-	}
+func testWaitForMode(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Satisfied", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-	t.Parallel()
+			if err := v.WaitForMode(ctx, func(m Mode) bool { return m.Mode == "n" }); err != nil {
+				t.Fatal(err)
+			}
+		})
 
-	v1, cleanup := newChildProcess(t)
-	defer cleanup()
+		t.Run("Insert", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-	var addr string
-	if err := v1.Eval("$NVIM_LISTEN_ADDRESS", &addr); err != nil {
-		t.Fatal(err)
-	}
+			if err := v.FeedKeys("i", "n", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.WaitForMode(ctx, func(m Mode) bool { return m.Mode == "i" }); err != nil {
+				t.Fatal(err)
+			}
 
-	v2, err := Dial(addr, DialLogf(t.Logf))
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer v2.Close()
+			if err := v.FeedKeys("\x1b", "n", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.WaitForMode(ctx, func(m Mode) bool { return m.Mode == "n" }); err != nil {
+				t.Fatal(err)
+			}
+		})
 
-	if err := v2.SetVar("dial_test", "Hello"); err != nil {
-		t.Fatal(err)
-	}
+		t.Run("Timeout", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
 
-	var result string
-	if err := v1.Var("dial_test", &result); err != nil {
-		t.Fatal(err)
+			err := v.WaitForMode(ctx, func(Mode) bool { return false })
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got %v error but want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func testVisualSelection(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		clearBuffer(t, v, Buffer(0))
+
+		lines := [][]byte{
+			[]byte("hello world"),
+			[]byte("aäöb cd"),
+			[]byte("\tfoo bar"),
+		}
+		if err := v.SetBufferLines(Buffer(0), 0, -1, true, lines); err != nil {
+			t.Fatal(err)
+		}
+		defer clearBuffer(t, v, Buffer(0))
+
+		tests := map[string]struct {
+			keys      string
+			exclusive bool
+			want      Selection
+		}{
+			"Characterwise": {
+				keys: "gg0lvjl\x1b",
+				want: Selection{
+					Mode:  "v",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 5},
+					Lines: [][]byte{[]byte("ello world"), []byte("aäö")},
+				},
+			},
+			"CharacterwiseBackward": {
+				keys: "gg0jllvkh\x1b",
+				want: Selection{
+					Mode:  "v",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 5},
+					Lines: [][]byte{[]byte("ello world"), []byte("aäö")},
+				},
+			},
+			"CharacterwiseExclusive": {
+				keys:      "gg0lvlll\x1b",
+				exclusive: true,
+				want: Selection{
+					Mode:  "v",
+					Start: [2]int{1, 1},
+					End:   [2]int{1, 4},
+					Lines: [][]byte{[]byte("ell")},
+				},
+			},
+			"Linewise": {
+				keys: "gg0lVj\x1b",
+				want: Selection{
+					Mode:  "V",
+					Start: [2]int{1, 0},
+					End:   [2]int{2, 9},
+					Lines: [][]byte{[]byte("hello world"), []byte("aäöb cd")},
+				},
+			},
+			"Blockwise": {
+				keys: "gg0l\x16jl\x1b",
+				want: Selection{
+					Mode:  "\x16",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 5},
+					Lines: [][]byte{[]byte("el"), []byte("äö")},
+				},
+			},
+			"BlockwiseToEnd": {
+				keys: "gg0l\x16j$\x1b",
+				want: Selection{
+					Mode:  "\x16",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 9},
+					Lines: [][]byte{[]byte("ello world"), []byte("äöb cd")},
+				},
+			},
+			"BlockwiseToEndActive": {
+				keys: "gg0l\x16j$",
+				want: Selection{
+					Mode:  "\x16",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 9},
+					Lines: [][]byte{[]byte("ello world"), []byte("äöb cd")},
+				},
+			},
+			"Active": {
+				keys: "gg0wvl",
+				want: Selection{
+					Mode:  "v",
+					Start: [2]int{1, 6},
+					End:   [2]int{1, 8},
+					Lines: [][]byte{[]byte("wo")},
+				},
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				selection := "inclusive"
+				if tt.exclusive {
+					selection = "exclusive"
+				}
+				if err := v.SetOption("selection", selection); err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					if err := v.SetOption("selection", "inclusive"); err != nil {
+						t.Fatal(err)
+					}
+				}()
+
+				keys, err := v.ReplaceTermcodes(tt.keys, true, true, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := v.FeedKeys(keys, "nx", false); err != nil {
+					t.Fatal(err)
+				}
+				defer v.FeedKeys("\x1b", "nx", false)
+
+				got, err := v.VisualSelection()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(*got, tt.want) {
+					t.Fatalf("got %#v selection but want %#v", *got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func testWordUnderCursor(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		clearBuffer(t, v, Buffer(0))
+		defer clearBuffer(t, v, Buffer(0))
+
+		lines := [][]byte{
+			[]byte("foo héllo-wörld bar"),
+			[]byte("   "),
+		}
+		if err := v.SetBufferLines(Buffer(0), 0, -1, true, lines); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := map[string]struct {
+			pos     [2]int
+			word    string
+			bigWord string
+		}{
+			"Start": {
+				pos:     [2]int{1, 0},
+				word:    "foo",
+				bigWord: "foo",
+			},
+			"Multibyte": {
+				pos:     [2]int{1, 5},
+				word:    "héllo",
+				bigWord: "héllo-wörld",
+			},
+			"Punctuation": {
+				pos:     [2]int{1, 10},
+				word:    "wörld",
+				bigWord: "héllo-wörld",
+			},
+			"BeforeWord": {
+				pos:     [2]int{1, 3},
+				word:    "héllo",
+				bigWord: "héllo-wörld",
+			},
+			"NoWord": {
+				pos:     [2]int{2, 1},
+				word:    "",
+				bigWord: "",
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				if err := v.SetWindowCursor(Window(0), tt.pos); err != nil {
+					t.Fatal(err)
+				}
+
+				word, err := v.WordUnderCursor()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if word != tt.word {
+					t.Errorf("WordUnderCursor() = %q, want %q", word, tt.word)
+				}
+
+				bigWord, err := v.BigWordUnderCursor()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bigWord != tt.bigWord {
+					t.Errorf("BigWordUnderCursor() = %q, want %q", bigWord, tt.bigWord)
+				}
+			})
+		}
+	}
+}
+
+func testMotionText(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		clearBuffer(t, v, Buffer(0))
+		defer clearBuffer(t, v, Buffer(0))
+
+		lines := [][]byte{
+			[]byte("hello world"),
+			[]byte("aäöb cd"),
+		}
+		if err := v.SetBufferLines(Buffer(0), 0, -1, true, lines); err != nil {
+			t.Fatal(err)
+		}
+
+		const setup = `
+_G.go_client_motion_type = nil
+_G.go_client_opfunc = function(motion_type) _G.go_client_motion_type = motion_type end
+vim.o.operatorfunc = 'v:lua.go_client_opfunc'
+`
+		if err := v.ExecLua(setup, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.SetOption("operatorfunc", ""); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		tests := map[string]struct {
+			keys string
+			want Selection
+		}{
+			"Characterwise": {
+				keys: "gg0lg@e",
+				want: Selection{
+					Mode:  "v",
+					Start: [2]int{1, 1},
+					End:   [2]int{1, 5},
+					Lines: [][]byte{[]byte("ello")},
+				},
+			},
+			"Linewise": {
+				keys: "gg0lg@j",
+				want: Selection{
+					Mode:  "V",
+					Start: [2]int{1, 0},
+					End:   [2]int{2, 9},
+					Lines: [][]byte{[]byte("hello world"), []byte("aäöb cd")},
+				},
+			},
+			"Blockwise": {
+				keys: "gg0l\x16jlg@",
+				want: Selection{
+					Mode:  "\x16",
+					Start: [2]int{1, 1},
+					End:   [2]int{2, 5},
+					Lines: [][]byte{[]byte("el"), []byte("äö")},
+				},
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				if err := v.FeedKeys(tt.keys, "x", false); err != nil {
+					t.Fatal(err)
+				}
+
+				var motionType string
+				if err := v.ExecLua("return _G.go_client_motion_type", &motionType); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := v.MotionText(motionType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(*got, tt.want) {
+					t.Fatalf("got %#v motion text but want %#v", *got, tt.want)
+				}
+			})
+		}
+
+		if _, err := v.MotionText("word"); err == nil {
+			t.Fatal("MotionText returned no error for invalid motion type")
+		}
+	}
+}
+
+func testShowFloatPreview(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		lines := []string{"func Println(a ...interface{}) (n int, err error)", "", "Println formats using the default formats."}
+		window, buffer, err := v.ShowFloatPreview(lines, &FloatPreviewOptions{MaxWidth: 30, Filetype: "markdown"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.CloseWindow(window, true); err != nil {
+				t.Fatal(err)
+			}
+			valid, err := v.IsBufferValid(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid {
+				t.Fatal("expected preview buffer to be wiped out")
+			}
+		}()
+
+		var (
+			config   WindowConfig
+			got      [][]byte
+			filetype string
+			wrap     bool
+			number   bool
+		)
+		b := v.NewBatch()
+		b.WindowConfig(window, &config)
+		b.BufferLines(buffer, 0, -1, true, &got)
+		b.BufferOption(buffer, "filetype", &filetype)
+		b.WindowOption(window, "wrap", &wrap)
+		b.WindowOption(window, "number", &number)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+
+		if config.Relative == "" {
+			t.Fatal("expected floating window")
+		}
+		if config.Width != 30 || config.Height != len(lines) {
+			t.Fatalf("got %dx%d window but want %dx%d", config.Width, config.Height, 30, len(lines))
+		}
+		if joined := bytes.Join(got, []byte("\n")); string(joined) != strings.Join(lines, "\n") {
+			t.Fatalf("got %q lines but want %q", got, lines)
+		}
+		if filetype != "markdown" {
+			t.Fatalf("got %q filetype but want %q", filetype, "markdown")
+		}
+		if wrap || number {
+			t.Fatalf("got wrap=%v number=%v but want both off", wrap, number)
+		}
+	}
+}
+
+func testDigraphs(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		digraphs, err := v.Digraphs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsDigraph(digraphs, Digraph{Chars: "a:", Char: "ä"}) {
+			t.Fatal("expected default digraph a: in digraphs")
+		}
+
+		if err := v.SetDigraph("go", 0x1F439); err != nil {
+			t.Fatal(err)
+		}
+		digraphs, err = v.Digraphs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !containsDigraph(digraphs, Digraph{Chars: "go", Char: "\U0001F439"}) {
+			t.Fatal("expected digraph go in digraphs")
+		}
+
+		if err := v.SetDigraph("abc", 'x'); err == nil {
+			t.Fatal("expected error for three character digraph")
+		}
+		if err := v.SetDigraph("xy", -1); err == nil {
+			t.Fatal("expected error for invalid code point")
+		}
+
+		b := v.NewBatch()
+		b.SetDigraph("gb", 0x1F43B)
+		b.Digraphs(&digraphs)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if !containsDigraph(digraphs, Digraph{Chars: "gb", Char: "\U0001F43B"}) {
+			t.Fatal("expected digraph gb in digraphs")
+		}
+
+		b.SetDigraph("abc", 'x')
+		if err := b.Execute(); err == nil {
+			t.Fatal("expected error for three character digraph in batch")
+		}
+	}
+}
+
+func containsDigraph(digraphs []Digraph, digraph Digraph) bool {
+	for _, d := range digraphs {
+		if d == digraph {
+			return true
+		}
+	}
+	return false
+}
+
+func testParseCmd(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Nvim", func(t *testing.T) {
+			cmd, err := v.ParseCmd("2,4delete x", map[string]interface{}{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.Cmd != "delete" || !reflect.DeepEqual(cmd.Range, []int{2, 4}) || cmd.Reg != "x" || cmd.Addr != "line" {
+				t.Fatalf("got %+v for delete command", cmd)
+			}
+			if want := (CmdMods{Tab: -1, Verbose: -1}); !reflect.DeepEqual(cmd.Mods, want) {
+				t.Fatalf("got %+v mods but want %+v", cmd.Mods, want)
+			}
+
+			cmd, err = v.ParseCmd("silent! keepjumps 3tab vertical botright split! foo.txt | echo 'x'", map[string]interface{}{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cmd.Cmd != "split" || !cmd.Bang || !reflect.DeepEqual(cmd.Args, []string{"foo.txt"}) || cmd.NArgs != "?" {
+				t.Fatalf("got %+v for split command", cmd)
+			}
+			if cmd.NextCmd != "echo 'x'" {
+				t.Fatalf("got %q next command but want %q", cmd.NextCmd, "echo 'x'")
+			}
+			if !cmd.Magic.File || !cmd.Magic.Bar {
+				t.Fatalf("got %+v magic but want file and bar", cmd.Magic)
+			}
+			wantMods := CmdMods{
+				EmsgSilent: true,
+				KeepJumps:  true,
+				Tab:        3,
+				Verbose:    -1,
+				Vertical:   true,
+				Split:      "botright",
+			}
+			if !reflect.DeepEqual(cmd.Mods, wantMods) {
+				t.Fatalf("got %+v mods but want %+v", cmd.Mods, wantMods)
+			}
+
+			if _, err := v.ParseCmd("NotAnEditorCommand", map[string]interface{}{}); err == nil {
+				t.Fatal("expected error for unknown command")
+			}
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			b := v.NewBatch()
+
+			var cmd ParsedCmd
+			b.ParseCmd("filter! /pat/ 2verbose ls", map[string]interface{}{}, &cmd)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if cmd.Cmd != "ls" || cmd.Range != nil {
+				t.Fatalf("got %+v for ls command", cmd)
+			}
+			wantMods := CmdMods{
+				Filter:  CmdFilter{Pattern: "pat", Force: true},
+				Tab:     -1,
+				Verbose: 2,
+			}
+			if !reflect.DeepEqual(cmd.Mods, wantMods) {
+				t.Fatalf("got %+v mods but want %+v", cmd.Mods, wantMods)
+			}
+		})
+	}
+}
+
+func testLocalOption(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Buffer", func(t *testing.T) {
+			buffer, err := v.CreateBuffer(false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := v.DeleteBuffer(buffer, map[string]bool{"force": true}); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			if err := buffer.SetOption(v, "shiftwidth", 3); err != nil {
+				t.Fatal(err)
+			}
+
+			var shiftwidth int
+			if err := buffer.Option(v, "shiftwidth", &shiftwidth); err != nil {
+				t.Fatal(err)
+			}
+			if shiftwidth != 3 {
+				t.Fatalf("got %d shiftwidth but want %d", shiftwidth, 3)
+			}
+
+			var current int
+			if err := v.BufferOption(0, "shiftwidth", &current); err != nil {
+				t.Fatal(err)
+			}
+			if current == 3 {
+				t.Fatal("expected shiftwidth of current buffer to be unchanged")
+			}
+
+			if err := buffer.SetOption(v, "hlsearch", true); err == nil {
+				t.Fatal("expected error for global option")
+			}
+			if err := buffer.SetOption(v, "number", true); err == nil {
+				t.Fatal("expected error for window option")
+			}
+		})
+
+		t.Run("Window", func(t *testing.T) {
+			window, err := v.CurrentWindow()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := window.SetOption(v, "number", true); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := window.SetOption(v, "number", false); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			var number bool
+			if err := window.Option(v, "number", &number); err != nil {
+				t.Fatal(err)
+			}
+			if !number {
+				t.Fatal("expected number to be set")
+			}
+
+			var textwidth int
+			if err := window.Option(v, "textwidth", &textwidth); err == nil {
+				t.Fatal("expected error for buffer option")
+			}
+		})
+	}
+}
+
+func testAutocmds(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buffer, err := v.CreateBuffer(false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.DeleteBuffer(buffer, map[string]bool{"force": true}); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		const setup = `
+local buf = ...
+local group = vim.api.nvim_create_augroup('GoClientAutocmds', {clear = true})
+vim.api.nvim_create_autocmd('BufEnter', {group = group, buffer = buf, command = 'echo "enter"', desc = 'command'})
+vim.api.nvim_create_autocmd('BufLeave', {group = group, buffer = buf, callback = function() end, desc = 'lua'})
+vim.api.nvim_create_autocmd('BufWinEnter', {group = group, pattern = '*.go', command = 'echo "go"'})
+`
+		if err := v.ExecLua(setup, nil, buffer); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.Command("augroup GoClientAutocmds | autocmd! | augroup END | augroup! GoClientAutocmds"); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		t.Run("Nvim", func(t *testing.T) {
+			autocmds, err := v.BufferAutocmds(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(autocmds) != 2 {
+				t.Fatalf("got %d buffer-local autocmds but want 2: %+v", len(autocmds), autocmds)
+			}
+			for _, a := range autocmds {
+				if !a.BufLocal || a.Buffer != int(buffer) || a.GroupName != "GoClientAutocmds" {
+					t.Fatalf("got %+v but want buffer-local autocmd in group GoClientAutocmds", a)
+				}
+				switch a.Event {
+				case "BufEnter":
+					if a.HasCallback() || a.HasLuaCallback() || a.Command != `echo "enter"` {
+						t.Fatalf("got %+v but want command autocmd", a)
+					}
+				case "BufLeave":
+					if !a.HasCallback() || !a.HasLuaCallback() || a.Desc != "lua" {
+						t.Fatalf("got %+v but want Lua callback autocmd", a)
+					}
+				default:
+					t.Fatalf("unexpected autocmd %+v", a)
+				}
+			}
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			b := v.NewBatch()
+
+			var autocmds []*Autocmd
+			b.Autocmds(map[string]interface{}{"group": "GoClientAutocmds", "pattern": "*.go"}, &autocmds)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if len(autocmds) != 1 || autocmds[0].Event != "BufWinEnter" || autocmds[0].BufLocal {
+				t.Fatalf("got %+v but want one BufWinEnter autocmd", autocmds)
+			}
+		})
+	}
+}
+
+func testModifyLines(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buffer, err := v.CurrentBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		clearBuffer(t, v, buffer)
+		defer clearBuffer(t, v, buffer)
+
+		if err := v.SetBufferLines(buffer, 0, -1, true, [][]byte{[]byte("a"), []byte("b"), []byte("c")}); err != nil {
+			t.Fatal(err)
+		}
+
+		checkLines := func(t *testing.T, want ...string) {
+			t.Helper()
+			lines, err := v.BufferLines(buffer, 0, -1, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(bytes.Join(lines, []byte("\n"))); got != strings.Join(want, "\n") {
+				t.Fatalf("got %q lines but want %q", got, want)
+			}
+		}
+
+		t.Run("Modify", func(t *testing.T) {
+			err := buffer.ModifyLines(v, 1, -1, func(old []string) ([]string, error) {
+				if !reflect.DeepEqual(old, []string{"b", "c"}) {
+					t.Fatalf("got %q old lines but want %q", old, []string{"b", "c"})
+				}
+				return []string{"B", "C", "D"}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLines(t, "a", "B", "C", "D")
+		})
+
+		t.Run("Conflict", func(t *testing.T) {
+			err := buffer.ModifyLines(v, 0, 1, func(old []string) ([]string, error) {
+				// Change the buffer before ModifyLines sets the lines.
+				if err := v.SetBufferLines(buffer, -1, -1, true, [][]byte{[]byte("E")}); err != nil {
+					t.Fatal(err)
+				}
+				return []string{"X"}, nil
+			})
+			if !errors.Is(err, ErrConflict) {
+				t.Fatalf("got %v error but want ErrConflict", err)
+			}
+			checkLines(t, "a", "B", "C", "D", "E")
+		})
+
+		t.Run("ModifyError", func(t *testing.T) {
+			errModify := errors.New("modify failed")
+			err := buffer.ModifyLines(v, 0, 1, func(old []string) ([]string, error) {
+				return nil, errModify
+			})
+			if err != errModify {
+				t.Fatalf("got %v error but want %v", err, errModify)
+			}
+			checkLines(t, "a", "B", "C", "D", "E")
+		})
+	}
+}
+
+func testEffectiveCwd(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		var global string
+		if err := v.Call("getcwd", &global); err != nil {
+			t.Fatal(err)
+		}
+
+		tabDir, err := ioutil.TempDir("", "nvim-go-tcd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tabDir)
+		winDir, err := ioutil.TempDir("", "nvim-go-lcd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(winDir)
+
+		// Resolve symlinks like /tmp on macOS, because Nvim reports the
+		// resolved directory.
+		for _, dir := range []*string{&tabDir, &winDir} {
+			if *dir, err = filepath.EvalSymlinks(*dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := func(t *testing.T, window Window, wantDir string, wantScope CwdScope) {
+			t.Helper()
+			dir, scope, err := v.EffectiveCwd(window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dir != wantDir || scope != wantScope {
+				t.Fatalf("got (%q, %q) but want (%q, %q)", dir, scope, wantDir, wantScope)
+			}
+		}
+
+		if err := v.Command("tabnew"); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.Command("tabclose!"); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		first, err := v.CurrentWindow()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		check(t, first, global, GlobalCwdScope)
+
+		if err := v.Command("tcd " + tabDir); err != nil {
+			t.Fatal(err)
+		}
+		check(t, first, tabDir, TabpageCwdScope)
+
+		if err := v.Command("split | lcd " + winDir); err != nil {
+			t.Fatal(err)
+		}
+		second, err := v.CurrentWindow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, second, winDir, WindowCwdScope)
+		check(t, first, tabDir, TabpageCwdScope)
+
+		// Resolve the directory of a window that is not current.
+		if err := v.SetCurrentWindow(first); err != nil {
+			t.Fatal(err)
+		}
+		check(t, second, winDir, WindowCwdScope)
+	}
+}
+
+func testFilterBuffers(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		var listed, unlisted, unloaded Buffer
+		b := v.NewBatch()
+		b.CreateBuffer(true, false, &listed)
+		b.CreateBuffer(false, true, &unlisted)
+		b.CreateBuffer(true, false, &unloaded)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			for _, buf := range []Buffer{listed, unlisted, unloaded} {
+				if err := v.DeleteBuffer(buf, map[string]bool{"force": true}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}()
+
+		b = v.NewBatch()
+		b.SetBufferOption(listed, "filetype", "go")
+		b.SetBufferOption(unlisted, "filetype", "go")
+		b.Command(fmt.Sprintf("bunload %d", unloaded))
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+
+		contains := func(bufs []Buffer, buf Buffer) bool {
+			for _, b := range bufs {
+				if b == buf {
+					return true
+				}
+			}
+			return false
+		}
+
+		tests := map[string]struct {
+			filter BufferFilter
+			want   map[Buffer]bool
+		}{
+			"Listed": {
+				filter: v.ListedBuffer(),
+				want:   map[Buffer]bool{listed: true, unlisted: false, unloaded: true},
+			},
+			"Loaded": {
+				filter: v.LoadedBuffer(),
+				want:   map[Buffer]bool{listed: true, unlisted: true, unloaded: false},
+			},
+			"Filetype": {
+				filter: v.FiletypeBuffer("go"),
+				want:   map[Buffer]bool{listed: true, unlisted: true, unloaded: false},
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				bufs, err := v.SelectBuffers(tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for buf, want := range tt.want {
+					if got := contains(bufs, buf); got != want {
+						t.Fatalf("buffer %d selected is %v, want %v", buf, got, want)
+					}
+				}
+			})
+		}
+
+		t.Run("Reuse", func(t *testing.T) {
+			// A filter fetches the properties again for each call.
+			filter := v.LoadedBuffer()
+			if _, err := v.SelectBuffers(filter); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Command(fmt.Sprintf("bunload %d", listed)); err != nil {
+				t.Fatal(err)
+			}
+			bufs, err := v.SelectBuffers(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contains(bufs, listed) {
+				t.Fatalf("unloaded buffer %d is selected", listed)
+			}
+		})
+
+		t.Run("Predicate", func(t *testing.T) {
+			bufs, err := v.FilterBuffers(func(b Buffer) (bool, error) { return b == unlisted, nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bufs) != 1 || bufs[0] != unlisted {
+				t.Fatalf("got buffers %v but want [%d]", bufs, unlisted)
+			}
+		})
+
+		t.Run("Error", func(t *testing.T) {
+			errPred := errors.New("predicate error")
+			_, err := v.FilterBuffers(func(Buffer) (bool, error) { return false, errPred })
+			if !errors.Is(err, errPred) {
+				t.Fatalf("got error %v but want %v", err, errPred)
+			}
+		})
+	}
+}
+
+func testCall(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Window", func(t *testing.T) {
+			current, err := v.CurrentWindow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Command("split"); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := v.Command("close"); err != nil {
+					t.Fatal(err)
+				}
+			}()
+			other := current
+			if current, err = v.CurrentWindow(); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := other.Call(v, func() (interface{}, error) {
+				return v.CurrentWindow()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != other {
+				t.Fatalf("got current window %v in Call, want %v", result, other)
+			}
+
+			win, err := v.CurrentWindow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if win != current {
+				t.Fatalf("got current window %v after Call, want %v", win, current)
+			}
+		})
+
+		t.Run("Buffer", func(t *testing.T) {
+			buf, err := v.CreateBuffer(false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := v.DeleteBuffer(buf, map[string]bool{"force": true}); err != nil {
+					t.Fatal(err)
+				}
+			}()
+
+			result, err := buf.Call(v, func() (interface{}, error) {
+				// Run a command that acts on the current buffer.
+				if err := v.Command("call setline(1, 'hello')"); err != nil {
+					return nil, err
+				}
+				return v.CurrentBuffer()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != buf {
+				t.Fatalf("got current buffer %v in Call, want %v", result, buf)
+			}
+
+			lines, err := v.BufferLines(buf, 0, -1, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != 1 || string(lines[0]) != "hello" {
+				t.Fatalf("got lines %q, want [hello]", lines)
+			}
+		})
+
+		t.Run("Error", func(t *testing.T) {
+			errCall := errors.New("call error")
+			_, err := Window(0).Call(v, func() (interface{}, error) {
+				return nil, errCall
+			})
+			if !errors.Is(err, errCall) {
+				t.Fatalf("got error %v, want %v", err, errCall)
+			}
+		})
+
+		t.Run("Nested", func(t *testing.T) {
+			buf, err := v.CurrentBuffer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := Window(0).Call(v, func() (interface{}, error) {
+				return buf.Call(v, func() (interface{}, error) {
+					return "nested", nil
+				})
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != "nested" {
+				t.Fatalf("got %v, want nested", result)
+			}
+		})
+	}
+}
+
+func testInspectPos(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buf, err := v.CreateBuffer(false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.DeleteBuffer(buf, map[string]bool{"force": true}); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		if err := v.SetBufferLines(buf, 0, -1, true, [][]byte{[]byte("hello world")}); err != nil {
+			t.Fatal(err)
+		}
+
+		nsID, err := v.CreateNamespace("test_inspect_pos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hlID, err := v.SetBufferExtmark(buf, nsID, 0, 0, map[string]interface{}{
+			"end_col":  5,
+			"hl_group": "Search",
+			"priority": 200,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.SetBufferExtmark(buf, nsID, 0, 2, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("Default", func(t *testing.T) {
+			result, err := v.InspectPos(buf, 0, 2, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Row != 0 || result.Col != 2 {
+				t.Fatalf("got position (%d, %d), want (0, 2)", result.Row, result.Col)
+			}
+			if len(result.Extmarks) != 1 {
+				t.Fatalf("got %d extmarks, want 1", len(result.Extmarks))
+			}
+			want := &InspectExtmark{
+				ID:     hlID,
+				NSID:   nsID,
+				NS:     "test_inspect_pos",
+				Row:    0,
+				Col:    0,
+				EndRow: 0,
+				EndCol: 5,
+			}
+			got := result.Extmarks[0]
+			opts := got.Opts
+			got.Opts = InspectExtmarkOpts{}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got extmark %+v, want %+v", got, want)
+			}
+			if opts.HLGroup != "Search" || opts.HLGroupLink != "Search" || opts.Priority != 200 {
+				t.Fatalf("got extmark opts %+v, want hl_group Search with priority 200", opts)
+			}
+		})
+
+		t.Run("AllExtmarks", func(t *testing.T) {
+			result, err := v.InspectPos(buf, 0, 2, &InspectPosOptions{AllExtmarks: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Extmarks) != 2 {
+				t.Fatalf("got %d extmarks, want 2", len(result.Extmarks))
+			}
+		})
+
+		t.Run("Filter", func(t *testing.T) {
+			result, err := v.InspectPos(buf, 0, 2, &InspectPosOptions{Syntax: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Extmarks) != 0 {
+				t.Fatalf("got %d extmarks, want 0", len(result.Extmarks))
+			}
+		})
+	}
+}
+
+func testDiagnostic(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buf, err := v.CreateBuffer(false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.DeleteBuffer(buf, map[string]bool{"force": true}); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		if err := v.SetBufferLines(buf, 0, -1, true, [][]byte{[]byte("first"), []byte("second line")}); err != nil {
+			t.Fatal(err)
+		}
+		nsID, err := v.CreateNamespace("test_diagnostic")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		type details struct {
+			EndRow      int        `msgpack:"end_row"`
+			EndCol      int        `msgpack:"end_col"`
+			HLGroup     string     `msgpack:"hl_group"`
+			SignText    string     `msgpack:"sign_text"`
+			SignHLGroup string     `msgpack:"sign_hl_group"`
+			VirtText    [][]string `msgpack:"virt_text"`
+		}
+		extmark := func(t *testing.T, id int) (row, col int, d details) {
+			t.Helper()
+			var result struct {
+				Row     int `msgpack:",array"`
+				Col     int
+				Details details
+			}
+			if err := v.ExecLua("return vim.api.nvim_buf_get_extmark_by_id(...)", &result, buf, nsID, id, map[string]bool{"details": true}); err != nil {
+				t.Fatal(err)
+			}
+			return result.Row, result.Col, result.Details
+		}
+
+		t.Run("Default", func(t *testing.T) {
+			id, err := buf.SetDiagnostic(v, nsID, 1, DiagnosticWarn, "unused\nvariable", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			row, col, d := extmark(t, id)
+			if row != 1 || col != 0 {
+				t.Fatalf("got position (%d, %d), want (1, 0)", row, col)
+			}
+			want := details{
+				EndRow:      1,
+				EndCol:      len("second line"),
+				HLGroup:     "DiagnosticUnderlineWarn",
+				SignText:    "W ",
+				SignHLGroup: "DiagnosticSignWarn",
+				VirtText:    [][]string{{"■ unused variable", "DiagnosticVirtualTextWarn"}},
+			}
+			if !reflect.DeepEqual(d, want) {
+				t.Fatalf("got details %+v, want %+v", d, want)
+			}
+		})
+
+		t.Run("Options", func(t *testing.T) {
+			id, err := buf.SetDiagnostic(v, nsID, 0, DiagnosticError, "bad", &DiagnosticOptions{
+				Col:           1,
+				EndCol:        3,
+				NoVirtualText: true,
+				NoSign:        true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			row, col, d := extmark(t, id)
+			if row != 0 || col != 1 {
+				t.Fatalf("got position (%d, %d), want (0, 1)", row, col)
+			}
+			want := details{
+				EndRow:  0,
+				EndCol:  3,
+				HLGroup: "DiagnosticUnderlineError",
+			}
+			if !reflect.DeepEqual(d, want) {
+				t.Fatalf("got details %+v, want %+v", d, want)
+			}
+		})
+
+		t.Run("InvalidSeverity", func(t *testing.T) {
+			if _, err := buf.SetDiagnostic(v, nsID, 0, DiagnosticSeverity(0), "bad", nil); err == nil {
+				t.Fatal("expected error for invalid severity")
+			}
+		})
+
+		t.Run("Clear", func(t *testing.T) {
+			if err := buf.ClearDiagnostics(v, nsID); err != nil {
+				t.Fatal(err)
+			}
+			marks, err := v.BufferExtmarks(buf, nsID, 0, -1, map[string]interface{}{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(marks) != 0 {
+				t.Fatalf("got %d extmarks after ClearDiagnostics, want 0", len(marks))
+			}
+		})
+	}
+}
+
+func testSnapshot(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		first, err := v.CurrentWindow()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir, err := ioutil.TempDir("", "nvim-go-snapshot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		name := filepath.Join(dir, "snapshot.go")
+
+		if err := v.Command("new " + name); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.Command("bwipeout!"); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		win, err := v.CurrentWindow()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := v.CurrentBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cwd string
+		if err := v.Call("getcwd", &cwd); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := v.Snapshot(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Window != win || s.Buffer != buf {
+			t.Fatalf("got window %v and buffer %v, want %v and %v", s.Window, s.Buffer, win, buf)
+		}
+		if s.Mode.Mode != "n" || s.Cwd != cwd || s.Modified || s.Cursor != [2]int{1, 0} {
+			t.Fatalf("got snapshot %+v for the new buffer", s)
+		}
+		// Compare the base names, because the directory may contain symlinks.
+		if filepath.Base(s.Name) != "snapshot.go" {
+			t.Fatalf("got name %q, want %q", s.Name, name)
+		}
+		if s.Filetype != "go" && s.Filetype != "" {
+			t.Fatalf("got filetype %q", s.Filetype)
+		}
+
+		if err := v.SetBufferLines(buf, 0, -1, true, [][]byte{[]byte("package main"), []byte("")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.SetWindowCursor(win, [2]int{2, 0}); err != nil {
+			t.Fatal(err)
+		}
+		modified, err := v.Snapshot(&SnapshotOptions{Window: win})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !modified.Modified || modified.ChangedTick <= s.ChangedTick || modified.Cursor != [2]int{2, 0} {
+			t.Fatalf("got snapshot %+v after modifying the buffer", modified)
+		}
+
+		// Snapshot a window other than the current window.
+		other, err := v.Snapshot(&SnapshotOptions{Window: first})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Window != first || other.Buffer == buf {
+			t.Fatalf("got window %v and buffer %v, want %v and a buffer other than %v", other.Window, other.Buffer, first, buf)
+		}
+	}
+}
+
+func testCallContext(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Canceled", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			var result string
+			err := v.CallContext(ctx, "execute", &result, "sleep 200m")
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			// The client continues to work after the discarded response.
+			var n int
+			if err := v.Eval("1+2", &n); err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Fatalf("got %d, want 3", n)
+			}
+		})
+
+		t.Run("Completed", func(t *testing.T) {
+			ctx := context.Background()
+
+			var sum int
+			if err := v.CallContext(ctx, "eval", &sum, "1+2"); err != nil {
+				t.Fatal(err)
+			}
+			if sum != 3 {
+				t.Fatalf("got %d, want 3", sum)
+			}
+
+			var s string
+			if err := v.ExecLuaContext(ctx, "return ...", &s, "lua"); err != nil {
+				t.Fatal(err)
+			}
+			if s != "lua" {
+				t.Fatalf("got %q, want %q", s, "lua")
+			}
+		})
+	}
+}
+
+func testSendNotification(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		if err := v.SetVar("notified", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		const n = 50
+		var wg sync.WaitGroup
+		errc := make(chan error, 2*n)
+		for i := 0; i < n; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				errc <- v.SendNotification("nvim_command", "let g:notified += 1")
+			}()
+			go func() {
+				defer wg.Done()
+				var result int
+				errc <- v.Eval("1", &result)
+			}()
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The notifications are executed before the request sent after
+		// them.
+		var notified int
+		if err := v.Var("notified", &notified); err != nil {
+			t.Fatal(err)
+		}
+		if notified != n {
+			t.Fatalf("got %d notifications executed, want %d", notified, n)
+		}
+	}
+}
+
+func testCmdline(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		if err := v.CmdlineSetText("echo", -1); !errors.Is(err, ErrNoCmdline) {
+			t.Fatalf("got %v error but want %v", err, ErrNoCmdline)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := v.FeedKeys(":abc", "n", false); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.WaitForMode(ctx, func(m Mode) bool { return m.Mode == "c" }); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.FeedKeys("\x1b", "n", false); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.WaitForMode(ctx, func(m Mode) bool { return m.Mode == "n" }); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		state, err := v.CmdlineState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (CmdlineState{Type: ":", Text: "abc", Pos: 3}); *state != want {
+			t.Fatalf("got %+v state, want %+v", *state, want)
+		}
+
+		if err := v.CmdlineSetText("let g:x = 1", 4); err != nil {
+			t.Fatal(err)
+		}
+		state, err = v.CmdlineState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (CmdlineState{Type: ":", Text: "let g:x = 1", Pos: 4}); *state != want {
+			t.Fatalf("got %+v state, want %+v", *state, want)
+		}
+
+		if err := v.CmdlineSetPos(0); err != nil {
+			t.Fatal(err)
+		}
+		state, err = v.CmdlineState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Pos != 0 {
+			t.Fatalf("got %d position, want 0", state.Pos)
+		}
+	}
+}
+
+func testJumpList(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		if err := v.Command("enew"); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.Command("bwipeout!"); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		buf, err := v.CurrentBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("JumpList", func(t *testing.T) {
+			if err := v.Command("clearjumps"); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.SetBufferLines(buf, 0, -1, true, bytes.Fields([]byte("a b c d e"))); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Command("normal! 3GG"); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := v.JumpList(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Jumps) != 2 {
+				t.Fatalf("got %d jumps, want 2", len(list.Jumps))
+			}
+			if list.Current != len(list.Jumps) {
+				t.Fatalf("got current %d, want %d", list.Current, len(list.Jumps))
+			}
+			for i, want := range []int{1, 3} {
+				j := list.Jumps[i]
+				if j.Buffer != buf || j.LNum != want || j.Col != 0 {
+					t.Fatalf("got jump %d %+v, want line %d in %v", i, j, want, buf)
+				}
+			}
+		})
+
+		t.Run("ChangeList", func(t *testing.T) {
+			if err := v.Command("normal! 2GAx"); err != nil {
+				t.Fatal(err)
+			}
+			if err := v.Command("normal! 4GIy"); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := v.ChangeList(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Changes) < 2 {
+				t.Fatalf("got %d changes, want at least 2", len(list.Changes))
+			}
+			got := list.Changes[len(list.Changes)-2:]
+			want := []*Change{{LNum: 2, Col: 1}, {LNum: 4, Col: 0}}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got changes %+v %+v, want %+v %+v", got[0], got[1], want[0], want[1])
+			}
+			if list.Current != len(list.Changes) {
+				t.Fatalf("got current %d, want %d", list.Current, len(list.Changes))
+			}
+		})
+	}
+}
+
+func testBufferSearch(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		// The buffer is not displayed in a window.
+		buf, err := v.CreateBuffer(false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := v.DeleteBuffer(buf, map[string]bool{"force": true}); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		lines := [][]byte{
+			[]byte("foo bar"),
+			[]byte("baz foo"),
+			[]byte("föö foofoo"),
+		}
+		if err := v.SetBufferLines(buf, 0, -1, true, lines); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := map[string]struct {
+			pattern string
+			opts    *SearchOptions
+			want    []Match
+		}{
+			"All": {
+				pattern: "foo",
+				want: []Match{
+					{Start: [2]int{1, 0}, End: [2]int{1, 2}},
+					{Start: [2]int{2, 4}, End: [2]int{2, 6}},
+					{Start: [2]int{3, 6}, End: [2]int{3, 8}},
+					{Start: [2]int{3, 9}, End: [2]int{3, 11}},
+				},
+			},
+			"Multibyte": {
+				pattern: "ö",
+				want: []Match{
+					{Start: [2]int{3, 1}, End: [2]int{3, 1}},
+					{Start: [2]int{3, 3}, End: [2]int{3, 3}},
+				},
+			},
+			"Range": {
+				pattern: "foo",
+				opts:    &SearchOptions{StartLine: 2, EndLine: 2},
+				want: []Match{
+					{Start: [2]int{2, 4}, End: [2]int{2, 6}},
+				},
+			},
+			"MaxMatches": {
+				pattern: "ba",
+				opts:    &SearchOptions{MaxMatches: 1},
+				want: []Match{
+					{Start: [2]int{1, 4}, End: [2]int{1, 5}},
+				},
+			},
+			"NoMatch": {
+				pattern: "qux",
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				got, err := buf.Search(v, tt.pattern, tt.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("got %v matches, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func testSubscribeHandler(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		const event = "test_subscribe_handler"
+		received := make(chan int, 2)
+		unsubscribe, err := v.SubscribeHandler(event, func(n int) {
+			received <- n
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := v.Command("call rpcnotify(0, '" + event + "', 1)"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case n := <-received:
+			if n != 1 {
+				t.Fatalf("got %d, want 1", n)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+
+		if err := unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+		if err := unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The event is not sent after unsubscribing. The request after the
+		// broadcast ensures that a notification would have been received.
+		if err := v.Command("call rpcnotify(0, '" + event + "', 2)"); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := v.Eval("1", &n); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case n := <-received:
+			t.Fatalf("got event %d after unsubscribe", n)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func testAPISchema(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		schema, err := v.APISchema()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schema.Version.APILevel == 0 {
+			t.Fatalf("got zero API level in %+v", schema.Version)
+		}
+		fn := schema.Function("nvim_get_api_info")
+		if fn == nil {
+			t.Fatal("nvim_get_api_info not found")
+		}
+		if fn.ReturnType != "Array" {
+			t.Fatalf("got %q return type, want %q", fn.ReturnType, "Array")
+		}
+		if typ, ok := schema.Types["Buffer"]; !ok || typ.ID != 0 {
+			t.Fatalf("got Buffer type %+v", typ)
+		}
+	}
+}
+
+func testBatchHelpers(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buffer, err := v.CurrentBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.ExecLua(`vim.api.nvim_create_autocmd("BufEnter", {buffer = ..., command = "let g:batch_helpers = 1"})`, nil, buffer); err != nil {
+			t.Fatal(err)
+		}
+		defer v.Command("autocmd! BufEnter <buffer>")
+
+		var (
+			state      CmdlineState
+			expanded   string
+			normalized string
+			autocmds   []*Autocmd
+		)
+		b := v.NewBatch()
+		b.CmdlineState(&state)
+		b.TermcodesExpand("<C-a>", &expanded)
+		b.NormalizeKeys("<c-a>", &normalized)
+		b.BufferAutocmds(buffer, &autocmds)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+
+		if state.Type != "" {
+			t.Fatalf("got command line type %q, want empty", state.Type)
+		}
+		if expanded != "\x01" {
+			t.Fatalf("got expanded %q, want %q", expanded, "\x01")
+		}
+		if normalized != "<C-A>" {
+			t.Fatalf("got normalized %q, want %q", normalized, "<C-A>")
+		}
+		if len(autocmds) != 1 || autocmds[0].Event != "BufEnter" {
+			t.Fatalf("got autocmds %+v, want one BufEnter autocmd", autocmds)
+		}
+	}
+}
+
+func testInputAll(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		clearBuffer(t, v, Buffer(0))
+		defer clearBuffer(t, v, Buffer(0))
+
+		want := strings.Repeat("abcdefghij", 10000)
+		if err := v.InputAll("i" + want + "<Esc>"); err != nil {
+			t.Fatal(err)
+		}
+
+		// input is processed asynchronously, so poll until the buffer is filled.
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			lines, err := v.BufferLines(Buffer(0), 0, -1, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) == 1 && string(lines[0]) == want {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d lines, want one line with %d bytes", len(lines), len(want))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func testNormalizeKeys(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("TermcodesExpand", func(t *testing.T) {
+			got, err := v.TermcodesExpand("<C-a><lt>x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "\x01<x"; got != want {
+				t.Fatalf("got %q but want %q", got, want)
+			}
+		})
+
+		tests := map[string]struct {
+			keys []string
+			want string
+		}{
+			"Ctrl": {
+				keys: []string{"<c-a>", "<C-a>", "<C-A>", "<c-A>"},
+				want: "<C-A>",
+			},
+			"Space": {
+				keys: []string{" ", "<space>", "<Space>"},
+				want: "<Space>",
+			},
+			"Enter": {
+				keys: []string{"<cr>", "<CR>", "<Enter>", "<Return>"},
+				want: "<CR>",
+			},
+			"MetaLower": {
+				keys: []string{"<m-a>", "<M-a>", "<A-a>"},
+				want: "<M-a>",
+			},
+			"MetaUpper": {
+				keys: []string{"<M-A>", "<a-A>"},
+				want: "<M-A>",
+			},
+			"Leader": {
+				keys: []string{"<leader>ff", "\\ff"},
+				want: "\\ff",
+			},
+			"LessThan": {
+				keys: []string{"<lt>", "<LT>", "<"},
+				want: "<lt>",
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				for _, keys := range tt.keys {
+					got, err := v.NormalizeKeys(keys)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != tt.want {
+						t.Fatalf("NormalizeKeys(%q) = %q, want %q", keys, got, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func testSetup(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Batch", func(t *testing.T) {
+			b := v.NewBatch()
+			b.Command("let g:go_batch_mixed = 1")
+			b.ExecLua("vim.g.go_batch_mixed = vim.g.go_batch_mixed + ...", nil, 2)
+			var got int
+			b.Call("eval", &got, "g:go_batch_mixed * 10")
+			b.Command("unlet g:go_batch_mixed")
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if got != 30 {
+				t.Fatalf("got %d but want 30", got)
+			}
+		})
+
+		t.Run("Setup", func(t *testing.T) {
+			steps := []SetupStep{
+				{Command: "let g:go_setup = 1"},
+				{Lua: "vim.g.go_setup = vim.g.go_setup + ...", Args: []interface{}{2}},
+				{Command: "let g:go_setup = g:go_setup * 10"},
+			}
+			if err := v.Setup(steps); err != nil {
+				t.Fatal(err)
+			}
+			defer v.Command("unlet g:go_setup")
+
+			var got int
+			if err := v.Var("go_setup", &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != 30 {
+				t.Fatalf("got %d but want 30", got)
+			}
+		})
+
+		t.Run("Error", func(t *testing.T) {
+			steps := []SetupStep{
+				{Command: "let g:go_setup_error = 1"},
+				{Lua: "error('setup error')"},
+				{Command: "let g:go_setup_error = 2"},
+			}
+			err := v.Setup(steps)
+			var batchErr *BatchError
+			if !errors.As(err, &batchErr) {
+				t.Fatalf("got %v error but want *BatchError", err)
+			}
+			if batchErr.Index != 1 {
+				t.Fatalf("got %d error index but want 1", batchErr.Index)
+			}
+			defer v.Command("unlet g:go_setup_error")
+
+			var got int
+			if err := v.Var("go_setup_error", &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != 1 {
+				t.Fatalf("got %d but want 1", got)
+			}
+		})
+
+		t.Run("Invalid", func(t *testing.T) {
+			steps := []SetupStep{
+				{Lua: "return", Command: "echo"},
+			}
+			if err := v.Setup(steps); err == nil {
+				t.Fatal("expected error")
+			}
+			if err := v.Setup([]SetupStep{{}}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func testTerminalStream(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		buf, err := v.CreateBuffer(true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		channel, stream, err := v.OpenTermStream(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		t.Run("Write", func(t *testing.T) {
+			if _, err := io.WriteString(stream, "hello terminal"); err != nil {
+				t.Fatal(err)
+			}
+
+			// terminal output is processed asynchronously, so poll until the buffer is updated.
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				lines, err := v.BufferLines(buf, 0, 1, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(lines) > 0 && bytes.HasPrefix(lines[0], []byte("hello terminal")) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("terminal buffer does not contain written data: %q", lines)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+
+		t.Run("Read", func(t *testing.T) {
+			const code = "vim.rpcnotify(...)"
+			for _, data := range []string{"hello", " ", "world"} {
+				if err := v.ExecLua(code, nil, v.ChannelID(), TerminalStreamMethod(channel), data); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := make([]byte, len("hello world"))
+			if _, err := io.ReadFull(stream, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "hello world" {
+				t.Fatalf("got %q but want %q", got, "hello world")
+			}
+		})
+
+		t.Run("Close", func(t *testing.T) {
+			if err := stream.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("got %v error but want %v", err, io.EOF)
+			}
+			if _, err := stream.Write([]byte("x")); err != io.ErrClosedPipe {
+				t.Fatalf("got %v error but want %v", err, io.ErrClosedPipe)
+			}
+		})
+	}
+}
+
+// TODO(zchee): correct testcase
+func testTerm(v *Nvim) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("Nvim", func(t *testing.T) {
+			t.Parallel()
+
+			buf, err := v.CreateBuffer(true, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &WindowConfig{
+				Relative: "editor",
+				Width:    79,
+				Height:   31,
+				Row:      1,
+				Col:      1,
+			}
+			if _, err := v.OpenWindow(buf, false, cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			termID, err := v.OpenTerm(buf, make(map[string]interface{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := "\x1b[38;2;00;00;255mTRUECOLOR\x1b[0m"
+			if err := v.Call("chansend", nil, termID, data); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		t.Run("Batch", func(t *testing.T) {
+			t.Parallel()
+
+			b := v.NewBatch()
+
+			var buf Buffer
+			b.CreateBuffer(true, true, &buf)
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := &WindowConfig{
+				Relative: "editor",
+				Width:    79,
+				Height:   31,
+				Row:      1,
+				Col:      1,
+			}
+			var win Window
+			b.OpenWindow(buf, false, cfg, &win)
+
+			var termID int
+			b.OpenTerm(buf, make(map[string]interface{}), &termID)
+
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+
+			data := "\x1b[38;2;00;00;255mTRUECOLOR\x1b[0m"
+			b.Call("chansend", nil, termID, data)
+
+			if err := b.Execute(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUI(t *testing.T) {
+	v, cleanup := newChildProcess(t)
+	defer cleanup()
+
+	redrawCh := make(chan struct{}, 1)
+	if err := v.RegisterHandler("redraw", func(updates ...[]interface{}) {
+		select {
+		case redrawCh <- struct{}{}:
+		default:
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	options := map[string]interface{}{
+		"rgb":           true,
+		"ext_linegrid":  true,
+		"ext_popupmenu": true,
+	}
+	if err := v.AttachUI(80, 24, options); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := v.DetachUI(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	t.Run("Nvim", func(t *testing.T) {
+		if err := v.SetUIOption("ext_tabline", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.TryResizeUI(100, 30); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.TryResizeUIGrid(1, 90, 25); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.SetPumHeight(10); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.TryResizeUIGrid(100, 90, 25); err == nil {
+			t.Fatal("expected error for invalid grid")
+		}
+
+		uis, err := v.UIs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uis) != 1 {
+			t.Fatalf("got %d UIs but want 1", len(uis))
+		}
+		if !uis[0].ExtTabline {
+			t.Fatal("ext_tabline is not set")
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		b := v.NewBatch()
+		b.SetUIOption("ext_tabline", false)
+		b.TryResizeUI(80, 24)
+		b.TryResizeUIGrid(1, 80, 24)
+		b.SetPumHeight(5)
+		if err := b.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	select {
+	case <-redrawCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no redraw notification received")
+	}
+}
+
+func TestHandleRedraw(t *testing.T) {
+	t.Parallel()
+
+	v, cleanup := newChildProcess(t)
+	defer cleanup()
+
+	batches := make(chan []RedrawEvent, 100)
+	if err := v.HandleRedraw(func(events []RedrawEvent) {
+		batches <- events
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.AttachUIWithOptions(80, 24, &UI{RGB: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := v.DetachUI(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var resized, flushed bool
+	for !resized || !flushed {
+		select {
+		case events := <-batches:
+			for _, event := range events {
+				switch event := event.(type) {
+				case GridResizeEvent:
+					if event.Grid == DefaultGrid && event.Width == 80 && event.Height == 24 {
+						resized = true
+					}
+				case FlushEvent:
+					flushed = true
+				}
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timeout waiting for redraw events, resized %v flushed %v", resized, flushed)
+		}
+	}
+}
+
+func TestUIClient(t *testing.T) {
+	t.Parallel()
+
+	v, cleanup := newChildProcess(t)
+	defer cleanup()
+
+	resized := make(chan [3]int, 10)
+	var flushes int32
+	u, err := v.NewUIClient(80, 24, &UIClientOptions{
+		Attach: map[string]interface{}{"rgb": true},
+		Handler: &RedrawHandler{
+			Flush: func() { atomic.AddInt32(&flushes, 1) },
+		},
+		OnResize: func(grid, width, height int) {
+			resized <- [3]int{grid, width, height}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := u.Detach(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	waitResize := func(t *testing.T, want [3]int) {
+		t.Helper()
+		for {
+			select {
+			case got := <-resized:
+				if got == want {
+					return
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timeout waiting for resize to %v", want)
+			}
+		}
+	}
+
+	if width, height := u.Size(); width != 80 || height != 24 {
+		t.Fatalf("got size %dx%d, want 80x24", width, height)
+	}
+	waitResize(t, [3]int{DefaultGrid, 80, 24})
+
+	if err := u.Resize(100, 30); err != nil {
+		t.Fatal(err)
+	}
+	waitResize(t, [3]int{DefaultGrid, 100, 30})
+	if width, height := u.Size(); width != 100 || height != 30 {
+		t.Fatalf("got size %dx%d, want 100x30", width, height)
+	}
+	if _, _, ok := u.GridSize(100); ok {
+		t.Fatal("got size for a grid that does not exist")
+	}
+
+	// Events not handled by the UIClient are passed to the handler.
+	if atomic.LoadInt32(&flushes) == 0 {
+		t.Fatal("no flush event received")
+	}
+}
+
+func TestDial(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not supported dial unix socket on windows GOOS")
+	}
+
+	t.Parallel()
+
+	v1, cleanup := newChildProcess(t)
+	defer cleanup()
+
+	var addr string
+	if err := v1.Eval("$NVIM_LISTEN_ADDRESS", &addr); err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := Dial(addr, DialLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v2.Close()
+
+	if err := v2.SetVar("dial_test", "Hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+	if err := v1.Var("dial_test", &result); err != nil {
+		t.Fatal(err)
 	}
 
 	if expected := "Hello"; result != expected {
 		t.Fatalf("got %s, want %s", result, expected)
 	}
 
-	if err := v2.Close(); err != nil {
-		log.Fatal(err)
+	if err := v2.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestFixError(t *testing.T) {
+	t.Parallel()
+
+	errOther := errors.New("other")
+	tests := map[string]struct {
+		err     error
+		want    error
+		wantMsg string
+	}{
+		"Exception": {
+			err:     rpc.Error{Value: []interface{}{int64(0), "Vim:E492: Not an editor command: foo"}},
+			want:    &APIError{Method: "nvim_command", Kind: ExceptionError, Message: "Vim:E492: Not an editor command: foo"},
+			wantMsg: "nvim:nvim_command exception: Vim:E492: Not an editor command: foo",
+		},
+		"Validation": {
+			err:     rpc.Error{Value: []interface{}{uint64(1), "Invalid buffer id: 9"}},
+			want:    &APIError{Method: "nvim_command", Kind: ValidationError, Message: "Invalid buffer id: 9"},
+			wantMsg: "nvim:nvim_command validation: Invalid buffer id: 9",
+		},
+		"UnknownKind": {
+			err:     rpc.Error{Value: []interface{}{int64(5), "msg"}},
+			want:    rpc.Error{Value: []interface{}{int64(5), "msg"}},
+			wantMsg: "[5 msg]",
+		},
+		"Other": {
+			err:     errOther,
+			want:    errOther,
+			wantMsg: "other",
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := fixError("nvim_command", tt.err)
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Fatalf("got %#v, want %#v", err, tt.want)
+			}
+			if err.Error() != tt.wantMsg {
+				t.Fatalf("got message %q, want %q", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+
+	t.Run("BatchError", func(t *testing.T) {
+		t.Parallel()
+
+		err := error(&BatchError{Index: 1, Err: &APIError{Method: "nvim_get_var", Kind: ValidationError, Message: "Key not found: x"}})
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Kind != ValidationError {
+			t.Fatalf("errors.As(%v) did not find the validation *APIError", err)
+		}
+		if want := "nvim:nvim_get_var validation: Key not found: x"; err.Error() != want {
+			t.Fatalf("got message %q, want %q", err.Error(), want)
+		}
+	})
+}
+
+func TestIsReconnectable(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"EOF": {
+			err:  io.EOF,
+			want: true,
+		},
+		"WrappedEOF": {
+			err:  fmt.Errorf("msgpack/rpc: %w", io.ErrUnexpectedEOF),
+			want: true,
+		},
+		"NetError": {
+			err:  &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection timed out")},
+			want: true,
+		},
+		"ConnectionReset": {
+			err:  fmt.Errorf("read: %w", syscall.ECONNRESET),
+			want: true,
+		},
+		"TimedOut": {
+			err:  &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ETIMEDOUT)},
+			want: true,
+		},
+		"HostUnreachable": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)},
+			want: true,
+		},
+		"NetworkUnreachable": {
+			err:  fmt.Errorf("dial: %w", syscall.ENETUNREACH),
+			want: true,
+		},
+		"OtherErrno": {
+			// Any errno is retried, for example WSAECONNRESET on Windows.
+			err:  syscall.Errno(10054),
+			want: true,
+		},
+		"Protocol": {
+			err:  fmt.Errorf("%w length 5", rpc.ErrInvalidMessage),
+			want: false,
+		},
+		"MessageSize": {
+			err:  fmt.Errorf("msgpack/rpc: message exceeds maximum size of 10 bytes: %w", msgpack.ErrLimitExceeded),
+			want: false,
+		},
+		"Decode": {
+			err:  fmt.Errorf("msgpack/rpc: error decoding reply: %w", &msgpack.DecodeConvertError{SrcType: msgpack.String, DestType: reflect.TypeOf(0)}),
+			want: false,
+		},
+		"Unknown": {
+			err:  errors.New("msgpack: unknown format code c1"),
+			want: false,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isReconnectable(tt.err); got != tt.want {
+				t.Fatalf("isReconnectable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialReconnect(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server answers the generation request with the number of the
+	// connection.
+	eps := make(chan *rpc.Endpoint, 2)
+	go func() {
+		for n := 0; ; n++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			ep, err := rpc.NewEndpoint(c, c, c)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := n
+			if err := ep.Register("generation", func() (int, error) { return n, nil }); err != nil {
+				t.Error(err)
+				return
+			}
+			go ep.Serve()
+			eps <- ep
+		}
+	}()
+
+	reconnected := make(chan int, 1)
+	v, err := Dial(l.Addr().String(),
+		DialServe(false),
+		DialLogf(t.Logf),
+		DialReconnect(2, func(attempt int) time.Duration { return 10 * time.Millisecond }),
+		DialOnReconnect(func(v *Nvim) error {
+			var n int
+			if err := v.Request("generation", &n); err != nil {
+				return err
+			}
+			reconnected <- n
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	if err := v.RegisterHandler("hello", func() (string, error) { return "hello", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- v.Serve()
+	}()
+
+	ep := <-eps
+	var n int
+	if err := v.Request("generation", &n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || v.Generation() != 0 {
+		t.Fatalf("got generation %d and server connection %d, want 0", v.Generation(), n)
+	}
+
+	ep.Close()
+	select {
+	case n := <-reconnected:
+		if n != 1 {
+			t.Fatalf("got server connection %d in reconnect hook, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reconnect")
+	}
+	if got := v.Generation(); got != 1 {
+		t.Fatalf("got generation %d, want 1", got)
+	}
+
+	// The handlers are registered on the new connection.
+	ep = <-eps
+	var hello string
+	if err := ep.Call("hello", &hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello != "hello" {
+		t.Fatalf("got %q from hello handler, want %q", hello, "hello")
+	}
+
+	// Serve returns when the reconnect attempts are exhausted.
+	l.Close()
+	ep.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("got nil error from Serve after failed reconnect")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for Serve to return")
+	}
+}
+
+func TestDialReconnectIf(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		reconnect bool
+	}{
+		"Retry": {
+			reconnect: true,
+		},
+		"Fatal": {
+			reconnect: false,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			conns := make(chan net.Conn, 2)
+			go func() {
+				for {
+					c, err := l.Accept()
+					if err != nil {
+						return
+					}
+					conns <- c
+				}
+			}()
+
+			var errs []error
+			reconnected := make(chan struct{}, 1)
+			v, err := Dial(l.Addr().String(),
+				DialServe(false),
+				DialLogf(t.Logf),
+				DialReconnectIf(func(err error) bool {
+					errs = append(errs, err)
+					return tt.reconnect
+				}),
+				DialOnReconnect(func(v *Nvim) error {
+					reconnected <- struct{}{}
+					return nil
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer v.Close()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- v.Serve()
+			}()
+
+			// DialReconnectIf enables the reconnection without DialReconnect.
+			(<-conns).Close()
+			if !tt.reconnect {
+				// Serve returns rpc.ErrPeerClosed when the connection is
+				// closed by the server and the error is not retried.
+				select {
+				case err := <-done:
+					if !errors.Is(err, rpc.ErrPeerClosed) {
+						t.Fatalf("Serve() = %v, want %v", err, rpc.ErrPeerClosed)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for Serve to return")
+				}
+				if len(errs) != 1 || v.Generation() != 0 {
+					t.Fatalf("got predicate calls %v and generation %d, want 1 call and generation 0", errs, v.Generation())
+				}
+				return
+			}
+
+			select {
+			case <-reconnected:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for reconnect")
+			}
+			if got := v.Generation(); got != 1 {
+				t.Fatalf("got generation %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDefaultReconnectBackoff(t *testing.T) {
+	t.Parallel()
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	for i, d := range want {
+		if got := defaultReconnectBackoff(i + 1); got != d {
+			t.Errorf("defaultReconnectBackoff(%d) = %v, want %v", i+1, got, d)
+		}
+	}
+	if got := defaultReconnectBackoff(100); got != maxReconnectBackoff {
+		t.Errorf("defaultReconnectBackoff(100) = %v, want %v", got, maxReconnectBackoff)
+	}
+}
+
+func TestAPISchema(t *testing.T) {
+	t.Parallel()
+
+	buffer := map[string]interface{}{
+		"name":        "nvim_buf_line_count",
+		"parameters":  []interface{}{[]interface{}{"Buffer", "buffer"}},
+		"return_type": "Integer",
+	}
+
+	tests := map[string]struct {
+		info map[string]interface{}
+		want *APISchema
+	}{
+		"NoVersion": {
+			// Metadata without version, ui_events, ui_options and type prefixes.
+			info: map[string]interface{}{
+				"functions": []interface{}{
+					map[string]interface{}{
+						"name":                "buffer_line_count",
+						"parameters":          []interface{}{[]interface{}{"Buffer", "buffer"}},
+						"return_type":         "Integer",
+						"async":               false,
+						"can_fail":            true,
+						"receives_channel_id": false,
+					},
+				},
+				"error_types": map[string]interface{}{"Exception": map[string]interface{}{"id": 0}},
+				"types":       map[string]interface{}{"Buffer": map[string]interface{}{"id": 0}},
+			},
+			want: &APISchema{
+				Functions: []*APIFunction{
+					{
+						Name:       "buffer_line_count",
+						Parameters: []*APIParameter{{Type: "Buffer", Name: "buffer"}},
+						ReturnType: "Integer",
+					},
+				},
+				ErrorTypes: map[string]*APIErrorType{"Exception": {ID: 0}},
+				Types:      map[string]*APIType{"Buffer": {ID: 0}},
+			},
+		},
+		"UIEvents": {
+			info: map[string]interface{}{
+				"version": map[string]interface{}{
+					"major":          0,
+					"minor":          3,
+					"patch":          1,
+					"api_level":      5,
+					"api_compatible": 0,
+					"api_prerelease": false,
+				},
+				"functions": []interface{}{
+					map[string]interface{}{
+						"name":        "nvim_buf_line_count",
+						"parameters":  []interface{}{[]interface{}{"Buffer", "buffer"}},
+						"return_type": "Integer",
+						"method":      true,
+						"since":       1,
+					},
+				},
+				"ui_events": []interface{}{
+					map[string]interface{}{
+						"name":       "resize",
+						"parameters": []interface{}{[]interface{}{"Integer", "width"}, []interface{}{"Integer", "height"}},
+						"since":      3,
+					},
+				},
+				"ui_options":  []interface{}{"rgb", "ext_cmdline"},
+				"error_types": map[string]interface{}{"Validation": map[string]interface{}{"id": 1}},
+				"types":       map[string]interface{}{"Window": map[string]interface{}{"id": 1, "prefix": "nvim_win_"}},
+			},
+			want: &APISchema{
+				Version: APIVersion{Minor: 3, Patch: 1, APILevel: 5},
+				Functions: []*APIFunction{
+					{
+						Name:       "nvim_buf_line_count",
+						Parameters: []*APIParameter{{Type: "Buffer", Name: "buffer"}},
+						ReturnType: "Integer",
+						Method:     true,
+						Since:      1,
+					},
+				},
+				UIEvents: []*APIUIEvent{
+					{
+						Name:       "resize",
+						Parameters: []*APIParameter{{Type: "Integer", Name: "width"}, {Type: "Integer", Name: "height"}},
+						Since:      3,
+					},
+				},
+				UIOptions:  []string{"rgb", "ext_cmdline"},
+				ErrorTypes: map[string]*APIErrorType{"Validation": {ID: 1}},
+				Types:      map[string]*APIType{"Window": {ID: 1, Prefix: "nvim_win_"}},
+			},
+		},
+		"UnknownKeys": {
+			info: map[string]interface{}{
+				"version": map[string]interface{}{
+					"major":          0,
+					"minor":          10,
+					"patch":          0,
+					"build":          "v0.10.0-dev",
+					"api_level":      12,
+					"api_compatible": 0,
+					"api_prerelease": true,
+					"prerelease":     true,
+				},
+				"functions": []interface{}{
+					buffer,
+					map[string]interface{}{
+						"name":             "nvim_buf_get_number",
+						"parameters":       []interface{}{[]interface{}{"Buffer", "buffer"}},
+						"return_type":      "Integer",
+						"method":           true,
+						"since":            1,
+						"deprecated_since": 2,
+						"fast":             false,
+					},
+				},
+				"types": map[string]interface{}{
+					"Tabpage": map[string]interface{}{"id": 2, "prefix": "nvim_tabpage_", "doc": "tabpage"},
+				},
+				"future": map[string]interface{}{"key": []interface{}{1, 2}},
+			},
+			want: &APISchema{
+				Version: APIVersion{Minor: 10, Build: "v0.10.0-dev", APILevel: 12, APIPrerelease: true},
+				Functions: []*APIFunction{
+					{
+						Name:       "nvim_buf_line_count",
+						Parameters: []*APIParameter{{Type: "Buffer", Name: "buffer"}},
+						ReturnType: "Integer",
+					},
+					{
+						Name:            "nvim_buf_get_number",
+						Parameters:      []*APIParameter{{Type: "Buffer", Name: "buffer"}},
+						ReturnType:      "Integer",
+						Method:          true,
+						Since:           1,
+						DeprecatedSince: 2,
+					},
+				},
+				Types: map[string]*APIType{"Tabpage": {ID: 2, Prefix: "nvim_tabpage_"}},
+			},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := msgpack.NewEncoder(&buf).Encode(tt.info); err != nil {
+				t.Fatal(err)
+			}
+			var got *APISchema
+			if err := msgpack.NewDecoder(&buf).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Captured", func(t *testing.T) {
+		t.Parallel()
+
+		f, err := os.Open(filepath.Join("..", "msgpack", "testdata", "api_metadata.mpack.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var schema APISchema
+		if err := msgpack.NewDecoder(gz).Decode(&schema.Functions); err != nil {
+			t.Fatal(err)
+		}
+		fn := schema.Function("nvim_buf_attach")
+		if fn == nil {
+			t.Fatal("nvim_buf_attach not found")
+		}
+		want := &APIFunction{
+			Name: "nvim_buf_attach",
+			Parameters: []*APIParameter{
+				{Type: "Buffer", Name: "buffer"},
+				{Type: "Boolean", Name: "send_buffer"},
+				{Type: "DictionaryOf(LuaRef)", Name: "opts"},
+			},
+			ReturnType: "Boolean",
+			Method:     true,
+			Since:      4,
+		}
+		if !reflect.DeepEqual(fn, want) {
+			t.Fatalf("got %#v, want %#v", fn, want)
+		}
+		if schema.Function("nvim_no_such_function") != nil {
+			t.Fatal("found nvim_no_such_function")
+		}
+	})
+}
+
+type testPoint struct{ X, Y int }
+
+func (p testPoint) MarshalMsgPack(enc *msgpack.Encoder) error {
+	return enc.PackExtension(10, []byte{byte(p.X), byte(p.Y)})
+}
+
+// newFakeServer returns a client connected to a fake Nvim server. Handlers
+// registered on the server endpoint serve the requests from the client. The
+// client and the server are closed when the test finishes.
+func newFakeServer(tb testing.TB, opts ...rpc.Option) (*rpc.Endpoint, *Nvim) {
+	tb.Helper()
+
+	c1, c2 := net.Pipe()
+	server, err := rpc.NewEndpoint(c2, c2, c2, append([]rpc.Option{rpc.WithLogf(tb.Logf)}, opts...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go server.Serve()
+
+	v, err := New(c1, c1, c1, tb.Logf)
+	if err != nil {
+		server.Close()
+		tb.Fatal(err)
+	}
+	go v.Serve()
+
+	tb.Cleanup(func() {
+		v.Close()
+		server.Close()
+	})
+	return server, v
+}
+
+func TestRegisterHandlerValidation(t *testing.T) {
+	t.Parallel()
+
+	_, v := newFakeServer(t)
+
+	if err := v.RegisterHandler("ok", func(v *Nvim, s string) (string, error) { return s, nil }); err != nil {
+		t.Fatal(err)
+	}
+	for name, fn := range map[string]interface{}{
+		"Nil":          nil,
+		"TwoValues":    func(s string) (string, string) { return s, s },
+		"NotAFunction": 42,
+	} {
+		err := v.RegisterHandler("bad", fn)
+		if err == nil {
+			t.Errorf("%s: RegisterHandler did not return an error", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), "bad") {
+			t.Errorf("%s: error %q does not name the method", name, err)
+		}
+	}
+}
+
+func TestRegisterExtension(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	if err := server.Register("point", func() (testPoint, error) { return testPoint{1, 2}, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(p []byte) (interface{}, error) {
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid point %x", p)
+		}
+		return testPoint{int(p[0]), int(p[1])}, nil
+	}
+	for _, id := range []int{0, 1, 2} {
+		if err := v.RegisterExtension(id, decode); err == nil {
+			t.Fatalf("registration of built-in extension %d returned nil error", id)
+		}
+	}
+	if err := v.RegisterExtension(10, decode); err != nil {
+		t.Fatal(err)
+	}
+
+	var result interface{}
+	if err := v.Request("point", &result); err != nil {
+		t.Fatal(err)
+	}
+	if want := (testPoint{1, 2}); result != want {
+		t.Fatalf("got %#v, want %#v", result, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	if err := v.RegisterHandler("slow", func() (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var result int
+	slow := server.Go("slow", nil, &result)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- v.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v before the handler returned", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if err := (<-slow.Done).Err; err != nil {
+		t.Fatal(err)
+	}
+	if result != 1 {
+		t.Fatalf("got result %d, want 1", result)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRawCall(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	if err := server.Register("nvim_future", func(args ...interface{}) (int, error) {
+		if len(args) == 0 {
+			return 0, rpc.Error{Value: []interface{}{validationError, "no args"}}
+		}
+		return len(args), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := v.RawCall("nvim_future", &n, "a", []interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("got %d args, want 2", n)
+	}
+
+	err := v.RawCall("nvim_future", &n)
+	if want := "nvim:nvim_future validation: no args"; err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %s", err, want)
+	}
+}
+
+func TestChannelID(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var calls int32
+	if err := server.Register("nvim_get_api_info", func() ([]interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("not ready")
+		}
+		return []interface{}{7, map[string]interface{}{}}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := v.ChannelIDErr(); err == nil {
+		t.Fatalf("got channel id %d, want error", id)
+	}
+	if id := v.ChannelID(); id != 7 {
+		t.Fatalf("got channel id %d, want 7", id)
+	}
+
+	// The channel id is cached.
+	if id, err := v.ChannelIDErr(); err != nil || id != 7 {
+		t.Fatalf("got channel id %d, %v, want 7", id, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("got %d nvim_get_api_info calls, want 2", n)
+	}
+
+	v.SetChannelID(3)
+	if id, err := v.ChannelIDErr(); err != nil || id != 3 {
+		t.Fatalf("got channel id %d, %v, want 3", id, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("got %d nvim_get_api_info calls, want 2", n)
+	}
+}
+
+func TestDialNetwork(t *testing.T) {
+	t.Parallel()
+
+	pipe := "unix"
+	if dialPipe != nil {
+		pipe = "pipe"
+	}
+	tests := map[string]string{
+		"/tmp/nvim.sock":       "unix",
+		"127.0.0.1:6666":       "tcp",
+		`\\.\pipe\nvim-1234-0`: pipe,
+	}
+	for address, want := range tests {
+		var network string
+		netDial := func(ctx context.Context, n, a string) (net.Conn, error) {
+			network = n
+			return nil, errors.New("dial")
+		}
+		if _, err := Dial(address, DialNetDial(netDial)); err == nil {
+			t.Fatalf("Dial(%q) did not return an error", address)
+		}
+		if network != want {
+			t.Errorf("Dial(%q) network = %q, want %q", address, network, want)
+		}
+	}
+}
+
+func TestDialOnDisconnect(t *testing.T) {
+	t.Parallel()
+
+	conns := make(chan net.Conn, 2)
+	netDial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		conns <- c2
+		return c1, nil
+	}
+
+	disconnected := make(chan error, 2)
+	v, err := Dial("nvim",
+		DialServe(false),
+		DialLogf(t.Logf),
+		DialNetDial(netDial),
+		DialOnDisconnect(func(err error) { disconnected <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- v.Serve()
+	}()
+
+	// Nvim closes the connection.
+	(<-conns).Close()
+	if err := <-done; !errors.Is(err, rpc.ErrPeerClosed) {
+		t.Fatalf("Serve() = %v, want %v", err, rpc.ErrPeerClosed)
+	}
+	if err := <-disconnected; err != io.EOF {
+		t.Fatalf("disconnect error = %v, want %v", err, io.EOF)
+	}
+	v.Close()
+
+	// The client is closed.
+	v, err = Dial("nvim",
+		DialLogf(t.Logf),
+		DialNetDial(netDial),
+		DialOnDisconnect(func(err error) { disconnected <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := <-conns
+	defer c.Close()
+	v.Close()
+	if err := <-disconnected; err != rpc.ErrClosed {
+		t.Fatalf("disconnect error = %v, want %v", err, rpc.ErrClosed)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	v, err := NewChildProcess(
+		ChildProcessCommand(sh),
+		ChildProcessArgs("-c", "exit 3"),
+		ChildProcessServe(false),
+		ChildProcessLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := v.ProcessState(); ps != nil {
+		t.Fatalf("ProcessState() = %v before Close, want nil", ps)
+	}
+	// Serve returns when the process exits.
+	if err := v.Serve(); !errors.Is(err, rpc.ErrPeerClosed) {
+		t.Fatalf("Serve() = %v, want %v", err, rpc.ErrPeerClosed)
+	}
+	if err := v.Close(); err == nil {
+		t.Fatal("Close did not return the exit error")
+	}
+	if ps := v.ProcessState(); ps == nil || !ps.Exited() {
+		t.Fatalf("ProcessState() = %v, want exited process", ps)
+	}
+	if got := v.ExitCode(); got != 3 {
+		t.Fatalf("ExitCode() = %d, want 3", got)
+	}
+
+	// A client without a child process.
+	_, v = newFakeServer(t)
+	v.Close()
+	if ps := v.ProcessState(); ps != nil {
+		t.Fatalf("ProcessState() = %v, want nil", ps)
+	}
+	if got := v.ExitCode(); got != -1 {
+		t.Fatalf("ExitCode() = %d, want -1", got)
+	}
+}
+
+func TestBatchReset(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var executed [][]interface{}
+	if err := server.Register("nvim_call_atomic", func(calls [][]interface{}) ([]interface{}, error) {
+		executed = calls
+		results := make([]interface{}, len(calls))
+		for i := range results {
+			results[i] = i
+		}
+		return []interface{}{results, nil}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := v.NewBatch()
+
+	// Executing an empty batch does not send a request.
+	if err := b.Execute(); err != nil {
+		t.Fatalf("Execute() on empty batch = %v", err)
+	}
+	if executed != nil {
+		t.Fatalf("Execute() on empty batch sent %v", executed)
+	}
+
+	// Reset discards calls and errors.
+	var r1, r2 int
+	b.Request("discarded", &r1)
+	b.SetDigraph("x", 0)
+	b.Reset()
+	if err := b.Execute(); err != nil {
+		t.Fatalf("Execute() after Reset = %v", err)
+	}
+	if executed != nil {
+		t.Fatalf("Execute() after Reset sent %v", executed)
+	}
+
+	b.Request("a", &r1, "arg")
+	b.Request("b", &r2)
+	if err := b.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{{"a", []interface{}{"arg"}}, {"b", []interface{}{}}}
+	if !reflect.DeepEqual(executed, want) {
+		t.Fatalf("executed %v, want %v", executed, want)
+	}
+	if r1 != 0 || r2 != 1 {
+		t.Fatalf("got results %d, %d, want 0, 1", r1, r2)
+	}
+}
+
+func BenchmarkBatchReuse(b *testing.B) {
+	_, v := newFakeServer(b)
+
+	var (
+		line   []byte
+		buffer Buffer
+		mode   Mode
+	)
+	batch := v.NewBatch()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		batch.CurrentLine(&line)
+		batch.CurrentBuffer(&buffer)
+		batch.Mode(&mode)
+		batch.Request("nvim_get_current_win", nil)
+		batch.Reset()
+	}
+}
+
+func TestChildProcessContext(t *testing.T) {
+	t.Parallel()
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	disconnected := make(chan error, 1)
+	// The background process keeps stdout open after sh is killed.
+	v, err := NewChildProcess(
+		ChildProcessCommand(sh),
+		ChildProcessArgs("-c", "sleep 10 & wait"),
+		ChildProcessContext(ctx),
+		ChildProcessOnDisconnect(func(err error) { disconnected <- err }),
+		ChildProcessLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-disconnected:
+		if err != rpc.ErrClosed {
+			t.Fatalf("disconnected with %v, want %v", err, rpc.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the context was canceled")
+	}
+
+	if _, err := v.APIInfo(); err == nil {
+		t.Fatal("call after the context was canceled did not fail")
+	}
+	if err := v.Close(); err == nil {
+		t.Fatal("Close did not return the exit error")
+	}
+	if ps := v.ProcessState(); ps == nil || ps.Exited() {
+		t.Fatalf("ProcessState() = %v, want killed process", ps)
+	}
+}
+
+func TestChildProcessShutdownSignals(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not support signals other than os.Kill")
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// The scripts do not exit when stdin is closed, like a stuck Nvim.
+	tests := map[string]struct {
+		script  string
+		signals []os.Signal
+		want    syscall.Signal
+		code    int
+	}{
+		"Default": {
+			script: `trap "exit 3" TERM; while :; do sleep 0.01; done`,
+			code:   3,
+		},
+		"Interrupt": {
+			script:  `trap "exit 4" INT; while :; do sleep 0.01; done`,
+			signals: []os.Signal{os.Interrupt},
+			code:    4,
+		},
+		"Ignored": {
+			script: `trap "" TERM; while :; do sleep 0.01; done`,
+			want:   syscall.SIGKILL,
+			code:   -1,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			options := []ChildProcessOption{
+				ChildProcessCommand(sh),
+				ChildProcessArgs("-c", tt.script),
+				ChildProcessShutdownTimeout(100 * time.Millisecond),
+				ChildProcessLogf(t.Logf),
+			}
+			if tt.signals != nil {
+				options = append(options, ChildProcessShutdownSignals(tt.signals...))
+			}
+			v, err := NewChildProcess(options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v.Close()
+			ps := v.ProcessState()
+			if ps == nil {
+				t.Fatal("ProcessState() = nil after Close")
+			}
+			if code := ps.ExitCode(); code != tt.code {
+				t.Fatalf("got exit code %d, want %d", code, tt.code)
+			}
+			if tt.want != 0 {
+				if ws, ok := ps.Sys().(syscall.WaitStatus); !ok || ws.Signal() != tt.want {
+					t.Fatalf("process state %v, want killed by %v", ps, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDialByteCounters(t *testing.T) {
+	t.Parallel()
+
+	servers := make(chan *rpc.Endpoint, 1)
+	netDial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		server, err := rpc.NewEndpoint(c2, c2, c2, rpc.WithLogf(t.Logf), rpc.WithByteCounters())
+		if err != nil {
+			return nil, err
+		}
+		if err := server.Register("nvim_get_current_line", func() ([]byte, error) {
+			return []byte("hello"), nil
+		}); err != nil {
+			return nil, err
+		}
+		go server.Serve()
+		servers <- server
+		return c1, nil
+	}
+
+	v, err := Dial("nvim", DialLogf(t.Logf), DialNetDial(netDial), DialByteCounters())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	server := <-servers
+
+	line, err := v.CurrentLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "hello" {
+		t.Fatalf("CurrentLine() = %q, want %q", line, "hello")
+	}
+
+	cs, ss := v.Stats(), server.Stats()
+	if cs.Calls != 1 || cs.RepliesReceived != 1 {
+		t.Fatalf("got %d calls and %d replies, want 1 and 1", cs.Calls, cs.RepliesReceived)
+	}
+	if cs.BytesWritten == 0 || cs.BytesWritten != ss.BytesRead {
+		t.Errorf("client wrote %d bytes, server read %d bytes", cs.BytesWritten, ss.BytesRead)
+	}
+	if cs.BytesRead == 0 || cs.BytesRead != ss.BytesWritten {
+		t.Errorf("client read %d bytes, server wrote %d bytes", cs.BytesRead, ss.BytesWritten)
+	}
+}
+
+// levelLogger records the levels of the logged messages.
+type levelLogger struct {
+	mu     sync.Mutex
+	levels map[string][]string
+}
+
+func (l *levelLogger) add(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.levels[level] = append(l.levels[level], fmt.Sprintf(format, args...))
+}
+
+func (l *levelLogger) Debugf(format string, args ...interface{}) { l.add("debug", format, args...) }
+func (l *levelLogger) Infof(format string, args ...interface{})  { l.add("info", format, args...) }
+func (l *levelLogger) Errorf(format string, args ...interface{}) { l.add("error", format, args...) }
+
+func TestDialLogger(t *testing.T) {
+	t.Parallel()
+
+	netDial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		server, err := rpc.NewEndpoint(c2, c2, c2, rpc.WithLogf(t.Logf))
+		if err != nil {
+			return nil, err
+		}
+		if err := server.Register("nvim_get_current_line", func() ([]byte, error) {
+			return []byte("hello"), nil
+		}); err != nil {
+			return nil, err
+		}
+		go server.Serve()
+		return c1, nil
+	}
+
+	logger := &levelLogger{levels: make(map[string][]string)}
+	logf := func(format string, args ...interface{}) {
+		t.Errorf("logf called with logger: "+format, args...)
+	}
+	v, err := Dial("nvim", DialLogf(logf), DialLogger(logger), DialNetDial(netDial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+
+	if _, err := v.CurrentLine(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	want := []string{
+		"msgpack/rpc: sent request 1 nvim_get_current_line",
+		"msgpack/rpc: received reply 1",
+	}
+	if got := logger.levels["debug"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got debug messages %q, want %q", got, want)
+	}
+}
+
+func TestExecCommands(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var scripts []string
+	if err := server.Register("nvim_exec", func(src string, output bool) (string, error) {
+		scripts = append(scripts, src)
+		if strings.Contains(src, "bogus") {
+			return "", errors.New("nvim_exec(), line 2: Vim:E492: Not an editor command: bogus")
+		}
+		return "", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.ExecCommands(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ExecCommands([]string{"set nowrap", "let g:x = 1"}); err != nil {
+		t.Fatal(err)
+	}
+	err := v.ExecCommands([]string{"set nowrap", "bogus", "let g:x = 1"})
+	var cerr *ExecCommandsError
+	if !errors.As(err, &cerr) || cerr.Index != 1 || cerr.Command != "bogus" {
+		t.Fatalf("got error %v, want *ExecCommandsError for command 1", err)
+	}
+
+	want := []string{"set nowrap\nlet g:x = 1", "set nowrap\nbogus\nlet g:x = 1"}
+	if !reflect.DeepEqual(scripts, want) {
+		t.Fatalf("got scripts %q, want %q", scripts, want)
+	}
+}
+
+func TestBufferLineIterator(t *testing.T) {
+	t.Parallel()
+
+	newBuffer := func(n int) [][]byte {
+		lines := make([][]byte, n)
+		for i := range lines {
+			lines[i] = []byte(strconv.Itoa(i))
+		}
+		return lines
+	}
+
+	tests := map[string]struct {
+		lines  int
+		chunk  int
+		modify func(call int, lines [][]byte) [][]byte
+		want   int // number of lines returned
+	}{
+		"Empty":        {lines: 0, chunk: 3, want: 0},
+		"Partial":      {lines: 7, chunk: 3, want: 7},
+		"Exact":        {lines: 6, chunk: 3, want: 6},
+		"DefaultChunk": {lines: 1500, chunk: 0, want: 1500},
+		"Deleted": {
+			lines: 10,
+			chunk: 3,
+			modify: func(call int, lines [][]byte) [][]byte {
+				if call == 2 {
+					return lines[:4]
+				}
+				return lines
+			},
+			want: 4,
+		},
+		"Added": {
+			lines: 5,
+			chunk: 3,
+			modify: func(call int, lines [][]byte) [][]byte {
+				if call == 2 {
+					return newBuffer(8)
+				}
+				return lines
+			},
+			want: 8,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server, v := newFakeServer(t)
+			lines := newBuffer(tt.lines)
+			var calls int
+			if err := server.Register("nvim_buf_get_lines", func(b Buffer, start, end int, strict bool) ([][]byte, error) {
+				calls++
+				if tt.modify != nil {
+					lines = tt.modify(calls, lines)
+				}
+				if start > len(lines) {
+					start = len(lines)
+				}
+				if end > len(lines) {
+					end = len(lines)
+				}
+				return lines[start:end], nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			it := v.BufferLineIterator(0, tt.chunk)
+			var n int
+			for {
+				line, ok := it.Next()
+				if !ok {
+					break
+				}
+				if line != strconv.Itoa(n) {
+					t.Fatalf("line %d is %q", n, line)
+				}
+				n++
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Fatalf("got %d lines, want %d", n, tt.want)
+			}
+		})
+	}
+
+	// An error stops the iteration.
+	server, v := newFakeServer(t)
+	server.Close()
+	it := v.BufferLineIterator(0, 10)
+	if _, ok := it.Next(); ok {
+		t.Fatal("Next() returned a line after an error")
+	}
+	if it.Err() == nil {
+		t.Fatal("Err() = nil after an error")
+	}
+}
+
+func TestSetExtmark(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var gotOpts []map[string]interface{}
+	if err := server.Register("nvim_buf_set_extmark", func(b Buffer, ns, line, col int, opts map[string]interface{}) (int, error) {
+		gotOpts = append(gotOpts, opts)
+		return len(gotOpts), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_buf_get_extmarks", func(b Buffer, ns int, start, end interface{}, opts map[string]interface{}) ([]interface{}, error) {
+		if opts["details"] != true || opts["limit"] != int64(10) {
+			return nil, fmt.Errorf("unexpected opts %v", opts)
+		}
+		return []interface{}{
+			[]interface{}{1, 0, 2, map[string]interface{}{
+				"ns_id":     ns,
+				"end_row":   0,
+				"end_col":   5,
+				"hl_group":  "Search",
+				"virt_text": [][]string{{"error", "ErrorMsg"}},
+				"priority":  4096,
+			}},
+		}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.SetExtmark(1, 2, 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	opts := new(ExtmarkOptions).WithEnd(0, 5).WithHighlight("Search").WithVirtText("error", "ErrorMsg").WithVirtText(" ", "")
+	id, err := v.SetExtmark(1, 2, 0, 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 {
+		t.Fatalf("got id %d, want 2", id)
+	}
+	want := []map[string]interface{}{
+		{},
+		{
+			"end_row":   int64(0),
+			"end_col":   int64(5),
+			"hl_group":  "Search",
+			"virt_text": []interface{}{[]interface{}{"error", "ErrorMsg"}, []interface{}{" "}},
+		},
+	}
+	if !reflect.DeepEqual(gotOpts, want) {
+		t.Fatalf("got opts %v, want %v", gotOpts, want)
+	}
+
+	for _, opts := range []*ExtmarkOptions{
+		{VirtText: [][]string{{"a", "b", "c"}}},
+		{VirtText: [][]string{{}}},
+		{VirtLines: [][][]string{{{"a"}}, {{"a", "b", "c"}}}},
+		{VirtTextPos: "below"},
+		{HLMode: "mix"},
+	} {
+		if _, err := v.SetExtmark(1, 2, 0, 0, opts); err == nil {
+			t.Errorf("SetExtmark with options %+v did not return an error", opts)
+		}
+	}
+	if len(gotOpts) != 2 {
+		t.Fatalf("invalid options were sent to Nvim")
+	}
+
+	marks, err := v.BufferExtmarkDetails(1, 2, 0, -1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMarks := []*ExtmarkDetails{{
+		ID:  1,
+		Row: 0,
+		Col: 2,
+		Options: ExtmarkOptions{
+			EndRow:   new(int),
+			EndCol:   func() *int { n := 5; return &n }(),
+			HLGroup:  "Search",
+			VirtText: [][]string{{"error", "ErrorMsg"}},
+			Priority: 4096,
+		},
+	}}
+	if !reflect.DeepEqual(marks, wantMarks) {
+		t.Fatalf("got extmarks %+v, want %+v", marks[0], wantMarks[0])
+	}
+}
+
+func TestSetClient(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var got []interface{}
+	if err := server.Register("nvim_set_client_info", func(name string, version map[string]interface{}, typ string, methods, attributes map[string]interface{}) error {
+		got = []interface{}{name, version, typ, methods, attributes}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_get_chan_info", func(id int) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"client": map[string]interface{}{
+				"name": "test",
+				"type": "remote",
+				"methods": map[string]interface{}{
+					"one":   map[string]interface{}{"nargs": 1},
+					"range": map[string]interface{}{"async": true, "nargs": []int{0, 2}},
+				},
+			},
+		}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		Name:    "test",
+		Version: ClientVersion{Major: 1, Minor: 2, Prerelease: "dev"},
+		Type:    PluginClientType,
+		Methods: map[string]*ClientMethod{
+			"Hello": {Async: true, NArgs: ClientMethodNArgs{Min: 1, Max: 1}},
+		},
+		Attributes: ClientAttributes{ClientAttributeKeyWebsite: "https://example.com"},
+	}
+	if err := v.SetClient(client); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		"test",
+		map[string]interface{}{"major": int64(1), "minor": int64(2), "prerelease": "dev"},
+		"plugin",
+		map[string]interface{}{
+			"Hello": map[string]interface{}{"async": true, "nargs": []interface{}{int64(1), int64(1)}},
+		},
+		map[string]interface{}{"website": "https://example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	b := v.NewBatch()
+	b.SetClient(&Client{Name: "test", Type: "server"})
+	if err := b.Execute(); err == nil {
+		t.Fatal("expected error for invalid client type in batch")
+	}
+	got = nil
+	if err := v.SetClient(&Client{Name: "test"}); err == nil {
+		t.Fatal("expected error for missing client type")
+	}
+	if got != nil {
+		t.Fatalf("nvim_set_client_info called with invalid client type: %v", got)
+	}
+
+	channel, err := v.ChannelInfo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMethods := map[string]*ClientMethod{
+		"one":   {NArgs: ClientMethodNArgs{Min: 1, Max: 1}},
+		"range": {Async: true, NArgs: ClientMethodNArgs{Min: 0, Max: 2}},
+	}
+	if !reflect.DeepEqual(channel.Client.Methods, wantMethods) {
+		t.Fatalf("got methods %+v, want %+v", channel.Client.Methods, wantMethods)
+	}
+}
+
+func TestClientMethodNArgs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value interface{}
+		want  ClientMethodNArgs
+	}{
+		"Int":       {value: 2, want: ClientMethodNArgs{Min: 2, Max: 2}},
+		"One":       {value: []int{1}, want: ClientMethodNArgs{Min: 1, Max: 1}},
+		"MinMax":    {value: []int{0, 2}, want: ClientMethodNArgs{Min: 0, Max: 2}},
+		"ExtraArgs": {value: []interface{}{1, 3, []int{4, 5}, 6}, want: ClientMethodNArgs{Min: 1, Max: 3}},
+		"Empty":     {value: []int{}, want: ClientMethodNArgs{}},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := msgpack.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ClientMethodNArgs{Min: -1, Max: -1}
+			if err := msgpack.Unmarshal(p, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("CorruptLength", func(t *testing.T) {
+		t.Parallel()
+
+		// An array of 2^32-1 elements holding only [1, 2].
+		dec := msgpack.NewDecoder(bytes.NewReader([]byte{0xdd, 0xff, 0xff, 0xff, 0xff, 0x01, 0x02}))
+		if err := dec.Unpack(); err != nil {
+			t.Fatal(err)
+		}
+		var got ClientMethodNArgs
+		if err := got.UnmarshalMsgPack(dec); err == nil {
+			t.Fatal("UnmarshalMsgPack did not return an error for a truncated array")
+		}
+	})
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	type keyMap struct {
+		buffer         Buffer
+		mode, lhs, rhs string
+		opts           map[string]interface{}
+	}
+	var got []keyMap
+	if err := server.Register("nvim_set_keymap", func(mode, lhs, rhs string, opts map[string]interface{}) error {
+		got = append(got, keyMap{mode: mode, lhs: lhs, rhs: rhs, opts: opts})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_buf_set_keymap", func(buffer Buffer, mode, lhs, rhs string, opts map[string]interface{}) error {
+		got = append(got, keyMap{buffer: buffer, mode: mode, lhs: lhs, rhs: rhs, opts: opts})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_get_api_info", func() ([]interface{}, error) {
+		return []interface{}{3, map[string]interface{}{}}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Map("n", "<Leader>a", ":echo 1<CR>", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Map("i", "jk", "<Esc>", &KeyMapOptions{NoRemap: true, Silent: true, Desc: "leave insert mode"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.BufferMap(2, "n", "K", "", &KeyMapOptions{Callback: "hover", NoWait: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Map("n", "<Tab>", "", &KeyMapOptions{Callback: "it's<next>", Expr: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Map("n", "x", "y", &KeyMapOptions{Callback: "x"}); err == nil {
+		t.Fatal("expected error for mapping with rhs and callback")
+	}
+
+	want := []keyMap{
+		{mode: "n", lhs: "<Leader>a", rhs: ":echo 1<CR>", opts: map[string]interface{}{}},
+		{mode: "i", lhs: "jk", rhs: "<Esc>", opts: map[string]interface{}{"noremap": true, "silent": true, "desc": "leave insert mode"}},
+		{buffer: 2, mode: "n", lhs: "K", rhs: "<Cmd>call rpcnotify(3, 'hover')<CR>", opts: map[string]interface{}{"nowait": true}},
+		{mode: "n", lhs: "<Tab>", rhs: "rpcrequest(3, 'it''s<lt>next>')", opts: map[string]interface{}{"expr": true}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestKeyMapMode(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	// Response of nvim_get_keymap("n") from Nvim 0.9 for ":nnoremap <silent> <C-L> <Cmd>nohlsearch<CR>".
+	if err := server.Register("nvim_get_keymap", func(mode string) ([]map[string]interface{}, error) {
+		return []map[string]interface{}{{
+			"lhs":       "<C-L>",
+			"lhsraw":    "\x0c",
+			"rhs":       "<Cmd>nohlsearch<CR>",
+			"noremap":   1,
+			"silent":    1,
+			"expr":      0,
+			"nowait":    0,
+			"script":    0,
+			"buffer":    0,
+			"sid":       -8,
+			"lnum":      0,
+			"mode":      mode,
+			"mode_bits": 1,
+			"abbr":      0,
+		}}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	maps, err := v.KeyMap("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Mapping{{
+		LHS:      "<C-L>",
+		LHSRaw:   "\x0c",
+		RHS:      "<Cmd>nohlsearch<CR>",
+		NoRemap:  1,
+		Silent:   1,
+		SID:      -8,
+		Mode:     "n",
+		ModeBits: 1,
+	}}
+	if !reflect.DeepEqual(maps, want) {
+		t.Fatalf("got %+v, want %+v", maps[0], want[0])
+	}
+}
+
+func TestKeyMapCallback(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	if err := server.Register("nvim_get_keymap", func(mode string) ([]map[string]interface{}, error) {
+		return []map[string]interface{}{
+			{
+				// vim.keymap.set('n', '<F3>', function() end, {desc = 'callback'})
+				"lhs":      "<F3>",
+				"lhsraw":   "\x80k3",
+				"callback": nil,
+				"desc":     "callback",
+				"noremap":  1,
+				"mode":     mode,
+			},
+			{
+				"lhs":     "<F2>",
+				"lhsraw":  "\x80k2",
+				"rhs":     "<Cmd>update<CR>",
+				"rhsraw":  "\x80\xfdhupdate\r",
+				"noremap": 1,
+				"mode":    mode,
+			},
+		}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	maps, err := v.KeyMap("n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maps) != 2 {
+		t.Fatalf("got %d mappings, want 2", len(maps))
+	}
+
+	// Over RPC, a callback mapping has neither a callback nor an rhs.
+	callback := maps[0]
+	if callback.Callback != nil || callback.RHS != "" {
+		t.Fatalf("got callback %v and rhs %q for %s callback mapping", callback.Callback, callback.RHS, callback.LHS)
+	}
+	if callback.Desc != "callback" || callback.Mode != "n" || callback.LHSRaw != "\x80k3" {
+		t.Fatalf("unexpected callback mapping %+v", callback)
+	}
+
+	rhs := maps[1]
+	if rhs.RHS != "<Cmd>update<CR>" || rhs.RHSRaw != "\x80\xfdhupdate\r" {
+		t.Fatalf("got rhs %q and raw rhs %q", rhs.RHS, rhs.RHSRaw)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	// Nvim handles the requests of a channel one at a time.
+	server, v := newFakeServer(t, rpc.WithSynchronousDispatch())
+	var order []int
+	if err := server.Register("test_double", func(n int) (int, error) {
+		order = append(order, n)
+		return 2 * n, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("test_fail", func(n int) (int, error) {
+		order = append(order, n)
+		return 0, errors.New("failed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_call_function", func(fname string, args []int) (string, error) {
+		return fname + strconv.Itoa(len(args)), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := v.Pipeline()
+	results := make([]int, 4)
+	p.Request("test_double", &results[0], 1)
+	p.Request("test_fail", &results[1], 2)
+	p.Request("test_double", &results[2], 3)
+	p.Request("test_double", &results[3], 4)
+	var fn string
+	p.Call("len", &fn)
+	if n := p.Len(); n != 5 {
+		t.Fatalf("got %d calls, want 5", n)
+	}
+
+	errs := p.Wait()
+	if len(errs) != 5 {
+		t.Fatalf("got %d errors, want 5", len(errs))
+	}
+	for i, err := range errs {
+		if (err != nil) != (i == 1) {
+			t.Fatalf("call %d: unexpected error %v", i, err)
+		}
+	}
+	if want := []int{2, 0, 6, 8}; !reflect.DeepEqual(results, want) {
+		t.Fatalf("got results %v, want %v", results, want)
+	}
+	if fn != "len0" {
+		t.Fatalf("got %q from Call, want %q", fn, "len0")
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("calls executed in order %v, want %v", order, want)
+	}
+
+	if n := p.Len(); n != 0 {
+		t.Fatalf("got %d calls after Wait, want 0", n)
+	}
+	if errs := p.Wait(); len(errs) != 0 {
+		t.Fatalf("got %d errors from empty pipeline", len(errs))
+	}
+}
+
+func TestInputAllRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		consumed  []int
+		wantInput []string
+		wantErr   bool
+	}{
+		"Partial": {
+			consumed:  []int{0, 2, 0, 3},
+			wantInput: []string{"abcde", "abcde", "cde", "cde"},
+		},
+		"NotConsumed": {
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server, v := newFakeServer(t)
+			var input []string
+			if err := server.Register("nvim_input", func(keys string) (int, error) {
+				input = append(input, keys)
+				if len(input) > len(tt.consumed) {
+					return 0, nil
+				}
+				return tt.consumed[len(input)-1], nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := v.InputAll("abcde")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("InputAll did not return an error")
+				}
+				if len(input) != maxInputRetries {
+					t.Fatalf("got %d calls, want %d", len(input), maxInputRetries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(input, tt.wantInput) {
+				t.Fatalf("got input %q, want %q", input, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestOpenTermStream(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	if err := server.Register("nvim_get_api_info", func() ([]interface{}, error) {
+		return []interface{}{1, map[string]interface{}{}}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Input typed before nvim_open_term returns is delivered to the stream.
+	if err := server.Register("nvim_exec_lua", func(code string, args []interface{}) (int, error) {
+		if err := server.Notify(args[2].(string), []byte("early")); err != nil {
+			return 0, err
+		}
+		return 7, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	channel, stream, err := v.OpenTermStream(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel != 7 {
+		t.Fatalf("got channel %d, want 7", channel)
+	}
+	if err := server.Notify(TerminalStreamMethod(channel), []byte(" late")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len("early late"))
+	if _, err := io.ReadFull(stream, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "early late" {
+		t.Fatalf("got %q, want %q", got, "early late")
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	v.epMu.RLock()
+	defer v.epMu.RUnlock()
+	for method := range v.registrations {
+		if strings.HasPrefix(method, terminalStreamMethodPrefix) {
+			t.Fatalf("method %s is registered after Close", method)
+		}
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	t.Parallel()
+
+	invalidBuffer := &BatchError{Index: 0, Err: &APIError{Method: "nvim_exec_lua", Kind: ExceptionError, Message: "Invalid buffer id: 99"}}
+	setLines := &BatchError{Index: 1, Err: &APIError{Method: "nvim_buf_set_lines", Kind: ValidationError, Message: "Index out of bounds"}}
+	tests := map[string]struct {
+		err  error
+		want error
+	}{
+		"Nil": {
+			err:  nil,
+			want: nil,
+		},
+		"Mismatch": {
+			err:  &BatchError{Index: 0, Err: &APIError{Method: "nvim_exec_lua", Kind: ExceptionError, Message: "Error executing lua: " + changedTickMismatch}},
+			want: ErrConflict,
+		},
+		"InvalidBuffer": {
+			err:  invalidBuffer,
+			want: invalidBuffer,
+		},
+		"SetLines": {
+			err:  setLines,
+			want: setLines,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := conflictError(tt.err); got != tt.want {
+				t.Fatalf("conflictError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUIClientUnregister(t *testing.T) {
+	t.Parallel()
+
+	for _, attachErr := range []bool{false, true} {
+		attachErr := attachErr
+		t.Run(fmt.Sprintf("AttachError=%v", attachErr), func(t *testing.T) {
+			t.Parallel()
+
+			server, v := newFakeServer(t)
+			if err := server.Register("nvim_ui_attach", func(width, height int, opts map[string]interface{}) error {
+				if attachErr {
+					return errors.New("UI already attached to channel")
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Register("nvim_ui_detach", func() error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			registered := func() bool {
+				v.epMu.RLock()
+				defer v.epMu.RUnlock()
+				_, ok := v.registrations["redraw"]
+				return ok
+			}
+
+			u, err := v.NewUIClient(80, 24, nil)
+			if attachErr {
+				if err == nil {
+					t.Fatal("NewUIClient did not return an error")
+				}
+				if registered() {
+					t.Fatal("redraw handler is registered after the attach error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !registered() {
+				t.Fatal("redraw handler is not registered")
+			}
+			if err := u.Detach(); err != nil {
+				t.Fatal(err)
+			}
+			if registered() {
+				t.Fatal("redraw handler is registered after Detach")
+			}
+		})
+	}
+}
+
+func TestSubscribeHandlerRefCount(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	for _, method := range []string{"nvim_subscribe", "nvim_unsubscribe"} {
+		method := method
+		if err := server.Register(method, func(event string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, method+" "+event)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registered := func() bool {
+		v.epMu.RLock()
+		defer v.epMu.RUnlock()
+		_, ok := v.registrations["event"]
+		return ok
+	}
+	checkCalls := func(want ...string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(calls, want) {
+			t.Fatalf("got calls %q, want %q", calls, want)
+		}
+	}
+
+	unsubscribe1, err := v.SubscribeHandler("event", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe2, err := v.SubscribeHandler("event", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCalls("nvim_subscribe event")
+
+	if err := unsubscribe1(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unsubscribe1(); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls("nvim_subscribe event")
+	if !registered() {
+		t.Fatal("handler is not registered while a subscription remains")
+	}
+
+	if err := unsubscribe2(); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls("nvim_subscribe event", "nvim_unsubscribe event")
+	if registered() {
+		t.Fatal("handler is registered after the last unsubscribe")
+	}
+}
+
+// connFrames is a rpc.FrameReader and rpc.FrameWriter that sends each frame
+// as is over a connection.
+type connFrames struct {
+	net.Conn
+	buf [512]byte
+}
+
+func (f *connFrames) ReadFrame() ([]byte, error) {
+	n, err := f.Read(f.buf[:])
+	return f.buf[:n], err
+}
+
+func (f *connFrames) WriteFrame(p []byte) error {
+	_, err := f.Write(p)
+	return err
+}
+
+func TestNewFramed(t *testing.T) {
+	t.Parallel()
+
+	c1, c2 := net.Pipe()
+	server, err := rpc.NewEndpoint(c2, c2, c2, rpc.WithLogf(t.Logf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register("nvim_get_current_line", func() ([]byte, error) {
+		return []byte("hello"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	frames := &connFrames{Conn: c1}
+	disconnected := make(chan error, 1)
+	v, err := NewFramed(frames, frames, frames,
+		FramedLogf(t.Logf),
+		FramedByteCounters(),
+		FramedOnDisconnect(func(err error) { disconnected <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	go v.Serve()
+
+	line, err := v.CurrentLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "hello" {
+		t.Fatalf("got line %q, want %q", line, "hello")
+	}
+	if stats := v.Stats(); stats.BytesRead == 0 || stats.BytesWritten == 0 {
+		t.Fatalf("got %d bytes read and %d bytes written, want counted bytes", stats.BytesRead, stats.BytesWritten)
+	}
+
+	server.Close()
+	if err := <-disconnected; err != io.EOF {
+		t.Fatalf("disconnect error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestCheckOptionScope(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		info  OptionInfo
+		scope string
+		ok    bool
+	}{
+		{OptionInfo{Name: "shiftwidth", Scope: "buf"}, "buf", true},
+		{OptionInfo{Name: "shiftwidth", Scope: "buf"}, "win", false},
+		{OptionInfo{Name: "number", Scope: "win"}, "win", true},
+		{OptionInfo{Name: "number", Scope: "win"}, "buf", false},
+		{OptionInfo{Name: "hidden", Scope: "global"}, "buf", false},
+		{OptionInfo{Name: "makeprg", Scope: "buf", GlobalLocal: true}, "buf", true},
+		{OptionInfo{Name: "makeprg", Scope: "buf", GlobalLocal: true}, "win", false},
+		{OptionInfo{Name: "makeprg", Scope: "buf", GlobalLocal: true}, "global", true},
+		{OptionInfo{Name: "scrolloff", Scope: "win", GlobalLocal: true}, "buf", false},
+	}
+	for _, tt := range tests {
+		err := checkOptionScope(&tt.info, tt.scope)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("checkOptionScope(%s with scope %q, global-local %v, %q) returned %v", tt.info.Name, tt.info.Scope, tt.info.GlobalLocal, tt.scope, err)
+		}
+	}
+}
+
+func TestLocalOptionScope(t *testing.T) {
+	t.Parallel()
+
+	server, v := newFakeServer(t)
+	if err := server.Register("nvim_call_atomic", func(calls []interface{}) ([]interface{}, error) {
+		info := map[string]interface{}{"name": "number", "scope": "win"}
+		return []interface{}{[]interface{}{info, 2}, nil}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The value of a window option is not stored for a buffer.
+	result := 7
+	if err := Buffer(1).Option(v, "number", &result); err == nil {
+		t.Fatal("Buffer.Option did not return an error for a window option")
+	}
+	if result != 7 {
+		t.Fatalf("result = %d after the scope error, want 7", result)
+	}
+
+	if err := Window(1000).Option(v, "number", &result); err != nil {
+		t.Fatal(err)
+	}
+	if result != 2 {
+		t.Fatalf("result = %d, want 2", result)
 	}
 }
 
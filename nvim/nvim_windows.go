@@ -1,7 +1,12 @@
 package nvim
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+)
 
 func init() {
 	embedProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	dialPipe = dialNamedPipe
+	defaultShutdownSignals = []os.Signal{os.Kill}
 }
@@ -0,0 +1,216 @@
+// Package nvimtest implements a fake Nvim for testing clients and plugin
+// handlers without running Nvim.
+//
+// A Server serves the MessagePack RPC protocol over in-memory pipes. The
+// application registers the results of the API methods called by the code
+// under test, runs the code with the client returned by Client, and checks the
+// calls recorded by the server:
+//
+//	s := nvimtest.NewServer(t)
+//	s.Respond("nvim_get_current_line", []byte("hello"))
+//	line, err := s.Client().CurrentLine()
+//	...
+//	if calls := s.CallsTo("nvim_get_current_line"); len(calls) != 1 {
+//		t.Errorf("got %d calls, want 1", len(calls))
+//	}
+//
+// Calls to methods without a registered result fail with an error. Use Call
+// and Notify to invoke the handlers registered by the client, for example the
+// handlers of a plugin.
+package nvimtest
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/neovim/go-client/msgpack/rpc"
+	"github.com/neovim/go-client/nvim"
+)
+
+// Call represents a request or notification received by a Server.
+type Call struct {
+	// Method is the name of the method.
+	Method string
+
+	// Args is the arguments decoded as the types of the handler arguments.
+	// The arguments of methods registered with Respond and RespondError
+	// have the types described in msgpack.Decoder.Decode for decoding to an
+	// interface value.
+	Args []interface{}
+}
+
+// Server is a fake Nvim. Create a Server with NewServer.
+type Server struct {
+	ep     *rpc.Endpoint
+	client *nvim.Nvim
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewServer starts a server and a client connected to the server. The server
+// and the client are closed when the test ends.
+//
+// The server runs the handlers one at a time in the order the requests and
+// notifications are received, so the calls are recorded in the order they
+// were made. Handlers registered with Handle must not call the client.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+
+	serverR, clientW := io.Pipe()
+	clientR, serverW := io.Pipe()
+
+	ep, err := rpc.NewEndpoint(serverR, serverW, &pipeCloser{serverR, serverW},
+		rpc.WithLogf(tb.Logf), rpc.WithSynchronousDispatch())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	client, err := nvim.New(clientR, clientW, &pipeCloser{clientR, clientW}, tb.Logf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := &Server{ep: ep, client: client}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ep.Serve()
+	}()
+	go func() {
+		defer wg.Done()
+		client.Serve()
+	}()
+	tb.Cleanup(func() {
+		client.Close()
+		ep.Close()
+		wg.Wait()
+	})
+	return s
+}
+
+// pipeCloser closes both ends of a connection.
+type pipeCloser struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (c *pipeCloser) Close() error {
+	c.w.Close()
+	return c.r.Close()
+}
+
+// Client returns the client connected to the server.
+func (s *Server) Client() *nvim.Nvim {
+	return s.client
+}
+
+// Respond registers result as the result of method. A previous registration
+// of method is replaced.
+func (s *Server) Respond(method string, result interface{}) error {
+	return s.Handle(method, func(args ...interface{}) (interface{}, error) {
+		return result, nil
+	})
+}
+
+// RespondError registers an error of kind with the message msg as the error
+// of method. The client returns the error as an *nvim.APIError. A previous
+// registration of method is replaced.
+func (s *Server) RespondError(method string, kind nvim.ErrorKind, msg string) error {
+	return s.Handle(method, func(args ...interface{}) (interface{}, error) {
+		return nil, rpc.Error{Value: []interface{}{int(kind), msg}}
+	})
+}
+
+// Handle registers fn as the handler of method. The function signature for fn
+// is one of
+//
+//	func({args}) ({resultType}, error)
+//	func({args}) error
+//	func({args})
+//
+// where {args} is zero or more arguments and {resultType} is the type of a
+// return value. Unlike the handlers registered with nvim.Nvim.RegisterHandler,
+// fn does not take a *nvim.Nvim argument. The calls are recorded before fn is
+// called. A previous registration of method is replaced.
+func (s *Server) Handle(method string, fn interface{}) error {
+	fnv := reflect.ValueOf(fn)
+	if fnv.Kind() != reflect.Func {
+		return fmt.Errorf("nvimtest: handler for %s is %T, not a function", method, fn)
+	}
+	if fnv.Type().NumIn() > 0 && fnv.Type().In(0) == nvimType {
+		return fmt.Errorf("nvimtest: handler for %s takes a *nvim.Nvim argument", method)
+	}
+	record := reflect.MakeFunc(fnv.Type(), func(in []reflect.Value) []reflect.Value {
+		s.record(method, fnv.Type(), in)
+		if fnv.Type().IsVariadic() {
+			return fnv.CallSlice(in)
+		}
+		return fnv.Call(in)
+	})
+	s.ep.Unregister(method)
+	return s.ep.Register(method, record.Interface())
+}
+
+var nvimType = reflect.TypeOf((*nvim.Nvim)(nil))
+
+// record records a call with the handler arguments in.
+func (s *Server) record(method string, t reflect.Type, in []reflect.Value) {
+	var args []interface{}
+	for i, v := range in {
+		if t.IsVariadic() && i == len(in)-1 {
+			for j := 0; j < v.Len(); j++ {
+				args = append(args, v.Index(j).Interface())
+			}
+			break
+		}
+		args = append(args, v.Interface())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls received by the server, in the order they were
+// received.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls of method received by the server.
+func (s *Server) CallsTo(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset removes the recorded calls.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// Call calls the handler registered by the client for method, like Nvim calls
+// the handlers with rpcrequest().
+func (s *Server) Call(method string, result interface{}, args ...interface{}) error {
+	return s.ep.Call(method, result, args...)
+}
+
+// Notify sends a notification to the client, like Nvim sends notifications
+// with rpcnotify().
+func (s *Server) Notify(method string, args ...interface{}) error {
+	return s.ep.Notify(method, args...)
+}
@@ -0,0 +1,125 @@
+package nvimtest_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neovim/go-client/nvim"
+	"github.com/neovim/go-client/nvim/nvimtest"
+	"github.com/neovim/go-client/nvim/plugin"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	s := nvimtest.NewServer(t)
+	v := s.Client()
+
+	if err := s.Respond("nvim_get_current_line", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RespondError("nvim_command", nvim.ExceptionError, "Vim:E492: Not an editor command: bogus"); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	if err := s.Handle("nvim_buf_set_lines", func(b nvim.Buffer, start, end int, strict bool, replacement []string) error {
+		lines = replacement
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := v.CurrentLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "hello" {
+		t.Fatalf("CurrentLine() = %q, want %q", line, "hello")
+	}
+	var apiErr *nvim.APIError
+	if err := v.Command("bogus"); !errors.As(err, &apiErr) || apiErr.Kind != nvim.ExceptionError || !strings.Contains(apiErr.Message, "E492") {
+		t.Fatalf("Command() returned error %v, want E492 exception", err)
+	}
+	if err := v.SetBufferLines(1, 0, -1, true, [][]byte{[]byte("a"), []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("got lines %q, want %q", lines, want)
+	}
+	if _, err := v.CurrentBuffer(); err == nil {
+		t.Fatal("call to a method without a result did not fail")
+	}
+
+	want := []nvimtest.Call{
+		{Method: "nvim_get_current_line"},
+		{Method: "nvim_command", Args: []interface{}{"bogus"}},
+		{Method: "nvim_buf_set_lines", Args: []interface{}{nvim.Buffer(1), 0, -1, true, []string{"a", "b"}}},
+	}
+	if got := s.Calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got calls %+v, want %+v", got, want)
+	}
+	if got := s.CallsTo("nvim_command"); !reflect.DeepEqual(got, want[1:2]) {
+		t.Fatalf("got calls %+v, want %+v", got, want[1:2])
+	}
+	s.Reset()
+	if got := s.Calls(); len(got) != 0 {
+		t.Fatalf("got calls %+v after Reset", got)
+	}
+
+	if err := s.Handle("nvim_eval", nil); err == nil {
+		t.Fatal("Handle with a nil function did not fail")
+	}
+	if err := s.Handle("nvim_eval", func(v *nvim.Nvim, expr string) (interface{}, error) { return nil, nil }); err == nil {
+		t.Fatal("Handle with a *nvim.Nvim argument did not fail")
+	}
+}
+
+func TestServerPlugin(t *testing.T) {
+	t.Parallel()
+
+	s := nvimtest.NewServer(t)
+	p := plugin.New(s.Client())
+
+	errEmpty := errors.New("empty name")
+	p.HandleFunction(&plugin.FunctionOptions{Name: "Greet"}, func(args []string) (string, error) {
+		if len(args) == 0 || args[0] == "" {
+			return "", errEmpty
+		}
+		if err := p.Nvim.SetVar("greeted", args[0]); err != nil {
+			return "", err
+		}
+		return "Hello, " + args[0], nil
+	})
+	notified := make(chan string, 1)
+	p.Handle("event", func(s string) { notified <- s })
+
+	if err := s.Respond("nvim_set_var", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+	if err := s.Call("0:function:Greet", &result, []string{"John"}); err != nil {
+		t.Fatal(err)
+	}
+	if result != "Hello, John" {
+		t.Fatalf("got %q, want %q", result, "Hello, John")
+	}
+	want := []nvimtest.Call{{Method: "nvim_set_var", Args: []interface{}{"greeted", "John"}}}
+	if got := s.Calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got calls %+v, want %+v", got, want)
+	}
+
+	err := s.Call("0:function:Greet", &result, []string{""})
+	if err == nil || !strings.Contains(err.Error(), errEmpty.Error()) {
+		t.Fatalf("got error %v, want %v", err, errEmpty)
+	}
+
+	if err := s.Notify("event", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-notified; got != "x" {
+		t.Fatalf("got notification %q, want %q", got, "x")
+	}
+}
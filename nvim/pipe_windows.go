@@ -0,0 +1,188 @@
+package nvim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	modkernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW        = modkernel32.NewProc("CreateEventW")
+	procGetOverlappedResult = modkernel32.NewProc("GetOverlappedResult")
+)
+
+const (
+	errorBrokenPipe syscall.Errno = 109
+	errorPipeBusy   syscall.Errno = 231
+)
+
+var errPipeDeadline = errors.New("nvim: deadlines are not supported on named pipes")
+
+// dialNamedPipe dials the client end of the named pipe address. The pipe is
+// opened for overlapped I/O, so reads and writes can run concurrently.
+func dialNamedPipe(ctx context.Context, address string) (net.Conn, error) {
+	path, err := syscall.UTF16PtrFromString(address)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		h, err := syscall.CreateFile(path,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+		if err == nil {
+			return &pipeConn{h: h, addr: pipeAddr(address)}, nil
+		}
+		if err != errorPipeBusy {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: os.NewSyscallError("CreateFile", err)}
+		}
+
+		// All instances of the pipe are busy. Try again until ctx is done.
+		t := time.NewTimer(10 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// pipeAddr is the net.Addr of a named pipe.
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a net.Conn for the client end of a named pipe.
+type pipeConn struct {
+	h    syscall.Handle
+	addr pipeAddr
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup // I/O operations started before Close
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	n, err := c.io("read", p, syscall.ReadFile)
+	if err == errorBrokenPipe {
+		// Nvim closed the pipe.
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		m, err := c.io("write", p[n:], syscall.WriteFile)
+		n += m
+		if err == errorBrokenPipe {
+			return n, c.opError("write", err)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// io runs the overlapped read or write operation f and waits for the result.
+func (c *pipeConn) io(op string, p []byte, f func(syscall.Handle, []byte, *uint32, *syscall.Overlapped) error) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	event, err := createEvent()
+	if err != nil {
+		return 0, c.opError(op, err)
+	}
+	defer syscall.CloseHandle(event)
+
+	var (
+		o syscall.Overlapped
+		n uint32
+	)
+	o.HEvent = event
+
+	// Start the operation under the lock, so Close cancels all operations
+	// started before the handle is closed.
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, c.opError(op, os.ErrClosed)
+	}
+	c.pending.Add(1)
+	defer c.pending.Done()
+	err = f(c.h, p, &n, &o)
+	c.mu.Unlock()
+
+	if err == syscall.ERROR_IO_PENDING {
+		err = getOverlappedResult(c.h, &o, &n)
+	}
+	switch err {
+	case nil:
+		return int(n), nil
+	case errorBrokenPipe:
+		return int(n), err
+	case syscall.ERROR_OPERATION_ABORTED:
+		return int(n), c.opError(op, os.ErrClosed)
+	default:
+		return int(n), c.opError(op, err)
+	}
+}
+
+func (c *pipeConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "pipe", Addr: c.addr, Err: err}
+}
+
+// Close cancels pending reads and writes and closes the pipe.
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.opError("close", os.ErrClosed)
+	}
+	c.closed = true
+	syscall.CancelIoEx(c.h, nil)
+	c.mu.Unlock()
+
+	c.pending.Wait()
+	return syscall.CloseHandle(c.h)
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.addr }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *pipeConn) SetDeadline(t time.Time) error      { return errPipeDeadline }
+func (c *pipeConn) SetReadDeadline(t time.Time) error  { return errPipeDeadline }
+func (c *pipeConn) SetWriteDeadline(t time.Time) error { return errPipeDeadline }
+
+// createEvent creates a manual-reset event for an overlapped operation.
+func createEvent() (syscall.Handle, error) {
+	r, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if r == 0 {
+		return 0, os.NewSyscallError("CreateEvent", err)
+	}
+	return syscall.Handle(r), nil
+}
+
+// getOverlappedResult waits for the overlapped operation o to complete and
+// stores the number of bytes transferred in n.
+func getOverlappedResult(h syscall.Handle, o *syscall.Overlapped, n *uint32) error {
+	r, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(n)), 1)
+	if r == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno != 0 {
+			return errno
+		}
+		return syscall.EINVAL
+	}
+	return nil
+}
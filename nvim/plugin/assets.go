@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// executable returns the path of the running executable. Tests replace it.
+var executable = os.Executable
+
+// assetDirs returns the directories searched for assets in order of
+// precedence.
+func (p *Plugin) assetDirs() []string {
+	if p.Root != "" {
+		return []string{p.Root}
+	}
+
+	exe, err := executable()
+	if err != nil {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	// The executable is either in the plugin's runtime directory or in a
+	// subdirectory of it, such as "bin".
+	dir := filepath.Dir(exe)
+	return []string{dir, filepath.Dir(dir)}
+}
+
+// AssetPath returns the path of the asset with the slash separated path name
+// relative to the plugin's runtime directory, for example "lua/myplugin.lua"
+// or "queries/go/highlights.scm".
+//
+// AssetPath searches for the asset in the following order:
+//
+//  1. Root, if Root is set.
+//  2. The directory of the executable and its parent directory, if Root is
+//     not set. Symbolic links to the executable are resolved first.
+//  3. The 'runtimepath' directories, using nvim_get_runtime_file.
+//
+// AssetPath returns an error if name is absolute or refers to a path outside
+// the runtime directory, such as "../secret", and an error wrapping
+// os.ErrNotExist if the asset is not found.
+func (p *Plugin) AssetPath(name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("plugin: invalid asset name %q", name)
+	}
+
+	for _, dir := range p.assetDirs() {
+		path := filepath.Join(dir, rel)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, nil
+		}
+	}
+
+	if p.Nvim != nil {
+		files, err := p.Nvim.RuntimeFiles(name, false)
+		if err != nil {
+			return "", err
+		}
+		if len(files) > 0 {
+			return files[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("plugin: asset %s: %w", name, os.ErrNotExist)
+}
+
+// ReadAsset reads the asset with the slash separated path name. See AssetPath
+// for how the asset is located.
+func (p *Plugin) ReadAsset(name string) ([]byte, error) {
+	path, err := p.AssetPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
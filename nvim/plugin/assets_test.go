@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvim-go-plugin-assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, data string) string {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	writeFile("plugin/bin/myplugin", "")
+	binAsset := writeFile("plugin/bin/lua/bin.lua", "bin")
+	parentAsset := writeFile("plugin/lua/parent.lua", "parent")
+	writeFile("plugin/lua/both.lua", "parent")
+	both := writeFile("plugin/bin/lua/both.lua", "bin")
+	rootAsset := writeFile("root/lua/parent.lua", "root")
+
+	saved := executable
+	defer func() { executable = saved }()
+	executable = func() (string, error) {
+		return filepath.Join(dir, "plugin", "bin", "myplugin"), nil
+	}
+
+	tests := map[string]struct {
+		root    string
+		name    string
+		want    string
+		wantErr error
+	}{
+		"ExecutableDir": {
+			name: "lua/bin.lua",
+			want: binAsset,
+		},
+		"ExecutableParentDir": {
+			name: "lua/parent.lua",
+			want: parentAsset,
+		},
+		"ExecutableDirFirst": {
+			name: "lua/both.lua",
+			want: both,
+		},
+		"Root": {
+			root: filepath.Join(dir, "root"),
+			name: "lua/parent.lua",
+			want: rootAsset,
+		},
+		"RootOnly": {
+			root:    filepath.Join(dir, "root"),
+			name:    "lua/bin.lua",
+			wantErr: os.ErrNotExist,
+		},
+		"NotFound": {
+			name:    "lua/missing.lua",
+			wantErr: os.ErrNotExist,
+		},
+		"Directory": {
+			name:    "lua",
+			wantErr: os.ErrNotExist,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{Root: tt.root}
+			got, err := p.AssetPath(tt.name)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("AssetPath(%q) returned error %v, want %v", tt.name, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("AssetPath(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("ReadAsset", func(t *testing.T) {
+		p := &Plugin{}
+		data, err := p.ReadAsset("lua/both.lua")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "bin" {
+			t.Fatalf("ReadAsset returned %q, want %q", data, "bin")
+		}
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		// The names starting with .. refer to existing files outside Root.
+		p := &Plugin{Root: filepath.Join(dir, "root")}
+		for _, name := range []string{
+			"",
+			filepath.Join(dir, "plugin", "lua", "parent.lua"),
+			"..",
+			"../plugin/lua/parent.lua",
+			"lua/../../plugin/lua/parent.lua",
+		} {
+			if _, err := p.AssetPath(name); err == nil {
+				t.Fatalf("AssetPath(%q) returned no error", name)
+			}
+		}
+	})
+}
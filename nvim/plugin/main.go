@@ -2,13 +2,15 @@
 package plugin
 
 import (
-	"bytes"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
+	"strings"
 
+	"github.com/neovim/go-client/msgpack/rpc"
 	"github.com/neovim/go-client/nvim"
 )
 
@@ -20,17 +22,36 @@ import (
 // from Nvim.
 //
 // Applications should use the default logger in the standard log package to
-// write to Nvim's log.
+// write to Nvim's log. Panics in handlers are recovered and written to the log
+// with the stack, and Nvim receives an error for requests.
 //
 // Run the plugin application with the command line option --manifest=hostName
-// to print the plugin manifest to stdout. Add the manifest manually to a
-// Vimscript file. The :UpdateRemotePlugins command is not supported at this
-// time.
+// to print the plugin manifest to stdout. The manifest has the format written
+// by the :UpdateRemotePlugins command, see Plugin.Manifest.
 //
 // If the --manifest=host command line flag is specified, then Main prints the
 // plugin manifest to stdout insead of running the application as a plugin.
 // If the --location=vimfile command line flag is specified, then plugin
-// manifest will be automatically written to .vim file.
+// manifest will be automatically written to .vim file. The section for host
+// in the file is replaced, other sections are kept, and the file is created
+// if it does not exist.
+//
+// To load the plugin with the standard remote plugin mechanism, register the
+// host in the Nvim configuration, for example
+//
+//  call remote#host#Register('hello', 'x', {host -> jobstart(['hello'], {'rpc': v:true})})
+//
+// and write the manifest for the host to the remote plugin manifest file,
+// which Nvim sources at startup:
+//
+//  hello --manifest=hello --location=$NVIM_RPLUGIN_MANIFEST
+//
+// $NVIM_RPLUGIN_MANIFEST is the manifest file used by Nvim. If the variable is
+// not set, use the default path of the file. The :UpdateRemotePlugins command
+// does not query the plugin for its handlers and removes the section for the
+// host from the file, so write the manifest again after running the command.
+//
+//  :help remote-plugin-manifest
 func Main(registerHandlers func(p *Plugin) error) {
 	pluginHost := flag.String("manifest", "", "Write plugin manifest for `host` to stdout")
 	vimFilePath := flag.String("location", "", "Manifest is automatically written to `.vim file`")
@@ -65,22 +86,35 @@ func Main(registerHandlers func(p *Plugin) error) {
 	if err := registerHandlers(p); err != nil {
 		log.Fatal(err)
 	}
-	if err := v.Serve(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- v.Serve()
+	}()
+	if err := p.setup(); err != nil {
+		log.Fatal(err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, rpc.ErrPeerClosed) {
 		log.Fatal(err)
 	}
 }
 
 func overwriteManifest(path, host string, manifest []byte) error {
 	input, err := ioutil.ReadFile(path)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	output := replaceManifest(host, input, manifest)
 	return ioutil.WriteFile(path, output, 0666)
 }
 
+// replaceManifest replaces the manifest section for host in input with
+// manifest, or appends manifest to input if input has no section for host.
+// The section is the comment line naming the host, if any, followed by the
+// remote#host#RegisterPlugin calls for host and the empty lines after them.
 func replaceManifest(host string, input, manifest []byte) []byte {
-	p := regexp.MustCompile(`(?ms)^call remote#host#RegisterPlugin\('` + regexp.QuoteMeta(host) + `'.*?^\\ ]\)$`)
+	h := regexp.QuoteMeta(strings.Replace(host, "'", "''", -1))
+	call := `call remote#host#RegisterPlugin\('` + h + `', .*\n(?:[ \t]*\\ \{.*\n)*[ \t]*\\ \]\)(?:\n|\z)`
+	p := regexp.MustCompile(`(?m)^(?:" ` + regexp.QuoteMeta(host) + ` plugins\n(?:` + call + `)*|(?:` + call + `)+)\n*`)
 	match := p.FindIndex(input)
 	var output []byte
 	if match == nil {
@@ -89,10 +123,6 @@ func replaceManifest(host string, input, manifest []byte) []byte {
 		}
 		output = append(input, manifest...)
 	} else {
-		if match[1] != len(input) {
-			// No need for trailing \n if in middle of file.
-			manifest = bytes.TrimSuffix(manifest, []byte{'\n'})
-		}
 		output = append([]byte{}, input[:match[0]]...)
 		output = append(output, manifest...)
 		output = append(output, input[match[1]:]...)
@@ -2,6 +2,9 @@ package plugin
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -69,3 +72,140 @@ func TestReplaceManifest(t *testing.T) {
 		}
 	}
 }
+
+func TestCommandManifest(t *testing.T) {
+	p := New(nil)
+	p.HandleCommand(&CommandOptions{Name: "Lines", Range: ".", NArgs: "*", Complete: "file", Bang: true}, func(args []string, r [2]int, bang bool) error { return nil })
+	p.HandleCommand(&CommandOptions{Name: "Count", Count: "3", Addr: "buffers", Bar: true, Register: true}, func(n int, register string) {})
+	// Keymaps are not part of the manifest and need no Nvim in manifest mode.
+	if err := p.HandleKeymapExpr("n", "Q", func() (string, error) { return "", nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = "\" P plugins\n" +
+		"call remote#host#RegisterPlugin('P', '0', [\n" +
+		"      \\ {'type': 'command', 'name': 'Count', 'sync': v:false, 'opts': {'addr': 'buffers', 'bar': '', 'count': '3', 'register': ''}},\n" +
+		"      \\ {'type': 'command', 'name': 'Lines', 'sync': v:true, 'opts': {'bang': '', 'complete': 'file', 'nargs': '*', 'range': ''}},\n" +
+		"     \\ ])\n" +
+		"\n\n"
+	if got := string(p.Manifest("P")); got != want {
+		t.Errorf("got manifest\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCommandOptionsValidate(t *testing.T) {
+	tests := map[string]*CommandOptions{
+		"EmptyName":        {},
+		"LowerCaseName":    {Name: "lines"},
+		"InvalidNameChar":  {Name: "Lines_2"},
+		"InvalidNArgs":     {Name: "Lines", NArgs: "2"},
+		"InvalidRange":     {Name: "Lines", Range: "$"},
+		"InvalidCount":     {Name: "Lines", Count: "x"},
+		"RangeAndCount":    {Name: "Lines", Range: "%", Count: "1"},
+		"InvalidAddr":      {Name: "Lines", Range: ".", Addr: "lines_and_more"},
+		"CompleteNoArgs":   {Name: "Lines", Complete: "file"},
+		"CompleteZeroArgs": {Name: "Lines", NArgs: "0", Complete: "file"},
+	}
+	for name, options := range tests {
+		if err := options.validate(); err == nil {
+			t.Errorf("%s: validate() did not return an error", name)
+		}
+	}
+
+	for _, options := range []*CommandOptions{
+		{Name: "Lines2"},
+		{Name: "Lines", Range: "."},
+		{Name: "Lines", Range: "10", Addr: "windows"},
+		{Name: "Lines", Count: "0", NArgs: "?", Complete: "buffer"},
+	} {
+		if err := options.validate(); err != nil {
+			t.Errorf("validate(%+v) returned error %v", options, err)
+		}
+	}
+}
+
+var (
+	manifestCallRegexp = regexp.MustCompile(`^call remote#host#RegisterPlugin\('((?:[^']|'')*)', '((?:[^']|'')*)', \[$`)
+	manifestSpecRegexp = regexp.MustCompile(`^ *\\ \{'type': '(\w+)', 'name': '((?:[^']|'')*)', 'sync': v:(true|false), 'opts': \{(.*)\}\},$`)
+	manifestOptRegexp  = regexp.MustCompile(`'(\w+)': '((?:[^']|'')*)'`)
+)
+
+// parseManifest parses a manifest written by Manifest and returns the specs
+// by path.
+func parseManifest(t *testing.T, host string, manifest string) map[string][]*pluginSpec {
+	unescape := strings.NewReplacer("''", "'").Replace
+
+	lines := strings.Split(manifest, "\n")
+	if lines[0] != `" `+host+` plugins` {
+		t.Fatalf("manifest starts with %q", lines[0])
+	}
+	if !strings.HasSuffix(manifest, "\n\n\n") {
+		t.Fatalf("manifest does not end with two empty lines")
+	}
+
+	specs := make(map[string][]*pluginSpec)
+	path := ""
+	for _, line := range lines[1 : len(lines)-3] {
+		if m := manifestCallRegexp.FindStringSubmatch(line); m != nil {
+			if path != "" {
+				t.Fatalf("nested call %q", line)
+			}
+			if unescape(m[1]) != host {
+				t.Fatalf("call for host %q, want %q", m[1], host)
+			}
+			path = unescape(m[2])
+			continue
+		}
+		if line == `     \ ])` {
+			path = ""
+			continue
+		}
+		m := manifestSpecRegexp.FindStringSubmatch(line)
+		if m == nil || path == "" {
+			t.Fatalf("unexpected line %q", line)
+		}
+		spec := &pluginSpec{Type: m[1], Name: unescape(m[2]), Sync: m[3] == "true", Opts: make(map[string]string)}
+		for _, opt := range manifestOptRegexp.FindAllStringSubmatch(m[4], -1) {
+			spec.Opts[opt[1]] = unescape(opt[2])
+		}
+		specs[path] = append(specs[path], spec)
+	}
+	if path != "" {
+		t.Fatalf("call for path %q not closed", path)
+	}
+	return specs
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	p := New(nil)
+	p.HandleFunction(&FunctionOptions{Name: "Hello", Eval: "expand('%')"}, func(args []string, name string) (string, error) { return "", nil })
+	p.HandleCommand(&CommandOptions{Name: "Lines", Range: "%", NArgs: "?"}, func(args []string, r [2]int) error { return nil })
+	p.HandleAutocmd(&AutocmdOptions{Event: "BufEnter", Pattern: "*.go"}, func() {})
+	p.HandleAutocmd(&AutocmdOptions{Event: "BufEnter", Pattern: "*.go", Group: "it's"}, func() error { return nil })
+
+	manifest := p.Manifest("go-host")
+	got := parseManifest(t, "go-host", string(manifest))
+
+	want := make(map[string][]*pluginSpec)
+	for _, spec := range p.pluginSpecs {
+		want[spec.path()] = append(want[spec.path()], &pluginSpec{Type: spec.Type, Name: spec.Name, Sync: spec.Sync, Opts: spec.Opts})
+	}
+	if len(want) != 2 {
+		t.Fatalf("got %d paths, want 2", len(want))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parsed manifest\n%s\ngot specs %v, want %v", manifest, got, want)
+	}
+
+	// The manifest replaces the section for the host and keeps the section
+	// for other hosts.
+	other := "\" python3 plugins\ncall remote#host#RegisterPlugin('python3', '/rplugin/python3/p.py', [\n      \\ {'sync': v:false, 'name': 'P', 'type': 'command', 'opts': {}},\n     \\ ])\n\n\n"
+	input := other + string(New(nil).Manifest("go-host"))
+	output := string(replaceManifest("go-host", []byte(input), manifest))
+	if want := other + string(manifest); output != want {
+		t.Fatalf("got\n%s\nwant\n%s", output, want)
+	}
+	if again := string(replaceManifest("go-host", []byte(output), manifest)); again != output {
+		t.Fatalf("replace is not idempotent, got\n%s\nwant\n%s", again, output)
+	}
+}
@@ -12,11 +12,23 @@ import (
 
 // Plugin represents a remote plugin.
 type Plugin struct {
-	Nvim        *nvim.Nvim
+	Nvim *nvim.Nvim
+
+	// Root is the plugin's runtime directory searched by AssetPath. If Root is
+	// empty, the directory is derived from the path of the executable.
+	Root string
+
 	pluginSpecs []*pluginSpec
 
 	// Event/pattern counters used to generate unique paths for autocmds.
 	eventPathCounts map[string]int
+
+	// Resources created with the plugin's helpers, released by Cleanup.
+	resources resources
+
+	// setups are run by Main after Serve starts and by RegisterForTests.
+	// They call Nvim, so they cannot run before the replies are read.
+	setups []func() error
 }
 
 // New returns an intialized plugin.
@@ -87,6 +99,48 @@ func (p *Plugin) Handle(method string, fn interface{}) {
 	}
 }
 
+// HandleKeymapExpr maps lhs in mode to an expression mapping that calls fn
+// with rpcrequest. The string returned by fn is executed as the keys of the
+// mapping. The keys are not remapped.
+//
+// The handler is registered immediately, but the mapping is set in Nvim when
+// Main starts serving the connection, or by RegisterForTests in tests.
+//
+// Nvim evaluates the expression with the text locked, so fn must not change
+// buffer text or move the cursor to another window. Nvim is blocked until fn
+// returns, so fn should return quickly. See
+//
+//	:help map-<expr>
+//
+// Special keys in the returned string must be in their internal form; use
+// Nvim.ReplaceTermcodes to translate key notation such as "<Esc>". If fn
+// returns an error, the mapping fails with the error message.
+func (p *Plugin) HandleKeymapExpr(mode, lhs string, fn func() (string, error)) error {
+	if p.Nvim == nil {
+		return nil
+	}
+	method := "keymap_expr:" + mode + ":" + lhs
+	if err := p.Nvim.RegisterHandler(method, fn); err != nil {
+		return err
+	}
+	p.setups = append(p.setups, func() error {
+		return p.Nvim.Map(mode, lhs, "", &nvim.KeyMapOptions{Expr: true, NoRemap: true, Callback: method})
+	})
+	return nil
+}
+
+// setup runs the functions that must wait until the connection is served.
+func (p *Plugin) setup() error {
+	setups := p.setups
+	p.setups = nil
+	for _, f := range setups {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // FunctionOptions specifies function options.
 type FunctionOptions struct {
 	// Name is the name of the function in Nvim. The name must be made of
@@ -139,8 +193,7 @@ func (p *Plugin) HandleFunction(options *FunctionOptions, fn interface{}) {
 // CommandOptions specifies command options.
 type CommandOptions struct {
 	// Name is the name of the command in Nvim. The name must be made of
-	// alphanumeric characters and '_', and must start with a capital
-	// letter.
+	// alphanumeric characters and must start with a capital letter.
 	Name string
 
 	// NArgs specifies the number command arguments.
@@ -182,6 +235,8 @@ type CommandOptions struct {
 	//  loaded_buffers  Range for loaded buffers
 	//  windows         Range for windows
 	//  tabs            Range for tab pages
+	//  quickfix        Range for quickfix entries
+	//  other           Other kind of range
 	//
 	//  :help command-addr
 	Addr string
@@ -224,7 +279,15 @@ type CommandOptions struct {
 // evaluate in Nvim from the type of fn's last argument. See the
 // HandleFunction documentation for information on how the expression is
 // generated.
+//
+// HandleCommand panics if the options are not valid for the :command
+// command, for example if both Range and Count are specified, or if Complete
+// is specified for a command without arguments.
 func (p *Plugin) HandleCommand(options *CommandOptions, fn interface{}) {
+	if err := options.validate(); err != nil {
+		panic(err)
+	}
+
 	m := make(map[string]string)
 
 	if options.NArgs != "" {
@@ -233,9 +296,10 @@ func (p *Plugin) HandleCommand(options *CommandOptions, fn interface{}) {
 
 	if options.Range != "" {
 		if options.Range == "." {
-			options.Range = ""
+			m["range"] = ""
+		} else {
+			m["range"] = options.Range
 		}
-		m["range"] = options.Range
 	} else if options.Count != "" {
 		m["count"] = options.Count
 	}
@@ -273,6 +337,71 @@ func (p *Plugin) HandleCommand(options *CommandOptions, fn interface{}) {
 	})
 }
 
+// validate returns an error if the options do not form a valid :command
+// command.
+func (options *CommandOptions) validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("plugin: command %q: %s", options.Name, fmt.Sprintf(format, args...))
+	}
+
+	if options.Name == "" || options.Name[0] < 'A' || options.Name[0] > 'Z' {
+		return invalid("name must start with a capital letter")
+	}
+	for _, c := range options.Name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return invalid("name must be made of alphanumeric characters")
+		}
+	}
+
+	switch options.NArgs {
+	case "", "0", "1", "*", "?", "+":
+	default:
+		return invalid("invalid NArgs %q", options.NArgs)
+	}
+
+	switch options.Range {
+	case "", ".", "%":
+	default:
+		if !isNumber(options.Range) {
+			return invalid("invalid Range %q", options.Range)
+		}
+	}
+
+	if options.Count != "" {
+		if !isNumber(options.Count) {
+			return invalid("invalid Count %q", options.Count)
+		}
+		if options.Range != "" {
+			return invalid("Range and Count are mutually exclusive")
+		}
+	}
+
+	switch options.Addr {
+	case "", "lines", "arguments", "buffers", "loaded_buffers", "windows", "tabs", "quickfix", "other":
+	default:
+		return invalid("invalid Addr %q", options.Addr)
+	}
+
+	if options.Complete != "" && (options.NArgs == "" || options.NArgs == "0") {
+		return invalid("Complete requires NArgs that allows arguments")
+	}
+
+	return nil
+}
+
+// isNumber reports whether s is a non-empty string of decimal digits.
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // AutocmdOptions specifies autocmd options.
 type AutocmdOptions struct {
 	// Event is the event name.
@@ -291,12 +420,19 @@ type AutocmdOptions struct {
 	//  :help autocmd-nested
 	Nested bool
 
-	// Eval is evaluated in Nvim and the result is passed the the handler
+	// Eval is evaluated in Nvim and the result is passed to the handler
 	// function.
 	Eval string
 }
 
-// HandleAutocmd registers fn as a handler an autocmnd event.
+// HandleAutocmd registers fn as a handler for an autocmd event. The arguments
+// to the function fn are:
+//
+//  v *nvim.Nvim        optional
+//  eval interface{}    when options.Eval != ""
+//
+// If fn returns an error, Nvim waits for fn to return when the event is
+// triggered. If fn has no results, fn is called asynchronously.
 //
 // If options.Eval == "*", then HandleAutocmd constructs the expression to
 // evaluate in Nvim from the type of fn's last argument. See the HandleFunction
@@ -346,8 +482,14 @@ func (p *Plugin) RegisterForTests() error {
 			return err
 		}
 	}
-	err := p.Nvim.Call("remote#host#Register", nil, host, "x", p.Nvim.ChannelID())
-	return err
+	client, err := p.Nvim.ChannelIDErr()
+	if err != nil {
+		return err
+	}
+	if err := p.Nvim.Call("remote#host#Register", nil, host, "x", client); err != nil {
+		return err
+	}
+	return p.setup()
 }
 
 func eval(eval string, f interface{}) string {
@@ -411,6 +553,13 @@ func (a byServiceMethod) Len() int           { return len(a) }
 func (a byServiceMethod) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byServiceMethod) Less(i, j int) bool { return a[i].sm < a[j].sm }
 
+// Manifest returns the plugin manifest for host in the format written by the
+// :UpdateRemotePlugins command to the remote plugin manifest file. The
+// manifest starts with a comment line naming the host, followed by a call to
+// remote#host#RegisterPlugin with the specs of the handlers for each path,
+// and ends with two empty lines.
+//
+//  :help remote-plugin-manifest
 func (p *Plugin) Manifest(host string) []byte {
 	var buf bytes.Buffer
 
@@ -418,23 +567,25 @@ func (p *Plugin) Manifest(host string) []byte {
 	sort.Sort(byServiceMethod(p.pluginSpecs))
 	escape := strings.NewReplacer("'", "''").Replace
 
+	fmt.Fprintf(&buf, "\" %s plugins\n", host)
+
 	prevPath := ""
-	for _, spec := range p.pluginSpecs {
+	for i, spec := range p.pluginSpecs {
 		path := spec.path()
-		if path != prevPath {
-			if prevPath != "" {
-				fmt.Fprintf(&buf, "\\ )")
+		if i == 0 || path != prevPath {
+			if i > 0 {
+				fmt.Fprintf(&buf, "     \\ ])\n")
 			}
-			fmt.Fprintf(&buf, "call remote#host#RegisterPlugin('%s', '%s', [\n", host, path)
+			fmt.Fprintf(&buf, "call remote#host#RegisterPlugin('%s', '%s', [\n", escape(host), escape(path))
 			prevPath = path
 		}
 
-		sync := "0"
+		sync := "v:false"
 		if spec.Sync {
-			sync = "1"
+			sync = "v:true"
 		}
 
-		fmt.Fprintf(&buf, "\\ {'type': '%s', 'name': '%s', 'sync': %s, 'opts': {", spec.Type, spec.Name, sync)
+		fmt.Fprintf(&buf, "      \\ {'type': '%s', 'name': '%s', 'sync': %s, 'opts': {", spec.Type, escape(spec.Name), sync)
 
 		var keys []string
 		for k := range spec.Opts {
@@ -450,8 +601,9 @@ func (p *Plugin) Manifest(host string) []byte {
 
 		fmt.Fprintf(&buf, "}},\n")
 	}
-	if prevPath != "" {
-		fmt.Fprintf(&buf, "\\ ])\n")
+	if len(p.pluginSpecs) > 0 {
+		fmt.Fprintf(&buf, "     \\ ])\n")
 	}
+	fmt.Fprintf(&buf, "\n\n")
 	return buf.Bytes()
 }
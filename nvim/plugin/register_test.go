@@ -1,10 +1,14 @@
 package plugin_test
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/neovim/go-client/nvim"
 	"github.com/neovim/go-client/nvim/plugin"
@@ -76,3 +80,123 @@ func TestRegister(t *testing.T) {
 		}
 	}
 }
+
+func TestRegisterCommand(t *testing.T) {
+	p, cleanup := newEmbeddedPlugin(t)
+	defer cleanup()
+
+	var (
+		lines [2]int
+		count int
+		bang  bool
+	)
+	p.HandleCommand(&plugin.CommandOptions{Name: "Lines", Range: ".", Bang: true}, func(r [2]int, b bool) error {
+		lines, bang = r, b
+		return nil
+	})
+	p.HandleCommand(&plugin.CommandOptions{Name: "Count", Count: "7"}, func(n int) error {
+		count = n
+		return nil
+	})
+
+	if err := p.RegisterForTests(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Nvim.SetBufferLines(0, 0, -1, true, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Nvim.Command("2,3Lines!"); err != nil {
+		t.Fatal(err)
+	}
+	if want := [2]int{2, 3}; lines != want || !bang {
+		t.Errorf("Lines got range %v, bang %v, want %v, true", lines, bang, want)
+	}
+
+	if err := p.Nvim.Command("Count"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("Count got count %d, want 7", count)
+	}
+	if err := p.Nvim.Command("Count 3"); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Count 3 got count %d, want 3", count)
+	}
+}
+
+func TestRegisterAutocmd(t *testing.T) {
+	p, cleanup := newEmbeddedPlugin(t)
+	defer cleanup()
+
+	var written []string
+	p.HandleAutocmd(&plugin.AutocmdOptions{Event: "BufWritePost", Pattern: "*.txt", Eval: "expand('<afile>:t')"}, func(name string) error {
+		written = append(written, name)
+		return nil
+	})
+	asyncWritten := make(chan string, 2)
+	p.HandleAutocmd(&plugin.AutocmdOptions{Event: "BufWritePost", Pattern: "*.txt", Eval: "expand('<afile>:t')"}, func(name string) {
+		asyncWritten <- name
+	})
+
+	if err := p.RegisterForTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "nvim-go-client-autocmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.txt", "b.go"} {
+		if err := p.Nvim.Command("write " + filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"a.txt"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("sync handler got %v, want %v", written, want)
+	}
+	select {
+	case name := <-asyncWritten:
+		if name != "a.txt" {
+			t.Errorf("async handler got %q, want %q", name, "a.txt")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("async handler was not called")
+	}
+}
+
+func TestHandleKeymapExpr(t *testing.T) {
+	p, cleanup := newEmbeddedPlugin(t)
+	defer cleanup()
+
+	var calls int
+	if err := p.HandleKeymapExpr("n", "Q", func() (string, error) {
+		calls++
+		return "ihello\x1b", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RegisterForTests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Nvim.FeedKeys("Q", "x", false); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := p.Nvim.CurrentLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; string(line) != want {
+		t.Errorf("got current line %q, want %q", line, want)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"sync"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+// extmark represents an extmark created by the plugin.
+type extmark struct {
+	buffer nvim.Buffer
+	nsID   int
+	id     int
+}
+
+// resources records the Nvim resources created by the plugin.
+type resources struct {
+	mu         sync.Mutex
+	buffers    []nvim.Buffer
+	windows    []nvim.Window
+	extmarks   []extmark
+	namespaces []int
+}
+
+// CreateBuffer creates a new buffer like nvim.Nvim.CreateBuffer and records
+// it for deletion by Cleanup.
+func (p *Plugin) CreateBuffer(listed, scratch bool) (nvim.Buffer, error) {
+	buffer, err := p.Nvim.CreateBuffer(listed, scratch)
+	if err != nil {
+		return buffer, err
+	}
+
+	p.resources.mu.Lock()
+	p.resources.buffers = append(p.resources.buffers, buffer)
+	p.resources.mu.Unlock()
+
+	return buffer, nil
+}
+
+// OpenWindow opens a new window like nvim.Nvim.OpenWindow and records it for
+// closing by Cleanup.
+func (p *Plugin) OpenWindow(buffer nvim.Buffer, enter bool, config *nvim.WindowConfig) (nvim.Window, error) {
+	window, err := p.Nvim.OpenWindow(buffer, enter, config)
+	if err != nil {
+		return window, err
+	}
+
+	p.resources.mu.Lock()
+	p.resources.windows = append(p.resources.windows, window)
+	p.resources.mu.Unlock()
+
+	return window, nil
+}
+
+// SetExtmark creates or updates an extmark like nvim.Nvim.SetBufferExtmark
+// and records it for deletion by Cleanup.
+func (p *Plugin) SetExtmark(buffer nvim.Buffer, nsID, line, col int, opts map[string]interface{}) (int, error) {
+	id, err := p.Nvim.SetBufferExtmark(buffer, nsID, line, col, opts)
+	if err != nil {
+		return id, err
+	}
+
+	p.resources.mu.Lock()
+	p.resources.extmarks = append(p.resources.extmarks, extmark{buffer: buffer, nsID: nsID, id: id})
+	p.resources.mu.Unlock()
+
+	return id, nil
+}
+
+// CreateNamespace creates or gets a namespace like nvim.Nvim.CreateNamespace
+// and records it for clearing by Cleanup.
+//
+// Nvim does not delete namespaces, so Cleanup clears the highlights,
+// extmarks and virtual text in the namespace from all buffers instead.
+func (p *Plugin) CreateNamespace(name string) (int, error) {
+	nsID, err := p.Nvim.CreateNamespace(name)
+	if err != nil {
+		return nsID, err
+	}
+
+	p.resources.mu.Lock()
+	p.resources.namespaces = append(p.resources.namespaces, nsID)
+	p.resources.mu.Unlock()
+
+	return nsID, nil
+}
+
+// Cleanup deletes the extmarks, clears the namespaces, closes the windows and
+// deletes the buffers created with the plugin's resource helpers.
+//
+// Resources already deleted in Nvim, for example a buffer wiped out by the
+// user, are skipped. Cleanup attempts to release every resource and returns
+// the first error encountered. The recorded resources are forgotten in any
+// case, so Cleanup can be called again after creating new resources.
+func (p *Plugin) Cleanup() error {
+	p.resources.mu.Lock()
+	extmarks := p.resources.extmarks
+	namespaces := p.resources.namespaces
+	windows := p.resources.windows
+	buffers := p.resources.buffers
+	p.resources.extmarks = nil
+	p.resources.namespaces = nil
+	p.resources.windows = nil
+	p.resources.buffers = nil
+	p.resources.mu.Unlock()
+
+	v := p.Nvim
+	var firstErr error
+	check := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, m := range extmarks {
+		valid, err := v.IsBufferValid(m.buffer)
+		if err != nil || !valid {
+			check(err)
+			continue
+		}
+		_, err = v.DeleteBufferExtmark(m.buffer, m.nsID, m.id)
+		check(err)
+	}
+
+	if len(namespaces) > 0 {
+		allBuffers, err := v.Buffers()
+		check(err)
+		for _, nsID := range namespaces {
+			for _, buffer := range allBuffers {
+				check(v.ClearBufferNamespace(buffer, nsID, 0, -1))
+			}
+		}
+	}
+
+	for _, window := range windows {
+		valid, err := v.IsWindowValid(window)
+		if err != nil || !valid {
+			check(err)
+			continue
+		}
+		check(v.CloseWindow(window, true))
+	}
+
+	for _, buffer := range buffers {
+		valid, err := v.IsBufferValid(buffer)
+		if err != nil || !valid {
+			check(err)
+			continue
+		}
+		check(v.DeleteBuffer(buffer, map[string]bool{"force": true}))
+	}
+
+	return firstErr
+}
@@ -0,0 +1,92 @@
+package plugin_test
+
+import (
+	"testing"
+
+	"github.com/neovim/go-client/nvim"
+)
+
+func TestCleanup(t *testing.T) {
+	p, cleanup := newEmbeddedPlugin(t)
+	defer cleanup()
+
+	v := p.Nvim
+
+	buffer, err := p.CreateBuffer(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.SetBufferLines(buffer, 0, -1, true, [][]byte{[]byte("hello"), []byte("world")}); err != nil {
+		t.Fatal(err)
+	}
+
+	nsID, err := p.CreateNamespace("test_plugin_cleanup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetExtmark(buffer, nsID, 0, 0, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// extmark created without the plugin helper, cleared through the namespace.
+	current, err := v.CurrentBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.SetBufferExtmark(current, nsID, 0, 0, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	window, err := p.OpenWindow(buffer, false, &nvim.WindowConfig{
+		Relative: "editor",
+		Width:    10,
+		Height:   2,
+		Row:      1,
+		Col:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// buffer deleted before Cleanup is skipped.
+	deleted, err := p.CreateBuffer(false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.DeleteBuffer(deleted, map[string]bool{"force": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	valid, err := v.IsWindowValid(window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Fatal("window is not closed")
+	}
+
+	valid, err = v.IsBufferValid(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Fatal("buffer is not deleted")
+	}
+
+	extmarks, err := v.BufferExtmarks(current, nsID, 0, -1, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extmarks) != 0 {
+		t.Fatalf("got %d extmarks in namespace but want 0", len(extmarks))
+	}
+
+	// all resources are forgotten after Cleanup.
+	if err := p.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+}
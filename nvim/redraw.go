@@ -0,0 +1,693 @@
+package nvim
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/neovim/go-client/msgpack"
+	"github.com/neovim/go-client/msgpack/rpc"
+)
+
+// GridCell represents a run of identical cells in a grid_line redraw event.
+type GridCell struct {
+	// Text is the text of the cell. Text is only valid until the GridLine
+	// handler returns.
+	Text []byte
+
+	// HLID is the highlight ID of the cell. When Nvim omits the highlight ID,
+	// HLID is set to the ID of the previous cell in the event.
+	HLID int
+
+	// Repeat is the number of times the cell is repeated.
+	Repeat int
+}
+
+// RedrawHandler specifies the functions called for the events in redraw
+// notifications. Events for a nil function are passed to Other, or skipped if
+// Other is nil.
+//
+//	:help ui-linegrid
+//	:help ui-global
+type RedrawHandler struct {
+	// GridResize is called for the grid_resize event.
+	GridResize func(grid, width, height int)
+
+	// GridClear is called for the grid_clear event.
+	GridClear func(grid int)
+
+	// GridDestroy is called for the grid_destroy event.
+	GridDestroy func(grid int)
+
+	// GridCursorGoto is called for the grid_cursor_goto event.
+	GridCursorGoto func(grid, row, col int)
+
+	// GridLine is called for the grid_line event. The cells slice and the
+	// text of the cells are reused for the next event.
+	GridLine func(grid, row, colStart int, cells []GridCell)
+
+	// GridScroll is called for the grid_scroll event.
+	GridScroll func(grid, top, bot, left, right, rows, cols int)
+
+	// DefaultColorsSet is called for the default_colors_set event.
+	DefaultColorsSet func(rgbFG, rgbBG, rgbSP, ctermFG, ctermBG int)
+
+	// ModeChange is called for the mode_change event.
+	ModeChange func(mode string, modeIdx int)
+
+	// Flush is called for the flush event.
+	Flush func()
+
+	// Other is called with the decoded arguments of other events.
+	Other func(name string, args []interface{})
+}
+
+// RegisterRedrawHandler registers h as the handler for the redraw
+// notifications sent to a UI attached with AttachUI.
+//
+// The events are decoded and passed to h one at a time as they are read from
+// Nvim, without decoding the whole notification to Go values first, and the
+// buffers for grid_line cells are reused between events. The functions of h
+// are called on the goroutine reading from Nvim. They must not call Nvim,
+// which would deadlock, and should return quickly.
+//
+// The connection to Nvim is closed if a redraw notification cannot be
+// decoded.
+func (v *Nvim) RegisterRedrawHandler(h *RedrawHandler) error {
+	r := &redrawDecoder{h: h}
+	return v.register("redraw", func(ep *rpc.Endpoint) error {
+		return ep.RegisterNotificationDecoder("redraw", r.decode)
+	})
+}
+
+// RedrawEvent is an event in a redraw notification passed to the function
+// registered with HandleRedraw. The event is one of the *Event types in this
+// package, or an OtherRedrawEvent for events without a type.
+type RedrawEvent interface {
+	// Name returns the name of the event, such as "grid_line".
+	Name() string
+}
+
+// GridResizeEvent represents the grid_resize redraw event.
+type GridResizeEvent struct {
+	Grid, Width, Height int
+}
+
+// GridClearEvent represents the grid_clear redraw event.
+type GridClearEvent struct {
+	Grid int
+}
+
+// GridDestroyEvent represents the grid_destroy redraw event.
+type GridDestroyEvent struct {
+	Grid int
+}
+
+// GridCursorGotoEvent represents the grid_cursor_goto redraw event.
+type GridCursorGotoEvent struct {
+	Grid, Row, Col int
+}
+
+// GridLineEvent represents the grid_line redraw event.
+type GridLineEvent struct {
+	Grid, Row, ColStart int
+
+	// Cells are the cells of the line starting at ColStart. Unlike the
+	// cells passed to RedrawHandler.GridLine, the cells are not reused.
+	Cells []GridCell
+}
+
+// GridScrollEvent represents the grid_scroll redraw event.
+type GridScrollEvent struct {
+	Grid, Top, Bot, Left, Right, Rows, Cols int
+}
+
+// DefaultColorsSetEvent represents the default_colors_set redraw event.
+type DefaultColorsSetEvent struct {
+	RGBFG, RGBBG, RGBSP, CtermFG, CtermBG int
+}
+
+// ModeChangeEvent represents the mode_change redraw event.
+type ModeChangeEvent struct {
+	Mode    string
+	ModeIdx int
+}
+
+// FlushEvent represents the flush redraw event.
+type FlushEvent struct{}
+
+// OtherRedrawEvent represents a redraw event without a type in this package.
+type OtherRedrawEvent struct {
+	Event string
+	Args  []interface{}
+}
+
+// Name implements RedrawEvent.
+func (GridResizeEvent) Name() string { return "grid_resize" }
+
+// Name implements RedrawEvent.
+func (GridClearEvent) Name() string { return "grid_clear" }
+
+// Name implements RedrawEvent.
+func (GridDestroyEvent) Name() string { return "grid_destroy" }
+
+// Name implements RedrawEvent.
+func (GridCursorGotoEvent) Name() string { return "grid_cursor_goto" }
+
+// Name implements RedrawEvent.
+func (GridLineEvent) Name() string { return "grid_line" }
+
+// Name implements RedrawEvent.
+func (GridScrollEvent) Name() string { return "grid_scroll" }
+
+// Name implements RedrawEvent.
+func (DefaultColorsSetEvent) Name() string { return "default_colors_set" }
+
+// Name implements RedrawEvent.
+func (ModeChangeEvent) Name() string { return "mode_change" }
+
+// Name implements RedrawEvent.
+func (FlushEvent) Name() string { return "flush" }
+
+// Name implements RedrawEvent.
+func (e OtherRedrawEvent) Name() string { return e.Event }
+
+// HandleRedraw registers fn as the handler for the redraw notifications sent
+// to a UI attached with AttachUIWithOptions or AttachUI. fn is called once for
+// each notification with the events of the notification in order. The events
+// are not reused, so fn can keep them.
+//
+// HandleRedraw replaces the handler registered with RegisterRedrawHandler.
+// Like the functions of a RedrawHandler, fn is called on the goroutine reading
+// from Nvim. It must not call Nvim, which would deadlock, and should return
+// quickly.
+func (v *Nvim) HandleRedraw(fn func(events []RedrawEvent)) error {
+	decode := redrawEventsDecoder(fn)
+	return v.register("redraw", func(ep *rpc.Endpoint) error {
+		return ep.RegisterNotificationDecoder("redraw", decode)
+	})
+}
+
+// redrawEventsDecoder returns a notification decoder that collects the events
+// of a redraw notification and passes them to fn.
+func redrawEventsDecoder(fn func(events []RedrawEvent)) func(dec *msgpack.Decoder, n int) error {
+	var events []RedrawEvent
+	h := &RedrawHandler{
+		GridResize: func(grid, width, height int) {
+			events = append(events, GridResizeEvent{Grid: grid, Width: width, Height: height})
+		},
+		GridClear: func(grid int) {
+			events = append(events, GridClearEvent{Grid: grid})
+		},
+		GridDestroy: func(grid int) {
+			events = append(events, GridDestroyEvent{Grid: grid})
+		},
+		GridCursorGoto: func(grid, row, col int) {
+			events = append(events, GridCursorGotoEvent{Grid: grid, Row: row, Col: col})
+		},
+		GridLine: func(grid, row, colStart int, cells []GridCell) {
+			events = append(events, GridLineEvent{Grid: grid, Row: row, ColStart: colStart, Cells: copyCells(cells)})
+		},
+		GridScroll: func(grid, top, bot, left, right, rows, cols int) {
+			events = append(events, GridScrollEvent{Grid: grid, Top: top, Bot: bot, Left: left, Right: right, Rows: rows, Cols: cols})
+		},
+		DefaultColorsSet: func(rgbFG, rgbBG, rgbSP, ctermFG, ctermBG int) {
+			events = append(events, DefaultColorsSetEvent{RGBFG: rgbFG, RGBBG: rgbBG, RGBSP: rgbSP, CtermFG: ctermFG, CtermBG: ctermBG})
+		},
+		ModeChange: func(mode string, modeIdx int) {
+			events = append(events, ModeChangeEvent{Mode: mode, ModeIdx: modeIdx})
+		},
+		Flush: func() {
+			events = append(events, FlushEvent{})
+		},
+		Other: func(name string, args []interface{}) {
+			events = append(events, OtherRedrawEvent{Event: name, Args: args})
+		},
+	}
+	r := &redrawDecoder{h: h}
+	return func(dec *msgpack.Decoder, n int) error {
+		err := r.decode(dec, n)
+		batch := events
+		events = nil
+		if err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			fn(batch)
+		}
+		return nil
+	}
+}
+
+// copyCells returns a copy of cells with the text of the cells in a single new
+// buffer.
+func copyCells(cells []GridCell) []GridCell {
+	n := 0
+	for _, cell := range cells {
+		n += len(cell.Text)
+	}
+	text := make([]byte, 0, n)
+	result := make([]GridCell, len(cells))
+	for i, cell := range cells {
+		start := len(text)
+		text = append(text, cell.Text...)
+		cell.Text = text[start:len(text):len(text)]
+		result[i] = cell
+	}
+	return result
+}
+
+// AttachUIWithOptions registers the client as a remote UI with the options in
+// opts, like AttachUI. The Width, Height and ChannelID fields of opts are
+// ignored. The ext_linegrid option is always enabled, so the redraw events can
+// be received with HandleRedraw or RegisterRedrawHandler.
+//
+//	:help ui-option
+func (v *Nvim) AttachUIWithOptions(width, height int, opts *UI) error {
+	return v.AttachUI(width, height, uiAttachOptions(opts))
+}
+
+// AttachUIWithOptions registers the client as a remote UI with the options in
+// opts. See Nvim.AttachUIWithOptions.
+func (b *Batch) AttachUIWithOptions(width, height int, opts *UI) {
+	b.AttachUI(width, height, uiAttachOptions(opts))
+}
+
+// uiAttachOptions returns the nvim_ui_attach options for opts.
+func uiAttachOptions(opts *UI) map[string]interface{} {
+	options := map[string]interface{}{"ext_linegrid": true}
+	if opts == nil {
+		return options
+	}
+	options["rgb"] = opts.RGB
+	for name, enabled := range map[string]bool{
+		"ext_popupmenu":  opts.ExtPopupmenu,
+		"ext_tabline":    opts.ExtTabline,
+		"ext_cmdline":    opts.ExtCmdline,
+		"ext_wildmenu":   opts.ExtWildmenu,
+		"ext_messages":   opts.ExtMessages,
+		"ext_multigrid":  opts.ExtMultigrid,
+		"ext_hlstate":    opts.ExtHlstate,
+		"ext_termcolors": opts.ExtTermcolors,
+	} {
+		if enabled {
+			options[name] = true
+		}
+	}
+	return options
+}
+
+// redrawDecoder decodes redraw notifications for a RedrawHandler.
+type redrawDecoder struct {
+	h    *RedrawHandler
+	dec  *msgpack.Decoder
+	args [7]int
+
+	// Buffers reused for grid_line events.
+	cells   []GridCell
+	offsets []int
+	text    []byte
+}
+
+// decode decodes the n event batches of a redraw notification. Each batch is
+// an array of the event name followed by the arguments of one or more events.
+func (r *redrawDecoder) decode(dec *msgpack.Decoder, n int) error {
+	r.dec = dec
+	for i := 0; i < n; i++ {
+		m, err := r.arrayLen()
+		if err != nil {
+			return err
+		}
+		if m == 0 {
+			continue
+		}
+		p, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		name := string(p)
+		for j := 1; j < m; j++ {
+			if err := r.event(name); err != nil {
+				return fmt.Errorf("nvim: redraw %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// event decodes the arguments of one event and calls the handler.
+func (r *redrawDecoder) event(name string) error {
+	h := r.h
+	switch {
+	case name == "grid_resize" && h.GridResize != nil:
+		args, err := r.ints(3)
+		if err != nil {
+			return err
+		}
+		h.GridResize(args[0], args[1], args[2])
+
+	case name == "grid_clear" && h.GridClear != nil:
+		args, err := r.ints(1)
+		if err != nil {
+			return err
+		}
+		h.GridClear(args[0])
+
+	case name == "grid_destroy" && h.GridDestroy != nil:
+		args, err := r.ints(1)
+		if err != nil {
+			return err
+		}
+		h.GridDestroy(args[0])
+
+	case name == "grid_cursor_goto" && h.GridCursorGoto != nil:
+		args, err := r.ints(3)
+		if err != nil {
+			return err
+		}
+		h.GridCursorGoto(args[0], args[1], args[2])
+
+	case name == "grid_line" && h.GridLine != nil:
+		return r.gridLine()
+
+	case name == "grid_scroll" && h.GridScroll != nil:
+		args, err := r.ints(7)
+		if err != nil {
+			return err
+		}
+		h.GridScroll(args[0], args[1], args[2], args[3], args[4], args[5], args[6])
+
+	case name == "default_colors_set" && h.DefaultColorsSet != nil:
+		args, err := r.ints(5)
+		if err != nil {
+			return err
+		}
+		h.DefaultColorsSet(args[0], args[1], args[2], args[3], args[4])
+
+	case name == "mode_change" && h.ModeChange != nil:
+		m, err := r.argsLen(2)
+		if err != nil {
+			return err
+		}
+		mode, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		modeIdx, err := r.int()
+		if err != nil {
+			return err
+		}
+		if err := r.skip(m - 2); err != nil {
+			return err
+		}
+		h.ModeChange(string(mode), modeIdx)
+
+	case name == "flush" && h.Flush != nil:
+		if err := r.skip(1); err != nil {
+			return err
+		}
+		h.Flush()
+
+	case h.Other != nil:
+		var args []interface{}
+		err := r.dec.Decode(&args)
+		if _, ok := err.(*msgpack.DecodeConvertError); err != nil && !ok {
+			return err
+		}
+		h.Other(name, args)
+
+	default:
+		return r.skip(1)
+	}
+	return nil
+}
+
+// gridLine decodes the arguments of a grid_line event and calls the handler.
+func (r *redrawDecoder) gridLine() error {
+	m, err := r.argsLen(4)
+	if err != nil {
+		return err
+	}
+	var pos [3]int
+	for i := range pos {
+		if pos[i], err = r.int(); err != nil {
+			return err
+		}
+	}
+	n, err := r.arrayLen()
+	if err != nil {
+		return err
+	}
+
+	r.cells = r.cells[:0]
+	r.offsets = r.offsets[:0]
+	r.text = r.text[:0]
+	hlID := 0
+	for i := 0; i < n; i++ {
+		k, err := r.arrayLen()
+		if err != nil {
+			return err
+		}
+		if k < 1 {
+			return fmt.Errorf("invalid cell length %d", k)
+		}
+		text, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		r.offsets = append(r.offsets, len(r.text))
+		r.text = append(r.text, text...)
+
+		cell := GridCell{HLID: hlID, Repeat: 1}
+		if k > 1 {
+			if hlID, err = r.int(); err != nil {
+				return err
+			}
+			cell.HLID = hlID
+		}
+		if k > 2 {
+			if cell.Repeat, err = r.int(); err != nil {
+				return err
+			}
+		}
+		if err := r.skip(k - 3); err != nil {
+			return err
+		}
+		r.cells = append(r.cells, cell)
+	}
+
+	// The text buffer may be reallocated while appending, so slice the text of
+	// the cells after all cells are read.
+	r.offsets = append(r.offsets, len(r.text))
+	for i := range r.cells {
+		start, end := r.offsets[i], r.offsets[i+1]
+		r.cells[i].Text = r.text[start:end:end]
+	}
+
+	// Skip arguments added in later versions of the protocol, like wrap.
+	if err := r.skip(m - 4); err != nil {
+		return err
+	}
+
+	r.h.GridLine(pos[0], pos[1], pos[2], r.cells)
+	return nil
+}
+
+// ints decodes an argument array of at least n integers. The returned slice
+// is reused by the next call.
+func (r *redrawDecoder) ints(n int) ([]int, error) {
+	m, err := r.argsLen(n)
+	if err != nil {
+		return nil, err
+	}
+	args := r.args[:n]
+	for i := range args {
+		if args[i], err = r.int(); err != nil {
+			return nil, err
+		}
+	}
+	return args, r.skip(m - n)
+}
+
+// argsLen decodes the length of an argument array with at least n elements.
+func (r *redrawDecoder) argsLen(n int) (int, error) {
+	m, err := r.arrayLen()
+	if err != nil {
+		return 0, err
+	}
+	if m < n {
+		r.skip(m)
+		return 0, fmt.Errorf("got %d arguments, want %d", m, n)
+	}
+	return m, nil
+}
+
+func (r *redrawDecoder) arrayLen() (int, error) {
+	if err := r.dec.Unpack(); err != nil {
+		return 0, err
+	}
+	if r.dec.Type() != msgpack.ArrayLen {
+		r.dec.Skip()
+		return 0, fmt.Errorf("expected array, found %s", r.dec.Type())
+	}
+	return r.dec.Len(), nil
+}
+
+func (r *redrawDecoder) int() (int, error) {
+	if err := r.dec.Unpack(); err != nil {
+		return 0, err
+	}
+	switch r.dec.Type() {
+	case msgpack.Int:
+		return int(r.dec.Int()), nil
+	case msgpack.Uint:
+		return int(r.dec.Uint()), nil
+	}
+	r.dec.Skip()
+	return 0, fmt.Errorf("expected integer, found %s", r.dec.Type())
+}
+
+// bytes decodes a string. The returned slice is only valid until the next
+// value is decoded.
+func (r *redrawDecoder) bytes() ([]byte, error) {
+	if err := r.dec.Unpack(); err != nil {
+		return nil, err
+	}
+	switch r.dec.Type() {
+	case msgpack.String, msgpack.Binary:
+		return r.dec.BytesNoCopy(), nil
+	}
+	r.dec.Skip()
+	return nil, fmt.Errorf("expected string, found %s", r.dec.Type())
+}
+
+// skip skips n values.
+func (r *redrawDecoder) skip(n int) error {
+	for ; n > 0; n-- {
+		if err := r.dec.Unpack(); err != nil {
+			return err
+		}
+		if err := r.dec.Skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UIClientOptions specifies options for NewUIClient.
+type UIClientOptions struct {
+	// Attach is the options passed to AttachUI. The ext_linegrid option is
+	// always enabled.
+	Attach map[string]interface{}
+
+	// Handler receives the redraw events. The UIClient updates its grid
+	// sizes before calling the handler.
+	Handler *RedrawHandler
+
+	// OnResize is called when a grid is resized, after the size reported by
+	// the UIClient is updated. Like the functions of Handler, OnResize is
+	// called on the goroutine reading from Nvim and must not call Nvim.
+	OnResize func(grid, width, height int)
+}
+
+// UIClient is a UI attached to Nvim that tracks the size of the grids. The
+// methods of UIClient are safe for concurrent use.
+type UIClient struct {
+	v        *Nvim
+	onResize func(grid, width, height int)
+
+	mu    sync.Mutex
+	grids map[int][2]int
+}
+
+// DefaultGrid is the ID of the global grid used by Nvim for the whole screen.
+const DefaultGrid = 1
+
+// NewUIClient attaches a UI to Nvim with the requested width and height and
+// returns a client that tracks the size of the grids from the grid_resize and
+// grid_destroy redraw events. The size of the default grid is the requested
+// size until Nvim reports the actual size.
+//
+// NewUIClient registers the redraw handler with RegisterRedrawHandler, so
+// redraw events must be received through opts.Handler. The redraw handler is
+// unregistered if attaching the UI fails and when the UI is detached.
+func (v *Nvim) NewUIClient(width, height int, opts *UIClientOptions) (*UIClient, error) {
+	if opts == nil {
+		opts = &UIClientOptions{}
+	}
+	u := &UIClient{
+		v:        v,
+		onResize: opts.OnResize,
+		grids:    map[int][2]int{DefaultGrid: {width, height}},
+	}
+
+	var h RedrawHandler
+	if opts.Handler != nil {
+		h = *opts.Handler
+	}
+	gridResize, gridDestroy := h.GridResize, h.GridDestroy
+	h.GridResize = func(grid, width, height int) {
+		u.gridResize(grid, width, height)
+		if gridResize != nil {
+			gridResize(grid, width, height)
+		}
+	}
+	h.GridDestroy = func(grid int) {
+		u.mu.Lock()
+		delete(u.grids, grid)
+		u.mu.Unlock()
+		if gridDestroy != nil {
+			gridDestroy(grid)
+		}
+	}
+	if err := v.RegisterRedrawHandler(&h); err != nil {
+		return nil, err
+	}
+
+	attach := make(map[string]interface{}, len(opts.Attach)+1)
+	for key, value := range opts.Attach {
+		attach[key] = value
+	}
+	attach["ext_linegrid"] = true
+	if err := v.AttachUI(width, height, attach); err != nil {
+		v.unregister("redraw")
+		return nil, err
+	}
+	return u, nil
+}
+
+func (u *UIClient) gridResize(grid, width, height int) {
+	u.mu.Lock()
+	u.grids[grid] = [2]int{width, height}
+	u.mu.Unlock()
+	if u.onResize != nil {
+		u.onResize(grid, width, height)
+	}
+}
+
+// Size returns the current size of the default grid.
+func (u *UIClient) Size() (width, height int) {
+	width, height, _ = u.GridSize(DefaultGrid)
+	return width, height
+}
+
+// GridSize returns the current size of grid. The ok result is false if the
+// grid does not exist.
+func (u *UIClient) GridSize(grid int) (width, height int, ok bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	size, ok := u.grids[grid]
+	return size[0], size[1], ok
+}
+
+// Resize requests Nvim to resize the UI. The size reported by Size changes
+// when Nvim sends the grid_resize event.
+func (u *UIClient) Resize(width, height int) error {
+	return u.v.TryResizeUI(width, height)
+}
+
+// Detach detaches the UI from Nvim and unregisters the redraw handler.
+func (u *UIClient) Detach() error {
+	err := u.v.DetachUI()
+	u.v.unregister("redraw")
+	return err
+}
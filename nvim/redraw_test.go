@@ -0,0 +1,250 @@
+package nvim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/neovim/go-client/msgpack"
+)
+
+func TestRedrawDecoder(t *testing.T) {
+	t.Parallel()
+
+	type gridLine struct {
+		grid, row, colStart int
+		cells               []GridCell
+	}
+
+	var (
+		events []string
+		lines  []gridLine
+		others [][]interface{}
+	)
+	h := &RedrawHandler{
+		GridResize: func(grid, width, height int) {
+			events = append(events, "grid_resize")
+			if grid != 1 || width != 80 || height != 24 {
+				t.Errorf("grid_resize(%d, %d, %d), want (1, 80, 24)", grid, width, height)
+			}
+		},
+		GridCursorGoto: func(grid, row, col int) {
+			events = append(events, "grid_cursor_goto")
+			if grid != 1 || row != 2 || col != 3 {
+				t.Errorf("grid_cursor_goto(%d, %d, %d), want (1, 2, 3)", grid, row, col)
+			}
+		},
+		GridLine: func(grid, row, colStart int, cells []GridCell) {
+			events = append(events, "grid_line")
+			// Copy the cells because the buffers are reused.
+			line := gridLine{grid: grid, row: row, colStart: colStart}
+			for _, cell := range cells {
+				cell.Text = append([]byte(nil), cell.Text...)
+				line.cells = append(line.cells, cell)
+			}
+			lines = append(lines, line)
+		},
+		ModeChange: func(mode string, modeIdx int) {
+			events = append(events, "mode_change")
+			if mode != "normal" || modeIdx != 1 {
+				t.Errorf("mode_change(%q, %d), want (\"normal\", 1)", mode, modeIdx)
+			}
+		},
+		Flush: func() {
+			events = append(events, "flush")
+		},
+		Other: func(name string, args []interface{}) {
+			events = append(events, name)
+			others = append(others, args)
+		},
+	}
+
+	updates := []interface{}{
+		[]interface{}{"grid_resize", []interface{}{1, 80, 24}},
+		[]interface{}{"mode_change", []interface{}{"normal", 1}},
+		[]interface{}{"grid_line",
+			[]interface{}{1, 0, 0, []interface{}{
+				[]interface{}{"h", 5},
+				[]interface{}{"ä"},
+				[]interface{}{" ", 0, 3},
+			}, false},
+			[]interface{}{1, 1, 4, []interface{}{
+				[]interface{}{"x", 7, 2},
+			}},
+		},
+		[]interface{}{"grid_cursor_goto", []interface{}{1, 2, 3}},
+		[]interface{}{"hl_group_set", []interface{}{"Normal", 5}},
+		[]interface{}{"grid_clear", []interface{}{1}},
+		[]interface{}{},
+		[]interface{}{"flush", []interface{}{}},
+	}
+	var buf bytes.Buffer
+	if err := msgpack.NewEncoder(&buf).Encode(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := msgpack.NewDecoder(&buf)
+	if err := dec.Unpack(); err != nil {
+		t.Fatal(err)
+	}
+	r := &redrawDecoder{h: h}
+	if err := r.decode(dec, dec.Len()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantEvents := []string{"grid_resize", "mode_change", "grid_line", "grid_line", "grid_cursor_goto", "hl_group_set", "grid_clear", "flush"}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Fatalf("got %q events, want %q", events, wantEvents)
+	}
+
+	wantLines := []gridLine{
+		{
+			grid: 1,
+			cells: []GridCell{
+				{Text: []byte("h"), HLID: 5, Repeat: 1},
+				{Text: []byte("ä"), HLID: 5, Repeat: 1},
+				{Text: []byte(" "), HLID: 0, Repeat: 3},
+			},
+		},
+		{
+			grid:     1,
+			row:      1,
+			colStart: 4,
+			cells: []GridCell{
+				{Text: []byte("x"), HLID: 7, Repeat: 2},
+			},
+		},
+	}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Fatalf("got %+v lines, want %+v", lines, wantLines)
+	}
+
+	wantOthers := [][]interface{}{
+		{"Normal", int64(5)},
+		{int64(1)},
+	}
+	if !reflect.DeepEqual(others, wantOthers) {
+		t.Fatalf("got %#v other events, want %#v", others, wantOthers)
+	}
+
+	t.Run("InvalidArgs", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := msgpack.NewEncoder(&buf).Encode([]interface{}{
+			[]interface{}{"grid_resize", []interface{}{1, "x", 24}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		dec := msgpack.NewDecoder(&buf)
+		if err := dec.Unpack(); err != nil {
+			t.Fatal(err)
+		}
+		r := &redrawDecoder{h: h}
+		if err := r.decode(dec, dec.Len()); err == nil {
+			t.Fatal("expected error for invalid grid_resize arguments")
+		}
+	})
+}
+
+func TestRedrawEvents(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]RedrawEvent
+	decode := redrawEventsDecoder(func(events []RedrawEvent) {
+		batches = append(batches, events)
+	})
+
+	notifications := [][]interface{}{
+		{
+			[]interface{}{"grid_resize", []interface{}{1, 80, 24}},
+			[]interface{}{"grid_line",
+				[]interface{}{1, 0, 0, []interface{}{
+					[]interface{}{"a", 5},
+					[]interface{}{"b", 6, 2},
+				}},
+				[]interface{}{1, 1, 3, []interface{}{
+					[]interface{}{"c"},
+				}},
+			},
+			[]interface{}{"grid_cursor_goto", []interface{}{1, 2, 3}},
+			[]interface{}{"hl_group_set", []interface{}{"Normal", 5}},
+		},
+		{},
+		{
+			[]interface{}{"mode_change", []interface{}{"insert", 2}},
+			[]interface{}{"grid_scroll", []interface{}{1, 0, 10, 0, 80, 2, 0}},
+			[]interface{}{"flush", []interface{}{}},
+		},
+	}
+	for _, n := range notifications {
+		var buf bytes.Buffer
+		if err := msgpack.NewEncoder(&buf).Encode(n); err != nil {
+			t.Fatal(err)
+		}
+		dec := msgpack.NewDecoder(&buf)
+		if err := dec.Unpack(); err != nil {
+			t.Fatal(err)
+		}
+		if err := decode(dec, dec.Len()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]RedrawEvent{
+		{
+			GridResizeEvent{Grid: 1, Width: 80, Height: 24},
+			GridLineEvent{Grid: 1, Cells: []GridCell{
+				{Text: []byte("a"), HLID: 5, Repeat: 1},
+				{Text: []byte("b"), HLID: 6, Repeat: 2},
+			}},
+			GridLineEvent{Grid: 1, Row: 1, ColStart: 3, Cells: []GridCell{
+				{Text: []byte("c"), HLID: 0, Repeat: 1},
+			}},
+			GridCursorGotoEvent{Grid: 1, Row: 2, Col: 3},
+			OtherRedrawEvent{Event: "hl_group_set", Args: []interface{}{"Normal", int64(5)}},
+		},
+		{
+			ModeChangeEvent{Mode: "insert", ModeIdx: 2},
+			GridScrollEvent{Grid: 1, Bot: 10, Right: 80, Rows: 2},
+			FlushEvent{},
+		},
+	}
+	if !reflect.DeepEqual(batches, want) {
+		t.Fatalf("got %#v, want %#v", batches, want)
+	}
+	if name := batches[0][4].Name(); name != "hl_group_set" {
+		t.Fatalf("got other event name %q, want %q", name, "hl_group_set")
+	}
+}
+
+func TestUIAttachOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		opts *UI
+		want map[string]interface{}
+	}{
+		"Nil": {
+			opts: nil,
+			want: map[string]interface{}{"ext_linegrid": true},
+		},
+		"Options": {
+			opts: &UI{Width: 80, Height: 24, RGB: true, ExtCmdline: true, ExtMultigrid: true, ChannelID: 3},
+			want: map[string]interface{}{
+				"ext_linegrid":  true,
+				"rgb":           true,
+				"ext_cmdline":   true,
+				"ext_multigrid": true,
+			},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := uiAttachOptions(tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
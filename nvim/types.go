@@ -1,5 +1,12 @@
 package nvim
 
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/neovim/go-client/msgpack"
+)
+
 const (
 	// EventBufChangedtick event name of "nvim_buf_changedtick_event".
 	EventBufChangedtick = "nvim_buf_changedtick_event"
@@ -99,6 +106,285 @@ type Mode struct {
 	Blocking bool `msgpack:"blocking"`
 }
 
+// SnapshotOptions specifies the window of an EditorSnapshot.
+type SnapshotOptions struct {
+	// Window is the window to snapshot. The current window is used if Window
+	// is 0.
+	Window Window
+}
+
+// EditorSnapshot represents the state of the editor returned by Snapshot.
+type EditorSnapshot struct {
+	// Mode is the current mode.
+	Mode Mode
+
+	// Cwd is the effective working directory of Window.
+	Cwd string
+
+	// Window is the snapshot window.
+	Window Window
+
+	// Buffer is the buffer displayed in Window.
+	Buffer Buffer
+
+	// Name is the full file name of Buffer, or empty for an unnamed buffer.
+	Name string
+
+	// Cursor is the (1,0)-indexed cursor position in Window.
+	Cursor [2]int
+
+	// ChangedTick is the changedtick of Buffer.
+	ChangedTick int
+
+	// Modified is the 'modified' option of Buffer.
+	Modified bool
+
+	// Filetype is the 'filetype' option of Buffer.
+	Filetype string
+}
+
+// CmdlineState represents the state of the command line returned by
+// CmdlineState.
+type CmdlineState struct {
+	// Type is the type of the command line, such as ":" for an Ex command or
+	// "/" for a forward search. Type is empty if the command line is not
+	// being edited.
+	//
+	//	:help getcmdtype()
+	Type string `msgpack:"type"`
+
+	// Text is the contents of the command line.
+	Text string `msgpack:"text"`
+
+	// Pos is the zero-based byte position of the cursor in Text.
+	Pos int `msgpack:"pos"`
+}
+
+// APISchema represents the API metadata returned by APISchema.
+//
+// The shape of the metadata has changed between Nvim versions. Fields that
+// are not reported by the connected Nvim are left at their zero value and
+// keys that are unknown to this package are ignored.
+//
+//	:help api-metadata
+type APISchema struct {
+	// Version is the version of Nvim. Version is the zero value for old Nvim
+	// versions that do not report it.
+	Version APIVersion `msgpack:"version"`
+
+	// Functions is the list of API functions.
+	Functions []*APIFunction `msgpack:"functions"`
+
+	// UIEvents is the list of UI events. UIEvents is nil if not reported.
+	UIEvents []*APIUIEvent `msgpack:"ui_events"`
+
+	// UIOptions is the list of UI extensions supported by nvim_ui_attach.
+	// UIOptions is nil if not reported.
+	UIOptions []string `msgpack:"ui_options"`
+
+	// ErrorTypes maps the error type name to the error type.
+	ErrorTypes map[string]*APIErrorType `msgpack:"error_types"`
+
+	// Types maps the extension type name to the extension type.
+	Types map[string]*APIType `msgpack:"types"`
+}
+
+// Function returns the API function with the given name, or nil if the
+// function is not in the schema.
+func (s *APISchema) Function(name string) *APIFunction {
+	for _, f := range s.Functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// APIVersion represents the version of Nvim in APISchema.
+type APIVersion struct {
+	Major int `msgpack:"major"`
+	Minor int `msgpack:"minor"`
+	Patch int `msgpack:"patch"`
+
+	// Build is the build string, such as "v0.10.0-dev-1234+gabcdef". Build is
+	// empty if not reported.
+	Build string `msgpack:"build"`
+
+	APILevel      int  `msgpack:"api_level"`
+	APICompatible int  `msgpack:"api_compatible"`
+	APIPrerelease bool `msgpack:"api_prerelease"`
+}
+
+// APIFunction represents an API function in APISchema.
+type APIFunction struct {
+	// Name is the name of the function, such as "nvim_buf_get_lines".
+	Name string `msgpack:"name"`
+
+	// Parameters is the list of function parameters.
+	Parameters []*APIParameter `msgpack:"parameters"`
+
+	// ReturnType is the API type of the function result.
+	ReturnType string `msgpack:"return_type"`
+
+	// Method reports whether the function is a method of the type of the
+	// first parameter.
+	Method bool `msgpack:"method"`
+
+	// Since is the API level that introduced the function.
+	Since int `msgpack:"since"`
+
+	// DeprecatedSince is the API level that deprecated the function, or 0 if
+	// the function is not deprecated.
+	DeprecatedSince int `msgpack:"deprecated_since"`
+}
+
+// APIParameter represents a parameter of an APIFunction or APIUIEvent.
+type APIParameter struct {
+	// Type is the API type of the parameter, such as "Buffer" or
+	// "ArrayOf(String)".
+	Type string `msgpack:",array"`
+
+	// Name is the name of the parameter.
+	Name string
+}
+
+// APIUIEvent represents a UI event in APISchema.
+type APIUIEvent struct {
+	// Name is the name of the event, such as "grid_line".
+	Name string `msgpack:"name"`
+
+	// Parameters is the list of event parameters.
+	Parameters []*APIParameter `msgpack:"parameters"`
+
+	// Since is the API level that introduced the event.
+	Since int `msgpack:"since"`
+}
+
+// APIErrorType represents an error type in APISchema.
+type APIErrorType struct {
+	ID int `msgpack:"id"`
+}
+
+// APIType represents an extension type in APISchema.
+type APIType struct {
+	// ID is the msgpack extension type id.
+	ID int `msgpack:"id"`
+
+	// Prefix is the prefix of the API functions that are methods of the
+	// type, such as "nvim_buf_". Prefix is empty if not reported.
+	Prefix string `msgpack:"prefix"`
+}
+
+// SearchOptions specifies the options for Buffer.Search.
+type SearchOptions struct {
+	// StartLine is the one-based line where the search starts. The search
+	// starts at the first line if StartLine is 0.
+	StartLine int
+
+	// EndLine is the one-based last line searched. The search ends at the
+	// last line of the buffer if EndLine is 0.
+	EndLine int
+
+	// MaxMatches is the maximum number of matches returned. All matches are
+	// returned if MaxMatches is 0.
+	MaxMatches int
+}
+
+// Match represents a match returned by Buffer.Search.
+type Match struct {
+	// Start is the (1,0)-indexed position of the first byte of the match.
+	Start [2]int `msgpack:"start"`
+
+	// End is the (1,0)-indexed position of the first byte of the last
+	// character of the match. End is equal to Start for a match of a single
+	// character and for a zero-width match.
+	End [2]int `msgpack:"end"`
+}
+
+// JumpList represents the jumplist of a window returned by JumpList.
+//
+//	:help jumplist
+type JumpList struct {
+	// Jumps are the entries of the list, oldest first.
+	Jumps []*Jump
+
+	// Current is the index in Jumps of the current position. It is equal to
+	// len(Jumps) when the current position is after the last entry.
+	Current int
+}
+
+// Jump represents an entry in a jumplist.
+type Jump struct {
+	// Buffer is the buffer of the position.
+	Buffer Buffer
+
+	// Filename is the name of the file of the position, if any.
+	Filename string
+
+	// LNum is the one-based line number of the position.
+	LNum int
+
+	// Col is the zero-based byte column of the position.
+	Col int
+
+	// ColAdd is the offset in screen columns from Col, used with
+	// 'virtualedit'.
+	ColAdd int
+}
+
+// ChangeList represents the changelist of a buffer returned by ChangeList.
+//
+//	:help changelist
+type ChangeList struct {
+	// Changes are the positions of the changes, oldest first.
+	Changes []*Change
+
+	// Current is the index in Changes of the current position. It is equal
+	// to len(Changes) when the current position is after the last change. For
+	// a buffer other than the current buffer, Current is always len(Changes).
+	Current int
+}
+
+// Change represents an entry in a changelist.
+type Change struct {
+	// LNum is the one-based line number of the change.
+	LNum int `msgpack:"lnum"`
+
+	// Col is the zero-based byte column of the change.
+	Col int `msgpack:"col"`
+
+	// ColAdd is the offset in screen columns from Col, used with
+	// 'virtualedit'.
+	ColAdd int `msgpack:"coladd"`
+}
+
+// Selection represents a visual selection.
+type Selection struct {
+	// Mode is the visual mode of the selection: "v" for characterwise, "V"
+	// for linewise and "\x16" (CTRL-V) for blockwise.
+	Mode string
+
+	// Start is the (1,0)-indexed position of the first selected byte.
+	Start [2]int
+
+	// End is the (1,0)-indexed position just past the last selected byte.
+	// For a blockwise selection, Start and End are the corners of the block
+	// on its first and last line.
+	End [2]int
+
+	// Lines is the selected text, one element per selected line.
+	Lines [][]byte
+}
+
+// Digraph represents a digraph.
+type Digraph struct {
+	// Chars is the two characters typed after CTRL-K to enter the digraph.
+	Chars string `msgpack:",array"`
+
+	// Char is the resulting character.
+	Char string
+}
+
 // HLAttrs represents a highlight definitions.
 type HLAttrs struct {
 	// Bold is the bold font style.
@@ -147,9 +433,21 @@ type Mapping struct {
 	// LHS is the {lhs} of the mapping.
 	LHS string `msgpack:"lhs,omitempty"`
 
+	// LHSRaw is the {lhs} of the mapping as raw bytes, with special keys in
+	// their internal form.
+	LHSRaw string `msgpack:"lhsraw,omitempty"`
+
+	// LHSRawAlt is the alternative raw {lhs} of the mapping, if any, for keys
+	// such as <C-[> that have two internal forms.
+	LHSRawAlt string `msgpack:"lhsrawalt,omitempty"`
+
 	// RHS is the {hrs} of the mapping as typed.
 	RHS string `msgpack:"rhs,omitempty"`
 
+	// RHSRaw is the {rhs} of the mapping as raw bytes, with special keys in
+	// their internal form. RHSRaw is empty if Nvim does not report it.
+	RHSRaw string `msgpack:"rhsraw,omitempty"`
+
 	// Silent is 1 for a :map-silent mapping, else 0.
 	Silent int `msgpack:"silent,omitempty"`
 
@@ -169,9 +467,121 @@ type Mapping struct {
 	NoWait int `msgpack:"nowait,omitempty"`
 
 	// Mode specifies modes for which the mapping is defined.
-	Mode string `msgpack:"string,omitempty"`
+	Mode string `msgpack:"mode,omitempty"`
+
+	// Desc is the description of the mapping.
+	Desc string `msgpack:"desc,omitempty"`
+
+	// Callback is the Lua function called by the mapping instead of the {rhs}.
+	//
+	// Nvim only sends Lua function references to Lua callers, so Callback is
+	// nil when the mapping is listed over RPC. RHS is empty for a callback
+	// mapping, but also for a mapping whose {rhs} is empty.
+	Callback LuaRef `msgpack:"callback,omitempty"`
+
+	// LNum is the line number in the script where the mapping was defined.
+	LNum int `msgpack:"lnum,omitempty"`
+
+	// Script is 1 if the mapping was defined with <script>.
+	Script int `msgpack:"script,omitempty"`
+
+	// Abbr is 1 if the mapping is an abbreviation.
+	Abbr int `msgpack:"abbr,omitempty"`
+
+	// ModeBits is the Nvim internal binary representation of Mode.
+	ModeBits int `msgpack:"mode_bits,omitempty"`
+}
+
+// KeyMapOptions specifies the options of a mapping set with Map or BufferMap.
+// It is the typed form of the opts map of SetKeyMap and SetBufferKeyMap.
+//
+//	:help :map-arguments
+type KeyMapOptions struct {
+	// NoRemap makes the {rhs} of the mapping not remappable.
+	NoRemap bool `msgpack:"noremap,omitempty"`
+
+	// Silent does not echo the command executed by the mapping.
+	Silent bool `msgpack:"silent,omitempty"`
+
+	// Expr evaluates the {rhs} as an expression and uses the result as the
+	// keys of the mapping.
+	Expr bool `msgpack:"expr,omitempty"`
+
+	// NoWait does not wait for more keys when a longer mapping starts with
+	// the {lhs}.
+	NoWait bool `msgpack:"nowait,omitempty"`
+
+	// Script remaps only mappings local to the script.
+	Script bool `msgpack:"script,omitempty"`
+
+	// Unique fails if a mapping for the {lhs} already exists.
+	Unique bool `msgpack:"unique,omitempty"`
+
+	// Desc is the description of the mapping.
+	Desc string `msgpack:"desc,omitempty"`
+
+	// Callback is the name of an RPC method registered with
+	// Nvim.RegisterHandler that the mapping calls instead of executing an
+	// {rhs}. The method is called without arguments with rpcnotify, or with
+	// rpcrequest for an Expr mapping, in which case the string returned by the
+	// method is used as the keys of the mapping.
+	Callback string `msgpack:"-"`
+}
+
+// Autocmd represents a autocmd returned by Autocmds.
+type Autocmd struct {
+	// ID is the autocmd id. ID is zero for autocmds created with ":autocmd".
+	ID int `msgpack:"id,omitempty"`
+
+	// Group is the autocmd group id.
+	Group int `msgpack:"group,omitempty"`
+
+	// GroupName is the autocmd group name.
+	GroupName string `msgpack:"group_name,omitempty"`
+
+	// Desc is the autocmd description.
+	Desc string `msgpack:"desc,omitempty"`
+
+	// Event is the autocmd event.
+	Event string `msgpack:"event"`
+
+	// Command is the autocmd command. Command is empty if the autocmd calls
+	// Callback.
+	Command string `msgpack:"command,omitempty"`
+
+	// Callback is the autocmd callback. A Lua function cannot be sent over
+	// RPC, so Callback is nil for a Lua callback. A Vimscript function
+	// callback is represented by the function name.
+	Callback LuaRef `msgpack:"callback,omitempty"`
+
+	// Once is whether the autocmd is only run once.
+	Once bool `msgpack:"once"`
+
+	// Pattern is the autocmd pattern. For buffer-local autocmds, Pattern is
+	// "<buffer=N>".
+	Pattern string `msgpack:"pattern"`
+
+	// BufLocal is whether the autocmd is buffer-local.
+	BufLocal bool `msgpack:"buflocal"`
+
+	// Buffer is the buffer number of a buffer-local autocmd.
+	Buffer int `msgpack:"buffer,omitempty"`
+}
+
+// HasCallback reports whether the autocmd calls a function instead of
+// executing a command.
+func (a *Autocmd) HasCallback() bool {
+	return a.Callback != nil || a.Command == ""
+}
+
+// HasLuaCallback reports whether the autocmd calls a Lua function.
+func (a *Autocmd) HasLuaCallback() bool {
+	return a.Callback == nil && a.Command == ""
 }
 
+// LuaRef represents a reference to a Lua function.
+type LuaRef interface{}
+
 // ClientVersion represents a version of client for nvim.
 type ClientVersion struct {
 	// Major major version. (defaults to 0 if not set, for no release yet)
@@ -193,6 +603,16 @@ type ClientVersion struct {
 // ClientType type of client information.
 type ClientType string
 
+// valid reports whether t is one of the client types known to Nvim.
+func (t ClientType) valid() bool {
+	switch t {
+	case RemoteClientType, UIClientType, EmbedderClientType, HostClientType, PluginClientType:
+		return true
+	default:
+		return false
+	}
+}
+
 const (
 	// RemoteClientType for the client library.
 	RemoteClientType ClientType = "remote"
@@ -225,7 +645,7 @@ type ClientMethod struct {
 	Async bool `msgpack:"async"`
 
 	// NArgs is the number of method arguments.
-	NArgs ClientMethodNArgs
+	NArgs ClientMethodNArgs `msgpack:"nargs"`
 }
 
 // ClientMethodNArgs is the number of arguments. Could be a single integer or an array two integers, minimum and maximum inclusive.
@@ -237,6 +657,49 @@ type ClientMethodNArgs struct {
 	Max int
 }
 
+// UnmarshalMsgPack implements msgpack.Unmarshaler. The number of arguments is
+// decoded from a single integer or from a [min, max] array. A [n] array sets
+// both Min and Max to n, and elements after max are ignored.
+func (n *ClientMethodNArgs) UnmarshalMsgPack(dec *msgpack.Decoder) error {
+	switch dec.Type() {
+	case msgpack.Int:
+		n.Min = int(dec.Int())
+		n.Max = n.Min
+		return nil
+	case msgpack.Uint:
+		n.Min = int(dec.Uint())
+		n.Max = n.Min
+		return nil
+	case msgpack.ArrayLen:
+	default:
+		err := &msgpack.DecodeConvertError{SrcType: dec.Type(), DestType: reflect.TypeOf(n).Elem()}
+		dec.Skip()
+		return err
+	}
+	*n = ClientMethodNArgs{}
+	// Decode the elements one by one instead of allocating for the length
+	// sent by the peer.
+	l := dec.Len()
+	for i := 0; i < l; i++ {
+		var err error
+		switch i {
+		case 0:
+			err = dec.Decode(&n.Min)
+			n.Max = n.Min
+		case 1:
+			err = dec.Decode(&n.Max)
+		default:
+			if err = dec.Unpack(); err == nil {
+				err = dec.Skip()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ClientAttributes informal attributes describing the client. Clients might define their own keys, but the following are suggested.
 type ClientAttributes map[string]string
 
@@ -290,6 +753,137 @@ type Channel struct {
 	Client *Client `msgpack:"client,omitempty"`
 }
 
+// ParsedCmd represents a ParseCmd function return type.
+type ParsedCmd struct {
+	// Cmd is the command name.
+	Cmd string `msgpack:"cmd"`
+
+	// Range is the command range. Range has zero, one or two elements.
+	// Range is nil if the command does not accept a range.
+	Range []int `msgpack:"range,omitempty"`
+
+	// Count is the command count. Count is zero if the command does not
+	// accept a count.
+	Count int `msgpack:"count,omitempty"`
+
+	// Reg is the name of the register. Reg is empty if the command does not
+	// accept a register.
+	Reg string `msgpack:"reg,omitempty"`
+
+	// Bang is whether the command contains a "!" modifier.
+	Bang bool `msgpack:"bang"`
+
+	// Args is the command arguments.
+	Args []string `msgpack:"args"`
+
+	// Addr is the value of the "-addr" command attribute, for example "line",
+	// "buf" or "?" for an unknown address type.
+	Addr string `msgpack:"addr"`
+
+	// NArgs is the value of the "-nargs" command attribute: "0", "1", "?",
+	// "+" or "*".
+	NArgs string `msgpack:"nargs"`
+
+	// NextCmd is the next command if there are multiple commands separated
+	// by a "|".
+	NextCmd string `msgpack:"nextcmd"`
+
+	// Magic is which characters have special meaning in the command
+	// arguments.
+	Magic CmdMagic `msgpack:"magic"`
+
+	// Mods is the command modifiers.
+	Mods CmdMods `msgpack:"mods"`
+}
+
+// CmdMagic represents which characters have special meaning in the arguments
+// of a ParsedCmd.
+type CmdMagic struct {
+	// File is whether the command expands filenames, for example "%" and
+	// "<cword>".
+	File bool `msgpack:"file"`
+
+	// Bar is whether "|" is treated as a command separator and '"' as the
+	// start of a comment.
+	Bar bool `msgpack:"bar"`
+}
+
+// CmdMods represents the command modifiers of a ParsedCmd.
+type CmdMods struct {
+	// Filter is the ":filter" modifier.
+	Filter CmdFilter `msgpack:"filter"`
+
+	// Silent is the ":silent" modifier.
+	Silent bool `msgpack:"silent"`
+
+	// EmsgSilent is the ":silent!" modifier.
+	EmsgSilent bool `msgpack:"emsg_silent"`
+
+	// Unsilent is the ":unsilent" modifier.
+	Unsilent bool `msgpack:"unsilent"`
+
+	// Sandbox is the ":sandbox" modifier.
+	Sandbox bool `msgpack:"sandbox"`
+
+	// NoAutocmd is the ":noautocmd" modifier.
+	NoAutocmd bool `msgpack:"noautocmd"`
+
+	// Browse is the ":browse" modifier.
+	Browse bool `msgpack:"browse"`
+
+	// Confirm is the ":confirm" modifier.
+	Confirm bool `msgpack:"confirm"`
+
+	// Hide is the ":hide" modifier.
+	Hide bool `msgpack:"hide"`
+
+	// Horizontal is the ":horizontal" modifier.
+	Horizontal bool `msgpack:"horizontal"`
+
+	// KeepAlt is the ":keepalt" modifier.
+	KeepAlt bool `msgpack:"keepalt"`
+
+	// KeepJumps is the ":keepjumps" modifier.
+	KeepJumps bool `msgpack:"keepjumps"`
+
+	// KeepMarks is the ":keepmarks" modifier.
+	KeepMarks bool `msgpack:"keepmarks"`
+
+	// KeepPatterns is the ":keeppatterns" modifier.
+	KeepPatterns bool `msgpack:"keeppatterns"`
+
+	// LockMarks is the ":lockmarks" modifier.
+	LockMarks bool `msgpack:"lockmarks"`
+
+	// NoSwapfile is the ":noswapfile" modifier.
+	NoSwapfile bool `msgpack:"noswapfile"`
+
+	// Tab is the count of the ":tab" modifier. Tab is -1 if the modifier is
+	// not used.
+	Tab int `msgpack:"tab"`
+
+	// Verbose is the count of the ":verbose" modifier. Verbose is -1 if the
+	// modifier is not used.
+	Verbose int `msgpack:"verbose"`
+
+	// Vertical is the ":vertical" modifier.
+	Vertical bool `msgpack:"vertical"`
+
+	// Split is the split modifier: "aboveleft", "belowright", "topleft",
+	// "botright" or empty if no split modifier is used.
+	Split string `msgpack:"split"`
+}
+
+// CmdFilter represents the ":filter" command modifier.
+type CmdFilter struct {
+	// Pattern is the filter pattern. Pattern is empty if the modifier is not
+	// used.
+	Pattern string `msgpack:"pattern"`
+
+	// Force is whether the filter is inverted with "!".
+	Force bool `msgpack:"force"`
+}
+
 // Process represents a Proc and ProcChildren functions return type.
 type Process struct {
 	// Name is the name of process command.
@@ -328,9 +922,21 @@ type UI struct {
 	// ExtNewgrid use new revision of the grid events.
 	ExtNewgrid bool `msgpack:"ext_newgrid,omitempty"`
 
+	// ExtLinegrid use line based grid events.
+	ExtLinegrid bool `msgpack:"ext_linegrid,omitempty"`
+
+	// ExtMultigrid use per-window grid based events.
+	ExtMultigrid bool `msgpack:"ext_multigrid,omitempty"`
+
+	// ExtMessages externalize the messages.
+	ExtMessages bool `msgpack:"ext_messages,omitempty"`
+
 	// ExtHlstate use detailed highlight state.
 	ExtHlstate bool `msgpack:"ext_hlstate,omitempty"`
 
+	// ExtTermcolors use external default colors.
+	ExtTermcolors bool `msgpack:"ext_termcolors,omitempty"`
+
 	// ChannelID channel id of remote UI (not present for TUI)
 	ChannelID int `msgpack:"chan,omitempty"`
 }
@@ -485,6 +1091,7 @@ type WindowConfig struct {
 	BufPos [2]int `msgpack:"bufpos,omitempty"`
 
 	// Row is the row position in units of "screen cell height", may be fractional.
+	// Row and Col are always sent to Nvim for a floating window, even if zero.
 	Row float64 `msgpack:"row,omitempty"`
 
 	// Col is the column position in units of "screen cell width", may be fractional.
@@ -500,7 +1107,147 @@ type WindowConfig struct {
 	Style string `msgpack:"style,omitempty"`
 
 	// Border is the style of window border.
-	Border []string `msgpack:"border,omitempty"`
+	Border WindowBorder `msgpack:"border,omitempty"`
+
+	// ZIndex is the stacking order of floating windows. Windows with a
+	// larger ZIndex are drawn on top. Nvim uses 50 if ZIndex is 0.
+	ZIndex int `msgpack:"zindex,omitempty"`
+}
+
+// windowConfig is WindowConfig without the MarshalMsgPack method.
+type windowConfig WindowConfig
+
+// MarshalMsgPack implements msgpack.Marshaler. Row and Col are encoded for a
+// floating window even if zero, because Nvim requires a position for a
+// floating window.
+func (c WindowConfig) MarshalMsgPack(enc *msgpack.Encoder) error {
+	v := struct {
+		*windowConfig
+		Row *float64 `msgpack:"row,omitempty"`
+		Col *float64 `msgpack:"col,omitempty"`
+	}{windowConfig: (*windowConfig)(&c)}
+	if c.Relative != "" || c.Row != 0 || c.Col != 0 {
+		v.Row, v.Col = &c.Row, &c.Col
+	}
+	return enc.Encode(&v)
+}
+
+// Validate returns an error if the configuration is not consistent, for
+// example if Row and Col are set for a window that is not floating. Nvim
+// reports these errors too, but Validate can check a configuration before it
+// is sent.
+func (c *WindowConfig) Validate() error {
+	switch c.Relative {
+	case "":
+		if c.Row != 0 || c.Col != 0 || c.Anchor != "" || c.BufPos != [2]int{} {
+			return fmt.Errorf("nvim: window position requires a relative option")
+		}
+	case "editor", "cursor", "mouse", "laststatus", "tabline":
+		if c.Win != 0 || c.BufPos != [2]int{} {
+			return fmt.Errorf("nvim: win and bufpos require relative %q, not %q", "win", c.Relative)
+		}
+	case "win":
+	default:
+		return fmt.Errorf("nvim: invalid relative option %q", c.Relative)
+	}
+	switch c.Anchor {
+	case "", "NW", "NE", "SW", "SE":
+	default:
+		return fmt.Errorf("nvim: invalid anchor %q", c.Anchor)
+	}
+	if c.Width < 0 || c.Height < 0 || (c.Relative != "" && (c.Width == 0 || c.Height == 0)) {
+		return fmt.Errorf("nvim: floating window size %dx%d, want at least 1x1", c.Width, c.Height)
+	}
+	return c.Border.validate()
+}
+
+// WindowBorder is the border of a window. A border is either a named style
+// created with BorderStyle or the characters of the border, starting with the
+// top-left corner and going clockwise. The characters are repeated if fewer
+// than 8 are specified.
+//
+//	:help nvim_open_win()
+type WindowBorder []string
+
+// BorderStyle returns the border with the named style "none", "single",
+// "double", "rounded", "solid" or "shadow".
+func BorderStyle(name string) WindowBorder {
+	return WindowBorder{name}
+}
+
+var borderStyles = map[string]bool{
+	"none":    true,
+	"single":  true,
+	"double":  true,
+	"rounded": true,
+	"solid":   true,
+	"shadow":  true,
+}
+
+// Style returns the name of the style of a border created with BorderStyle,
+// or "" for a border specified by its characters.
+func (b WindowBorder) Style() string {
+	if len(b) == 1 && borderStyles[b[0]] {
+		return b[0]
+	}
+	return ""
+}
+
+func (b WindowBorder) validate() error {
+	if b.Style() != "" {
+		return nil
+	}
+	switch len(b) {
+	case 0, 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("nvim: border has %d characters, want 1, 2, 4 or 8", len(b))
+	}
+	return nil
+}
+
+// MarshalMsgPack implements msgpack.Marshaler. A named style is encoded as a
+// string and the characters of a border as an array.
+func (b WindowBorder) MarshalMsgPack(enc *msgpack.Encoder) error {
+	if style := b.Style(); style != "" {
+		return enc.PackString(style)
+	}
+	return enc.Encode([]string(b))
+}
+
+// UnmarshalMsgPack implements msgpack.Unmarshaler. The border is decoded from
+// a named style or an array of characters, where each character may be a
+// [char, hlgroup] pair. The highlight groups are discarded.
+func (b *WindowBorder) UnmarshalMsgPack(dec *msgpack.Decoder) error {
+	switch dec.Type() {
+	case msgpack.Nil:
+		*b = nil
+		return nil
+	case msgpack.String:
+		*b = WindowBorder{dec.String()}
+		return nil
+	case msgpack.ArrayLen:
+	default:
+		err := &msgpack.DecodeConvertError{SrcType: dec.Type(), DestType: reflect.TypeOf(b).Elem()}
+		dec.Skip()
+		return err
+	}
+	border := make(WindowBorder, dec.Len())
+	for i := range border {
+		var char interface{}
+		if err := dec.Decode(&char); err != nil {
+			return err
+		}
+		switch char := char.(type) {
+		case string:
+			border[i] = char
+		case []interface{}:
+			if len(char) > 0 {
+				border[i], _ = char[0].(string)
+			}
+		}
+	}
+	*b = border
+	return nil
 }
 
 // ExtMark represents a extmarks type.
@@ -515,6 +1262,250 @@ type ExtMark struct {
 	Col int
 }
 
+// ExtmarkOptions specifies the options of an extmark set with SetExtmark.
+// The zero value creates an extmark without decorations. The With methods set
+// fields and return the options, so the calls can be chained:
+//
+//	opts := new(nvim.ExtmarkOptions).WithEnd(2, 0).WithHighlight("Search")
+//
+//	:help nvim_buf_set_extmark()
+type ExtmarkOptions struct {
+	// ID is the ID of the extmark to create or update. Nvim allocates a new
+	// ID if ID is 0.
+	ID int `msgpack:"id,omitempty"`
+
+	// EndRow and EndCol are the zero-based end position of the extmark.
+	// EndCol is exclusive. The extmark has no end position if nil.
+	EndRow *int `msgpack:"end_row,omitempty"`
+	EndCol *int `msgpack:"end_col,omitempty"`
+
+	// HLGroup is the highlight group of the text from the start to the end
+	// position.
+	HLGroup string `msgpack:"hl_group,omitempty"`
+
+	// HLEOL continues the highlight to the end of the screen line.
+	HLEOL bool `msgpack:"hl_eol,omitempty"`
+
+	// VirtText is the virtual text of the extmark. Each chunk is a
+	// [text, hlgroup] pair, or [text] for text without a highlight.
+	VirtText [][]string `msgpack:"virt_text,omitempty"`
+
+	// VirtTextPos is the position of the virtual text: "eol", "overlay",
+	// "right_align" or "inline". The default is "eol".
+	VirtTextPos string `msgpack:"virt_text_pos,omitempty"`
+
+	// VirtTextHide hides the virtual text when the background text is
+	// selected or hidden.
+	VirtTextHide bool `msgpack:"virt_text_hide,omitempty"`
+
+	// HLMode specifies how the highlights of the virtual text are combined
+	// with the highlights of the text: "replace", "combine" or "blend".
+	HLMode string `msgpack:"hl_mode,omitempty"`
+
+	// VirtLines are virtual lines added below the line of the extmark, or
+	// above with VirtLinesAbove. Each line is a list of chunks like
+	// VirtText.
+	VirtLines      [][][]string `msgpack:"virt_lines,omitempty"`
+	VirtLinesAbove bool         `msgpack:"virt_lines_above,omitempty"`
+
+	// Ephemeral uses the extmark only for the current redraw of a decoration
+	// provider.
+	Ephemeral bool `msgpack:"ephemeral,omitempty"`
+
+	// RightGravity specifies whether the extmark moves right when text is
+	// inserted at its position. The default is true.
+	RightGravity *bool `msgpack:"right_gravity,omitempty"`
+
+	// EndRightGravity specifies whether the end position moves right when
+	// text is inserted at the end position.
+	EndRightGravity bool `msgpack:"end_right_gravity,omitempty"`
+
+	// Priority is the priority of the highlights of the extmark. Nvim uses
+	// the default priority 4096 if Priority is 0.
+	Priority int `msgpack:"priority,omitempty"`
+
+	// SignText is the text of the sign shown in the sign column, one or two
+	// display cells.
+	SignText string `msgpack:"sign_text,omitempty"`
+
+	// SignHLGroup is the highlight group of the sign text.
+	SignHLGroup string `msgpack:"sign_hl_group,omitempty"`
+
+	// NumberHLGroup is the highlight group of the number column.
+	NumberHLGroup string `msgpack:"number_hl_group,omitempty"`
+
+	// LineHLGroup is the highlight group of the whole line.
+	LineHLGroup string `msgpack:"line_hl_group,omitempty"`
+}
+
+// WithEnd sets the zero-based end position of the extmark.
+func (o *ExtmarkOptions) WithEnd(row, col int) *ExtmarkOptions {
+	o.EndRow = &row
+	o.EndCol = &col
+	return o
+}
+
+// WithHighlight sets the highlight group of the text of the extmark.
+func (o *ExtmarkOptions) WithHighlight(hlGroup string) *ExtmarkOptions {
+	o.HLGroup = hlGroup
+	return o
+}
+
+// WithVirtText appends a chunk of virtual text. If hlGroup is empty, the
+// text has no highlight.
+func (o *ExtmarkOptions) WithVirtText(text, hlGroup string) *ExtmarkOptions {
+	chunk := []string{text}
+	if hlGroup != "" {
+		chunk = append(chunk, hlGroup)
+	}
+	o.VirtText = append(o.VirtText, chunk)
+	return o
+}
+
+// WithSign sets the text and highlight group of the sign of the extmark.
+func (o *ExtmarkOptions) WithSign(text, hlGroup string) *ExtmarkOptions {
+	o.SignText = text
+	o.SignHLGroup = hlGroup
+	return o
+}
+
+// WithPriority sets the priority of the highlights of the extmark.
+func (o *ExtmarkOptions) WithPriority(priority int) *ExtmarkOptions {
+	o.Priority = priority
+	return o
+}
+
+// WithRightGravity sets the gravity of the start position of the extmark.
+func (o *ExtmarkOptions) WithRightGravity(right bool) *ExtmarkOptions {
+	o.RightGravity = &right
+	return o
+}
+
+// ExtmarkDetails represents an extmark with its options returned by
+// BufferExtmarkDetails.
+type ExtmarkDetails struct {
+	// ID is the extmark ID.
+	ID int `msgpack:",array"`
+
+	// Row and Col are the zero-based start position of the extmark.
+	Row int
+	Col int
+
+	// Options is the options of the extmark.
+	Options ExtmarkOptions
+}
+
+// InspectPosOptions specifies the items returned by InspectPos.
+type InspectPosOptions struct {
+	// Syntax includes the syntax highlight groups.
+	Syntax bool
+
+	// Treesitter includes the treesitter captures.
+	Treesitter bool
+
+	// Extmarks includes the extmarks with a highlight group.
+	Extmarks bool
+
+	// AllExtmarks includes all extmarks, including extmarks without a
+	// highlight group. AllExtmarks implies Extmarks.
+	AllExtmarks bool
+
+	// SemanticTokens includes the LSP semantic tokens.
+	SemanticTokens bool
+}
+
+// InspectResult represents the items at a position returned by InspectPos.
+type InspectResult struct {
+	// Row is the zero-based row of the position.
+	Row int `msgpack:"row"`
+
+	// Col is the zero-based byte column of the position.
+	Col int `msgpack:"col"`
+
+	// Treesitter is the list of treesitter captures.
+	Treesitter []*InspectTreesitter `msgpack:"treesitter"`
+
+	// Syntax is the list of syntax highlight groups, outermost first.
+	Syntax []*InspectSyntax `msgpack:"syntax"`
+
+	// Extmarks is the list of extmarks, excluding semantic tokens.
+	Extmarks []*InspectExtmark `msgpack:"extmarks"`
+
+	// SemanticTokens is the list of extmarks for LSP semantic tokens.
+	SemanticTokens []*InspectExtmark `msgpack:"semantic_tokens"`
+}
+
+// InspectTreesitter represents a treesitter capture returned by InspectPos.
+type InspectTreesitter struct {
+	// Capture is the name of the capture, without the "@" prefix.
+	Capture string `msgpack:"capture"`
+
+	// Lang is the language of the tree that contains the capture.
+	Lang string `msgpack:"lang"`
+
+	// HLGroup is the highlight group of the capture, like "@keyword.go".
+	HLGroup string `msgpack:"hl_group"`
+
+	// HLGroupLink is the highlight group that HLGroup resolves to.
+	HLGroupLink string `msgpack:"hl_group_link"`
+}
+
+// InspectSyntax represents a syntax highlight group returned by InspectPos.
+type InspectSyntax struct {
+	// HLGroup is the syntax highlight group.
+	HLGroup string `msgpack:"hl_group"`
+
+	// HLGroupLink is the highlight group that HLGroup resolves to.
+	HLGroupLink string `msgpack:"hl_group_link"`
+}
+
+// InspectExtmark represents an extmark returned by InspectPos.
+type InspectExtmark struct {
+	// ID is the extmark ID.
+	ID int `msgpack:"id"`
+
+	// NSID is the namespace ID of the extmark.
+	NSID int `msgpack:"ns_id"`
+
+	// NS is the namespace name of the extmark, or empty for an anonymous
+	// namespace.
+	NS string `msgpack:"ns"`
+
+	// Row and Col are the zero-based start position of the extmark.
+	Row int `msgpack:"row"`
+	Col int `msgpack:"col"`
+
+	// EndRow and EndCol are the zero-based end position of the extmark.
+	// EndCol is exclusive.
+	EndRow int `msgpack:"end_row"`
+	EndCol int `msgpack:"end_col"`
+
+	// Opts is the details of the extmark.
+	Opts InspectExtmarkOpts `msgpack:"opts"`
+}
+
+// InspectExtmarkOpts represents the details of an extmark returned by
+// InspectPos.
+type InspectExtmarkOpts struct {
+	// HLGroup is the highlight group of the extmark.
+	HLGroup string `msgpack:"hl_group,omitempty"`
+
+	// HLGroupLink is the highlight group that HLGroup resolves to.
+	HLGroupLink string `msgpack:"hl_group_link,omitempty"`
+
+	// HLEol continues the highlight to the end of the screen line.
+	HLEol bool `msgpack:"hl_eol,omitempty"`
+
+	// Priority is the priority of the highlight.
+	Priority int `msgpack:"priority,omitempty"`
+
+	// RightGravity is the gravity of the start of the extmark.
+	RightGravity bool `msgpack:"right_gravity,omitempty"`
+
+	// EndRightGravity is the gravity of the end of the extmark.
+	EndRightGravity bool `msgpack:"end_right_gravity,omitempty"`
+}
+
 // OptionInfo represents a option information.
 type OptionInfo struct {
 	// Name is the name of the option (like 'filetype').
@@ -554,6 +1545,91 @@ type OptionInfo struct {
 	FlagList bool `msgpack:"flaglist"`
 }
 
+// OptionValueScope represents a option value scope.
+type OptionValueScope string
+
+// list of OptionValueScope.
+const (
+	// GlobalScope is the global value of the option, like ":setglobal".
+	GlobalScope OptionValueScope = "global"
+
+	// LocalScope is the local value of the option, like ":setlocal".
+	LocalScope OptionValueScope = "local"
+)
+
+// CwdScope represents the scope of a working directory.
+type CwdScope string
+
+// list of CwdScope.
+const (
+	// GlobalCwdScope is the global working directory, set with ":cd".
+	GlobalCwdScope CwdScope = "global"
+
+	// TabpageCwdScope is the tabpage-local working directory, set with
+	// ":tcd".
+	TabpageCwdScope CwdScope = "tabpage"
+
+	// WindowCwdScope is the window-local working directory, set with ":lcd".
+	WindowCwdScope CwdScope = "window"
+)
+
+// DiagnosticSeverity represents the severity of a diagnostic. The values
+// match vim.diagnostic.severity.
+type DiagnosticSeverity int
+
+// list of DiagnosticSeverity.
+const (
+	DiagnosticError DiagnosticSeverity = 1 + iota
+	DiagnosticWarn
+	DiagnosticInfo
+	DiagnosticHint
+)
+
+// String returns the name of the severity used in the names of the
+// Diagnostic* highlight groups, like "Error" in "DiagnosticSignError".
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case DiagnosticError:
+		return "Error"
+	case DiagnosticWarn:
+		return "Warn"
+	case DiagnosticInfo:
+		return "Info"
+	case DiagnosticHint:
+		return "Hint"
+	default:
+		return "Unknown"
+	}
+}
+
+// DiagnosticOptions specifies the presentation of a diagnostic set with
+// Buffer.SetDiagnostic.
+type DiagnosticOptions struct {
+	// Col is the zero-based byte column where the underline starts.
+	Col int
+
+	// EndCol is the exclusive zero-based byte column where the underline
+	// ends. If EndCol is 0, the underline extends to the end of the line.
+	EndCol int
+
+	// Prefix is written before the message in the virtual text. The
+	// default is "■ ".
+	Prefix string
+
+	// Priority is the priority of the extmark. The default is the priority
+	// of extmarks.
+	Priority int
+
+	// NoVirtualText disables the message shown at the end of the line.
+	NoVirtualText bool
+
+	// NoSign disables the sign.
+	NoSign bool
+
+	// NoUnderline disables the underline.
+	NoUnderline bool
+}
+
 // LogLevel represents a nvim log level.
 type LogLevel int
 